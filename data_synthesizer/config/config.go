@@ -1,58 +1,628 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+	_ "time/tzdata" // MARKET_TIMEZONE without zoneinfo on the host
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	ApiKey        string
-	Tickers       []string
-	MessageCount  int
-	VeramoURL     string
-	VeramoToken   string
-	DidProvider   string
-	DidWebHost    string
-	DidWebProject string
-	Port          string
-	KMS           string
-	MetricsPort   string
-	SSIValidation bool
-	CacheDid      bool
-	ProcessingMode string
+	ApiKey                   string
+	ApiKeys                  []string
+	FinnhubKeyCooldown       time.Duration
+	FinnhubCompression       bool
+	Tickers                  []string
+	MessageCount             int
+	MessageCountPerSymbol    int
+	VeramoURL                string
+	VeramoToken              string
+	DidProvider              string
+	DidWebHost               string
+	DidWebProject            string
+	Port                     string
+	KMS                      string
+	MetricsPort              string
+	SSIValidation            bool
+	CacheDid                 bool
+	ProcessingMode           string
+	FinnhubMaxRestarts       int
+	FinnhubReconnectAttempts int
+	FinnhubReconnectMaxDelay time.Duration
+	FinnhubCloseTimeout      time.Duration
+	FinnhubPollFallbackAfter int
+	FinnhubPollInterval      time.Duration
+	RunDuration              time.Duration
+	ShutdownDrainTimeout     time.Duration
+	DataSource               string
+	ReplayFile               string
+	ReplaySpeed              float64
+	ReplayLoops              int
+	RecordFile               string
+	RecordMaxMB              int
+	SyntheticRate            float64
+	SyntheticSeed            int
+	SyntheticVolumeMean      float64
+	SyntheticVolumeSigma     float64
+	EnablePprof              bool
+	PprofBlockRate           int
+	PprofMutexFraction       int
+	TradeProcessors          int
+	Sampling                 SymbolRates
+	TradeRateLimit           SymbolRates
+	AsyncQueueSize           int
+	AsyncBackpressure        string
+	TradeDispatch            string
+	DedupWindow              time.Duration
+	DedupSize                int
+	TradeSilenceTimeout      time.Duration
+	TradeStartupTimeout      time.Duration
+	TradeSilenceHours        ActiveHours
+	SymbolFormat             string
+	TradeFieldNames          FieldNames
+	TradeTimeField           string
+	TradeFilters             TradeFilters
+	MarketHours              MarketHours
+	MarketHoursMode          string
 }
 
+// SymbolRates is a per-symbol number such as SAMPLING, given as
+// "default=1,BINANCE:BTCUSDT=0.1". Symbols without an entry get Default.
+type SymbolRates struct {
+	Default float64            `json:"default"`
+	Symbols map[string]float64 `json:"symbols,omitempty"`
+}
+
+// For returns the rate for the symbol
+func (r SymbolRates) For(symbol string) float64 {
+	if rate, ok := r.Symbols[symbol]; ok {
+		return rate
+	}
+	return r.Default
+}
+
+// String formats the rates as the environment gives them
+func (r SymbolRates) String() string {
+	entries := []string{"default=" + strconv.FormatFloat(r.Default, 'g', -1, 64)}
+	for _, symbol := range slices.Sorted(maps.Keys(r.Symbols)) {
+		entries = append(entries, symbol+"="+strconv.FormatFloat(r.Symbols[symbol], 'g', -1, 64))
+	}
+	return strings.Join(entries, ",")
+}
+
+// SymbolCodes is a per-symbol list such as TRADE_EXCLUDE_CONDITIONS, given
+// as "default=1|8,BINANCE:BTCUSDT=12" with the codes separated by "|".
+// Symbols without an entry get Default.
+type SymbolCodes struct {
+	Default []string            `json:"default"`
+	Symbols map[string][]string `json:"symbols,omitempty"`
+}
+
+// For returns the codes for the symbol
+func (c SymbolCodes) For(symbol string) []string {
+	if codes, ok := c.Symbols[symbol]; ok {
+		return codes
+	}
+	return c.Default
+}
+
+// String formats the codes as the environment gives them
+func (c SymbolCodes) String() string {
+	entries := []string{"default=" + strings.Join(c.Default, "|")}
+	for _, symbol := range slices.Sorted(maps.Keys(c.Symbols)) {
+		entries = append(entries, symbol+"="+strings.Join(c.Symbols[symbol], "|"))
+	}
+	return strings.Join(entries, ",")
+}
+
+// TradeFilters drop trades that aren't economically meaningful before they
+// are handled. Each is set per symbol, like SAMPLING; 0 or no codes
+// filter nothing.
+type TradeFilters struct {
+	MinVolume         SymbolRates `json:"min_volume"`
+	MinPrice          SymbolRates `json:"min_price"`
+	MaxPrice          SymbolRates `json:"max_price"`
+	ExcludeConditions SymbolCodes `json:"exclude_conditions"`
+}
+
+// ActiveHours is a daily window in UTC such as TRADE_SILENCE_HOURS, given
+// as "13:30-20:00". It may wrap past midnight; the zero value is all day.
+type ActiveHours struct {
+	From time.Duration // Since midnight
+	To   time.Duration
+}
+
+// Start returns when the window holding t started, and whether there is
+// one. All day, the start is the zero time.
+func (h ActiveHours) Start(t time.Time) (time.Time, bool) {
+	if h.From == h.To {
+		return time.Time{}, true
+	}
+	midnight := t.UTC().Truncate(24 * time.Hour)
+	offset := t.Sub(midnight)
+	switch {
+	case h.From < h.To:
+		return midnight.Add(h.From), offset >= h.From && offset < h.To
+	case offset >= h.From:
+		return midnight.Add(h.From), true
+	default:
+		// Wrapped past midnight: the window started yesterday
+		return midnight.Add(h.From - 24*time.Hour), offset < h.To
+	}
+}
+
+// String formats the window as the environment gives it, "" for all day
+func (h ActiveHours) String() string {
+	if h.From == h.To {
+		return ""
+	}
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(h.From) + "-" + format(h.To)
+}
+
+// TradingWindow is when a symbol trades, such as "Mon-Fri 09:30-16:00" in
+// MARKET_HOURS: from From to To local time, on the days given or every
+// day. It may wrap past midnight into the next day; the zero value, given
+// as "always", is always open.
+type TradingWindow struct {
+	Days [7]bool       // By time.Weekday, the days the window opens on; none is every day
+	From time.Duration // Since local midnight
+	To   time.Duration
+}
+
+// opens reports whether the window opens on the day
+func (w TradingWindow) opens(day time.Weekday) bool {
+	return w.Days == [7]bool{} || w.Days[day]
+}
+
+// Open reports whether local, a time in the window's zone, is inside the
+// window. The window goes by the wall clock, so it keeps its local hours
+// across daylight saving changes.
+func (w TradingWindow) Open(local time.Time) bool {
+	clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	day := local.Weekday()
+	switch {
+	case w.From == w.To:
+		return w.opens(day)
+	case w.From < w.To:
+		return w.opens(day) && clock >= w.From && clock < w.To
+	default:
+		// Wrapped past midnight: after From today, or before To on the day
+		// after one the window opened
+		return w.opens(day) && clock >= w.From || w.opens((day+6)%7) && clock < w.To
+	}
+}
+
+// String formats the window as the environment gives it
+func (w TradingWindow) String() string {
+	var days []string
+	if w.Days != [7]bool{} && w.Days != [7]bool{true, true, true, true, true, true, true} {
+		// Runs of open days, going round the week from a closed one
+		closed := time.Weekday(slices.Index(w.Days[:], false))
+		first := time.Weekday(-1)
+		for i := time.Weekday(1); i <= 7; i++ {
+			day := (closed + i) % 7
+			switch {
+			case w.Days[day] && first < 0:
+				first = day
+			case !w.Days[day] && first >= 0:
+				if last := (day + 6) % 7; first == last {
+					days = append(days, first.String()[:3])
+				} else {
+					days = append(days, first.String()[:3]+"-"+last.String()[:3])
+				}
+				first = -1
+			}
+		}
+	}
+	if w.From == w.To {
+		if days == nil {
+			return "always"
+		}
+		return strings.Join(days, "|")
+	}
+	hours := ActiveHours{From: w.From, To: w.To}.String()
+	if days == nil {
+		return hours
+	}
+	return strings.Join(days, "|") + " " + hours
+}
+
+// MarketHours are the trading windows of MARKET_HOURS, such as
+// "default=always,AAPL=Mon-Fri 09:30-16:00", in Location. A symbol gets
+// its own window, else its exchange's, given by prefix such as "BINANCE:",
+// else Default.
+type MarketHours struct {
+	Location *time.Location
+	Default  TradingWindow
+	Windows  map[string]TradingWindow // By symbol or exchange prefix
+}
+
+// For returns the window for the symbol
+func (h MarketHours) For(symbol string) TradingWindow {
+	if window, ok := h.Windows[symbol]; ok {
+		return window
+	}
+	if exchange, _, ok := strings.Cut(symbol, ":"); ok {
+		if window, ok := h.Windows[exchange+":"]; ok {
+			return window
+		}
+	}
+	return h.Default
+}
+
+// Open reports whether the symbol's window holds t
+func (h MarketHours) Open(symbol string, t time.Time) bool {
+	location := h.Location
+	if location == nil {
+		location = time.UTC
+	}
+	return h.For(symbol).Open(t.In(location))
+}
+
+// Gated reports whether any window ever closes
+func (h MarketHours) Gated() bool {
+	for _, window := range h.Windows {
+		if window != (TradingWindow{}) {
+			return true
+		}
+	}
+	return h.Default != TradingWindow{}
+}
+
+// String formats the windows as the environment gives them
+func (h MarketHours) String() string {
+	entries := []string{"default=" + h.Default.String()}
+	for _, key := range slices.Sorted(maps.Keys(h.Windows)) {
+		entries = append(entries, key+"="+h.Windows[key].String())
+	}
+	return strings.Join(entries, ",")
+}
+
+// MarshalJSON gives the windows by symbol as the environment does, with
+// the zone's name
+func (h MarketHours) MarshalJSON() ([]byte, error) {
+	windows := make(map[string]string, len(h.Windows))
+	for key, window := range h.Windows {
+		windows[key] = window.String()
+	}
+	return json.Marshal(struct {
+		Timezone string            `json:"timezone"`
+		Default  string            `json:"default"`
+		Windows  map[string]string `json:"windows,omitempty"`
+	}{h.Location.String(), h.Default.String(), windows})
+}
+
+// FieldNames renames trade fields on the way downstream, such as
+// TRADE_FIELD_NAMES, given as "Event_Timestamp=timestamp,Price=price"
+type FieldNames map[string]string
+
+// String formats the names as the environment gives them
+func (n FieldNames) String() string {
+	entries := make([]string, 0, len(n))
+	for _, field := range slices.Sorted(maps.Keys(n)) {
+		entries = append(entries, field+"="+n[field])
+	}
+	return strings.Join(entries, ",")
+}
+
+// Symbol formats selected by SYMBOL_FORMAT
+const (
+	SymbolKeep  = "keep"  // As Finnhub sends them, such as BINANCE:BTCUSDT
+	SymbolStrip = "strip" // Without the exchange prefix, such as BTCUSDT
+	SymbolSplit = "split" // Without the prefix, which moves to the trade's Exchange
+)
+
+// NormalizeSymbol returns the symbol in the format, with its exchange
+// prefix when the format splits it off. Normalizing twice changes nothing,
+// so symbols can be normalized wherever they are looked up.
+func NormalizeSymbol(format string, symbol string) (string, string) {
+	exchange, ticker, ok := strings.Cut(symbol, ":")
+	if !ok || format == SymbolKeep || format == "" {
+		return symbol, ""
+	}
+	if format == SymbolSplit {
+		return ticker, exchange
+	}
+	return ticker, ""
+}
+
+// Processing modes selected by PROCESSING_MODE
+const (
+	ProcessingSync  = "sync"  // Each trade is handled before the next is read
+	ProcessingAsync = "async" // Trades are queued for workers
+)
+
+// What async processing does when a worker's queue is full, selected by
+// ASYNC_BACKPRESSURE
+const (
+	BackpressureBlock      = "block"       // Wait for room, slowing the reader
+	BackpressureDropOldest = "drop_oldest" // Drop the oldest queued trade
+)
+
+// How a frame's trades reach the trade handler, selected by TRADE_DISPATCH
+const (
+	DispatchBatch = "batch" // All of a frame's trades in one HandleBatch call
+	DispatchTrade = "trade" // One HandleTrade call per trade
+)
+
+// Data sources selected by DATA_SOURCE
+const (
+	DataSourceFinnhub   = "finnhub"   // Live Finnhub WebSocket
+	DataSourceFile      = "file"      // Frames recorded in REPLAY_FILE
+	DataSourceSynthetic = "synthetic" // Trades generated for the subscribed tickers
+)
+
+// What happens to symbols outside their MARKET_HOURS, selected by
+// MARKET_HOURS_MODE
 const (
-	defaultKMS          = "local"
-	defaultPort         = "4200"
-	defaultMetricsPort  = "2122"
-	defaultMessageCount = 1000
+	MarketHoursDrop        = "drop"        // Their trades are dropped
+	MarketHoursUnsubscribe = "unsubscribe" // They are unsubscribed until the window opens
 )
 
+const (
+	defaultKMS                  = "local"
+	defaultPort                 = "4200"
+	defaultMetricsPort          = "2122"
+	defaultMessageCount         = 1000
+	defaultMaxRestarts          = 10
+	defaultReconnectAttempts    = 5
+	defaultReconnectMaxDelay    = "30s"
+	defaultCloseTimeout         = "5s"
+	defaultPollFallbackAfter    = 3
+	defaultPollInterval         = "15s"
+	defaultKeyCooldown          = "5m"
+	defaultCompression          = false
+	defaultDrainTimeout         = "10s"
+	defaultDidProvider          = "did:key"
+	defaultRunDuration          = "0s"
+	defaultProcessing           = ProcessingSync
+	defaultAsyncQueueSize       = 256
+	defaultSSIValidation        = true
+	defaultCacheDid             = false
+	defaultReplaySpeed          = "1"
+	defaultReplayLoops          = 1
+	defaultRecordMaxMB          = 100
+	defaultSyntheticRate        = "10"
+	defaultSyntheticVolumeMean  = "1"
+	defaultSyntheticVolumeSigma = "1"
+	defaultEnablePprof          = false
+	defaultSampling             = 1.0  // Handle every trade
+	defaultTradeRateLimit       = 0.0  // No limit
+	defaultDedupWindow          = "0s" // No dedup
+	defaultDedupSize            = 10000
+	defaultSilenceTimeout       = "0s" // No watchdog
+	defaultStartupTimeout       = "0s" // Wait for the first trade however long it takes
+	defaultMarketTimezone       = "America/New_York"
+)
+
+// Setting describes an environment variable LoadConfig reads, for tools
+// such as command-line flags that set it
+type Setting struct {
+	Env     string
+	Default string // As LoadConfig applies it; "" when there is none
+	Usage   string
+	Bool    bool // Parsed like SSI_VALIDATION: 1/t/true/yes/y or 0/f/false/no/n
+	Secret  bool // Only read from the environment
+}
+
+// Settings lists every environment variable LoadConfig reads
+var Settings = []Setting{
+	{Env: "FINNHUB_API_KEY", Usage: "Finnhub WebSocket API key (required for the finnhub data source, unless FINNHUB_API_KEYS is set)", Secret: true},
+	{Env: "FINNHUB_API_KEYS", Usage: "CSV list of Finnhub API keys, rotated through on connection failures and rate limiting (default: FINNHUB_API_KEY)", Secret: true},
+	{Env: "FINNHUB_KEY_COOLDOWN", Default: defaultKeyCooldown, Usage: "How long a Finnhub API key that failed is left unused while there are others"},
+	{Env: "FINNHUB_COMPRESSION", Default: strconv.FormatBool(defaultCompression), Usage: "Offer permessage-deflate compression on the Finnhub WebSocket", Bool: true},
+	{Env: "TICKERS", Usage: "CSV list of tickers, e.g. BINANCE:BTCUSDT,BINANCE:ETHUSDT (required)"},
+	{Env: "VERAMO_API_URL", Usage: "Veramo gateway base URL (required)"},
+	{Env: "VERAMO_API_TOKEN", Usage: "Bearer token for Veramo (required)", Secret: true},
+	{Env: "PORT", Default: defaultPort, Usage: "HTTP/WebSocket port"},
+	{Env: "METRICS_PORT", Default: defaultMetricsPort, Usage: "Prometheus metrics port"},
+	{Env: "MESSAGE_COUNT", Default: strconv.Itoa(defaultMessageCount), Usage: "Max messages before stopping (0 = unlimited)"},
+	{Env: "MESSAGE_COUNT_PER_SYMBOL", Default: "0", Usage: "Max messages per ticker; a ticker stops once it has them and the run once every ticker has (0 = unlimited)"},
+	{Env: "RUN_DURATION", Default: defaultRunDuration, Usage: "Wall-clock limit from the first Finnhub connection, e.g. 10m (0 = unlimited)"},
+	{Env: "DID_PROVIDER", Default: defaultDidProvider, Usage: "DID method: did:key or did:web"},
+	{Env: "DID_WEB_HOST", Usage: "Host for did:web DIDs (required for did:web)"},
+	{Env: "DID_WEB_PROJECT", Usage: "Project path for did:web DIDs"},
+	{Env: "SSI_VALIDATION", Default: strconv.FormatBool(defaultSSIValidation), Usage: "Sign events as Verifiable Credentials", Bool: true},
+	{Env: "KMS", Default: defaultKMS, Usage: "Key management system for DIDs"},
+	{Env: "CACHE_DID", Default: strconv.FormatBool(defaultCacheDid), Usage: "Metrics label (always true for did:ethr)", Bool: true},
+	{Env: "PROCESSING_MODE", Default: defaultProcessing, Usage: "sync handles each trade as it is read, async queues trades for TRADE_PROCESSORS workers"},
+	{Env: "ASYNC_QUEUE_SIZE", Default: strconv.Itoa(defaultAsyncQueueSize), Usage: "Trades queued per async worker"},
+	{Env: "TRADE_DISPATCH", Default: DispatchBatch, Usage: "Hand each frame's trades over as one batch or trade by trade"},
+	{Env: "ASYNC_BACKPRESSURE", Default: BackpressureBlock, Usage: "When an async queue is full: block or drop_oldest"},
+	{Env: "FINNHUB_MAX_RESTARTS", Default: strconv.Itoa(defaultMaxRestarts), Usage: "Consecutive Finnhub client restarts before exiting"},
+	{Env: "FINNHUB_RECONNECT_ATTEMPTS", Default: strconv.Itoa(defaultReconnectAttempts), Usage: "In-place Finnhub reconnects before the client is restarted (0 = restart straight away)"},
+	{Env: "FINNHUB_RECONNECT_MAX_DELAY", Default: defaultReconnectMaxDelay, Usage: "Longest backoff between Finnhub reconnect attempts"},
+	{Env: "FINNHUB_CLOSE_TIMEOUT", Default: defaultCloseTimeout, Usage: "Budget for unsubscribing and the Finnhub close handshake on shutdown"},
+	{Env: "FINNHUB_POLL_FALLBACK_AFTER", Default: strconv.Itoa(defaultPollFallbackAfter), Usage: "Failed reconnects before polling REST quotes until the WebSocket is back (0 = never poll)"},
+	{Env: "FINNHUB_POLL_INTERVAL", Default: defaultPollInterval, Usage: "Interval between REST quote polls for each ticker while polling"},
+	{Env: "SHUTDOWN_DRAIN_TIMEOUT", Default: defaultDrainTimeout, Usage: "Total budget for draining trades and clients on shutdown"},
+	{Env: "DATA_SOURCE", Default: DataSourceFinnhub, Usage: "Where trades come from: finnhub (live), file (replay) or synthetic (generated)"},
+	{Env: "REPLAY_FILE", Usage: "Recorded Finnhub frames, one JSON frame per line (required for the file data source)"},
+	{Env: "REPLAY_SPEED", Default: defaultReplaySpeed, Usage: "Replay speed factor: 1 honors the recorded gaps, 0 replays as fast as possible"},
+	{Env: "REPLAY_LOOPS", Default: strconv.Itoa(defaultReplayLoops), Usage: "Times to replay the file (0 = until stopped)"},
+	{Env: "RECORD_FILE", Usage: "Append every raw Finnhub frame to this file, in the format REPLAY_FILE takes (default: no recording)"},
+	{Env: "RECORD_MAX_MB", Default: strconv.Itoa(defaultRecordMaxMB), Usage: "Size in MB at which RECORD_FILE is rotated (0 = never)"},
+	{Env: "SYNTHETIC_RATE", Default: defaultSyntheticRate, Usage: "Average synthetic trades per second, per ticker"},
+	{Env: "SYNTHETIC_SEED", Default: "0", Usage: "Seed for synthetic trades; the same seed and tickers give the same trades (0 = random)"},
+	{Env: "SYNTHETIC_VOLUME_MEAN", Default: defaultSyntheticVolumeMean, Usage: "Mean volume of synthetic trades"},
+	{Env: "SYNTHETIC_VOLUME_SIGMA", Default: defaultSyntheticVolumeSigma, Usage: "Spread of the log-normal synthetic trade volumes (0 = always the mean)"},
+	{Env: "TRADE_PROCESSORS", Usage: "Trade processors sharing the work by symbol (default: number of tickers, capped at GOMAXPROCS)"},
+	{Env: "SAMPLING", Default: "default=1", Usage: "Fraction of trades handled, per symbol, e.g. default=1,BINANCE:BTCUSDT=0.1"},
+	{Env: "TRADE_RATE_LIMIT", Default: "default=0", Usage: "Most trades handled per second, per symbol, e.g. default=0,BINANCE:BTCUSDT=20 (0 = unlimited)"},
+	{Env: "DEDUP_WINDOW", Default: defaultDedupWindow, Usage: "Drop Finnhub trades seen again within this window, e.g. after a reconnect (0s = keep duplicates)"},
+	{Env: "DEDUP_SIZE", Default: strconv.Itoa(defaultDedupSize), Usage: "Most trades remembered for DEDUP_WINDOW"},
+	{Env: "TRADE_SILENCE_TIMEOUT", Default: defaultSilenceTimeout, Usage: "Reconnect to Finnhub when no trades arrive for this long (0s = never)"},
+	{Env: "TRADE_STARTUP_TIMEOUT", Default: defaultStartupTimeout, Usage: "Reconnect to Finnhub when the first trade doesn't arrive within this long of connecting (0s = wait)"},
+	{Env: "TRADE_SILENCE_HOURS", Usage: "UTC hours trades are expected in, e.g. 13:30-20:00; the timeouts only apply then (default: all day)"},
+	{Env: "TRADE_MIN_VOLUME", Default: "default=0", Usage: "Smallest trade volume handled, per symbol, e.g. default=0,BINANCE:BTCUSDT=0.01 (0 = any)"},
+	{Env: "TRADE_MIN_PRICE", Default: "default=0", Usage: "Lowest trade price handled, per symbol (0 = any)"},
+	{Env: "TRADE_MAX_PRICE", Default: "default=0", Usage: "Highest trade price handled, per symbol (0 = any)"},
+	{Env: "TRADE_EXCLUDE_CONDITIONS", Usage: "Finnhub trade condition codes to drop, per symbol, e.g. default=1|8,AAPL=12 (default: none)"},
+	{Env: "MARKET_HOURS", Usage: "Trading windows by symbol or exchange prefix, e.g. default=Mon-Fri 09:30-16:00,BINANCE:=always (default: always open)"},
+	{Env: "MARKET_TIMEZONE", Default: defaultMarketTimezone, Usage: "IANA time zone the MARKET_HOURS windows are in"},
+	{Env: "MARKET_HOURS_MODE", Default: MarketHoursDrop, Usage: "Outside its MARKET_HOURS, drop a symbol's trades or unsubscribe from it until the window opens"},
+	{Env: "SYMBOL_FORMAT", Default: SymbolKeep, Usage: "Symbols in trades, metrics and DIDs: keep (BINANCE:BTCUSDT), strip (BTCUSDT) or split (BTCUSDT with Exchange BINANCE)"},
+	{Env: "TRADE_FIELD_NAMES", Usage: "Renamed trade data fields, e.g. Event_Timestamp=timestamp,Price=price (default: Finnhub's names)"},
+	{Env: "TRADE_TIME_FIELD", Usage: "Trade data field for the event time as RFC 3339, e.g. Event_Time (default: none)"},
+	{Env: "ENABLE_PPROF", Default: strconv.FormatBool(defaultEnablePprof), Usage: "Serve /debug/pprof/ on the metrics port", Bool: true},
+	{Env: "PPROF_BLOCK_RATE", Default: "0", Usage: "runtime.SetBlockProfileRate with ENABLE_PPROF (0 = off)"},
+	{Env: "PPROF_MUTEX_FRACTION", Default: "0", Usage: "runtime.SetMutexProfileFraction with ENABLE_PPROF (0 = off)"},
+}
+
+// Redacted returns the effective configuration by environment variable,
+// formatted as the environment gives it, with keys and tokens redacted
+func (c Config) Redacted() map[string]string {
+	redact := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return "[REDACTED]"
+	}
+	return map[string]string{
+		"FINNHUB_API_KEY":             redact(c.ApiKey),
+		"FINNHUB_API_KEYS":            redact(strings.Join(c.ApiKeys, ",")),
+		"FINNHUB_KEY_COOLDOWN":        c.FinnhubKeyCooldown.String(),
+		"FINNHUB_COMPRESSION":         strconv.FormatBool(c.FinnhubCompression),
+		"TICKERS":                     strings.Join(c.Tickers, ","),
+		"VERAMO_API_URL":              c.VeramoURL,
+		"VERAMO_API_TOKEN":            redact(c.VeramoToken),
+		"PORT":                        c.Port,
+		"METRICS_PORT":                c.MetricsPort,
+		"MESSAGE_COUNT":               strconv.Itoa(c.MessageCount),
+		"MESSAGE_COUNT_PER_SYMBOL":    strconv.Itoa(c.MessageCountPerSymbol),
+		"RUN_DURATION":                c.RunDuration.String(),
+		"DID_PROVIDER":                c.DidProvider,
+		"DID_WEB_HOST":                c.DidWebHost,
+		"DID_WEB_PROJECT":             c.DidWebProject,
+		"SSI_VALIDATION":              strconv.FormatBool(c.SSIValidation),
+		"KMS":                         c.KMS,
+		"CACHE_DID":                   strconv.FormatBool(c.CacheDid),
+		"PROCESSING_MODE":             c.ProcessingMode,
+		"ASYNC_QUEUE_SIZE":            strconv.Itoa(c.AsyncQueueSize),
+		"ASYNC_BACKPRESSURE":          c.AsyncBackpressure,
+		"TRADE_DISPATCH":              c.TradeDispatch,
+		"FINNHUB_MAX_RESTARTS":        strconv.Itoa(c.FinnhubMaxRestarts),
+		"FINNHUB_RECONNECT_ATTEMPTS":  strconv.Itoa(c.FinnhubReconnectAttempts),
+		"FINNHUB_RECONNECT_MAX_DELAY": c.FinnhubReconnectMaxDelay.String(),
+		"FINNHUB_CLOSE_TIMEOUT":       c.FinnhubCloseTimeout.String(),
+		"FINNHUB_POLL_FALLBACK_AFTER": strconv.Itoa(c.FinnhubPollFallbackAfter),
+		"FINNHUB_POLL_INTERVAL":       c.FinnhubPollInterval.String(),
+		"SHUTDOWN_DRAIN_TIMEOUT":      c.ShutdownDrainTimeout.String(),
+		"DATA_SOURCE":                 c.DataSource,
+		"REPLAY_FILE":                 c.ReplayFile,
+		"REPLAY_SPEED":                strconv.FormatFloat(c.ReplaySpeed, 'g', -1, 64),
+		"REPLAY_LOOPS":                strconv.Itoa(c.ReplayLoops),
+		"RECORD_FILE":                 c.RecordFile,
+		"RECORD_MAX_MB":               strconv.Itoa(c.RecordMaxMB),
+		"SYNTHETIC_RATE":              strconv.FormatFloat(c.SyntheticRate, 'g', -1, 64),
+		"SYNTHETIC_SEED":              strconv.Itoa(c.SyntheticSeed),
+		"SYNTHETIC_VOLUME_MEAN":       strconv.FormatFloat(c.SyntheticVolumeMean, 'g', -1, 64),
+		"SYNTHETIC_VOLUME_SIGMA":      strconv.FormatFloat(c.SyntheticVolumeSigma, 'g', -1, 64),
+		"ENABLE_PPROF":                strconv.FormatBool(c.EnablePprof),
+		"PPROF_BLOCK_RATE":            strconv.Itoa(c.PprofBlockRate),
+		"PPROF_MUTEX_FRACTION":        strconv.Itoa(c.PprofMutexFraction),
+		"TRADE_PROCESSORS":            strconv.Itoa(c.TradeProcessors),
+		"SAMPLING":                    c.Sampling.String(),
+		"TRADE_RATE_LIMIT":            c.TradeRateLimit.String(),
+		"DEDUP_WINDOW":                c.DedupWindow.String(),
+		"DEDUP_SIZE":                  strconv.Itoa(c.DedupSize),
+		"TRADE_SILENCE_TIMEOUT":       c.TradeSilenceTimeout.String(),
+		"TRADE_STARTUP_TIMEOUT":       c.TradeStartupTimeout.String(),
+		"TRADE_SILENCE_HOURS":         c.TradeSilenceHours.String(),
+		"TRADE_MIN_VOLUME":            c.TradeFilters.MinVolume.String(),
+		"TRADE_MIN_PRICE":             c.TradeFilters.MinPrice.String(),
+		"TRADE_MAX_PRICE":             c.TradeFilters.MaxPrice.String(),
+		"TRADE_EXCLUDE_CONDITIONS":    c.TradeFilters.ExcludeConditions.String(),
+		"MARKET_HOURS":                c.MarketHours.String(),
+		"MARKET_TIMEZONE":             c.MarketHours.Location.String(),
+		"MARKET_HOURS_MODE":           c.MarketHoursMode,
+		"SYMBOL_FORMAT":               c.SymbolFormat,
+		"TRADE_FIELD_NAMES":           c.TradeFieldNames.String(),
+		"TRADE_TIME_FIELD":            c.TradeTimeField,
+	}
+}
+
 // LoadConfig loads from .env (if present) and environment variables.
 func LoadConfig() (Config, error) {
 	_ = godotenv.Load() // ok if missing
 
 	cfg := Config{
-		KMS:           getEnvDefault("KMS", defaultKMS),
-		Port:          getEnvDefault("PORT", defaultPort),
-		MetricsPort:   getEnvDefault("METRICS_PORT", defaultMetricsPort),
-		DidProvider:   getEnvDefault("DID_PROVIDER", "did:key"),
-		MessageCount:  parseIntDefault("MESSAGE_COUNT", defaultMessageCount),
-		SSIValidation: parseBoolDefault("SSI_VALIDATION", true),
+		KMS:                      getEnvDefault("KMS", defaultKMS),
+		Port:                     getEnvDefault("PORT", defaultPort),
+		MetricsPort:              getEnvDefault("METRICS_PORT", defaultMetricsPort),
+		DidProvider:              getEnvDefault("DID_PROVIDER", defaultDidProvider),
+		MessageCount:             parseIntDefault("MESSAGE_COUNT", defaultMessageCount),
+		MessageCountPerSymbol:    parseIntDefault("MESSAGE_COUNT_PER_SYMBOL", 0),
+		SSIValidation:            parseBoolDefault("SSI_VALIDATION", defaultSSIValidation),
+		FinnhubMaxRestarts:       parseIntDefault("FINNHUB_MAX_RESTARTS", defaultMaxRestarts),
+		FinnhubReconnectAttempts: parseIntDefault("FINNHUB_RECONNECT_ATTEMPTS", defaultReconnectAttempts),
+		FinnhubPollFallbackAfter: parseIntDefault("FINNHUB_POLL_FALLBACK_AFTER", defaultPollFallbackAfter),
+		FinnhubCompression:       parseBoolDefault("FINNHUB_COMPRESSION", defaultCompression),
+		ReplayLoops:              parseIntDefault("REPLAY_LOOPS", defaultReplayLoops),
+		RecordFile:               getEnvDefault("RECORD_FILE", ""),
+		RecordMaxMB:              parseIntDefault("RECORD_MAX_MB", defaultRecordMaxMB),
+		SyntheticSeed:            parseIntDefault("SYNTHETIC_SEED", 0),
+		EnablePprof:              parseBoolDefault("ENABLE_PPROF", defaultEnablePprof),
+		PprofBlockRate:           parseIntDefault("PPROF_BLOCK_RATE", 0),
+		PprofMutexFraction:       parseIntDefault("PPROF_MUTEX_FRACTION", 0),
 	}
 
 	var err error
 
-	// Required strings
-	if cfg.ApiKey, err = getEnvRequired("FINNHUB_API_KEY"); err != nil {
-		return Config{}, err
+	// DATA_SOURCE decides whether the Finnhub key or a replay file is required;
+	// synthetic trades need neither
+	switch cfg.DataSource = getEnvDefault("DATA_SOURCE", DataSourceFinnhub); cfg.DataSource {
+	case DataSourceFinnhub:
+		// FINNHUB_API_KEYS takes over from FINNHUB_API_KEY when both are set
+		cfg.ApiKey = getEnvDefault("FINNHUB_API_KEY", "")
+		if cfg.ApiKeys = splitCSV(getEnvDefault("FINNHUB_API_KEYS", "")); len(cfg.ApiKeys) == 0 && cfg.ApiKey != "" {
+			cfg.ApiKeys = []string{cfg.ApiKey}
+		}
+		if len(cfg.ApiKeys) == 0 {
+			return Config{}, fmt.Errorf("environment variable %q or %q is required", "FINNHUB_API_KEY", "FINNHUB_API_KEYS")
+		}
+	case DataSourceFile:
+		if cfg.ReplayFile, err = getEnvRequired("REPLAY_FILE"); err != nil {
+			return Config{}, err
+		}
+	case DataSourceSynthetic:
+	default:
+		return Config{}, fmt.Errorf("%q must be %q, %q or %q, got %q", "DATA_SOURCE", DataSourceFinnhub, DataSourceFile, DataSourceSynthetic, cfg.DataSource)
+	}
+
+	replaySpeedEnv := getEnvDefault("REPLAY_SPEED", defaultReplaySpeed)
+	if cfg.ReplaySpeed, err = strconv.ParseFloat(replaySpeedEnv, 64); err != nil || cfg.ReplaySpeed < 0 {
+		return Config{}, fmt.Errorf("invalid %q speed %q", "REPLAY_SPEED", replaySpeedEnv)
+	}
+
+	// SYNTHETIC_* (optional): shape of the generated trades
+	syntheticRateEnv := getEnvDefault("SYNTHETIC_RATE", defaultSyntheticRate)
+	if cfg.SyntheticRate, err = strconv.ParseFloat(syntheticRateEnv, 64); err != nil || cfg.SyntheticRate <= 0 {
+		return Config{}, fmt.Errorf("invalid %q rate %q", "SYNTHETIC_RATE", syntheticRateEnv)
+	}
+	volumeMeanEnv := getEnvDefault("SYNTHETIC_VOLUME_MEAN", defaultSyntheticVolumeMean)
+	if cfg.SyntheticVolumeMean, err = strconv.ParseFloat(volumeMeanEnv, 64); err != nil || cfg.SyntheticVolumeMean <= 0 {
+		return Config{}, fmt.Errorf("invalid %q volume %q", "SYNTHETIC_VOLUME_MEAN", volumeMeanEnv)
 	}
+	volumeSigmaEnv := getEnvDefault("SYNTHETIC_VOLUME_SIGMA", defaultSyntheticVolumeSigma)
+	if cfg.SyntheticVolumeSigma, err = strconv.ParseFloat(volumeSigmaEnv, 64); err != nil || cfg.SyntheticVolumeSigma < 0 {
+		return Config{}, fmt.Errorf("invalid %q spread %q", "SYNTHETIC_VOLUME_SIGMA", volumeSigmaEnv)
+	}
+
+	// Required strings
 	if cfg.VeramoURL, err = getEnvRequired("VERAMO_API_URL"); err != nil {
 		return Config{}, err
 	}
@@ -70,7 +640,13 @@ func LoadConfig() (Config, error) {
 		return Config{}, fmt.Errorf("no valid tickers found in %q", "TICKERS")
 	}
 
-	cacheDid := parseBoolDefault("CACHE_DID", false)
+	// TRADE_PROCESSORS defaults to one per ticker, capped at GOMAXPROCS
+	cfg.TradeProcessors = parseIntDefault("TRADE_PROCESSORS", min(len(cfg.Tickers), runtime.GOMAXPROCS(0)))
+	if cfg.TradeProcessors < 1 {
+		return Config{}, fmt.Errorf("%q must be at least 1", "TRADE_PROCESSORS")
+	}
+
+	cacheDid := parseBoolDefault("CACHE_DID", defaultCacheDid)
 	cfg.CacheDid = cacheDid || strings.HasPrefix(cfg.DidProvider, "did:ethr")
 
 	// did:web specific requirements
@@ -79,12 +655,130 @@ func LoadConfig() (Config, error) {
 	if cfg.DidProvider == "did:web" && strings.TrimSpace(cfg.DidWebHost) == "" {
 		return Config{}, fmt.Errorf("%q is required when %q is %q", "DID_WEB_HOST", "DID_PROVIDER", "did:web")
 	}
-	processingMode := "sync"
-	if getEnvDefault("PROCESSING_MODE", "sync") == "async" {
-		processingMode = "async"
+	processingMode := defaultProcessing
+	if getEnvDefault("PROCESSING_MODE", defaultProcessing) == ProcessingAsync {
+		processingMode = ProcessingAsync
 	}
 	cfg.ProcessingMode = processingMode
 
+	// ASYNC_* (optional): queueing for PROCESSING_MODE=async
+	if cfg.AsyncQueueSize = parseIntDefault("ASYNC_QUEUE_SIZE", defaultAsyncQueueSize); cfg.AsyncQueueSize < 1 {
+		return Config{}, fmt.Errorf("%q must be at least 1", "ASYNC_QUEUE_SIZE")
+	}
+	switch cfg.AsyncBackpressure = getEnvDefault("ASYNC_BACKPRESSURE", BackpressureBlock); cfg.AsyncBackpressure {
+	case BackpressureBlock, BackpressureDropOldest:
+	default:
+		return Config{}, fmt.Errorf("%q must be %q or %q, got %q", "ASYNC_BACKPRESSURE", BackpressureBlock, BackpressureDropOldest, cfg.AsyncBackpressure)
+	}
+
+	// TRADE_DISPATCH (optional): batch per frame, or per-trade for comparison
+	switch cfg.TradeDispatch = getEnvDefault("TRADE_DISPATCH", DispatchBatch); cfg.TradeDispatch {
+	case DispatchBatch, DispatchTrade:
+	default:
+		return Config{}, fmt.Errorf("%q must be %q or %q, got %q", "TRADE_DISPATCH", DispatchBatch, DispatchTrade, cfg.TradeDispatch)
+	}
+
+	// SAMPLING and TRADE_RATE_LIMIT (optional): per-symbol trade throttling
+	if cfg.Sampling, err = parseSymbolRates("SAMPLING", defaultSampling, func(rate float64) bool { return rate > 0 && rate <= 1 }); err != nil {
+		return Config{}, err
+	}
+	if cfg.TradeRateLimit, err = parseSymbolRates("TRADE_RATE_LIMIT", defaultTradeRateLimit, func(rate float64) bool { return rate >= 0 }); err != nil {
+		return Config{}, err
+	}
+
+	// TRADE_MIN_*, TRADE_MAX_PRICE and TRADE_EXCLUDE_CONDITIONS (optional):
+	// per-symbol trade filters
+	if cfg.TradeFilters, err = parseTradeFilters(); err != nil {
+		return Config{}, err
+	}
+
+	// DEDUP_* (optional): dropping trades Finnhub sends again, 0s for none
+	dedupWindowEnv := getEnvDefault("DEDUP_WINDOW", defaultDedupWindow)
+	if cfg.DedupWindow, err = time.ParseDuration(dedupWindowEnv); err != nil || cfg.DedupWindow < 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "DEDUP_WINDOW", dedupWindowEnv)
+	}
+	if cfg.DedupSize = parseIntDefault("DEDUP_SIZE", defaultDedupSize); cfg.DedupSize < 1 {
+		return Config{}, fmt.Errorf("%q must be at least 1", "DEDUP_SIZE")
+	}
+
+	// TRADE_SILENCE_* and TRADE_STARTUP_TIMEOUT (optional): the watchdog for
+	// a connection that stays up without trades
+	silenceTimeoutEnv := getEnvDefault("TRADE_SILENCE_TIMEOUT", defaultSilenceTimeout)
+	if cfg.TradeSilenceTimeout, err = time.ParseDuration(silenceTimeoutEnv); err != nil || cfg.TradeSilenceTimeout < 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "TRADE_SILENCE_TIMEOUT", silenceTimeoutEnv)
+	}
+	startupTimeoutEnv := getEnvDefault("TRADE_STARTUP_TIMEOUT", defaultStartupTimeout)
+	if cfg.TradeStartupTimeout, err = time.ParseDuration(startupTimeoutEnv); err != nil || cfg.TradeStartupTimeout < 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "TRADE_STARTUP_TIMEOUT", startupTimeoutEnv)
+	}
+	if cfg.TradeSilenceHours, err = parseActiveHours("TRADE_SILENCE_HOURS"); err != nil {
+		return Config{}, err
+	}
+
+	// MARKET_* (optional): trading windows outside which symbols are gated
+	if cfg.MarketHours, err = parseMarketHours("MARKET_HOURS", "MARKET_TIMEZONE"); err != nil {
+		return Config{}, err
+	}
+	switch cfg.MarketHoursMode = getEnvDefault("MARKET_HOURS_MODE", MarketHoursDrop); cfg.MarketHoursMode {
+	case MarketHoursDrop, MarketHoursUnsubscribe:
+	default:
+		return Config{}, fmt.Errorf("%q must be %q or %q, got %q", "MARKET_HOURS_MODE", MarketHoursDrop, MarketHoursUnsubscribe, cfg.MarketHoursMode)
+	}
+
+	// SYMBOL_FORMAT, TRADE_FIELD_NAMES and TRADE_TIME_FIELD (optional): how
+	// trades are mapped before they are handled
+	switch cfg.SymbolFormat = getEnvDefault("SYMBOL_FORMAT", SymbolKeep); cfg.SymbolFormat {
+	case SymbolKeep, SymbolStrip, SymbolSplit:
+	default:
+		return Config{}, fmt.Errorf("%q must be %q, %q or %q, got %q", "SYMBOL_FORMAT", SymbolKeep, SymbolStrip, SymbolSplit, cfg.SymbolFormat)
+	}
+	if cfg.TradeFieldNames, err = parseFieldNames("TRADE_FIELD_NAMES"); err != nil {
+		return Config{}, err
+	}
+	cfg.TradeTimeField = getEnvDefault("TRADE_TIME_FIELD", "")
+
+	// FINNHUB_KEY_COOLDOWN (optional): rest for a Finnhub key that failed
+	keyCooldownEnv := getEnvDefault("FINNHUB_KEY_COOLDOWN", defaultKeyCooldown)
+	if cfg.FinnhubKeyCooldown, err = time.ParseDuration(keyCooldownEnv); err != nil || cfg.FinnhubKeyCooldown < 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "FINNHUB_KEY_COOLDOWN", keyCooldownEnv)
+	}
+
+	// RUN_DURATION (optional): wall-clock limit on the run, 0 for none
+	runDurationEnv := getEnvDefault("RUN_DURATION", defaultRunDuration)
+	if cfg.RunDuration, err = time.ParseDuration(runDurationEnv); err != nil || cfg.RunDuration < 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "RUN_DURATION", runDurationEnv)
+	}
+
+	// FINNHUB_RECONNECT_* (optional): in-place reconnects before the Runner restarts the client
+	if cfg.FinnhubReconnectAttempts < 0 {
+		return Config{}, fmt.Errorf("%q must not be negative", "FINNHUB_RECONNECT_ATTEMPTS")
+	}
+	reconnectDelayEnv := getEnvDefault("FINNHUB_RECONNECT_MAX_DELAY", defaultReconnectMaxDelay)
+	if cfg.FinnhubReconnectMaxDelay, err = time.ParseDuration(reconnectDelayEnv); err != nil || cfg.FinnhubReconnectMaxDelay <= 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "FINNHUB_RECONNECT_MAX_DELAY", reconnectDelayEnv)
+	}
+
+	// FINNHUB_CLOSE_TIMEOUT (optional): bound on the unsubscribe and close handshake
+	closeTimeoutEnv := getEnvDefault("FINNHUB_CLOSE_TIMEOUT", defaultCloseTimeout)
+	if cfg.FinnhubCloseTimeout, err = time.ParseDuration(closeTimeoutEnv); err != nil || cfg.FinnhubCloseTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "FINNHUB_CLOSE_TIMEOUT", closeTimeoutEnv)
+	}
+
+	// FINNHUB_POLL_* (optional): REST quote polling while reconnects keep failing
+	if cfg.FinnhubPollFallbackAfter < 0 {
+		return Config{}, fmt.Errorf("%q must not be negative", "FINNHUB_POLL_FALLBACK_AFTER")
+	}
+	pollIntervalEnv := getEnvDefault("FINNHUB_POLL_INTERVAL", defaultPollInterval)
+	if cfg.FinnhubPollInterval, err = time.ParseDuration(pollIntervalEnv); err != nil || cfg.FinnhubPollInterval <= 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "FINNHUB_POLL_INTERVAL", pollIntervalEnv)
+	}
+
+	// SHUTDOWN_DRAIN_TIMEOUT (optional): total budget for draining trades and clients on shutdown
+	drainTimeoutEnv := getEnvDefault("SHUTDOWN_DRAIN_TIMEOUT", defaultDrainTimeout)
+	if cfg.ShutdownDrainTimeout, err = time.ParseDuration(drainTimeoutEnv); err != nil || cfg.ShutdownDrainTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid %q duration %q", "SHUTDOWN_DRAIN_TIMEOUT", drainTimeoutEnv)
+	}
+
 	return cfg, nil
 }
 
@@ -137,6 +831,238 @@ func parseBoolDefault(key string, def bool) bool {
 	}
 }
 
+// parseSymbolRates parses "default=X,SYMBOL=Y,..." entries, each rate
+// checked by valid; the default applies when the variable or its default
+// entry is missing
+func parseSymbolRates(key string, def float64, valid func(float64) bool) (SymbolRates, error) {
+	rates := SymbolRates{Default: def, Symbols: make(map[string]float64)}
+	for _, entry := range splitCSV(getEnvDefault(key, "")) {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return SymbolRates{}, fmt.Errorf("invalid %q entry %q, expected SYMBOL=RATE", key, entry)
+		}
+		symbol, value := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || !valid(rate) {
+			return SymbolRates{}, fmt.Errorf("invalid %q rate %q for %s", key, value, symbol)
+		}
+		if symbol == "default" {
+			rates.Default = rate
+		} else {
+			rates.Symbols[symbol] = rate
+		}
+	}
+	return rates, nil
+}
+
+// parseTradeFilters parses the trade filters, checking that no symbol's
+// price band is empty
+func parseTradeFilters() (TradeFilters, error) {
+	var filters TradeFilters
+	var err error
+	nonNegative := func(value float64) bool { return value >= 0 }
+	if filters.MinVolume, err = parseSymbolRates("TRADE_MIN_VOLUME", 0, nonNegative); err != nil {
+		return TradeFilters{}, err
+	}
+	if filters.MinPrice, err = parseSymbolRates("TRADE_MIN_PRICE", 0, nonNegative); err != nil {
+		return TradeFilters{}, err
+	}
+	if filters.MaxPrice, err = parseSymbolRates("TRADE_MAX_PRICE", 0, nonNegative); err != nil {
+		return TradeFilters{}, err
+	}
+	if filters.ExcludeConditions, err = parseSymbolCodes("TRADE_EXCLUDE_CONDITIONS"); err != nil {
+		return TradeFilters{}, err
+	}
+
+	symbols := []string{"default"}
+	symbols = append(symbols, slices.Collect(maps.Keys(filters.MinPrice.Symbols))...)
+	symbols = append(symbols, slices.Collect(maps.Keys(filters.MaxPrice.Symbols))...)
+	for _, symbol := range symbols {
+		low, high := filters.MinPrice.For(symbol), filters.MaxPrice.For(symbol)
+		if high > 0 && low > high {
+			return TradeFilters{}, fmt.Errorf("%q of %g is above %q of %g for %s", "TRADE_MIN_PRICE", low, "TRADE_MAX_PRICE", high, symbol)
+		}
+	}
+	return filters, nil
+}
+
+// parseSymbolCodes parses "default=A|B,SYMBOL=C,..." entries; the default
+// is no codes
+func parseSymbolCodes(key string) (SymbolCodes, error) {
+	codes := SymbolCodes{Default: []string{}, Symbols: make(map[string][]string)}
+	for _, entry := range splitCSV(getEnvDefault(key, "")) {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return SymbolCodes{}, fmt.Errorf("invalid %q entry %q, expected SYMBOL=CODE|CODE", key, entry)
+		}
+		symbol := strings.TrimSpace(entry[:i])
+		list := []string{}
+		for _, code := range strings.Split(entry[i+1:], "|") {
+			if code = strings.TrimSpace(code); code != "" {
+				list = append(list, code)
+			}
+		}
+		if symbol == "default" {
+			codes.Default = list
+		} else {
+			codes.Symbols[symbol] = list
+		}
+	}
+	return codes, nil
+}
+
+// parseFieldNames parses "FIELD=NAME,..." entries, each name given once
+func parseFieldNames(key string) (FieldNames, error) {
+	names := make(FieldNames)
+	for _, entry := range splitCSV(getEnvDefault(key, "")) {
+		field, name, ok := strings.Cut(entry, "=")
+		field, name = strings.TrimSpace(field), strings.TrimSpace(name)
+		if !ok || field == "" || name == "" {
+			return nil, fmt.Errorf("invalid %q entry %q, expected FIELD=NAME", key, entry)
+		}
+		if slices.Contains(slices.Collect(maps.Values(names)), name) {
+			return nil, fmt.Errorf("invalid %q entry %q, %s is already a name", key, entry, name)
+		}
+		names[field] = name
+	}
+	return names, nil
+}
+
+// parseActiveHours parses an "HH:MM-HH:MM" UTC window; unset is all day
+func parseActiveHours(key string) (ActiveHours, error) {
+	value := getEnvDefault(key, "")
+	if value == "" {
+		return ActiveHours{}, nil
+	}
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return ActiveHours{}, fmt.Errorf("invalid %q hours %q, expected HH:MM-HH:MM", key, value)
+	}
+	var hours ActiveHours
+	var err error
+	if hours.From, err = parseClock(from); err != nil {
+		return ActiveHours{}, fmt.Errorf("invalid %q hours %q, expected HH:MM-HH:MM", key, value)
+	}
+	if hours.To, err = parseClock(to); err != nil {
+		return ActiveHours{}, fmt.Errorf("invalid %q hours %q, expected HH:MM-HH:MM", key, value)
+	}
+	if hours.From == hours.To {
+		return ActiveHours{}, fmt.Errorf("invalid %q hours %q, the window is empty", key, value)
+	}
+	return hours, nil
+}
+
+// parseClock parses "HH:MM" into the time since midnight
+func parseClock(text string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// parseMarketHours parses "default=WINDOW,SYMBOL=WINDOW,..." entries in the
+// time zone named by zoneKey, where SYMBOL may be an exchange prefix such
+// as "BINANCE:"; the default window is always open
+func parseMarketHours(key string, zoneKey string) (MarketHours, error) {
+	zone := getEnvDefault(zoneKey, defaultMarketTimezone)
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return MarketHours{}, fmt.Errorf("invalid %q time zone %q", zoneKey, zone)
+	}
+	hours := MarketHours{Location: location, Windows: make(map[string]TradingWindow)}
+	for _, entry := range splitCSV(getEnvDefault(key, "")) {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return MarketHours{}, fmt.Errorf("invalid %q entry %q, expected SYMBOL=WINDOW", key, entry)
+		}
+		symbol, value := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		window, err := parseTradingWindow(value)
+		if err != nil {
+			return MarketHours{}, fmt.Errorf("invalid %q window %q for %s, %v", key, value, symbol, err)
+		}
+		if symbol == "default" {
+			hours.Default = window
+		} else {
+			hours.Windows[symbol] = window
+		}
+	}
+	return hours, nil
+}
+
+// parseTradingWindow parses "always", or days, hours or both as in
+// "Mon-Fri 09:30-16:00", the days given as ranges or days separated by "|"
+func parseTradingWindow(value string) (TradingWindow, error) {
+	var window TradingWindow
+	var days, clock string
+	switch fields := strings.Fields(value); {
+	case len(fields) == 1 && strings.EqualFold(fields[0], "always"):
+		return window, nil
+	case len(fields) == 1 && strings.Contains(fields[0], ":"):
+		clock = fields[0]
+	case len(fields) == 1:
+		days = fields[0]
+	case len(fields) == 2:
+		days, clock = fields[0], fields[1]
+	default:
+		return TradingWindow{}, fmt.Errorf("expected always or DAYS HH:MM-HH:MM")
+	}
+
+	for _, span := range strings.Split(days, "|") {
+		if span == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			to = from
+		}
+		first, err := parseWeekday(from)
+		if err != nil {
+			return TradingWindow{}, err
+		}
+		last, err := parseWeekday(to)
+		if err != nil {
+			return TradingWindow{}, err
+		}
+		// Ranges may wrap past the end of the week, such as Sun-Thu or Fri-Mon
+		for day := first; ; day = (day + 1) % 7 {
+			window.Days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	if clock == "" {
+		return window, nil
+	}
+	from, to, ok := strings.Cut(clock, "-")
+	if !ok {
+		return TradingWindow{}, fmt.Errorf("expected HH:MM-HH:MM hours")
+	}
+	var err error
+	if window.From, err = parseClock(from); err != nil {
+		return TradingWindow{}, fmt.Errorf("expected HH:MM-HH:MM hours")
+	}
+	if window.To, err = parseClock(to); err != nil {
+		return TradingWindow{}, fmt.Errorf("expected HH:MM-HH:MM hours")
+	}
+	if window.From == window.To {
+		return TradingWindow{}, fmt.Errorf("the hours are empty")
+	}
+	return window, nil
+}
+
+// parseWeekday parses a day such as "Mon" or "monday"
+func parseWeekday(text string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if name := day.String(); strings.EqualFold(text, name) || strings.EqualFold(text, name[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", text)
+}
+
 func splitCSV(s string) []string {
 	parts := strings.Split(s, ",")
 	out := make([]string, 0, len(parts))
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// mustLocation loads a time zone or fails the test
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+// mustWindow parses a trading window or fails the test
+func mustWindow(t *testing.T, value string) TradingWindow {
+	t.Helper()
+	window, err := parseTradingWindow(value)
+	if err != nil {
+		t.Fatalf("parseTradingWindow(%q) error = %v", value, err)
+	}
+	return window
+}
+
+func TestParseTradingWindow(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // As formatted by String; "" for an error
+	}{
+		{"always", "always"},
+		{"ALWAYS", "always"},
+		{"Mon-Fri 09:30-16:00", "Mon-Fri 09:30-16:00"},
+		{"monday-friday 09:30-16:00", "Mon-Fri 09:30-16:00"},
+		{"Sat|Sun", "Sat-Sun"},
+		{"Mon|Wed|Fri", "Mon|Wed|Fri"},
+		{"Fri-Mon", "Fri-Mon"},
+		{"Sun-Thu 22:00-03:00", "Sun-Thu 22:00-03:00"},
+		{"22:00-03:00", "22:00-03:00"},
+		{"Sun-Sat 09:30-16:00", "09:30-16:00"},
+		{"Mon-Fri 09:30", ""},
+		{"Funday", ""},
+		{"Mon-Fri 10:00-10:00", ""},
+		{"Mon-Fri 9am-4pm", ""},
+		{"Mon Tue 09:30-16:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			window, err := parseTradingWindow(tt.value)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("parseTradingWindow(%q) = %v, want an error", tt.value, window)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTradingWindow(%q) error = %v", tt.value, err)
+			}
+			if got := window.String(); got != tt.want {
+				t.Errorf("parseTradingWindow(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTradingWindowOpen(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day int, clock string) time.Time {
+		c, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2024, 1, day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		window string
+		at     time.Time
+		open   bool
+	}{
+		{"equities before the bell", "Mon-Fri 09:30-16:00", at(1, "09:29"), false},
+		{"equities at the bell", "Mon-Fri 09:30-16:00", at(1, "09:30"), true},
+		{"equities before the close", "Mon-Fri 09:30-16:00", at(5, "15:59"), true},
+		{"equities at the close", "Mon-Fri 09:30-16:00", at(5, "16:00"), false},
+		{"equities on Saturday", "Mon-Fri 09:30-16:00", at(6, "12:00"), false},
+		{"equities on Sunday", "Mon-Fri 09:30-16:00", at(7, "12:00"), false},
+
+		{"crypto on Saturday night", "always", at(6, "23:59"), true},
+		{"crypto at midnight", "always", at(7, "00:00"), true},
+
+		{"overnight after it opens", "Sun-Thu 22:00-03:00", at(7, "22:00"), true},
+		{"overnight before midnight", "Sun-Thu 22:00-03:00", at(7, "23:59"), true},
+		{"overnight past midnight", "Sun-Thu 22:00-03:00", at(1, "00:00"), true},
+		{"overnight before it closes", "Sun-Thu 22:00-03:00", at(1, "02:59"), true},
+		{"overnight when it closes", "Sun-Thu 22:00-03:00", at(1, "03:00"), false},
+		{"overnight in the daytime", "Sun-Thu 22:00-03:00", at(1, "12:00"), false},
+		{"overnight from Thursday into Friday", "Sun-Thu 22:00-03:00", at(5, "02:00"), true},
+		{"overnight on Friday evening", "Sun-Thu 22:00-03:00", at(5, "23:00"), false},
+		{"overnight into Saturday", "Sun-Thu 22:00-03:00", at(6, "01:00"), false},
+		{"overnight into Sunday", "Sun-Thu 22:00-03:00", at(7, "01:00"), false},
+
+		{"every day overnight", "22:00-03:00", at(6, "01:00"), true},
+		{"weekend at midnight", "Sat|Sun", at(6, "00:00"), true},
+		{"weekend on Friday night", "Sat|Sun", at(5, "23:59"), false},
+		{"week wrapping range", "Fri-Mon", at(1, "12:00"), true},
+		{"week wrapping range midweek", "Fri-Mon", at(3, "12:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := mustWindow(t, tt.window)
+			if got := window.Open(tt.at); got != tt.open {
+				t.Errorf("%q.Open(%s) = %t, want %t", tt.window, tt.at.Format("Mon 15:04"), got, tt.open)
+			}
+		})
+	}
+}
+
+func TestMarketHoursDaylightSaving(t *testing.T) {
+	newYork := mustLocation(t, "America/New_York")
+	equities := MarketHours{Location: newYork, Default: mustWindow(t, "Mon-Fri 09:30-16:00")}
+	overnight := MarketHours{Location: newYork, Default: mustWindow(t, "22:00-03:00")}
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		hours MarketHours
+		at    time.Time
+		open  bool
+	}{
+		// Clocks go forward on Sunday 10 March 2024, so the bell moves from 14:30 to 13:30 UTC
+		{"bell in EST", equities, utc(time.March, 8, 14, 30), true},
+		{"before the bell in EST", equities, utc(time.March, 8, 14, 29), false},
+		{"bell in EDT", equities, utc(time.March, 11, 13, 30), true},
+		{"before the bell in EDT", equities, utc(time.March, 11, 13, 29), false},
+		{"close in EDT", equities, utc(time.March, 11, 20, 0), false},
+		{"EST close hour in EDT", equities, utc(time.March, 11, 20, 59), false},
+		// And back on Sunday 3 November 2024
+		{"bell back in EST", equities, utc(time.November, 4, 14, 30), true},
+		{"EDT bell back in EST", equities, utc(time.November, 4, 13, 30), false},
+		// An overnight window spanning the change closes at 03:00 local either side of it
+		{"overnight before spring forward", overnight, utc(time.March, 10, 6, 59), true},
+		{"overnight after spring forward", overnight, utc(time.March, 10, 7, 0), false},
+		{"overnight before fall back", overnight, utc(time.November, 3, 5, 30), true},
+		{"overnight during the repeated hour", overnight, utc(time.November, 3, 6, 30), true},
+		{"overnight after fall back", overnight, utc(time.November, 3, 8, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hours.Open("AAPL", tt.at); got != tt.open {
+				t.Errorf("Open(%s, %s local) = %t, want %t",
+					tt.at.Format(time.RFC3339), tt.at.In(newYork).Format("Mon 15:04 MST"), got, tt.open)
+			}
+		})
+	}
+}
+
+func TestParseMarketHours(t *testing.T) {
+	t.Setenv("MARKET_HOURS", "default=Mon-Fri 09:30-16:00,BINANCE:=always,BINANCE:ETHUSDT=Mon-Fri,TSLA=10:00-15:00")
+	t.Setenv("MARKET_TIMEZONE", "America/New_York")
+	hours, err := parseMarketHours("MARKET_HOURS", "MARKET_TIMEZONE")
+	if err != nil {
+		t.Fatalf("parseMarketHours() error = %v", err)
+	}
+	if got := hours.Location.String(); got != "America/New_York" {
+		t.Errorf("Location = %s, want America/New_York", got)
+	}
+	if !hours.Gated() {
+		t.Error("Gated() = false, want true")
+	}
+
+	// Saturday noon and Monday 11:00 in New York
+	saturday := time.Date(2024, 1, 6, 12, 0, 0, 0, hours.Location)
+	monday := time.Date(2024, 1, 8, 11, 0, 0, 0, hours.Location)
+	tests := []struct {
+		symbol     string
+		window     string
+		onSaturday bool
+		onMonday   bool
+	}{
+		{"AAPL", "Mon-Fri 09:30-16:00", false, true},
+		{"TSLA", "10:00-15:00", true, true},
+		{"BINANCE:BTCUSDT", "always", true, true},
+		{"BINANCE:ETHUSDT", "Mon-Fri", false, true},
+		{"COINBASE:BTC-USD", "Mon-Fri 09:30-16:00", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			if got := hours.For(tt.symbol).String(); got != tt.window {
+				t.Errorf("For(%s) = %q, want %q", tt.symbol, got, tt.window)
+			}
+			if got := hours.Open(tt.symbol, saturday); got != tt.onSaturday {
+				t.Errorf("Open(%s) on Saturday = %t, want %t", tt.symbol, got, tt.onSaturday)
+			}
+			if got := hours.Open(tt.symbol, monday); got != tt.onMonday {
+				t.Errorf("Open(%s) on Monday = %t, want %t", tt.symbol, got, tt.onMonday)
+			}
+		})
+	}
+}
+
+func TestParseMarketHoursUngated(t *testing.T) {
+	for _, value := range []string{"", "default=always", "default=always,BINANCE:=always"} {
+		t.Setenv("MARKET_HOURS", value)
+		hours, err := parseMarketHours("MARKET_HOURS", "MARKET_TIMEZONE")
+		if err != nil {
+			t.Fatalf("parseMarketHours(%q) error = %v", value, err)
+		}
+		if hours.Gated() {
+			t.Errorf("parseMarketHours(%q).Gated() = true, want false", value)
+		}
+		if !hours.Open("AAPL", time.Date(2024, 1, 6, 3, 0, 0, 0, time.UTC)) {
+			t.Errorf("parseMarketHours(%q) closed AAPL", value)
+		}
+	}
+}
+
+func TestParseMarketHoursErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		hours string
+		zone  string
+	}{
+		{"unknown zone", "default=always", "Mars/Olympus_Mons"},
+		{"missing window", "AAPL", ""},
+		{"empty symbol", "=always", ""},
+		{"bad window", "AAPL=Mon-Fri 9-5", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MARKET_HOURS", tt.hours)
+			t.Setenv("MARKET_TIMEZONE", tt.zone)
+			if _, err := parseMarketHours("MARKET_HOURS", "MARKET_TIMEZONE"); err == nil {
+				t.Errorf("parseMarketHours(%q, %q) succeeded, want an error", tt.hours, tt.zone)
+			}
+		})
+	}
+}
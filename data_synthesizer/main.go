@@ -2,104 +2,343 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"data_synthesizer/config"
+	"data_synthesizer/service/buildinfo"
 	"data_synthesizer/service/finnhub"
 	"data_synthesizer/service/metrics"
+	"data_synthesizer/service/readiness"
 	"data_synthesizer/service/veramo"
 	"data_synthesizer/service/websocket"
 )
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(http.StatusOK)
-    w.Write([]byte(`{"status": "healthy"}`))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "healthy"}`))
+}
+
+// InfoResponse is returned by GET /info
+type InfoResponse struct {
+	Build       buildinfo.Info      `json:"build"`
+	Config      map[string]string   `json:"config"`       // Effective settings by environment variable, secrets redacted
+	Filters     config.TradeFilters `json:"filters"`      // Trade filters by symbol, as parsed from the settings
+	MarketHours config.MarketHours  `json:"market_hours"` // Trading windows by symbol or exchange
+}
+
+// infoHandler reports the running build and its effective configuration
+func infoHandler(cfg config.Config) http.HandlerFunc {
+	body, _ := json.Marshal(InfoResponse{Build: buildinfo.Get(), Config: cfg.Redacted(), Filters: cfg.TradeFilters, MarketHours: cfg.MarketHours})
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// envFlag is a command-line flag that sets its environment variable, so a
+// flag goes through exactly the parsing config.LoadConfig applies to the
+// environment and overrides both it and .env
+type envFlag struct {
+	setting config.Setting
+}
+
+func (f *envFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.setting.Default
+}
+
+func (f *envFlag) Set(value string) error {
+	return os.Setenv(f.setting.Env, value)
+}
+
+// IsBoolFlag lets boolean settings be given as --flag, meaning true
+func (f *envFlag) IsBoolFlag() bool {
+	return f.setting.Bool
+}
+
+// flagName is the flag for an environment variable, e.g. MESSAGE_COUNT -> message-count
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// parseFlags registers a flag for every non-secret setting and applies the
+// ones given: flags override the environment, which overrides the defaults
+func parseFlags() {
+	for _, setting := range config.Settings {
+		if setting.Secret {
+			continue
+		}
+		flag.Var(&envFlag{setting: setting}, flagName(setting.Env),
+			fmt.Sprintf("%s (env %s)", setting.Usage, setting.Env))
+	}
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Flags override environment variables and .env, which override the defaults.")
+		for _, setting := range config.Settings {
+			if setting.Secret {
+				fmt.Fprintf(out, "%s is only read from the environment: %s\n", setting.Env, setting.Usage)
+			}
+		}
+		fmt.Fprintln(out)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+// newAppMux routes the app server. It has its own mux so /metrics is only
+// served on METRICS_PORT. The subscriptions API is mounted once the
+// identities are bootstrapped.
+func newAppMux(cfg config.Config, ready *readiness.Tracker) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/ready", ready.Handler)
+	mux.HandleFunc("/info", infoHandler(cfg))
+	mux.HandleFunc("/ws", websocket.HandleWebSocket)
+	return mux
+}
+
+// handleSubscriptions mounts the runtime subscriptions API on mux
+func handleSubscriptions(mux *http.ServeMux, api *finnhub.SubscriptionsAPI) {
+	mux.HandleFunc("GET /subscriptions", api.List)
+	mux.HandleFunc("POST /subscriptions", api.Add)
+	mux.HandleFunc("DELETE /subscriptions/{symbol}", api.Remove)
 }
 
 func main() {
+	parseFlags()
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err) // centralized fatal handling
 	}
 
+	build := buildinfo.Get()
+	log.Printf("🏷️ %s", build)
 	log.Printf("KMS: %s", cfg.KMS)
 	log.Printf("Veramo URL: %s", cfg.VeramoURL)
 	log.Printf("DidProvider: %s", cfg.DidProvider)
+	log.Printf("Data source: %s", cfg.DataSource)
+	if cfg.RunDuration > 0 {
+		log.Printf("Run duration: %s", cfg.RunDuration)
+	}
 
 	// Initialize prometheus metrics
 	metrics.Initialize(&cfg)
+	metrics.BuildInfo.WithLabelValues(build.Version, build.Revision, build.GoVersion).Set(1)
 
 	// Create context for graceful shutdown
 	ctx, cancel := signal.NotifyContext(context.Background(),
 		os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
+	// /health is liveness only; /ready answers 503 until every ticker has its identity
+	ready := readiness.NewTracker(cfg.Tickers)
+
+	log.Printf("Health server running on http://localhost:%s/health", cfg.Port)
+	log.Printf("Readiness check running on http://localhost:%s/ready", cfg.Port)
+	log.Printf("WebSocket server started on ws://localhost:%s/ws", cfg.Port)
+	// Start HTTP server
+	mux := newAppMux(cfg, ready)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.Port),
+		Handler: mux,
+	}
+
+	var wg sync.WaitGroup
+
+	// Start HTTP server in goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer log.Printf("✔ Done: HTTP server stopped.")
+		log.Printf("Starting HTTP server on :%s", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	// Bootstrap after the server is up, so readiness is observable meanwhile
 	veramoClient := veramo.NewClient(&cfg)
 
-	identity, err := veramo.BootstrapDevice(veramoClient, cfg.KMS, cfg.DidProvider, cfg.Tickers, cfg.DidWebHost, cfg.DidWebProject)
+	identity, err := veramo.BootstrapDevice(veramoClient, cfg.KMS, cfg.DidProvider, cfg.Tickers, cfg.SymbolFormat, cfg.DidWebHost, cfg.DidWebProject, ready.CredentialCreated)
 	if err != nil {
 		log.Fatalf("❌ Error initializing identity: %v", err)
 	}
+	ready.MarkBootstrapped()
+	log.Printf("🔐 Number of credentials: %d...", identity.CredentialCount())
 
+	// Trades are sharded by symbol across the pool's processors, with a
+	// worker each in async mode
+	handler := finnhub.NewProcessorPool(cfg.TradeProcessors, identity, &cfg)
+	log.Printf("Trade processors: %d (%s processing)", handler.Size(), cfg.ProcessingMode)
 
-	handler := finnhub.NewTradeProcessor(identity, &cfg)
-	metrics.ActiveTradeProcessors.Inc()
+	// Create and configure the supervised data source: live Finnhub or a replay.
+	// Subscriptions start from TICKERS and can change at runtime.
+	subscriptions := finnhub.NewSubscriptions(cfg.Tickers)
+	subscriptions.SetQuota(cfg.MessageCountPerSymbol)
 
-	// Create and configure client
-	client := finnhub.NewFinnhubClient(cfg.ApiKey, cfg.Tickers, cfg.MessageCount, handler)
+	// Sampling and rate limits are shared across restarts
+	throttle := finnhub.NewThrottle(cfg.Sampling, cfg.TradeRateLimit)
+	log.Printf("Trade throttling by default: %s", throttle.Describe(""))
+	for _, ticker := range cfg.Tickers {
+		log.Printf("Trade throttling for %s: %s", ticker, throttle.Describe(ticker))
+	}
 
-    log.Printf("Health server running on http://localhost:%s/health", cfg.Port)
-    log.Printf("WebSocket server started on ws://localhost:%s/ws", cfg.Port)
-    log.Printf("🔐 Number of credentials: %d...", len(identity.Credentials))
-	http.HandleFunc("/health", healthHandler)
-    http.HandleFunc("/ws", websocket.HandleWebSocket)
+	// Filters run before sampling, so filtered trades cost no rate limit
+	filter := finnhub.NewFilter(cfg.TradeFilters)
+	log.Printf("Trade filters by default: %s", filter.Describe(""))
+	for _, ticker := range cfg.Tickers {
+		log.Printf("Trade filters for %s: %s", ticker, filter.Describe(ticker))
+	}
 
-	// Start HTTP server
-    server := &http.Server{
-        Addr:    fmt.Sprintf(":%s", cfg.Port),
-        Handler: nil,
-    }
+	// Market hours gate the live connection only; replays and synthetic
+	// trades aren't happening now, so they play whatever the time
+	var schedule *finnhub.Schedule
+	switch {
+	case !cfg.MarketHours.Gated():
+	case cfg.DataSource != config.DataSourceFinnhub:
+		log.Printf("⚠️ MARKET_HOURS is only used with the %s data source", config.DataSourceFinnhub)
+	default:
+		schedule = finnhub.NewSchedule(cfg.MarketHours, cfg.MarketHoursMode == config.MarketHoursUnsubscribe)
+		log.Printf("Gating tickers by market hours, with MARKET_HOURS_MODE=%s", cfg.MarketHoursMode)
+		for _, ticker := range cfg.Tickers {
+			log.Printf("Market hours for %s: %s", ticker, schedule.Describe(ticker))
+		}
+	}
 
-	var wg sync.WaitGroup
+	// Like the throttle, trades seen are remembered across restarts, since a
+	// new connection may send the last ones again
+	var dedup *finnhub.Dedup
+	if cfg.DedupWindow > 0 {
+		dedup = finnhub.NewDedup(cfg.DedupWindow, cfg.DedupSize)
+		log.Printf("Deduplicating Finnhub trades within %s (up to %d trades)", cfg.DedupWindow, cfg.DedupSize)
+	}
 
-	// Start HTTP server in goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer log.Printf("✔ Done: HTTP server stopped.")
-        log.Printf("Starting HTTP server on :%s", cfg.Port)
-        if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-            log.Printf("HTTP server error: %v", err)
-        }
-    }()
+	// One recording for the run, across restarts; closed once the runner stops
+	var recorder *finnhub.Recorder
+	switch {
+	case cfg.RecordFile == "":
+	case cfg.DataSource != config.DataSourceFinnhub:
+		log.Printf("⚠️ RECORD_FILE is only used with the %s data source", config.DataSourceFinnhub)
+	default:
+		if recorder, err = finnhub.NewRecorder(cfg.RecordFile, int64(cfg.RecordMaxMB)<<20); err != nil {
+			log.Fatalf("Failed to start recording: %v", err)
+		}
+		log.Printf("Recording Finnhub frames to %s", cfg.RecordFile)
+	}
 
-	// Start Finnhub client in goroutine
+	// Trades reach the handler with symbols normalized as their credentials
+	// were bootstrapped
+	mapping, err := finnhub.NewTradeMapping(cfg.SymbolFormat, cfg.TradeFieldNames, cfg.TradeTimeField)
+	if err != nil {
+		log.Fatalf("Invalid trade mapping: %v", err)
+	}
+
+	// Like the throttle, which key is in use and which are cooling down is
+	// kept across restarts
+	keys := finnhub.NewKeyRing(cfg.ApiKeys, cfg.FinnhubKeyCooldown)
+	if len(cfg.ApiKeys) > 1 {
+		log.Printf("Rotating through %d Finnhub API keys, each cooling down for %s after failing", len(cfg.ApiKeys), cfg.FinnhubKeyCooldown)
+	}
+
+	newSource := func(maxMessages int) finnhub.DataSource {
+		client := finnhub.NewFinnhubClient(keys, subscriptions, maxMessages, handler)
+		client.SetReconnectPolicy(cfg.FinnhubReconnectAttempts, cfg.FinnhubReconnectMaxDelay)
+		client.SetCloseTimeout(cfg.FinnhubCloseTimeout)
+		client.SetCompression(cfg.FinnhubCompression)
+		client.SetPollingFallback(cfg.FinnhubPollFallbackAfter, cfg.FinnhubPollInterval)
+		client.SetThrottle(throttle)
+		client.SetFilter(filter)
+		client.SetSchedule(schedule)
+		client.SetDedup(dedup)
+		client.SetRecorder(recorder)
+		client.SetWatchdog(cfg.TradeSilenceTimeout, cfg.TradeStartupTimeout, cfg.TradeSilenceHours)
+		client.SetPerTradeDispatch(cfg.TradeDispatch == config.DispatchTrade)
+		client.SetTradeMapping(mapping)
+		return client
+	}
+	switch cfg.DataSource {
+	case config.DataSourceFile:
+		newSource = func(maxMessages int) finnhub.DataSource {
+			client := finnhub.NewReplayClient(cfg.ReplayFile, cfg.ReplaySpeed, cfg.ReplayLoops, subscriptions, maxMessages, handler)
+			client.SetThrottle(throttle)
+			client.SetFilter(filter)
+			client.SetPerTradeDispatch(cfg.TradeDispatch == config.DispatchTrade)
+			client.SetTradeMapping(mapping)
+			return client
+		}
+	case config.DataSourceSynthetic:
+		// A random seed is logged on Connect, so the run can be reproduced
+		// by setting SYNTHETIC_SEED to it
+		seed := uint64(cfg.SyntheticSeed)
+		if seed == 0 {
+			seed = uint64(rand.IntN(math.MaxInt)) + 1
+		}
+		newSource = func(maxMessages int) finnhub.DataSource {
+			client := finnhub.NewSyntheticClient(cfg.SyntheticRate, seed, cfg.SyntheticVolumeMean, cfg.SyntheticVolumeSigma, subscriptions, maxMessages, handler)
+			client.SetThrottle(throttle)
+			client.SetFilter(filter)
+			client.SetPerTradeDispatch(cfg.TradeDispatch == config.DispatchTrade)
+			client.SetTradeMapping(mapping)
+			return client
+		}
+	}
+	runner := finnhub.NewRunner(newSource, subscriptions, cfg.MessageCount, cfg.FinnhubMaxRestarts, cfg.RunDuration, handler)
+	handleSubscriptions(mux, finnhub.NewSubscriptionsAPI(runner, subscriptions, identity))
+	log.Printf("Subscriptions API running on http://localhost:%s/subscriptions", cfg.Port)
+
+	// Start Finnhub client in goroutine; running out of restarts shuts the service down
+	var runErr error
+	runnerDone := make(chan struct{})
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer close(runnerDone)
 		defer log.Printf("✔ Done: WebSocket client stopped.")
-		// Connect to WebSocket
-		if err := client.Connect(ctx); err != nil {
-			log.Printf("Failed to connect: %v", err)
+		if err := runner.Run(ctx); err != nil {
+			log.Printf("❌ Client error: %v", err)
+			runErr = err
+			cancel()
 		}
 
-		// Start processing messages
-		if err := client.Start(ctx); err != nil {
-			log.Printf("Client error: %v", err)
+		log.Printf("Processed %d messages. Client stopped (%s).", runner.GetMessageCount(), runner.StopReason())
+		for _, status := range subscriptions.Status() {
+			if status.Failed != "" {
+				log.Printf("  %s: %d messages, failed: %s", status.Symbol, status.Messages, status.Failed)
+				continue
+			}
+			log.Printf("  %s: %d messages", status.Symbol, status.Messages)
+		}
+		if recorder != nil {
+			if err := recorder.Close(); err != nil {
+				log.Printf("Error closing recording: %v", err)
+			}
 		}
-
-		log.Printf("Processed %d messages. Client stopped.", client.GetMessageCount())
 	}()
 
-	go metrics.StartMetricsServer(cfg.MetricsPort)
+	if cfg.EnablePprof {
+		runtime.SetBlockProfileRate(cfg.PprofBlockRate)
+		runtime.SetMutexProfileFraction(cfg.PprofMutexFraction)
+		log.Printf("pprof running on http://localhost:%s/debug/pprof/ (block rate %d, mutex fraction %d)",
+			cfg.MetricsPort, cfg.PprofBlockRate, cfg.PprofMutexFraction)
+	}
+	metricsServer := metrics.StartMetricsServer(cfg.MetricsPort, cfg.EnablePprof)
 
 	// Wait for either shutdown signal or goroutines to complete
 	done := make(chan struct{})
@@ -109,13 +348,27 @@ func main() {
 	}()
 
 	select {
-		case <-ctx.Done():
-			log.Println("Shutdown signal received, starting graceful shutdown...")
-		case <-done:
-			log.Println("All services completed, starting graceful shutdown...")
-			cancel() // Cancel context to stop any remaining operations
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, starting graceful shutdown...")
+	case <-done:
+		log.Println("All services completed, starting graceful shutdown...")
+		cancel() // Cancel context to stop any remaining operations
 	}
 
+	// Drain within SHUTDOWN_DRAIN_TIMEOUT: the cancelled context has stopped the
+	// Finnhub client reading, and the runner closes the TradeProcessor, which
+	// waits for in-flight trades. Then flush what they broadcast and close the
+	// WebSocket clients with a close frame.
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownDrainTimeout)
+	defer drainCancel()
+	select {
+	case <-runnerDone:
+	case <-drainCtx.Done():
+		log.Printf("⚠️ Trade processing still running at the drain deadline")
+	}
+	dropped := websocket.Shutdown(drainCtx)
+	log.Printf("✔ Done: WebSocket clients closed, %d messages dropped.", dropped)
+
 	// Shutdown HTTP server gracefully
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
@@ -123,8 +376,14 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Metrics server shutdown error: %v", err)
+	}
 
 	// Ensure all goroutines are done
 	<-done
+	if runErr != nil {
+		log.Fatalf("❌ Data source gave up: %v", runErr)
+	}
 	log.Println("Application shutdown complete")
 }
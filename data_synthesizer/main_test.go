@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"data_synthesizer/config"
+	"data_synthesizer/service/readiness"
+)
+
+// TestAppMuxLeavesMetricsToMetricsServer checks that metrics and profiles
+// are only served on the metrics port
+func TestAppMuxLeavesMetricsToMetricsServer(t *testing.T) {
+	cfg := config.Config{EnablePprof: true}
+	mux := newAppMux(cfg, readiness.NewTracker(nil))
+	for _, path := range []string{"/metrics", "/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/profile"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /health = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
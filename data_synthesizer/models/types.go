@@ -13,6 +13,7 @@ type FinnhubTradeRaw struct {
 	Symbol          string   `json:"s"`
 	Event_Timestamp int64    `json:"t"`
 	Volume          float64  `json:"v"`
+	Source          string   `json:"source,omitempty"` // Set for trades not read from the WebSocket
 }
 
 type FinnhubTrade struct {
@@ -22,8 +23,18 @@ type FinnhubTrade struct {
 	Symbol          string
 	Event_Timestamp int64
 	Volume          float64
+	Source          string `json:",omitempty"`
+	Exchange        string `json:",omitempty"` // Split off Symbol with SYMBOL_FORMAT=split
+
+	// Trade data as sent downstream, when the trade mapping renames or
+	// adds fields; nil sends the fields above
+	Fields map[string]interface{} `json:"-"`
 }
 
+// TradeSourceQuote marks trades synthesized from the Finnhub REST quote
+// endpoint while the WebSocket is unavailable
+const TradeSourceQuote = "rest_quote"
+
 func (t *FinnhubTradeRaw) EnsureDefaults() {
 	if t.Trade_Id == "" {
 		t.Trade_Id = uuid.NewString()
@@ -37,6 +48,7 @@ func (t *FinnhubTradeRaw) EnsureDefaults() {
 type TradeMessage struct {
 	Data []FinnhubTradeRaw `json:"data"`
 	Type string            `json:"type"`
+	Msg  string            `json:"msg,omitempty"` // Error frames only
 }
 
 // SubscribeMessage represents the subscription message format
@@ -48,7 +60,9 @@ type SubscribeMessage struct {
 // TradeHandler defines the interface for handling trade data
 type TradeHandler interface {
 	HandleTrade(trade FinnhubTrade, startTimestamp time.Time) error
-	HandleBatch(trades []FinnhubTrade, startTimestamp time.Time) error
+	// HandleBatch handles the trades of a frame, returning an error for each
+	// trade by index, nil for those handled
+	HandleBatch(trades []FinnhubTrade, startTimestamp time.Time) []error
 	Close() error
 }
 
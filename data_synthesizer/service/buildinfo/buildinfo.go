@@ -0,0 +1,58 @@
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Version is set at build time:
+//
+//	go build -ldflags "-X data_synthesizer/service/buildinfo.Version=v1.2.3"
+var Version = "dev"
+
+// Info identifies the build that is running
+type Info struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision"` // VCS commit, "unknown" when built outside a checkout
+	Dirty      bool   `json:"dirty"`    // Built with uncommitted changes
+	CommitTime string `json:"commit_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build information, read once from the binary
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, Revision: "unknown", GoVersion: runtime.Version()}
+		build, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.modified":
+				info.Dirty = setting.Value == "true"
+			case "vcs.time":
+				info.CommitTime = setting.Value
+			}
+		}
+	})
+	return info
+}
+
+// String is the startup banner line
+func (i Info) String() string {
+	revision := i.Revision
+	if i.Dirty {
+		revision += "-dirty"
+	}
+	return fmt.Sprintf("data_synthesizer %s (revision %s, %s)", i.Version, revision, i.GoVersion)
+}
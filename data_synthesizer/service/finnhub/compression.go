@@ -0,0 +1,50 @@
+package finnhub
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"data_synthesizer/service/metrics"
+)
+
+// Read buffer for compressed connections, so a frame is inflated from few
+// large reads rather than many small ones
+const compressedReadBuffer = 64 << 10
+
+// countingConn counts the bytes read off the network, before they are
+// decrypted and inflated, under the "wire" received bytes
+type countingConn struct {
+	net.Conn
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	metrics.FinnhubReceivedBytes.WithLabelValues("wire").Add(float64(n))
+	return n, err
+}
+
+// dialCounting dials like the WebSocket dialer does by default, counting
+// the bytes read from the connection
+func dialCounting(ctx context.Context, network string, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn}, nil
+}
+
+// negotiatedDeflate reports whether the server accepted permessage-deflate
+// in its handshake response
+func negotiatedDeflate(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	for _, extensions := range resp.Header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(extensions, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
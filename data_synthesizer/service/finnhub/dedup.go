@@ -0,0 +1,81 @@
+package finnhub
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"data_synthesizer/models"
+)
+
+// Status counted in TradesProcessedTotal for trades the Dedup drops
+const statusDuplicate = "duplicate"
+
+// Dedup drops trades seen within a sliding window, such as the ones Finnhub
+// sends again after a reconnect. It remembers at most size trades, so
+// memory stays flat however busy the symbols are.
+type Dedup struct {
+	window time.Duration
+	size   int
+
+	mu    sync.Mutex
+	seen  map[string]*list.Element
+	order *list.List // dedupEntry values, oldest first
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// NewDedup creates a dedup remembering up to size trades for window each
+func NewDedup(window time.Duration, size int) *Dedup {
+	return &Dedup{
+		window: window,
+		size:   size,
+		seen:   make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// Duplicate reports whether the trade arriving at now was already seen
+// within the window, remembering it otherwise
+func (d *Dedup) Duplicate(record models.FinnhubTradeRaw, now time.Time) bool {
+	key := dedupKey(record)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Forget the trades that left the window
+	for front := d.order.Front(); front != nil; front = d.order.Front() {
+		entry := front.Value.(dedupEntry)
+		if now.Sub(entry.seen) < d.window {
+			break
+		}
+		d.forget(front)
+	}
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+
+	if d.order.Len() >= d.size {
+		d.forget(d.order.Front())
+	}
+	d.seen[key] = d.order.PushBack(dedupEntry{key: key, seen: now})
+	return false
+}
+
+func (d *Dedup) forget(element *list.Element) {
+	delete(d.seen, element.Value.(dedupEntry).key)
+	d.order.Remove(element)
+}
+
+// dedupKey identifies a trade by its id or, as Finnhub trades usually come
+// without one, by what it is made of
+func dedupKey(record models.FinnhubTradeRaw) string {
+	if record.Trade_Id != "" {
+		return "id:" + record.Trade_Id
+	}
+	return fmt.Sprintf("%s|%d|%g|%g", record.Symbol, record.Event_Timestamp, record.Price, record.Volume)
+}
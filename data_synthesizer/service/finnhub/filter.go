@@ -0,0 +1,68 @@
+package finnhub
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"data_synthesizer/config"
+	"data_synthesizer/models"
+)
+
+// Status counted in TradesProcessedTotal for trades the Filter drops
+const statusFiltered = "filtered"
+
+// Filter drops trades that aren't economically meaningful, such as odd
+// lots or trades with excluded condition codes, before they cost sampling,
+// rate limits or signing
+type Filter struct {
+	filters config.TradeFilters
+}
+
+// NewFilter creates a filter with the given per-symbol filters
+func NewFilter(filters config.TradeFilters) *Filter {
+	return &Filter{filters: filters}
+}
+
+// Allow reports whether the trade passes its symbol's filters. REST quotes
+// carry no volume, so the minimum volume doesn't apply to them.
+func (f *Filter) Allow(record models.FinnhubTradeRaw) bool {
+	symbol := record.Symbol
+	if minVolume := f.filters.MinVolume.For(symbol); minVolume > 0 && record.Source != models.TradeSourceQuote && record.Volume < minVolume {
+		return false
+	}
+	if minPrice := f.filters.MinPrice.For(symbol); minPrice > 0 && record.Price < minPrice {
+		return false
+	}
+	if maxPrice := f.filters.MaxPrice.For(symbol); maxPrice > 0 && record.Price > maxPrice {
+		return false
+	}
+	excluded := f.filters.ExcludeConditions.For(symbol)
+	return !slices.ContainsFunc(record.Trade_Condition, func(code string) bool {
+		return slices.Contains(excluded, code)
+	})
+}
+
+// Describe returns the effective filters for the symbol
+func (f *Filter) Describe(symbol string) string {
+	var rules []string
+	if minVolume := f.filters.MinVolume.For(symbol); minVolume > 0 {
+		rules = append(rules, fmt.Sprintf("volume at least %g", minVolume))
+	}
+	minPrice, maxPrice := f.filters.MinPrice.For(symbol), f.filters.MaxPrice.For(symbol)
+	switch {
+	case minPrice > 0 && maxPrice > 0:
+		rules = append(rules, fmt.Sprintf("price between %g and %g", minPrice, maxPrice))
+	case minPrice > 0:
+		rules = append(rules, fmt.Sprintf("price at least %g", minPrice))
+	case maxPrice > 0:
+		rules = append(rules, fmt.Sprintf("price at most %g", maxPrice))
+	}
+	if excluded := f.filters.ExcludeConditions.For(symbol); len(excluded) > 0 {
+		rules = append(rules, "excluding conditions "+strings.Join(excluded, ", "))
+	}
+	if len(rules) == 0 {
+		return "no filters"
+	}
+	return strings.Join(rules, ", ")
+}
@@ -3,19 +3,23 @@ package finnhub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"data_synthesizer/config"
 	"data_synthesizer/models"
 	"data_synthesizer/service/metrics"
 )
 
-
 const (
 	// WebSocket connection timeout
 	dialTimeout = 10 * time.Second
@@ -23,144 +27,630 @@ const (
 	writeTimeout = 10 * time.Second
 	// Read timeout for receiving messages
 	readTimeout = 60 * time.Second
+	// Reconnect attempts after the connection drops, before Start gives up
+	defaultReconnectAttempts = 5
+	// Longest delay between reconnect attempts
+	defaultReconnectMaxDelay = 30 * time.Second
+	// Budget for unsubscribing and the close handshake on shutdown
+	defaultCloseTimeout = 5 * time.Second
+)
+
+var (
+	// Finnhub WebSocket endpoint, dialled with the API key appended; tests
+	// point it at a local server
+	finnhubURL = "wss://ws.finnhub.io?token="
+	// Interval between pings on an open connection
+	pingInterval = 30 * time.Second
 )
 
+// ErrConnectionLost is returned by Start when the Finnhub connection fails or
+// is closed by the server and reconnecting doesn't bring it back
+var ErrConnectionLost = errors.New("finnhub connection lost")
+
+// errTradesSilent is reported by the watchdog when a connection that is
+// otherwise up stops delivering trades
+var errTradesSilent = errors.New("no trades received")
+
+// errKeyRotated is reported by the reader when an error frame moved the
+// key ring on, so the connection is made again with the next key
+var errKeyRotated = errors.New("switching Finnhub API key")
+
 type FinnhubClient struct {
-	apiKey       string
-	tickers      []string
+	keys         *KeyRing
+	symbols      *Subscriptions
 	maxMessages  int
 	messageCount int
-	keyName      string
-	columnMap    map[string]string
 	wsConn       *websocket.Conn
+	subscribed   []string   // Tickers subscribed on wsConn
+	writeMu      sync.Mutex // Guards wsConn, subscribed and every write through writeLocked
 	mu           sync.RWMutex
 	tradeHandler models.TradeHandler
+
+	reconnectAttempts int
+	reconnectMaxDelay time.Duration
+	closeTimeout      time.Duration
+	throttle          *Throttle // Drops trades before they are handled; nil keeps them all
+	perTrade          bool      // Dispatch trades one by one instead of a batch per frame
+	compression       bool      // Offer permessage-deflate when connecting
+	filter            *Filter   // Drops trades that aren't meaningful; nil keeps them all
+	schedule          *Schedule // Gates symbols outside their market hours; nil never does
+
+	poller    *QuotePoller // Polls REST quotes while reconnects keep failing; nil never polls
+	pollAfter int          // Failed reconnects in a row before polling starts
+	dedup     *Dedup       // Drops trades seen recently; nil keeps them all
+	recorder  *Recorder    // Records every frame read; nil records none
+
+	silenceTimeout time.Duration      // Reconnect after this long without trades; 0 never does
+	startupTimeout time.Duration      // Reconnect when the first trade takes this long; 0 waits
+	silenceHours   config.ActiveHours // When the timeouts apply
+	lastTrade      atomic.Int64       // Unix ns of the last trade frame, 0 before the first
+	connectedAt    time.Time          // When wsConn was connected
+
+	rateLimits int           // Rate limit errors since the last trade frame; reader only
+	pause      time.Duration // Backoff the reader owes for a rate limit error; reader only
+
+	mapping *TradeMapping // Maps raw trades for the handler
+	rotated bool          // An error frame rotated the key; reader only
 }
 
-// NewFinnhubClient creates a new Finnhub WebSocket client
-func NewFinnhubClient(apiKey string, tickers []string, maxMessages int, handler models.TradeHandler) *FinnhubClient {
+// NewFinnhubClient creates a new Finnhub WebSocket client handling the
+// symbols in subscriptions, connecting with the ring's current key
+func NewFinnhubClient(keys *KeyRing, subscriptions *Subscriptions, maxMessages int, handler models.TradeHandler) *FinnhubClient {
 	return &FinnhubClient{
-		apiKey:      apiKey,
-		tickers:     tickers,
-		maxMessages: maxMessages,
-		keyName:     "Symbol",
-		columnMap: map[string]string{
-			"c": "Trade_Condition",
-			"p": "Price",
-			"s": "Symbol",
-			"t": "Event_Timestamp",
-			"v": "Volume",
-		},
-		tradeHandler: handler,
+		keys:              keys,
+		symbols:           subscriptions,
+		maxMessages:       maxMessages,
+		tradeHandler:      handler,
+		reconnectAttempts: defaultReconnectAttempts,
+		reconnectMaxDelay: defaultReconnectMaxDelay,
+		closeTimeout:      defaultCloseTimeout,
+		mapping:           finnhubMapping,
 	}
 }
 
+// SetReconnectPolicy sets how many times Start reconnects after the
+// connection drops (0 = never) and the longest delay between attempts
+func (fc *FinnhubClient) SetReconnectPolicy(attempts int, maxDelay time.Duration) {
+	fc.reconnectAttempts = attempts
+	fc.reconnectMaxDelay = maxDelay
+}
+
+// SetCloseTimeout bounds unsubscribing and the close handshake on shutdown
+func (fc *FinnhubClient) SetCloseTimeout(timeout time.Duration) {
+	fc.closeTimeout = timeout
+}
+
+// SetThrottle samples and rate limits trades per symbol before they are handled
+func (fc *FinnhubClient) SetThrottle(throttle *Throttle) {
+	fc.throttle = throttle
+}
+
+// SetFilter drops trades that don't pass the filter's per-symbol filters,
+// before they are sampled and handled
+func (fc *FinnhubClient) SetFilter(filter *Filter) {
+	fc.filter = filter
+}
+
+// SetSchedule drops the trades of symbols outside their market hours, and
+// unsubscribes from them until their window opens if the schedule says so
+func (fc *FinnhubClient) SetSchedule(schedule *Schedule) {
+	fc.schedule = schedule
+}
+
+// SetPollingFallback polls Finnhub REST quotes every interval once after
+// reconnects have failed in a row, until the WebSocket is back (0 = never)
+func (fc *FinnhubClient) SetPollingFallback(after int, interval time.Duration) {
+	fc.pollAfter = after
+	fc.poller = nil
+	if after > 0 {
+		fc.poller = NewQuotePoller(fc.keys, interval)
+	}
+}
+
+// SetDedup drops trades already seen within the dedup's window, before
+// they are handled
+func (fc *FinnhubClient) SetDedup(dedup *Dedup) {
+	fc.dedup = dedup
+}
+
+// SetRecorder records every frame read from the WebSocket, before it is
+// processed
+func (fc *FinnhubClient) SetRecorder(recorder *Recorder) {
+	fc.recorder = recorder
+}
+
+// SetWatchdog reconnects when no trade frames arrive for silence, or the
+// first one doesn't within startup of connecting, while hours are active
+// (0 = no timeout)
+func (fc *FinnhubClient) SetWatchdog(silence time.Duration, startup time.Duration, hours config.ActiveHours) {
+	fc.silenceTimeout = silence
+	fc.startupTimeout = startup
+	fc.silenceHours = hours
+}
+
+// SetPerTradeDispatch hands trades to the handler one by one, instead of all
+// of a frame's trades in one batch
+func (fc *FinnhubClient) SetPerTradeDispatch(perTrade bool) {
+	fc.perTrade = perTrade
+}
+
+// SetCompression offers permessage-deflate on every connection, which the
+// server may decline
+func (fc *FinnhubClient) SetCompression(enabled bool) {
+	fc.compression = enabled
+}
+
+// SetTradeMapping maps raw trades, symbols included, before they are handled
+func (fc *FinnhubClient) SetTradeMapping(mapping *TradeMapping) {
+	fc.mapping = mapping
+}
+
 // Connect establishes WebSocket connection and subscribes to tickers
 func (fc *FinnhubClient) Connect(ctx context.Context) error {
 	timer := prometheus.NewTimer(metrics.FinnhubConnectionDuration)
 	defer timer.ObserveDuration()
 
-	url := fmt.Sprintf("wss://ws.finnhub.io?token=%s", fc.apiKey)
+	if fc.keys == nil {
+		return fmt.Errorf("no Finnhub API key")
+	}
+	url := finnhubURL + fc.keys.Current()
 
 	dialer := &websocket.Dialer{
 		HandshakeTimeout: dialTimeout,
+		NetDialContext:   dialCounting,
+	}
+	if fc.compression {
+		dialer.EnableCompression = true
+		dialer.ReadBufferSize = compressedReadBuffer
 	}
 
-	conn, _, err := dialer.DialContext(ctx, url, nil)
+	conn, resp, err := dialer.DialContext(ctx, url, nil)
 	if err != nil {
+		// The next attempt uses the next key, rested or not
+		reason := "failed to connect"
+		if resp != nil {
+			reason = fmt.Sprintf("was refused with %s", resp.Status)
+		}
+		if ctx.Err() == nil {
+			fc.keys.Rotate(time.Now(), reason)
+		}
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
 
-	fc.wsConn = conn
-	log.Printf("Connected to Finnhub WebSocket")
+	metrics.FinnhubConnected.Set(1)
+	setActiveSource(sourceWebSocket)
+	fc.connectedAt = time.Now()
+	switch {
+	case negotiatedDeflate(resp):
+		metrics.FinnhubCompression.Set(1)
+		log.Printf("Connected to Finnhub WebSocket (permessage-deflate)")
+	case fc.compression:
+		metrics.FinnhubCompression.Set(0)
+		log.Printf("⚠️ Connected to Finnhub WebSocket, which declined permessage-deflate")
+	default:
+		metrics.FinnhubCompression.Set(0)
+		log.Printf("Connected to Finnhub WebSocket")
+	}
 
-	// Configure connection timeouts
+	// Configure connection timeouts; the reader also refreshes the read
+	// deadline after every message
 	conn.SetReadDeadline(time.Now().Add(readTimeout))
 	conn.SetPongHandler(func(string) error {
 		conn.SetReadDeadline(time.Now().Add(readTimeout))
 		return nil
 	})
 
-	// Subscribe to all tickers
-	if err := fc.subscribe(); err != nil {
-		conn.Close()
+	// Subscribe to all tickers; holding writeMu from here means a symbol
+	// added meanwhile is either in this snapshot or sent by Subscribe
+	fc.writeMu.Lock()
+	fc.wsConn = conn
+	err = fc.subscribe()
+	fc.writeMu.Unlock()
+	if err != nil {
+		fc.dropConn()
 		return fmt.Errorf("failed to subscribe to tickers: %w", err)
 	}
 
 	return nil
 }
 
-// subscribe sends subscription messages for all configured tickers
+// subscribe sends subscription messages for all subscribed tickers that
+// aren't done, recording those that succeed for unsubscribing on shutdown.
+// The caller holds writeMu.
 func (fc *FinnhubClient) subscribe() error {
-	for _, ticker := range fc.tickers {
-		subMsg := models.SubscribeMessage{
-			Type:   "subscribe",
-			Symbol: ticker,
+	fc.subscribed = fc.subscribed[:0]
+	for _, ticker := range fc.symbols.Active() {
+		if fc.gated(ticker, time.Now()) {
+			log.Printf("🌙 %s is outside its market hours, subscribing when they open", ticker)
+			continue
 		}
-
-		fc.wsConn.SetWriteDeadline(time.Now().Add(writeTimeout))
-		if err := fc.wsConn.WriteJSON(subMsg); err != nil {
+		if err := fc.writeSubscription("subscribe", ticker, time.Now().Add(writeTimeout)); err != nil {
 			metrics.FinnhubSubscriptionErrors.WithLabelValues(ticker).Inc()
 			return fmt.Errorf("failed to subscribe to %s: %w", ticker, err)
 		}
 
+		fc.subscribed = append(fc.subscribed, ticker)
 		log.Printf("Subscribed to %s", ticker)
 	}
 	return nil
 }
 
-// Start begins processing WebSocket messages
+// unsubscribe sends unsubscribe messages for the subscribed tickers, giving
+// up at the first failed write or at deadline
+func (fc *FinnhubClient) unsubscribe(deadline time.Time) error {
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	for _, ticker := range fc.subscribed {
+		if err := fc.writeSubscription("unsubscribe", ticker, deadline); err != nil {
+			return fmt.Errorf("failed to unsubscribe from %s: %w", ticker, err)
+		}
+		log.Printf("Unsubscribed from %s", ticker)
+	}
+	fc.subscribed = fc.subscribed[:0]
+	return nil
+}
+
+// writeSubscription sends a subscribe or unsubscribe message for the
+// ticker. The caller holds writeMu.
+func (fc *FinnhubClient) writeSubscription(msgType string, ticker string, deadline time.Time) error {
+	subMsg := models.SubscribeMessage{
+		Type:   msgType,
+		Symbol: ticker,
+	}
+
+	data, err := json.Marshal(subMsg)
+	if err != nil {
+		return err
+	}
+	return writeLocked(fc.wsConn, websocket.TextMessage, data, deadline)
+}
+
+// writeLocked writes a message on conn by deadline. gorilla/websocket allows
+// a single writer at a time, so subscriptions, pings and close frames all
+// write through here with writeMu held.
+func writeLocked(conn *websocket.Conn, messageType int, data []byte, deadline time.Time) error {
+	conn.SetWriteDeadline(deadline)
+	return conn.WriteMessage(messageType, data)
+}
+
+// Subscribe adds the symbol to the subscriptions, reporting whether it was
+// new, and subscribes to it on the connection. While disconnected, the
+// next connection subscribes to it.
+func (fc *FinnhubClient) Subscribe(symbol string) bool {
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	if !fc.symbols.Add(symbol) {
+		return false
+	}
+	if fc.wsConn == nil {
+		return true
+	}
+	if fc.gated(symbol, time.Now()) {
+		log.Printf("🌙 %s is outside its market hours, subscribing when they open", symbol)
+		return true
+	}
+
+	if err := fc.writeSubscription("subscribe", symbol, time.Now().Add(writeTimeout)); err != nil {
+		// A failed write means the connection is going; reconnecting resubscribes
+		metrics.FinnhubSubscriptionErrors.WithLabelValues(symbol).Inc()
+		log.Printf("⚠️ Failed to subscribe to %s, retrying on reconnect: %v", symbol, err)
+		return true
+	}
+	fc.subscribed = append(fc.subscribed, symbol)
+	log.Printf("Subscribed to %s", symbol)
+	return true
+}
+
+// Unsubscribe removes the symbol from the subscriptions, reporting whether
+// it was subscribed, and unsubscribes from it on the connection. Its trades
+// still arriving are dropped.
+func (fc *FinnhubClient) Unsubscribe(symbol string) bool {
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	if !fc.symbols.Remove(symbol) {
+		return false
+	}
+	fc.dropSubscription(symbol)
+	return true
+}
+
+// dropSubscription unsubscribes from the symbol on the connection, if it is
+// subscribed there. The caller holds writeMu.
+func (fc *FinnhubClient) dropSubscription(symbol string) {
+	if fc.wsConn == nil || !slices.Contains(fc.subscribed, symbol) {
+		return
+	}
+
+	fc.subscribed = slices.DeleteFunc(fc.subscribed, func(sub string) bool { return sub == symbol })
+	if err := fc.writeSubscription("unsubscribe", symbol, time.Now().Add(writeTimeout)); err != nil {
+		log.Printf("⚠️ Failed to unsubscribe from %s: %v", symbol, err)
+		return
+	}
+	log.Printf("Unsubscribed from %s", symbol)
+}
+
+// gated reports whether the symbol is left unsubscribed at now, being
+// outside its market hours with an unsubscribing schedule
+func (fc *FinnhubClient) gated(symbol string, now time.Time) bool {
+	return fc.schedule != nil && fc.schedule.unsubscribe && !fc.schedule.Open(symbol, now)
+}
+
+// gateSubscriptions unsubscribes from symbols as their market hours close
+// and subscribes to them again as they open, until ctx is cancelled
+func (fc *FinnhubClient) gateSubscriptions(ctx context.Context) {
+	if fc.schedule == nil || !fc.schedule.unsubscribe {
+		return
+	}
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			fc.applySchedule(now)
+		}
+	}
+}
+
+// applySchedule brings the subscriptions on the connection in line with
+// the market hours at now. A failed subscribe is tried again next time.
+func (fc *FinnhubClient) applySchedule(now time.Time) {
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	if fc.wsConn == nil {
+		return
+	}
+
+	for _, symbol := range fc.symbols.Active() {
+		subscribed := slices.Contains(fc.subscribed, symbol)
+		switch gated := fc.gated(symbol, now); {
+		case gated && subscribed:
+			log.Printf("🌙 Market hours closed for %s", symbol)
+			fc.dropSubscription(symbol)
+		case !gated && !subscribed:
+			if err := fc.writeSubscription("subscribe", symbol, time.Now().Add(writeTimeout)); err != nil {
+				metrics.FinnhubSubscriptionErrors.WithLabelValues(symbol).Inc()
+				log.Printf("⚠️ Failed to subscribe to %s as its market hours opened: %v", symbol, err)
+				continue
+			}
+			fc.subscribed = append(fc.subscribed, symbol)
+			log.Printf("🔔 Market hours opened for %s, subscribed", symbol)
+		}
+	}
+}
+
+// Start begins processing WebSocket messages. When the connection drops it
+// reconnects with backoff, resubscribes and carries on counting messages,
+// polling REST quotes meanwhile if the polling fallback is set and enough
+// reconnects fail. It returns ErrConnectionLost once the reconnect attempts
+// are used up, and otherwise once parentCtx is cancelled or the message
+// limit is reached. The trade handler is left open.
 func (fc *FinnhubClient) Start(parentCtx context.Context) error {
 	if fc.wsConn == nil {
 		return fmt.Errorf("not connected - call Connect() first")
 	}
 
+	for {
+		lost, err := fc.runConnection(parentCtx)
+		if !lost {
+			return err
+		}
+		if err := fc.reconnect(parentCtx, err); err != nil {
+			return err
+		}
+		// Cancelled, or the limit reached by polling, while reconnecting; a
+		// connection made as parentCtx was cancelled is shut down by the next
+		// runConnection
+		if fc.wsConn == nil {
+			return nil
+		}
+	}
+}
+
+// runConnection reads and pings the current connection until a read or
+// write fails, reporting the connection lost with its error, or until
+// parentCtx is cancelled or the message limit is reached, then shutting the
+// connection down cleanly and returning the result. Either way the
+// connection is closed on return.
+func (fc *FinnhubClient) runConnection(parentCtx context.Context) (bool, error) {
 	ctx, cancel := context.WithCancel(parentCtx)
 	defer cancel()
 
+	// Both goroutines work on this connection only, never on its replacement
+	conn := fc.wsConn
+	connErr := make(chan error, 3)
+	readDone := make(chan struct{})
+	pingDone := make(chan struct{})
+	watchDone := make(chan struct{})
+	gateDone := make(chan struct{})
+
 	// Start message processing goroutine
-	go fc.readMessages(ctx, cancel)
+	go func() {
+		defer close(readDone)
+		fc.readMessages(ctx, cancel, conn, connErr)
+	}()
 
 	// Start ping handler
-	go fc.pingHandler(ctx)
+	go func() {
+		defer close(pingDone)
+		fc.pingHandler(ctx, cancel, conn, connErr)
+	}()
+
+	// Start the watchdog for a connection without trades
+	go func() {
+		defer close(watchDone)
+		fc.watchdog(ctx, cancel, connErr)
+	}()
 
-	// Wait for context cancellation
+	// Start gating the subscriptions by market hours
+	go func() {
+		defer close(gateDone)
+		fc.gateSubscriptions(ctx)
+	}()
+
+	// Wait for context cancellation; only the reader may still use the
+	// connection after the ping handler stops
 	<-ctx.Done()
-	log.Println("Context cancelled, shutting down...")
-	return fc.Close()
+	<-pingDone
+	<-watchDone
+	<-gateDone
+	select {
+	case err := <-connErr:
+		fc.closeConn()
+		<-readDone
+		return true, err
+	default:
+		log.Println("Context cancelled, shutting down...")
+		return false, fc.shutdownConn(readDone)
+	}
+}
+
+// reconnect dials Finnhub again and resubscribes, backing off with jitter
+// between attempts. Once pollAfter attempts have failed it polls REST
+// quotes until connected, and keeps reconnecting past reconnectAttempts. It
+// returns nil once connected, when parentCtx is cancelled or when polling
+// reaches the message limit, and ErrConnectionLost when every attempt fails.
+func (fc *FinnhubClient) reconnect(parentCtx context.Context, cause error) error {
+	// Also cancelled by the poller at the message limit
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	var stopPolling func() // Stops the poller and waits for it; nil while not polling
+	defer func() {
+		if stopPolling != nil {
+			stopPolling()
+			if fc.wsConn == nil {
+				setActiveSource("")
+			}
+		}
+	}()
+
+	lastErr := cause
+	for attempt := 1; stopPolling != nil || attempt <= fc.reconnectAttempts; attempt++ {
+		delay := backoff(attempt, fc.reconnectMaxDelay)
+		if stopPolling != nil {
+			log.Printf("⚠️ Finnhub WebSocket still down (%v); polling REST quotes, reconnect %d in %s", lastErr, attempt, delay)
+		} else {
+			log.Printf("⚠️ Finnhub connection lost (%v); reconnect %d/%d in %s", lastErr, attempt, fc.reconnectAttempts, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		if err := fc.Connect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			metrics.FinnhubReconnects.WithLabelValues("failed").Inc()
+			lastErr = err
+			if fc.poller != nil && stopPolling == nil && attempt >= fc.pollAfter {
+				stopPolling = fc.startPolling(ctx, cancel)
+			}
+			continue
+		}
+		metrics.FinnhubReconnects.WithLabelValues("success").Inc()
+		if stopPolling != nil {
+			stopPolling()
+			stopPolling = nil
+			log.Printf("✔ Finnhub WebSocket is back, stopped polling REST quotes")
+			if ctx.Err() != nil && parentCtx.Err() == nil {
+				// The poller reached the message limit meanwhile
+				return fc.shutdownConn(nil)
+			}
+		}
+		log.Printf("✔ Reconnected to Finnhub after %d attempt(s), %d messages so far", attempt, fc.GetMessageCount())
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrConnectionLost, lastErr)
 }
 
-// readMessages processes incoming WebSocket messages
-func (fc *FinnhubClient) readMessages(ctx context.Context, cancel context.CancelFunc) {
+// startPolling runs the quote poller until ctx is cancelled or the returned
+// function is called, feeding its trades through the same pipeline as the
+// WebSocket's. At the message limit it cancels ctx. The returned function
+// stops the poller and waits for it.
+func (fc *FinnhubClient) startPolling(ctx context.Context, cancel context.CancelFunc) func() {
+	log.Printf("📡 Finnhub WebSocket unavailable, polling REST quotes every %s", fc.poller.interval)
+	setActiveSource(sourceREST)
+	pollCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fc.poller.Run(pollCtx, fc.symbols, func(records []models.FinnhubTradeRaw) bool {
+			if err := fc.processTrades(records); err != nil {
+				log.Printf("Error processing polled quotes: %v", err)
+			}
+			if fc.limitReached() {
+				cancel()
+				return false
+			}
+			return true
+		})
+	}()
+	return func() {
+		stop()
+		<-done
+	}
+}
+
+// readMessages processes incoming WebSocket messages from conn
+func (fc *FinnhubClient) readMessages(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, connErr chan<- error) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			_, message, err := fc.wsConn.ReadMessage()
+			_, message, err := conn.ReadMessage()
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 					log.Println("WebSocket connection closed")
 				} else {
 					log.Printf("Error reading message: %v", err)
 				}
+				connErr <- err
 				cancel()
 				return
 			}
+			metrics.FinnhubReceivedBytes.WithLabelValues("message").Add(float64(len(message)))
+			// Any message shows the connection is alive, not just pongs;
+			// on shutdown the close handshake owns the deadline
+			if ctx.Err() == nil {
+				conn.SetReadDeadline(time.Now().Add(readTimeout))
+			}
+			if fc.recorder != nil {
+				fc.recorder.Record(message, time.Now())
+			}
 
 			if err := fc.processMessage(message); err != nil {
 				log.Printf("Error processing message: %v", err)
 				continue
 			}
+			if fc.rotated {
+				fc.rotated = false
+				connErr <- errKeyRotated
+				cancel()
+				return
+			}
+			if fc.pause > 0 {
+				// Stop reading, and so subscribing, until Finnhub lets up
+				log.Printf("⏸ Finnhub rate limited, pausing reads for %s", fc.pause)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(fc.pause):
+				}
+				fc.pause = 0
+				conn.SetReadDeadline(time.Now().Add(readTimeout))
+			}
 
 			// Check if we've reached the message limit
-			fc.mu.RLock()
-			count := fc.messageCount
-			max := fc.maxMessages
-			fc.mu.RUnlock()
-
-			if max > 0 && count >= max {
-				log.Printf("Reached message limit of %d messages", max)
+			if fc.limitReached() {
 				cancel() // Cancel the context to stop processing
 				return
 			}
@@ -168,6 +658,25 @@ func (fc *FinnhubClient) readMessages(ctx context.Context, cancel context.Cancel
 	}
 }
 
+// limitReached reports, and logs, whether maxMessages have been handled or
+// every symbol has reached its quota
+func (fc *FinnhubClient) limitReached() bool {
+	fc.mu.RLock()
+	count := fc.messageCount
+	max := fc.maxMessages
+	fc.mu.RUnlock()
+
+	if max > 0 && count >= max {
+		log.Printf("Reached message limit of %d messages", max)
+		return true
+	}
+	if fc.symbols.QuotasReached() {
+		log.Printf("Every symbol reached its limit of %d messages", fc.symbols.Quota())
+		return true
+	}
+	return false
+}
+
 // processMessage handles individual WebSocket messages
 func (fc *FinnhubClient) processMessage(message []byte) error {
 	timer := prometheus.NewTimer(metrics.WebsocketMessageProcessingDuration.WithLabelValues("unknown"))
@@ -190,7 +699,13 @@ func (fc *FinnhubClient) processMessage(message []byte) error {
 		return nil
 	case "trade":
 		metrics.WebsocketMessagesReceived.WithLabelValues("trade").Inc()
+		fc.lastTrade.Store(time.Now().UnixNano())
+		fc.rateLimits = 0
 		return fc.processTrades(msg.Data)
+	case "error":
+		metrics.WebsocketMessagesReceived.WithLabelValues("error").Inc()
+		fc.processError(msg.Msg)
+		return nil
 	default:
 		metrics.WebsocketMessagesReceived.WithLabelValues("unknown").Inc()
 		log.Printf("Unknown message type: %s", msg.Type)
@@ -198,34 +713,171 @@ func (fc *FinnhubClient) processMessage(message []byte) error {
 	}
 }
 
+// Values of the reason label on FinnhubErrors
+const (
+	errorRateLimited   = "rate_limited"
+	errorInvalidSymbol = "invalid_symbol"
+	errorUnauthorized  = "unauthorized"
+	errorOther         = "other"
+)
+
+// processError handles an error frame. Rate limit and authorization errors
+// move on to the next API key, reconnecting with it. With no other key to
+// use, rate limit errors make the reader back off instead, longer for each
+// one until trades flow again. Finnhub rejecting a symbol marks it failed
+// and unsubscribes from it, rather than waiting for its trades forever.
+func (fc *FinnhubClient) processError(text string) {
+	reason := errorReason(text)
+	metrics.FinnhubErrors.WithLabelValues(reason).Inc()
+	log.Printf("⚠️ Finnhub error (%s): %s", reason, text)
 
-// processTrades handles trade data messages
+	switch reason {
+	case errorRateLimited, errorUnauthorized:
+		if fc.keys != nil && fc.keys.Rotate(time.Now(), "got a "+reason+" error") {
+			fc.rotated = true
+			return
+		}
+		if reason == errorUnauthorized {
+			return
+		}
+		fc.rateLimits++
+		fc.pause = backoff(fc.rateLimits, fc.reconnectMaxDelay)
+	case errorInvalidSymbol:
+		symbol := errorSymbol(text, fc.symbols.Symbols())
+		if symbol == "" {
+			log.Printf("⚠️ Finnhub rejected a symbol but named none subscribed: %s", text)
+			return
+		}
+		if !fc.symbols.Fail(symbol, text) {
+			return
+		}
+		log.Printf("❌ %s failed: %s", symbol, text)
+		fc.writeMu.Lock()
+		fc.dropSubscription(symbol)
+		fc.writeMu.Unlock()
+	}
+}
+
+// errorReason normalizes the text of a Finnhub error frame into a reason
+func errorReason(text string) string {
+	lower := strings.ToLower(text)
+	switch {
+	case strings.Contains(lower, "limit") || strings.Contains(lower, "too many"):
+		return errorRateLimited
+	case strings.Contains(lower, "symbol") || strings.Contains(lower, "ticker"):
+		return errorInvalidSymbol
+	case strings.Contains(lower, "token") || strings.Contains(lower, "key") || strings.Contains(lower, "auth"):
+		return errorUnauthorized
+	default:
+		return errorOther
+	}
+}
+
+// errorSymbol returns the first word of the error text that is one of
+// symbols, quoted or not; "" if none is
+func errorSymbol(text string, symbols []string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || strings.ContainsRune("\"'`(),;[]{}\t\n", r)
+	})
+	for _, word := range words {
+		if word = strings.TrimRight(word, "."); slices.Contains(symbols, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// processTrades handles trade data messages, as one batch stamped with the
+// frame's receive time unless per-trade dispatch is set. Duplicates and
+// trades of symbols that reached their quota are dropped. Subscriptions,
+// dedup and throttling go by Finnhub's symbols, metrics by mapped ones.
 func (fc *FinnhubClient) processTrades(trades []models.FinnhubTradeRaw) error {
+	receivedAt := time.Now().UTC()
+	batch := make([]models.FinnhubTrade, 0, len(trades))
+	subscribed := make([]string, 0, len(trades)) // Finnhub symbol of each batched trade
+	batched := make(map[string]int)              // Trades per symbol in the batch, not counted yet
 	for _, record := range trades {
+		label := fc.mapping.Symbol(record.Symbol)
+		// Frames in flight when a symbol is unsubscribed are dropped
+		if !fc.symbols.Has(record.Symbol) {
+			metrics.TradesProcessedTotal.WithLabelValues(label, "unsubscribed").Inc()
+			continue
+		}
+		if fc.schedule != nil && !fc.schedule.Open(record.Symbol, receivedAt) {
+			metrics.TradesProcessedTotal.WithLabelValues(label, statusOutsideHours).Inc()
+			continue
+		}
+		// Before EnsureDefaults gives the trade a random id
+		if fc.dedup != nil && fc.dedup.Duplicate(record, receivedAt) {
+			metrics.TradesProcessedTotal.WithLabelValues(label, statusDuplicate).Inc()
+			continue
+		}
+		if remaining := fc.symbols.remaining(record.Symbol); remaining >= 0 && remaining <= batched[record.Symbol] {
+			metrics.TradesProcessedTotal.WithLabelValues(label, "quota_reached").Inc()
+			continue
+		}
+		if fc.filter != nil && !fc.filter.Allow(record) {
+			metrics.TradesProcessedTotal.WithLabelValues(label, statusFiltered).Inc()
+			continue
+		}
+		if fc.throttle != nil {
+			if ok, status := fc.throttle.Allow(record.Symbol, time.Now()); !ok {
+				metrics.TradesProcessedTotal.WithLabelValues(label, status).Inc()
+				continue
+			}
+		}
 		record.EnsureDefaults()
-		startTimestamp  := time.Now().UTC()
+		if !fc.perTrade {
+			batch = append(batch, fc.mapRecord(record))
+			subscribed = append(subscribed, record.Symbol)
+			batched[record.Symbol]++
+			continue
+		}
+		startTimestamp := time.Now().UTC()
 		trade := fc.mapRecord(record)
 		err := fc.tradeHandler.HandleTrade(trade, startTimestamp)
 		if err != nil {
 			log.Printf("Error handling trade for %s: %v", record.Symbol, err)
 			continue
 		}
+		fc.handled(record.Symbol)
+	}
 
-		fc.mu.Lock()
-		fc.messageCount++
-		fc.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	for i, err := range fc.tradeHandler.HandleBatch(batch, receivedAt) {
+		if err != nil {
+			log.Printf("Error handling trade for %s: %v", batch[i].Symbol, err)
+			continue
+		}
+		fc.handled(subscribed[i])
 	}
 	return nil
 }
 
+// handled counts a trade of the subscribed symbol the handler accepted,
+// unsubscribing from the symbol on the connection once it reaches its quota
+func (fc *FinnhubClient) handled(symbol string) {
+	fc.mu.Lock()
+	fc.messageCount++
+	fc.mu.Unlock()
+	if fc.symbols.record(symbol) {
+		log.Printf("✅ %s reached its limit of %d messages", symbol, fc.symbols.Quota())
+		fc.writeMu.Lock()
+		fc.dropSubscription(symbol)
+		fc.writeMu.Unlock()
+	}
+}
+
 func (fc *FinnhubClient) mapRecord(record models.FinnhubTradeRaw) models.FinnhubTrade {
 	// Map raw trade data to structured format
-	return models.FinnhubTrade(record)
+	return fc.mapping.Apply(record)
 }
 
-// pingHandler sends periodic ping messages to keep connection alive
-func (fc *FinnhubClient) pingHandler(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+// pingHandler sends periodic ping messages to keep conn alive
+func (fc *FinnhubClient) pingHandler(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, connErr chan<- error) {
+	ticker := time.NewTicker(pingInterval)
 	defer ticker.Stop()
 
 	for {
@@ -233,15 +885,130 @@ func (fc *FinnhubClient) pingHandler(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			fc.wsConn.SetWriteDeadline(time.Now().Add(writeTimeout))
-			if err := fc.wsConn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			fc.writeMu.Lock()
+			err := writeLocked(conn, websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+			fc.writeMu.Unlock()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				log.Printf("Failed to send ping: %v", err)
+				connErr <- err
+				cancel()
 				return
 			}
 		}
 	}
 }
 
+// watchdog reports the connection lost when no trade frames arrive for
+// silenceTimeout, or the first one doesn't within startupTimeout, while
+// silenceHours are active. The silence counts from the last trade, the
+// connection or the start of the active hours, whichever is latest.
+func (fc *FinnhubClient) watchdog(ctx context.Context, cancel context.CancelFunc, connErr chan<- error) {
+	if fc.silenceTimeout == 0 && fc.startupTimeout == 0 {
+		return
+	}
+	interval := 10 * time.Second
+	for _, timeout := range []time.Duration{fc.silenceTimeout, fc.startupTimeout} {
+		if timeout > 0 {
+			interval = min(interval, timeout/4)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var closedAt time.Time // Last seen with every symbol outside market hours
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// No trades are expected while every market is closed, and the
+			// timeouts run from when one opens
+			if active := fc.symbols.Active(); fc.schedule != nil && len(fc.schedule.Closed(active, now)) == len(active) {
+				closedAt = now
+				continue
+			}
+			reason, timeout, since := "silence", fc.silenceTimeout, fc.connectedAt
+			if closedAt.After(since) {
+				since = closedAt
+			}
+			if last := fc.lastTrade.Load(); last == 0 {
+				reason, timeout = "startup", fc.startupTimeout
+			} else if lastTrade := time.Unix(0, last); lastTrade.After(since) {
+				since = lastTrade
+			}
+			if timeout == 0 {
+				continue
+			}
+			start, active := fc.silenceHours.Start(now)
+			if !active {
+				continue
+			}
+			if start.After(since) {
+				since = start
+			}
+			if now.Sub(since) < timeout {
+				continue
+			}
+
+			metrics.FinnhubSilenceReconnects.WithLabelValues(reason).Inc()
+			log.Printf("⚠️ No trades from Finnhub for %s, reconnecting", now.Sub(since).Round(time.Millisecond))
+			connErr <- errTradesSilent
+			cancel()
+			return
+		}
+	}
+}
+
+// shutdownConn unsubscribes from the subscribed tickers, sends a close frame
+// and waits for the server's close frame before closing the connection, all
+// within closeTimeout. readDone is closed once the reader, if one is running,
+// has stopped; the handshake reads the connection itself after that.
+func (fc *FinnhubClient) shutdownConn(readDone <-chan struct{}) error {
+	if fc.wsConn == nil {
+		return nil
+	}
+	conn := fc.wsConn
+	deadline := time.Now().Add(fc.closeTimeout)
+
+	// The deadline also ends a reader blocked waiting for data
+	conn.SetReadDeadline(deadline)
+	if err := fc.unsubscribe(deadline); err != nil {
+		log.Printf("Error unsubscribing: %v", err)
+	}
+	fc.writeMu.Lock()
+	err := writeLocked(conn, websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+	fc.writeMu.Unlock()
+	if err != nil {
+		log.Printf("Error sending close message: %v", err)
+		return fc.dropConn()
+	}
+
+	if readDone != nil {
+		select {
+		case <-readDone:
+		case <-time.After(time.Until(deadline)):
+			log.Printf("⚠️ Timeout waiting for the reader to stop, closing anyway")
+			return fc.dropConn()
+		}
+	}
+	// Discard the messages still in flight until the server's close frame,
+	// by the deadline even if the reader refreshed it on its way out
+	conn.SetReadDeadline(deadline)
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				log.Printf("⚠️ No close response from Finnhub: %v", err)
+			}
+			break
+		}
+	}
+	return fc.dropConn()
+}
+
 // Close gracefully closes the WebSocket connection
 func (fc *FinnhubClient) Close() error {
 	var err error
@@ -254,20 +1021,43 @@ func (fc *FinnhubClient) Close() error {
 		}
 	}
 
-	// Close WebSocket connection
-	if fc.wsConn != nil {
-		fc.wsConn.SetWriteDeadline(time.Now().Add(writeTimeout))
-		closeErr := fc.wsConn.WriteMessage(websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-		if closeErr != nil {
-			log.Printf("Error sending close message: %v", closeErr)
-		}
+	// Unsubscribe and close the WebSocket connection
+	if connErr := fc.shutdownConn(nil); connErr != nil && err == nil {
+		err = connErr
+	}
 
-		if connErr := fc.wsConn.Close(); connErr != nil && err == nil {
-			err = connErr
-		}
+	return err
+}
+
+// closeConn closes the WebSocket connection, leaving the trade handler open
+func (fc *FinnhubClient) closeConn() error {
+	if fc.wsConn == nil {
+		return nil
+	}
+	fc.writeMu.Lock()
+	closeErr := writeLocked(fc.wsConn, websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+	fc.writeMu.Unlock()
+	if closeErr != nil {
+		log.Printf("Error sending close message: %v", closeErr)
 	}
 
+	return fc.dropConn()
+}
+
+// dropConn closes the WebSocket connection without a close frame
+func (fc *FinnhubClient) dropConn() error {
+	if fc.wsConn == nil {
+		return nil
+	}
+	metrics.FinnhubConnected.Set(0)
+	metrics.FinnhubCompression.Set(0)
+	setActiveSource("")
+	err := fc.wsConn.Close()
+	fc.writeMu.Lock()
+	fc.wsConn = nil
+	fc.subscribed = fc.subscribed[:0]
+	fc.writeMu.Unlock()
 	return err
 }
 
@@ -277,4 +1067,3 @@ func (fc *FinnhubClient) GetMessageCount() int {
 	defer fc.mu.RUnlock()
 	return fc.messageCount
 }
-
@@ -0,0 +1,175 @@
+package finnhub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"data_synthesizer/config"
+	"data_synthesizer/models"
+	"data_synthesizer/service/metrics"
+)
+
+var initMetrics sync.Once
+
+// nopTradeHandler discards trades
+type nopTradeHandler struct{}
+
+func (nopTradeHandler) HandleTrade(models.FinnhubTrade, time.Time) error { return nil }
+
+func (nopTradeHandler) HandleBatch(trades []models.FinnhubTrade, _ time.Time) []error {
+	return make([]error, len(trades))
+}
+
+func (nopTradeHandler) Close() error { return nil }
+
+// fakeFinnhub is a WebSocket server recording the subscription messages and
+// pings it receives
+type fakeFinnhub struct {
+	mu       sync.Mutex
+	messages []models.SubscribeMessage
+	pings    int
+}
+
+func (f *fakeFinnhub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetPingHandler(func(data string) error {
+		f.mu.Lock()
+		f.pings++
+		f.mu.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg models.SubscribeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		f.mu.Lock()
+		f.messages = append(f.messages, msg)
+		f.mu.Unlock()
+	}
+}
+
+// subscribedTo replays the subscription messages, returning the symbols
+// left subscribed
+func (f *fakeFinnhub) subscribedTo() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var symbols []string
+	for _, msg := range f.messages {
+		switch msg.Type {
+		case "subscribe":
+			symbols = append(symbols, msg.Symbol)
+		case "unsubscribe":
+			symbols = slices.DeleteFunc(symbols, func(symbol string) bool { return symbol == msg.Symbol })
+		}
+	}
+	slices.Sort(symbols)
+	return symbols
+}
+
+// newTestFinnhub starts a fakeFinnhub and points the client at it
+func newTestFinnhub(t *testing.T) *fakeFinnhub {
+	t.Helper()
+	initMetrics.Do(func() { metrics.Initialize(&config.Config{}) })
+	server := &fakeFinnhub{}
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+
+	url, interval := finnhubURL, pingInterval
+	finnhubURL = "ws" + strings.TrimPrefix(srv.URL, "http") + "?token="
+	pingInterval = time.Millisecond
+	t.Cleanup(func() { finnhubURL, pingInterval = url, interval })
+	return server
+}
+
+// TestSubscribeWhilePinging runs the ping loop alongside concurrent
+// Subscribe and Unsubscribe calls, all writing on the one connection;
+// run with -race
+func TestSubscribeWhilePinging(t *testing.T) {
+	server := newTestFinnhub(t)
+	fc := NewFinnhubClient(NewKeyRing([]string{"key"}, time.Minute), NewSubscriptions([]string{"AAPL"}), 0, nopTradeHandler{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := fc.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- fc.Start(ctx) }()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			symbol := fmt.Sprintf("SYM%d", i)
+			for range 50 {
+				fc.Subscribe(symbol)
+				fc.Unsubscribe(symbol)
+			}
+			if i%2 == 0 {
+				fc.Subscribe(symbol)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Let the ping loop run on past the subscriptions
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.mu.Lock()
+		pings := server.pings
+		server.mu.Unlock()
+		if pings >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d pings, want at least 3", pings)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Check the subscriptions before shutdown unsubscribes from everything
+	want := []string{"AAPL", "SYM0", "SYM2", "SYM4", "SYM6"}
+	deadline = time.Now().Add(5 * time.Second)
+	for !slices.Equal(server.subscribedTo(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("server subscribed to %v, want %v", server.subscribedTo(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() didn't return after cancel")
+	}
+	if err := fc.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if got := server.subscribedTo(); len(got) != 0 {
+		t.Errorf("server still subscribed to %v after shutdown", got)
+	}
+}
@@ -0,0 +1,84 @@
+package finnhub
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"data_synthesizer/service/metrics"
+)
+
+// KeyRing holds the Finnhub API keys and the one in use. A key that fails,
+// for example because Finnhub rate limits it, cools down while the next
+// one is used, so a reconnect storm doesn't cycle straight back to it. It
+// is shared across restarts. Keys are only ever logged masked.
+type KeyRing struct {
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	keys    []string
+	current int
+	resting []time.Time // When each key's cooldown ends
+}
+
+// NewKeyRing creates a ring using keys in order, each failed key cooling
+// down for cooldown
+func NewKeyRing(keys []string, cooldown time.Duration) *KeyRing {
+	metrics.FinnhubAPIKeyIndex.Set(0)
+	return &KeyRing{
+		cooldown: cooldown,
+		keys:     keys,
+		resting:  make([]time.Time, len(keys)),
+	}
+}
+
+// Current returns the key in use
+func (kr *KeyRing) Current() string {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	return kr.keys[kr.current]
+}
+
+// Rotate cools down the key in use, which failed at now for reason, and
+// moves on to the next key that isn't cooling down. When every key is,
+// the one that has rested longest is used. It reports whether the key
+// changed.
+func (kr *KeyRing) Rotate(now time.Time, reason string) bool {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if len(kr.keys) < 2 {
+		return false
+	}
+
+	failed := kr.current
+	kr.resting[failed] = now.Add(kr.cooldown)
+	next := failed
+	for i := 1; i <= len(kr.keys); i++ {
+		candidate := (failed + i) % len(kr.keys)
+		if !kr.resting[candidate].After(now) {
+			next = candidate
+			break
+		}
+		if kr.resting[candidate].Before(kr.resting[next]) {
+			next = candidate
+		}
+	}
+	if next == failed {
+		return false
+	}
+
+	kr.current = next
+	metrics.FinnhubAPIKeyIndex.Set(float64(next))
+	log.Printf("🔑 Finnhub key %d (%s) %s, cooling down for %s; switching to key %d (%s)",
+		failed, maskKey(kr.keys[failed]), reason, kr.cooldown, next, maskKey(kr.keys[next]))
+	return true
+}
+
+// maskKey returns the key for logging: its last four characters only
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return strings.Repeat("*", len(key))
+	}
+	return "…" + key[len(key)-4:]
+}
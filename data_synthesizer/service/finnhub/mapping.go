@@ -0,0 +1,108 @@
+package finnhub
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"data_synthesizer/config"
+	"data_synthesizer/models"
+)
+
+// TradeMapping turns raw Finnhub trades into the trades handed downstream.
+// It normalizes symbols as SYMBOL_FORMAT says, and can rename trade data
+// fields and add the event time as an RFC 3339 string.
+type TradeMapping struct {
+	symbolFormat string
+	names        config.FieldNames // Downstream name by trade field; others keep theirs
+	timeField    string            // Field for the event time; "" adds none
+}
+
+// finnhubMapping hands trades over as Finnhub sends them
+var finnhubMapping = &TradeMapping{symbolFormat: config.SymbolKeep}
+
+// NewTradeMapping creates a mapping, checking that names renames fields
+// trades have and that no two fields end up with the same name
+func NewTradeMapping(symbolFormat string, names config.FieldNames, timeField string) (*TradeMapping, error) {
+	fields := tradeFields()
+	for field := range names {
+		if !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("unknown trade field %q, expected one of %s", field, strings.Join(fields, ", "))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, field := range append(fields, timeField) {
+		name := field
+		if renamed, ok := names[field]; ok {
+			name = renamed
+		}
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("trade field name %q is used twice", name)
+		}
+		seen[name] = true
+	}
+	return &TradeMapping{symbolFormat: symbolFormat, names: names, timeField: timeField}, nil
+}
+
+// Symbol returns the symbol as trades carry it downstream
+func (m *TradeMapping) Symbol(symbol string) string {
+	symbol, _ = config.NormalizeSymbol(m.symbolFormat, symbol)
+	return symbol
+}
+
+// Apply maps a raw trade. Its Fields are only set when the mapping renames
+// or adds fields.
+func (m *TradeMapping) Apply(record models.FinnhubTradeRaw) models.FinnhubTrade {
+	trade := models.FinnhubTrade{
+		Trade_Id:        record.Trade_Id,
+		Trade_Condition: record.Trade_Condition,
+		Price:           record.Price,
+		Event_Timestamp: record.Event_Timestamp,
+		Volume:          record.Volume,
+		Source:          record.Source,
+	}
+	trade.Symbol, trade.Exchange = config.NormalizeSymbol(m.symbolFormat, record.Symbol)
+	if len(m.names) == 0 && m.timeField == "" {
+		return trade
+	}
+
+	fields, err := structToMap(trade)
+	if err != nil {
+		log.Printf("⚠️ Error mapping trade fields for %s: %v", trade.Symbol, err)
+		return trade
+	}
+	trade.Fields = make(map[string]interface{}, len(fields)+1)
+	for field, value := range fields {
+		if name, ok := m.names[field]; ok {
+			field = name
+		}
+		trade.Fields[field] = value
+	}
+	if m.timeField != "" {
+		trade.Fields[m.timeField] = time.UnixMilli(record.Event_Timestamp).UTC().Format(time.RFC3339Nano)
+	}
+	return trade
+}
+
+// tradeFields returns the names of the trade data fields, as they are
+// sent downstream without a mapping
+func tradeFields() []string {
+	var fields []string
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(models.FinnhubTrade{})) {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
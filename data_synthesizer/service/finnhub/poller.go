@@ -0,0 +1,161 @@
+package finnhub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"data_synthesizer/models"
+	"data_synthesizer/service/metrics"
+)
+
+const (
+	// Finnhub REST quote endpoint, polled while the WebSocket is down
+	quoteURL = "https://finnhub.io/api/v1/quote"
+	// Timeout for each quote request
+	quoteTimeout = 10 * time.Second
+)
+
+// Values of the source label on FinnhubActiveSource
+const (
+	sourceWebSocket = "websocket"
+	sourceREST      = "rest"
+)
+
+// errQuoteRateLimited is returned by fetch when Finnhub answers 429
+var errQuoteRateLimited = errors.New("rate limited")
+
+// quote is the Finnhub REST quote response; t is in seconds and 0 when
+// Finnhub has no quote for the symbol
+type quote struct {
+	Current   float64 `json:"c"`
+	High      float64 `json:"h"`
+	Low       float64 `json:"l"`
+	Open      float64 `json:"o"`
+	Previous  float64 `json:"pc"`
+	Timestamp int64   `json:"t"`
+}
+
+// QuotePoller fetches the latest quote of each subscribed symbol from the
+// Finnhub REST API on an interval, as a fallback while the WebSocket is
+// unavailable. Each new quote becomes a trade at the current price.
+type QuotePoller struct {
+	keys     *KeyRing
+	url      string
+	interval time.Duration
+	client   *http.Client
+	last     map[string]int64 // Timestamp of the last quote handled per symbol
+}
+
+// NewQuotePoller creates a poller fetching every subscribed symbol's quote
+// once per interval with the ring's current key
+func NewQuotePoller(keys *KeyRing, interval time.Duration) *QuotePoller {
+	return &QuotePoller{
+		keys:     keys,
+		url:      quoteURL,
+		interval: interval,
+		client:   &http.Client{Timeout: quoteTimeout},
+		last:     make(map[string]int64),
+	}
+}
+
+// Run polls the symbols that aren't done straight away and then once per
+// interval, handing each round's new quotes to handle as one frame of
+// trades. It returns when ctx is cancelled or handle returns false.
+func (qp *QuotePoller) Run(ctx context.Context, symbols *Subscriptions, handle func([]models.FinnhubTradeRaw) bool) {
+	ticker := time.NewTicker(qp.interval)
+	defer ticker.Stop()
+
+	for {
+		if records := qp.poll(ctx, symbols.Active()); len(records) > 0 && !handle(records) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches each symbol's quote, returning trades for the quotes that
+// changed since the last poll
+func (qp *QuotePoller) poll(ctx context.Context, symbols []string) []models.FinnhubTradeRaw {
+	var records []models.FinnhubTradeRaw
+	for _, symbol := range symbols {
+		if ctx.Err() != nil {
+			return nil
+		}
+		q, err := qp.fetch(ctx, symbol)
+		switch {
+		case errors.Is(err, errQuoteRateLimited):
+			metrics.FinnhubQuotePolls.WithLabelValues("rate_limited").Inc()
+			log.Printf("⚠️ Finnhub quote for %s rate limited", symbol)
+			qp.keys.Rotate(time.Now(), "was rate limited polling quotes")
+			continue
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil
+			}
+			metrics.FinnhubQuotePolls.WithLabelValues("failed").Inc()
+			log.Printf("⚠️ Error polling Finnhub quote for %s: %v", symbol, err)
+			continue
+		case q.Timestamp == 0 || q.Timestamp == qp.last[symbol]:
+			// No quote, or no change since the last poll
+			metrics.FinnhubQuotePolls.WithLabelValues("unchanged").Inc()
+			continue
+		}
+		metrics.FinnhubQuotePolls.WithLabelValues("success").Inc()
+		qp.last[symbol] = q.Timestamp
+		records = append(records, models.FinnhubTradeRaw{
+			Price:           q.Current,
+			Symbol:          symbol,
+			Event_Timestamp: q.Timestamp * 1000, // Trades carry milliseconds
+			Source:          models.TradeSourceQuote,
+		})
+	}
+	return records
+}
+
+// fetch requests the symbol's latest quote
+func (qp *QuotePoller) fetch(ctx context.Context, symbol string) (quote, error) {
+	var q quote
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, qp.url+"?symbol="+url.QueryEscape(symbol), nil)
+	if err != nil {
+		return q, err
+	}
+	req.Header.Set("X-Finnhub-Token", qp.keys.Current())
+
+	resp, err := qp.client.Do(req)
+	if err != nil {
+		return q, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return q, errQuoteRateLimited
+	case resp.StatusCode != http.StatusOK:
+		return q, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&q); err != nil {
+		return q, fmt.Errorf("JSON decode error: %w", err)
+	}
+	return q, nil
+}
+
+// setActiveSource marks where trades currently come from; "" for neither
+func setActiveSource(source string) {
+	for _, s := range []string{sourceWebSocket, sourceREST} {
+		active := 0.0
+		if s == source {
+			active = 1
+		}
+		metrics.FinnhubActiveSource.WithLabelValues(s).Set(active)
+	}
+}
@@ -0,0 +1,221 @@
+package finnhub
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"data_synthesizer/config"
+	"data_synthesizer/models"
+	"data_synthesizer/service/metrics"
+	"data_synthesizer/service/veramo"
+)
+
+type queuedTrade struct {
+	trade          models.FinnhubTrade
+	startTimestamp time.Time
+}
+
+// ProcessorPool shards trades across TradeProcessors by a stable hash of
+// the symbol, so one symbol's trades stay ordered. In sync mode each trade
+// is handled by its symbol's processor before HandleTrade returns. In async
+// mode each processor has a worker handling its bounded queue, so different
+// symbols proceed in parallel.
+type ProcessorPool struct {
+	processors   []*TradeProcessor
+	queues       []chan queuedTrade // Async mode only
+	workers      sync.WaitGroup
+	mu           sync.RWMutex
+	closed       bool
+	async        bool
+	dropOldest   bool // Async backpressure: drop the oldest queued trade instead of blocking
+	drainTimeout time.Duration
+}
+
+// NewProcessorPool starts size TradeProcessors sharing the identity, with a
+// worker each when PROCESSING_MODE is async
+func NewProcessorPool(size int, identity *veramo.IdentityInformation, cfg *config.Config) *ProcessorPool {
+	pool := &ProcessorPool{
+		async:        cfg.ProcessingMode == config.ProcessingAsync,
+		dropOldest:   cfg.AsyncBackpressure == config.BackpressureDropOldest,
+		drainTimeout: cfg.ShutdownDrainTimeout,
+	}
+	for i := range size {
+		processor := NewTradeProcessor(identity, cfg)
+		pool.processors = append(pool.processors, processor)
+		if pool.async {
+			queue := make(chan queuedTrade, cfg.AsyncQueueSize)
+			pool.queues = append(pool.queues, queue)
+			pool.workers.Add(1)
+			go pool.work(strconv.Itoa(i), processor, queue)
+		}
+	}
+	metrics.ActiveTradeProcessors.Add(float64(size))
+	return pool
+}
+
+// work handles a shard's trades until its queue is closed and drained
+func (pp *ProcessorPool) work(worker string, processor *TradeProcessor, queue chan queuedTrade) {
+	defer pp.workers.Done()
+	depth := metrics.AsyncQueueDepth.WithLabelValues(worker)
+	busy := metrics.AsyncWorkerBusySeconds.WithLabelValues(worker)
+	for queued := range queue {
+		depth.Set(float64(len(queue)))
+		start := time.Now()
+		if err := processor.HandleTrade(queued.trade, queued.startTimestamp); err != nil {
+			log.Printf("❌ Error processing trade for symbol %s: %v", queued.trade.Symbol, err)
+		}
+		busy.Add(time.Since(start).Seconds())
+	}
+}
+
+// shard returns the index of the processor that handles the symbol
+func (pp *ProcessorPool) shard(symbol string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(symbol))
+	return int(hash.Sum32() % uint32(len(pp.processors)))
+}
+
+// HandleTrade hands the trade to its symbol's processor. In async mode it
+// queues the trade, and when the queue is full either blocks or drops the
+// oldest queued trade, depending on ASYNC_BACKPRESSURE.
+func (pp *ProcessorPool) HandleTrade(trade models.FinnhubTrade, startTimestamp time.Time) error {
+	shard := pp.shard(trade.Symbol)
+	if !pp.async {
+		return pp.processors[shard].HandleTrade(trade, startTimestamp)
+	}
+
+	pp.mu.RLock()
+	defer pp.mu.RUnlock()
+	if pp.closed {
+		metrics.TradesProcessedTotal.WithLabelValues(trade.Symbol, "failed").Inc()
+		return fmt.Errorf("processor pool is closed")
+	}
+	queue := pp.queues[shard]
+	queued := queuedTrade{trade: trade, startTimestamp: startTimestamp}
+	if !pp.dropOldest {
+		queue <- queued
+	} else {
+		for !pp.offer(queue, queued) {
+			// Make room; the worker may take the oldest trade first
+			select {
+			case dropped := <-queue:
+				metrics.TradesProcessedTotal.WithLabelValues(dropped.trade.Symbol, "dropped").Inc()
+			default:
+			}
+		}
+	}
+	metrics.AsyncQueueDepth.WithLabelValues(strconv.Itoa(shard)).Set(float64(len(queue)))
+	return nil
+}
+
+// offer queues the trade unless the queue is full
+func (pp *ProcessorPool) offer(queue chan queuedTrade, queued queuedTrade) bool {
+	select {
+	case queue <- queued:
+		return true
+	default:
+		return false
+	}
+}
+
+// HandleBatch hands each trade to its symbol's processor, returning each
+// trade's error by index. In sync mode each processor handles its share of
+// the batch as one batch, in order; in async mode the trades are queued.
+func (pp *ProcessorPool) HandleBatch(trades []models.FinnhubTrade, timestamp time.Time) []error {
+	errs := make([]error, len(trades))
+	if pp.async {
+		for i, trade := range trades {
+			errs[i] = pp.HandleTrade(trade, timestamp)
+		}
+		return errs
+	}
+
+	// Split the batch by shard, remembering where each trade came from
+	shares := make(map[int][]int)
+	var order []int
+	for i, trade := range trades {
+		shard := pp.shard(trade.Symbol)
+		if _, ok := shares[shard]; !ok {
+			order = append(order, shard)
+		}
+		shares[shard] = append(shares[shard], i)
+	}
+	for _, shard := range order {
+		share := make([]models.FinnhubTrade, len(shares[shard]))
+		for j, i := range shares[shard] {
+			share[j] = trades[i]
+		}
+		for j, err := range pp.processors[shard].HandleBatch(share, timestamp) {
+			errs[shares[shard][j]] = err
+		}
+	}
+	return errs
+}
+
+// Close stops accepting trades and, in async mode, lets every worker work
+// through its queue for up to SHUTDOWN_DRAIN_TIMEOUT. It then closes all the
+// processors, each waiting for its in-flight trades; trades still queued are
+// then cancelled.
+func (pp *ProcessorPool) Close() error {
+	pp.mu.Lock()
+	if pp.closed {
+		pp.mu.Unlock()
+		return nil // Already closed
+	}
+	pp.closed = true
+	for _, queue := range pp.queues {
+		close(queue)
+	}
+	pp.mu.Unlock()
+
+	if pp.async {
+		drained := make(chan struct{})
+		go func() {
+			pp.workers.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(pp.drainTimeout):
+			log.Printf("⚠️ Timeout waiting for queued trades, cancelling the rest")
+		}
+	}
+
+	errs := make([]error, len(pp.processors))
+	var wg sync.WaitGroup
+	for i, processor := range pp.processors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = processor.Close()
+			metrics.ActiveTradeProcessors.Dec()
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("📊 Processor pool closed - Total processed: %d", pp.GetProcessedCount())
+	return nil
+}
+
+// GetProcessedCount returns the number of trades processed across the pool
+func (pp *ProcessorPool) GetProcessedCount() int {
+	count := 0
+	for _, processor := range pp.processors {
+		count += processor.GetProcessedCount()
+	}
+	return count
+}
+
+// Size returns the number of processors in the pool
+func (pp *ProcessorPool) Size() int {
+	return len(pp.processors)
+}
@@ -0,0 +1,160 @@
+package finnhub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"data_synthesizer/service/metrics"
+)
+
+// Frames waiting to be written before Record starts dropping them
+const recordBuffer = 4096
+
+// RecordedFrame is a line of a recording: a raw Finnhub frame and when it
+// was received, in ms. The file data source replays recordings as well as
+// bare frames.
+type RecordedFrame struct {
+	ReceivedAt int64           `json:"received_at"`
+	Frame      json.RawMessage `json:"frame"`
+}
+
+// Recorder appends raw Finnhub frames to a file, one RecordedFrame per
+// line, for replaying later. Frames are written by a goroutine of its own,
+// so recording doesn't hold up the reader. Past maxBytes the file is
+// rotated: renamed with a timestamp suffix and started afresh.
+type Recorder struct {
+	path     string
+	maxBytes int64 // 0 never rotates
+
+	frames chan RecordedFrame
+	done   chan struct{}
+
+	file    *os.File
+	writer  *bufio.Writer
+	written int64 // Bytes in the current file
+}
+
+// NewRecorder opens path for appending and starts writing the frames
+// recorded
+func NewRecorder(path string, maxBytes int64) (*Recorder, error) {
+	r := &Recorder{
+		path:     path,
+		maxBytes: maxBytes,
+		frames:   make(chan RecordedFrame, recordBuffer),
+		done:     make(chan struct{}),
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+// Record queues the frame received at receivedAt for writing, dropping it
+// if the writer has fallen recordBuffer frames behind. The frame must not
+// be modified afterwards.
+func (r *Recorder) Record(frame []byte, receivedAt time.Time) {
+	select {
+	case r.frames <- RecordedFrame{ReceivedAt: receivedAt.UnixMilli(), Frame: frame}:
+	default:
+		metrics.RecordedFramesDropped.Inc()
+	}
+}
+
+// run writes the queued frames until Close, flushing whenever it catches up
+func (r *Recorder) run() {
+	defer close(r.done)
+	for frame := range r.frames {
+		if err := r.write(frame); err != nil {
+			log.Printf("⚠️ Error recording frame to %s: %v", r.path, err)
+		}
+		if len(r.frames) == 0 && r.writer != nil {
+			if err := r.writer.Flush(); err != nil {
+				log.Printf("⚠️ Error flushing %s: %v", r.path, err)
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(frame RecordedFrame) error {
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if r.maxBytes > 0 && r.written > 0 && r.written+int64(len(line)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	if r.writer == nil {
+		return fmt.Errorf("recording file is not open")
+	}
+	n, err := r.writer.Write(line)
+	r.written += int64(n)
+	metrics.RecordedBytes.Add(float64(n))
+	return err
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open recording file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat recording file: %w", err)
+	}
+	r.file = file
+	r.writer = bufio.NewWriter(file)
+	r.written = info.Size()
+	return nil
+}
+
+// rotate closes the current file, moves it aside and opens a new one
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", r.path, time.Now().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(r.path, rotated); err != nil {
+		// Keep appending to the current file
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate recording file: %w", err)
+	}
+	log.Printf("Rotated recording to %s", rotated)
+	return r.open()
+}
+
+// closeFile flushes, syncs and closes the current file
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	if syncErr := r.file.Sync(); syncErr != nil && err == nil {
+		err = syncErr
+	}
+	if closeErr := r.file.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	r.file = nil
+	r.writer = nil
+	return err
+}
+
+// Close writes the frames still queued, then flushes, syncs and closes the
+// file. Nothing may be recorded after Close.
+func (r *Recorder) Close() error {
+	close(r.frames)
+	<-r.done
+	return r.closeFile()
+}
@@ -0,0 +1,192 @@
+package finnhub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"data_synthesizer/models"
+)
+
+// Longest recorded frame the replay accepts
+const maxReplayFrame = 10 * 1024 * 1024
+
+// DataSource is a source of Finnhub trade messages the Runner supervises:
+// the live WebSocket client or a recorded file
+type DataSource interface {
+	Connect(ctx context.Context) error
+	// Start processes messages until ctx is cancelled or the message limit is
+	// reached, returning nil, or the source fails, returning an error. A
+	// finite source also returns nil once it is exhausted.
+	Start(ctx context.Context) error
+	Close() error
+	GetMessageCount() int
+}
+
+// ReplayClient replays raw Finnhub WebSocket frames recorded one per line,
+// bare or as written by a Recorder, feeding them through the same message
+// pipeline as the live client
+type ReplayClient struct {
+	path  string
+	speed float64 // 1 honors the recorded gaps between frames, 2 halves them, 0 doesn't wait
+	loops int     // Times to replay the file; 0 repeats it until stopped
+	file  *os.File
+
+	messages *FinnhubClient // Parses frames and counts messages like the live client
+}
+
+// NewReplayClient creates a client replaying the file at path, handling the
+// trades of the symbols in subscriptions
+func NewReplayClient(path string, speed float64, loops int, subscriptions *Subscriptions, maxMessages int, handler models.TradeHandler) *ReplayClient {
+	return &ReplayClient{
+		path:     path,
+		speed:    speed,
+		loops:    loops,
+		messages: NewFinnhubClient(nil, subscriptions, maxMessages, handler),
+	}
+}
+
+// SetThrottle samples and rate limits trades per symbol before they are handled
+func (rc *ReplayClient) SetThrottle(throttle *Throttle) {
+	rc.messages.SetThrottle(throttle)
+}
+
+// SetFilter drops trades that don't pass the filter's per-symbol filters,
+// before they are sampled and handled
+func (rc *ReplayClient) SetFilter(filter *Filter) {
+	rc.messages.SetFilter(filter)
+}
+
+// SetPerTradeDispatch hands trades to the handler one by one, instead of all
+// of a frame's trades in one batch
+func (rc *ReplayClient) SetPerTradeDispatch(perTrade bool) {
+	rc.messages.SetPerTradeDispatch(perTrade)
+}
+
+// SetTradeMapping maps raw trades, symbols included, before they are handled
+func (rc *ReplayClient) SetTradeMapping(mapping *TradeMapping) {
+	rc.messages.SetTradeMapping(mapping)
+}
+
+// Connect opens the replay file
+func (rc *ReplayClient) Connect(ctx context.Context) error {
+	file, err := os.Open(rc.path)
+	if err != nil {
+		return fmt.Errorf("failed to open replay file: %w", err)
+	}
+	rc.file = file
+	log.Printf("Replaying Finnhub messages from %s (speed %gx, loops %d)", rc.path, rc.speed, rc.loops)
+	return nil
+}
+
+// Start replays the file's frames until ctx is cancelled, the message limit
+// is reached or every loop has been played
+func (rc *ReplayClient) Start(ctx context.Context) error {
+	if rc.file == nil {
+		return fmt.Errorf("not connected - call Connect() first")
+	}
+	defer rc.closeFile()
+
+	for loop := 0; rc.loops == 0 || loop < rc.loops; loop++ {
+		if _, err := rc.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind replay file: %w", err)
+		}
+		done, err := rc.replay(ctx)
+		if err != nil || done {
+			return err
+		}
+	}
+	log.Printf("Replay finished")
+	return nil
+}
+
+// replay plays the file once, reporting whether the replay should stop
+func (rc *ReplayClient) replay(ctx context.Context) (bool, error) {
+	scanner := bufio.NewScanner(rc.file)
+	scanner.Buffer(make([]byte, 64*1024), maxReplayFrame)
+
+	var previous int64 // Timestamp of the last frame with trades, in ms
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		frame, timestamp := replayFrame(line)
+		if timestamp > 0 {
+			if previous > 0 && timestamp > previous && rc.speed > 0 {
+				gap := time.Duration(float64(time.Duration(timestamp-previous)*time.Millisecond) / rc.speed)
+				select {
+				case <-ctx.Done():
+					return true, nil
+				case <-time.After(gap):
+				}
+			}
+			previous = timestamp
+		}
+		if ctx.Err() != nil {
+			return true, nil
+		}
+
+		if err := rc.messages.processMessage(frame); err != nil {
+			log.Printf("Error processing message: %v", err)
+			continue
+		}
+		if rc.messages.limitReached() {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("failed to read replay file: %w", err)
+	}
+	return false, nil
+}
+
+// replayFrame returns the frame on a line of the file and its time in ms,
+// 0 when unknown. Recorded frames carry the time they were received; for
+// bare frames it is the time of their first trade.
+func replayFrame(line []byte) ([]byte, int64) {
+	var recorded RecordedFrame
+	if err := json.Unmarshal(line, &recorded); err == nil && len(recorded.Frame) > 0 {
+		return recorded.Frame, recorded.ReceivedAt
+	}
+	return line, frameTimestamp(line)
+}
+
+// frameTimestamp returns the time of a frame's first trade, in ms, or 0 for
+// frames without trades
+func frameTimestamp(frame []byte) int64 {
+	var msg models.TradeMessage
+	if err := json.Unmarshal(frame, &msg); err != nil || len(msg.Data) == 0 {
+		return 0
+	}
+	return msg.Data[0].Event_Timestamp
+}
+
+func (rc *ReplayClient) closeFile() error {
+	if rc.file == nil {
+		return nil
+	}
+	err := rc.file.Close()
+	rc.file = nil
+	return err
+}
+
+// Close closes the trade handler and the replay file
+func (rc *ReplayClient) Close() error {
+	err := rc.messages.Close()
+	if fileErr := rc.closeFile(); fileErr != nil && err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// GetMessageCount returns the current message count (thread-safe)
+func (rc *ReplayClient) GetMessageCount() int {
+	return rc.messages.GetMessageCount()
+}
@@ -0,0 +1,259 @@
+package finnhub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"data_synthesizer/models"
+	"data_synthesizer/service/metrics"
+)
+
+const (
+	// First delay before reconnecting or restarting, doubled after each attempt
+	restartBaseDelay = time.Second
+	// Longest delay between reconnects
+	restartMaxDelay = time.Minute
+	// A connection that stays up this long resets the restart budget
+	stableConnection = 5 * time.Minute
+)
+
+// Reasons a Runner stops, reported by StopReason
+const (
+	StopShutdown     = "shutdown"      // The context was cancelled
+	StopMessageCount = "message_count" // MESSAGE_COUNT messages were handled
+	StopSymbolQuota  = "symbol_quota"  // Every symbol handled MESSAGE_COUNT_PER_SYMBOL messages
+	StopRunDuration  = "run_duration"  // RUN_DURATION passed since the first connection
+	StopExhausted    = "exhausted"     // A finite source, such as a replay, ran out of messages
+	StopFailed       = "failed"        // The restart budget ran out
+)
+
+// SourceFactory creates a data source that handles at most maxMessages
+// messages (0 for no limit)
+type SourceFactory func(maxMessages int) DataSource
+
+// subscriber is a data source that subscribes to symbols on its connection
+type subscriber interface {
+	Subscribe(symbol string) bool
+	Unsubscribe(symbol string) bool
+}
+
+// Runner supervises a data source: when it fails it creates a new one,
+// reconnects with exponential backoff and jitter, and keeps counting
+// messages toward maxMessages across restarts. With a runDuration it also
+// stops that long after the first connection, whichever limit comes first.
+type Runner struct {
+	newSource   SourceFactory
+	symbols     *Subscriptions
+	maxMessages int
+	maxRestarts int
+	runDuration time.Duration
+	handler     models.TradeHandler
+
+	mu         sync.Mutex
+	processed  int        // Messages handled by sources that have stopped
+	current    DataSource // Source currently running, if any
+	stopReason string
+
+	// Held while switching sources and while changing subscriptions, so a
+	// new source's connection sees every change
+	sourceMu sync.Mutex
+
+	durationExpired atomic.Bool
+}
+
+// NewRunner creates a supervisor allowing maxRestarts consecutive restarts
+// before giving up, and running for at most runDuration (0 for no limit).
+// subscriptions are the symbols the sources handle and handler is the trade
+// handler they feed.
+func NewRunner(newSource SourceFactory, subscriptions *Subscriptions, maxMessages int, maxRestarts int, runDuration time.Duration, handler models.TradeHandler) *Runner {
+	return &Runner{
+		newSource:   newSource,
+		symbols:     subscriptions,
+		maxMessages: maxMessages,
+		maxRestarts: maxRestarts,
+		runDuration: runDuration,
+		handler:     handler,
+	}
+}
+
+// Run connects and processes messages until ctx is cancelled, the message
+// limit or every symbol's quota is reached or the run duration passes,
+// restarting the client when the connection fails. It returns an error once
+// maxRestarts consecutive restarts haven't produced a stable connection, and
+// nil once a finite source is exhausted. The trade handler is closed when Run
+// returns; StopReason then tells why it stopped.
+func (r *Runner) Run(ctx context.Context) error {
+	defer func() {
+		if err := r.handler.Close(); err != nil {
+			log.Printf("Error closing trade handler: %v", err)
+		}
+	}()
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	var runTimer *time.Timer
+	defer func() {
+		if runTimer != nil {
+			runTimer.Stop()
+		}
+	}()
+
+	restarts := 0
+	for {
+		remaining := 0
+		if r.maxMessages > 0 {
+			remaining = r.maxMessages - r.GetMessageCount()
+			if remaining <= 0 {
+				r.setStopReason(StopMessageCount)
+				return nil
+			}
+		}
+
+		client := r.newSource(remaining)
+		r.setCurrent(client)
+		reason := "connect_failed"
+		err := client.Connect(runCtx)
+		connectedAt := time.Now()
+		if err == nil {
+			reason = "connection_lost"
+			// The run duration counts from the first connection, across restarts
+			if r.runDuration > 0 && runTimer == nil {
+				runTimer = time.AfterFunc(r.runDuration, func() {
+					r.durationExpired.Store(true)
+					cancelRun()
+				})
+			}
+			err = client.Start(runCtx)
+		}
+		r.finish(client)
+
+		if runCtx.Err() != nil {
+			r.setStopReason(r.cancelReason())
+			return nil
+		}
+		if r.maxMessages > 0 && r.GetMessageCount() >= r.maxMessages {
+			r.setStopReason(StopMessageCount)
+			return nil
+		}
+		if r.symbols.QuotasReached() {
+			r.setStopReason(StopSymbolQuota)
+			return nil
+		}
+		if reason == "connection_lost" && err == nil {
+			r.setStopReason(StopExhausted)
+			return nil
+		}
+		if reason == "connection_lost" && time.Since(connectedAt) >= stableConnection {
+			restarts = 0
+		}
+		if restarts >= r.maxRestarts {
+			r.setStopReason(StopFailed)
+			return fmt.Errorf("data source failed after %d restarts: %w", restarts, err)
+		}
+
+		restarts++
+		delay := backoff(restarts, restartMaxDelay)
+		metrics.FinnhubClientRestarts.WithLabelValues(reason).Inc()
+		log.Printf("⚠️ Data source stopped (%v); restart %d/%d in %s", err, restarts, r.maxRestarts, delay)
+		select {
+		case <-runCtx.Done():
+			r.setStopReason(r.cancelReason())
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// cancelReason tells whether the run context was cancelled by the run
+// duration or by the caller
+func (r *Runner) cancelReason() string {
+	if r.durationExpired.Load() {
+		return StopRunDuration
+	}
+	return StopShutdown
+}
+
+func (r *Runner) setStopReason(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopReason = reason
+}
+
+// StopReason returns why Run stopped, or "" while it is running
+func (r *Runner) StopReason() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopReason
+}
+
+// backoff returns the delay before the given attempt: exponential from
+// restartBaseDelay up to maxDelay, with the upper half jittered
+func backoff(attempt int, maxDelay time.Duration) time.Duration {
+	delay := restartBaseDelay
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (r *Runner) setCurrent(client DataSource) {
+	r.sourceMu.Lock()
+	defer r.sourceMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = client
+}
+
+// Subscribe adds the symbol to the subscriptions, reporting whether it was
+// new, and subscribes to it on the running source's connection
+func (r *Runner) Subscribe(symbol string) bool {
+	r.sourceMu.Lock()
+	defer r.sourceMu.Unlock()
+	if source, ok := r.currentSource().(subscriber); ok {
+		return source.Subscribe(symbol)
+	}
+	return r.symbols.Add(symbol)
+}
+
+// Unsubscribe removes the symbol from the subscriptions, reporting whether
+// it was subscribed, and unsubscribes from it on the running source's
+// connection
+func (r *Runner) Unsubscribe(symbol string) bool {
+	r.sourceMu.Lock()
+	defer r.sourceMu.Unlock()
+	if source, ok := r.currentSource().(subscriber); ok {
+		return source.Unsubscribe(symbol)
+	}
+	return r.symbols.Remove(symbol)
+}
+
+func (r *Runner) currentSource() DataSource {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// finish adds a stopped source's messages to the total
+func (r *Runner) finish(client DataSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.processed += client.GetMessageCount()
+	r.current = nil
+}
+
+// GetMessageCount returns the messages handled across all restarts (thread-safe)
+func (r *Runner) GetMessageCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.processed
+	if r.current != nil {
+		count += r.current.GetMessageCount()
+	}
+	return count
+}
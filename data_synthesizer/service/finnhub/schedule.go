@@ -0,0 +1,54 @@
+package finnhub
+
+import (
+	"time"
+
+	"data_synthesizer/config"
+)
+
+const (
+	// Status counted in TradesProcessedTotal for trades outside market hours
+	statusOutsideHours = "outside_hours"
+	// How often unsubscribing schedules check for windows opening or closing
+	scheduleInterval = 30 * time.Second
+)
+
+// Schedule gates symbols by their market hours, such as equities that only
+// trade in the daytime while crypto trades around the clock. Outside its
+// window a symbol's trades are dropped, and with unsubscribe set the
+// symbol is unsubscribed until the window opens again.
+type Schedule struct {
+	hours       config.MarketHours
+	unsubscribe bool
+}
+
+// NewSchedule creates a schedule for the market hours, unsubscribing from
+// symbols outside them with unsubscribe set
+func NewSchedule(hours config.MarketHours, unsubscribe bool) *Schedule {
+	return &Schedule{hours: hours, unsubscribe: unsubscribe}
+}
+
+// Open reports whether the symbol's window holds now
+func (s *Schedule) Open(symbol string, now time.Time) bool {
+	return s.hours.Open(symbol, now)
+}
+
+// Closed returns the symbols outside their window at now
+func (s *Schedule) Closed(symbols []string, now time.Time) []string {
+	var closed []string
+	for _, symbol := range symbols {
+		if !s.Open(symbol, now) {
+			closed = append(closed, symbol)
+		}
+	}
+	return closed
+}
+
+// Describe returns the symbol's window with its time zone
+func (s *Schedule) Describe(symbol string) string {
+	window := s.hours.For(symbol)
+	if window == (config.TradingWindow{}) {
+		return "always open"
+	}
+	return window.String() + " " + s.hours.Location.String()
+}
@@ -0,0 +1,77 @@
+package finnhub
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"data_synthesizer/config"
+)
+
+// newTestSchedule gates equities to New York trading hours and leaves
+// Binance pairs open around the clock
+func newTestSchedule(t *testing.T) *Schedule {
+	t.Helper()
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equities := config.TradingWindow{From: 9*time.Hour + 30*time.Minute, To: 16 * time.Hour}
+	for day := time.Monday; day <= time.Friday; day++ {
+		equities.Days[day] = true
+	}
+	return NewSchedule(config.MarketHours{
+		Location: newYork,
+		Default:  equities,
+		Windows:  map[string]config.TradingWindow{"BINANCE:": {}},
+	}, false)
+}
+
+func TestScheduleOpen(t *testing.T) {
+	schedule := newTestSchedule(t)
+	tests := []struct {
+		name   string
+		symbol string
+		at     time.Time
+		open   bool
+	}{
+		{"equity at the bell", "AAPL", time.Date(2024, 3, 8, 14, 30, 0, 0, time.UTC), true},
+		{"equity an hour early after spring forward", "AAPL", time.Date(2024, 3, 11, 12, 30, 0, 0, time.UTC), false},
+		{"equity at the bell after spring forward", "AAPL", time.Date(2024, 3, 11, 13, 30, 0, 0, time.UTC), true},
+		{"equity at the weekend", "AAPL", time.Date(2024, 3, 9, 15, 0, 0, 0, time.UTC), false},
+		{"equity overnight", "AAPL", time.Date(2024, 3, 12, 3, 0, 0, 0, time.UTC), false},
+		{"crypto at the weekend", "BINANCE:BTCUSDT", time.Date(2024, 3, 9, 15, 0, 0, 0, time.UTC), true},
+		{"crypto overnight", "BINANCE:BTCUSDT", time.Date(2024, 3, 12, 3, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schedule.Open(tt.symbol, tt.at); got != tt.open {
+				t.Errorf("Open(%s, %s) = %t, want %t", tt.symbol, tt.at.Format(time.RFC3339), got, tt.open)
+			}
+		})
+	}
+}
+
+func TestScheduleClosed(t *testing.T) {
+	schedule := newTestSchedule(t)
+	symbols := []string{"AAPL", "BINANCE:BTCUSDT", "MSFT", "BINANCE:ETHUSDT"}
+
+	saturday := time.Date(2024, 3, 9, 15, 0, 0, 0, time.UTC)
+	if got, want := schedule.Closed(symbols, saturday), []string{"AAPL", "MSFT"}; !slices.Equal(got, want) {
+		t.Errorf("Closed() on Saturday = %v, want %v", got, want)
+	}
+	monday := time.Date(2024, 3, 11, 15, 0, 0, 0, time.UTC)
+	if got := schedule.Closed(symbols, monday); len(got) != 0 {
+		t.Errorf("Closed() during trading hours = %v, want none", got)
+	}
+}
+
+func TestScheduleDescribe(t *testing.T) {
+	schedule := newTestSchedule(t)
+	if got, want := schedule.Describe("AAPL"), "Mon-Fri 09:30-16:00 America/New_York"; got != want {
+		t.Errorf("Describe(AAPL) = %q, want %q", got, want)
+	}
+	if got, want := schedule.Describe("BINANCE:BTCUSDT"), "always open"; got != want {
+		t.Errorf("Describe(BINANCE:BTCUSDT) = %q, want %q", got, want)
+	}
+}
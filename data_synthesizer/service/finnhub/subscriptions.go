@@ -0,0 +1,179 @@
+package finnhub
+
+import (
+	"slices"
+	"sync"
+)
+
+// Subscriptions is the set of symbols the data source handles, shared by
+// the clients the Runner creates so changes made at runtime survive
+// restarts. It counts the trades handled per symbol, and with a quota
+// a symbol is done once it has handled that many.
+type Subscriptions struct {
+	mu      sync.RWMutex
+	symbols []string          // In subscription order
+	counts  map[string]int    // Trades handled per subscribed symbol
+	quota   int               // Trades handled per symbol before it is done; 0 for no limit
+	failed  map[string]string // Finnhub's error for symbols it rejected
+}
+
+// SubscriptionStatus is a subscribed symbol and the trades handled for it,
+// with Finnhub's error if it rejected the symbol
+type SubscriptionStatus struct {
+	Symbol   string `json:"symbol"`
+	Messages int    `json:"messages"`
+	Failed   string `json:"failed,omitempty"`
+}
+
+// NewSubscriptions creates a set holding the given symbols
+func NewSubscriptions(symbols []string) *Subscriptions {
+	s := &Subscriptions{counts: make(map[string]int), failed: make(map[string]string)}
+	for _, symbol := range symbols {
+		s.Add(symbol)
+	}
+	return s
+}
+
+// SetQuota limits the trades handled per symbol (0 = no limit)
+func (s *Subscriptions) SetQuota(quota int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quota = quota
+}
+
+// Quota returns the trades handled per symbol before it is done, 0 for no limit
+func (s *Subscriptions) Quota() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.quota
+}
+
+// Add subscribes the symbol, reporting whether it was new
+func (s *Subscriptions) Add(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.counts[symbol]; exists {
+		return false
+	}
+	s.symbols = append(s.symbols, symbol)
+	s.counts[symbol] = 0
+	return true
+}
+
+// Remove unsubscribes the symbol, reporting whether it was subscribed. Its
+// count starts over if it is added again.
+func (s *Subscriptions) Remove(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.counts[symbol]; !exists {
+		return false
+	}
+	s.symbols = slices.DeleteFunc(s.symbols, func(sub string) bool { return sub == symbol })
+	delete(s.counts, symbol)
+	delete(s.failed, symbol)
+	return true
+}
+
+// Fail marks a subscribed symbol as rejected by Finnhub with reason,
+// reporting whether it was subscribed and not failed yet. A failed symbol
+// is done; removing it and adding it again retries it.
+func (s *Subscriptions) Fail(symbol string, reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.counts[symbol]; !exists {
+		return false
+	}
+	if _, failed := s.failed[symbol]; failed {
+		return false
+	}
+	s.failed[symbol] = reason
+	return true
+}
+
+// Has reports whether the symbol is subscribed
+func (s *Subscriptions) Has(symbol string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.counts[symbol]
+	return exists
+}
+
+// Symbols returns the subscribed symbols in subscription order
+func (s *Subscriptions) Symbols() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.symbols)
+}
+
+// Active returns the subscribed symbols that aren't done, in subscription
+// order
+func (s *Subscriptions) Active() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var active []string
+	for _, symbol := range s.symbols {
+		if !s.done(symbol) {
+			active = append(active, symbol)
+		}
+	}
+	return active
+}
+
+// done reports whether the symbol failed or reached the quota. The caller
+// holds mu.
+func (s *Subscriptions) done(symbol string) bool {
+	if _, failed := s.failed[symbol]; failed {
+		return true
+	}
+	return s.quota > 0 && s.counts[symbol] >= s.quota
+}
+
+// remaining returns how many more trades the symbol may handle, or -1
+// without a quota
+func (s *Subscriptions) remaining(symbol string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.quota == 0 {
+		return -1
+	}
+	return max(s.quota-s.counts[symbol], 0)
+}
+
+// QuotasReached reports whether every subscribed symbol is done, failed
+// symbols included; never without a quota or without symbols
+func (s *Subscriptions) QuotasReached() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.quota == 0 || len(s.symbols) == 0 {
+		return false
+	}
+	for _, symbol := range s.symbols {
+		if !s.done(symbol) {
+			return false
+		}
+	}
+	return true
+}
+
+// record counts a handled trade, unless the symbol was unsubscribed
+// meanwhile, reporting whether it made the symbol reach the quota
+func (s *Subscriptions) record(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.counts[symbol]; !exists {
+		return false
+	}
+	s.counts[symbol]++
+	return s.quota > 0 && s.counts[symbol] == s.quota
+}
+
+// Status returns every subscribed symbol with its trade count
+func (s *Subscriptions) Status() []SubscriptionStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := make([]SubscriptionStatus, 0, len(s.symbols))
+	for _, symbol := range s.symbols {
+		status = append(status, SubscriptionStatus{Symbol: symbol, Messages: s.counts[symbol], Failed: s.failed[symbol]})
+	}
+	return status
+}
@@ -0,0 +1,96 @@
+package finnhub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"data_synthesizer/service/veramo"
+)
+
+// SubscriptionsAPI changes the Runner's subscriptions at runtime,
+// bootstrapping a DID and credential for symbols that don't have one
+type SubscriptionsAPI struct {
+	runner        *Runner
+	subscriptions *Subscriptions
+	identity      *veramo.IdentityInformation
+	addMu         sync.Mutex // Serializes additions so each DID is created once
+}
+
+// SubscriptionsResponse is returned by GET /subscriptions
+type SubscriptionsResponse struct {
+	Subscriptions []SubscriptionStatus `json:"subscriptions"`
+}
+
+// SubscribeRequest is the body of POST /subscriptions
+type SubscribeRequest struct {
+	Symbol string `json:"symbol"`
+}
+
+// NewSubscriptionsAPI creates the API for the runner's subscriptions
+func NewSubscriptionsAPI(runner *Runner, subscriptions *Subscriptions, identity *veramo.IdentityInformation) *SubscriptionsAPI {
+	return &SubscriptionsAPI{runner: runner, subscriptions: subscriptions, identity: identity}
+}
+
+// List handles GET /subscriptions: the subscribed symbols with the trades
+// handled for each since it was subscribed
+func (api *SubscriptionsAPI) List(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, SubscriptionsResponse{Subscriptions: api.subscriptions.Status()})
+}
+
+// Add handles POST /subscriptions with a {"symbol": ...} body. It answers
+// 201 once the symbol is subscribed, or 200 if it already was.
+func (api *SubscriptionsAPI) Add(w http.ResponseWriter, r *http.Request) {
+	var req SubscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	symbol := strings.TrimSpace(req.Symbol)
+	if symbol == "" || strings.ContainsAny(symbol, ", \t") {
+		http.Error(w, fmt.Sprintf("invalid symbol %q", req.Symbol), http.StatusBadRequest)
+		return
+	}
+
+	api.addMu.Lock()
+	defer api.addMu.Unlock()
+
+	// The symbol needs its identity before its trades can be signed
+	created, err := api.identity.EnsureCredential(symbol)
+	if err != nil {
+		log.Printf("❌ Error bootstrapping identity for %s: %v", symbol, err)
+		http.Error(w, fmt.Sprintf("failed to bootstrap identity for %s: %v", symbol, err), http.StatusBadGateway)
+		return
+	}
+	if created {
+		log.Printf("🔐 Number of credentials: %d...", api.identity.CredentialCount())
+	}
+
+	status := http.StatusOK
+	if api.runner.Subscribe(symbol) {
+		status = http.StatusCreated
+		log.Printf("➕ Subscribed to %s at runtime", symbol)
+	}
+	writeJSON(w, status, SubscriptionStatus{Symbol: symbol})
+}
+
+// Remove handles DELETE /subscriptions/{symbol}. Trades for the symbol
+// still in flight are dropped; its identity is kept for resubscribing.
+func (api *SubscriptionsAPI) Remove(w http.ResponseWriter, r *http.Request) {
+	symbol := r.PathValue("symbol")
+	if !api.runner.Unsubscribe(symbol) {
+		http.Error(w, fmt.Sprintf("not subscribed to %s", symbol), http.StatusNotFound)
+		return
+	}
+	log.Printf("➖ Unsubscribed from %s at runtime", symbol)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,181 @@
+package finnhub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"data_synthesizer/models"
+)
+
+const (
+	// Generated trades are sent as one frame per interval, like Finnhub batches them
+	syntheticFrameInterval = 100 * time.Millisecond
+	// Standard deviation of the relative price change between two trades
+	syntheticVolatility = 0.0005
+)
+
+// SyntheticClient generates plausible trades for the subscribed symbols,
+// for running the pipeline without a Finnhub key. Each symbol's trades
+// arrive at random at an average rate, its price follows a random walk and
+// its volumes are log-normal. The trades are sent as Finnhub frames through
+// the same message pipeline as the live client.
+type SyntheticClient struct {
+	rate        float64 // Average trades per second, per symbol
+	seed        uint64
+	volumeMean  float64
+	volumeSigma float64 // Spread of the log-normal volumes; 0 always trades volumeMean
+
+	rng     *rand.Rand
+	clock   time.Time // Time of the generated trades, advanced a frame at a time
+	symbols map[string]*syntheticSymbol
+
+	messages *FinnhubClient // Parses frames and counts messages like the live client
+}
+
+type syntheticSymbol struct {
+	price float64
+	next  time.Time // Time of the symbol's next trade
+}
+
+// NewSyntheticClient creates a client generating rate trades per second
+// for each symbol in subscriptions. The same seed and symbols give the same
+// trades.
+func NewSyntheticClient(rate float64, seed uint64, volumeMean float64, volumeSigma float64, subscriptions *Subscriptions, maxMessages int, handler models.TradeHandler) *SyntheticClient {
+	return &SyntheticClient{
+		rate:        rate,
+		seed:        seed,
+		volumeMean:  volumeMean,
+		volumeSigma: volumeSigma,
+		messages:    NewFinnhubClient(nil, subscriptions, maxMessages, handler),
+	}
+}
+
+// SetThrottle samples and rate limits trades per symbol before they are handled
+func (sc *SyntheticClient) SetThrottle(throttle *Throttle) {
+	sc.messages.SetThrottle(throttle)
+}
+
+// SetFilter drops trades that don't pass the filter's per-symbol filters,
+// before they are sampled and handled
+func (sc *SyntheticClient) SetFilter(filter *Filter) {
+	sc.messages.SetFilter(filter)
+}
+
+// SetPerTradeDispatch hands trades to the handler one by one, instead of all
+// of a frame's trades in one batch
+func (sc *SyntheticClient) SetPerTradeDispatch(perTrade bool) {
+	sc.messages.SetPerTradeDispatch(perTrade)
+}
+
+// SetTradeMapping maps raw trades, symbols included, before they are handled
+func (sc *SyntheticClient) SetTradeMapping(mapping *TradeMapping) {
+	sc.messages.SetTradeMapping(mapping)
+}
+
+// Connect seeds the generator
+func (sc *SyntheticClient) Connect(ctx context.Context) error {
+	sc.rng = rand.New(rand.NewPCG(sc.seed, sc.seed))
+	sc.clock = time.Now().Truncate(time.Millisecond)
+	sc.symbols = make(map[string]*syntheticSymbol)
+	log.Printf("Generating synthetic trades (%g trades/s per ticker, seed %d)", sc.rate, sc.seed)
+	return nil
+}
+
+// Start sends a frame of generated trades every syntheticFrameInterval
+// until ctx is cancelled or the message limit is reached
+func (sc *SyntheticClient) Start(ctx context.Context) error {
+	if sc.rng == nil {
+		return fmt.Errorf("not connected - call Connect() first")
+	}
+
+	ticker := time.NewTicker(syntheticFrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		sc.clock = sc.clock.Add(syntheticFrameInterval)
+		trades := sc.generate(sc.clock)
+		if len(trades) == 0 {
+			continue
+		}
+		frame, err := json.Marshal(models.TradeMessage{Type: "trade", Data: trades})
+		if err != nil {
+			return fmt.Errorf("failed to encode synthetic frame: %w", err)
+		}
+		if err := sc.messages.processMessage(frame); err != nil {
+			log.Printf("Error processing message: %v", err)
+			continue
+		}
+		if sc.messages.limitReached() {
+			return nil
+		}
+	}
+}
+
+// generate returns the trades up to until of every subscribed symbol that
+// isn't done, in subscription order
+func (sc *SyntheticClient) generate(until time.Time) []models.FinnhubTradeRaw {
+	var trades []models.FinnhubTradeRaw
+	for _, symbol := range sc.messages.symbols.Active() {
+		state := sc.symbol(symbol, until)
+		for !state.next.After(until) {
+			state.price *= math.Exp(syntheticVolatility * sc.rng.NormFloat64())
+			trades = append(trades, models.FinnhubTradeRaw{
+				Price:           math.Round(state.price*100) / 100,
+				Symbol:          symbol,
+				Event_Timestamp: state.next.UnixMilli(),
+				Volume:          sc.volume(),
+			})
+			state.next = state.next.Add(sc.interval())
+		}
+	}
+	return trades
+}
+
+// symbol returns the symbol's state, starting a symbol seen for the first
+// time at now with a price derived from its name
+func (sc *SyntheticClient) symbol(symbol string, now time.Time) *syntheticSymbol {
+	if state, ok := sc.symbols[symbol]; ok {
+		return state
+	}
+	// Between 10 and 1000, the same for a symbol whatever else is subscribed
+	hash := fnv.New32a()
+	hash.Write([]byte(symbol))
+	price := 10 * math.Pow(100, float64(hash.Sum32())/math.MaxUint32)
+
+	state := &syntheticSymbol{price: price, next: now.Add(sc.interval())}
+	sc.symbols[symbol] = state
+	return state
+}
+
+// interval returns the time until a symbol's next trade: exponential, so
+// trades arrive as a Poisson process at the average rate
+func (sc *SyntheticClient) interval() time.Duration {
+	return time.Duration(sc.rng.ExpFloat64() / sc.rate * float64(time.Second))
+}
+
+// volume returns a log-normal volume averaging volumeMean
+func (sc *SyntheticClient) volume() float64 {
+	volume := sc.volumeMean * math.Exp(sc.volumeSigma*sc.rng.NormFloat64()-sc.volumeSigma*sc.volumeSigma/2)
+	return max(math.Round(volume*10000)/10000, 0.0001)
+}
+
+// Close closes the trade handler
+func (sc *SyntheticClient) Close() error {
+	return sc.messages.Close()
+}
+
+// GetMessageCount returns the current message count (thread-safe)
+func (sc *SyntheticClient) GetMessageCount() int {
+	return sc.messages.GetMessageCount()
+}
@@ -0,0 +1,87 @@
+package finnhub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"data_synthesizer/config"
+)
+
+// Statuses counted in TradesProcessedTotal for trades the Throttle drops
+const (
+	statusSampledOut  = "sampled_out"
+	statusRateLimited = "rate_limited"
+)
+
+// Throttle keeps busy symbols from starving the others: per symbol, it
+// samples a fraction of the trades, then caps the trades handled per second
+type Throttle struct {
+	sampling  config.SymbolRates // Fraction of trades kept
+	rateLimit config.SymbolRates // Trades per second, 0 for no limit
+
+	mu      sync.Mutex
+	symbols map[string]*symbolThrottle
+}
+
+type symbolThrottle struct {
+	credit   float64 // Sampling credit; a trade is kept each time it reaches 1
+	tokens   float64 // Rate limit bucket, holding up to a second's worth
+	refilled time.Time
+}
+
+// NewThrottle creates a throttle with the given per-symbol rates
+func NewThrottle(sampling config.SymbolRates, rateLimit config.SymbolRates) *Throttle {
+	return &Throttle{
+		sampling:  sampling,
+		rateLimit: rateLimit,
+		symbols:   make(map[string]*symbolThrottle),
+	}
+}
+
+// Allow reports whether a trade for the symbol arriving at now should be
+// handled, or else the status it is dropped with
+func (t *Throttle) Allow(symbol string, now time.Time) (bool, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sampling := t.sampling.For(symbol)
+	limit := t.rateLimit.For(symbol)
+	state, ok := t.symbols[symbol]
+	if !ok {
+		// The first trade is always kept
+		state = &symbolThrottle{credit: 1, tokens: max(limit, 1), refilled: now}
+		t.symbols[symbol] = state
+	}
+
+	// Sample deterministically: keep one trade each time the credit adds up
+	// to a whole one, so 0.1 keeps exactly every tenth trade
+	keep := state.credit >= 1-1e-9
+	if keep {
+		state.credit--
+	}
+	state.credit += sampling
+	if !keep {
+		return false, statusSampledOut
+	}
+
+	if limit > 0 {
+		burst := max(limit, 1)
+		state.tokens = min(burst, state.tokens+now.Sub(state.refilled).Seconds()*limit)
+		state.refilled = now
+		if state.tokens < 1 {
+			return false, statusRateLimited
+		}
+		state.tokens--
+	}
+	return true, ""
+}
+
+// Describe returns the effective sampling and rate limit for the symbol
+func (t *Throttle) Describe(symbol string) string {
+	sampling := fmt.Sprintf("sampling %g", t.sampling.For(symbol))
+	if limit := t.rateLimit.For(symbol); limit > 0 {
+		return fmt.Sprintf("%s, at most %g trades/s", sampling, limit)
+	}
+	return sampling + ", no rate limit"
+}
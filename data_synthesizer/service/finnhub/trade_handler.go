@@ -29,6 +29,7 @@ type TradeProcessor struct {
 	wg                  sync.WaitGroup
 	closed              bool
 	ssiValidation       bool
+	closeTimeout        time.Duration
 }
 
 // NewTradeProcessor creates a new trade processor
@@ -39,6 +40,7 @@ func NewTradeProcessor(identity *veramo.IdentityInformation, config *config.Conf
 		ctx:                 ctx,
 		cancel:              cancel,
 		ssiValidation:       config.SSIValidation,
+		closeTimeout:        config.ShutdownDrainTimeout,
 	}
 }
 
@@ -54,11 +56,20 @@ func structToMap(data interface{}) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// tradeData returns the trade data sent downstream: the fields the trade
+// mapping produced, or the trade's own
+func tradeData(trade models.FinnhubTrade) (map[string]interface{}, error) {
+	if trade.Fields != nil {
+		return trade.Fields, nil
+	}
+	return structToMap(trade)
+}
+
 func (tp *TradeProcessor) SignPayload(trade models.FinnhubTrade) (map[string]interface{}, error) {
 	timer := prometheus.NewTimer(metrics.CredentialSigningDuration.WithLabelValues(trade.Symbol))
 	defer timer.ObserveDuration()
 
-	tradeMap, err := structToMap(trade)
+	tradeMap, err := tradeData(trade)
 	if err != nil {
 		metrics.CredentialSigningErrors.WithLabelValues(trade.Symbol, "struct_conversion").Inc()
 		log.Printf("❌ Error converting trade struct to map for symbol %s: %v", trade.Symbol, err)
@@ -117,7 +128,10 @@ func (tp *TradeProcessor) HandleTrade(trade models.FinnhubTrade, startTimestamp
 		metrics.TradesProcessedTotal.WithLabelValues(trade.Symbol, "failed").Inc()
 		return fmt.Errorf("trade processor is closed")
 	}
+	// Close waits for trades already past this point
+	tp.wg.Add(1)
 	tp.mu.RUnlock()
+	defer tp.wg.Done()
 
 	payload := map[string]interface{}{
 		"trade_event_id":              trade.Trade_Id,
@@ -126,7 +140,7 @@ func (tp *TradeProcessor) HandleTrade(trade models.FinnhubTrade, startTimestamp
 	}
 
 	if !tp.ssiValidation {
-		tradeMap, err := structToMap(trade)
+		tradeMap, err := tradeData(trade)
 		if err != nil {
 			metrics.CredentialSigningErrors.WithLabelValues(trade.Symbol, "struct_conversion").Inc()
 			log.Printf("❌ Error converting trade struct to map for symbol %s: %v", trade.Symbol, err)
@@ -181,37 +195,39 @@ func (tp *TradeProcessor) HandleTrade(trade models.FinnhubTrade, startTimestamp
 	return nil
 }
 
-// HandleBatch processes multiple trades efficiently with proper error handling
-func (tp *TradeProcessor) HandleBatch(trades []models.FinnhubTrade, timestamp time.Time) error {
+// HandleBatch processes multiple trades in order, returning each trade's
+// error by index. Each trade registers with Close through HandleTrade.
+func (tp *TradeProcessor) HandleBatch(trades []models.FinnhubTrade, timestamp time.Time) []error {
 	timer := prometheus.NewTimer(metrics.BatchProcessingDuration.WithLabelValues(fmt.Sprintf("%d", len(trades))))
 	defer timer.ObserveDuration()
 
-	tp.wg.Add(1)
-	defer tp.wg.Done()
-
-	var errors []error
-	for _, trade := range trades {
+	errs := make([]error, len(trades))
+	failed := 0
+	for i, trade := range trades {
 		// Check if we should stop processing
 		select {
 		case <-tp.ctx.Done():
 			log.Printf("Batch processing interrupted, processed %d out of %d trades",
-				len(trades)-len(errors), len(trades))
-			return fmt.Errorf("batch processing interrupted: %w", tp.ctx.Err())
+				i-failed, len(trades))
+			for j := i; j < len(trades); j++ {
+				metrics.TradesProcessedTotal.WithLabelValues(trades[j].Symbol, "cancelled").Inc()
+				errs[j] = fmt.Errorf("batch processing interrupted: %w", tp.ctx.Err())
+			}
+			return errs
 		default:
 		}
 
 		if err := tp.HandleTrade(trade, timestamp); err != nil {
-			errors = append(errors, err)
+			errs[i] = err
+			failed++
 			log.Printf("❌ Error processing trade for symbol %s: %v", trade.Symbol, err)
 		}
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("encountered %d errors during batch processing (first error: %w)",
-			len(errors), errors[0])
+	if failed > 0 {
+		log.Printf("⚠️ Encountered %d errors during batch processing of %d trades", failed, len(trades))
 	}
-
-	return nil
+	return errs
 }
 
 // Close cleanup resources with timeout
@@ -227,10 +243,9 @@ func (tp *TradeProcessor) Close() error {
 
 	log.Printf("🔄 Trade processor shutting down. Processed %d trades total.", processedCount)
 
-	// Cancel context to signal shutdown
-	tp.cancel()
-
-	// Wait for in-flight operations with timeout
+	// Wait for in-flight operations with timeout, letting them finish their
+	// broadcasts, then cancel whatever is left
+	defer tp.cancel()
 	done := make(chan struct{})
 	go func() {
 		tp.wg.Wait()
@@ -240,7 +255,7 @@ func (tp *TradeProcessor) Close() error {
 	select {
 	case <-done:
 		log.Printf("✅ All trade processing operations completed successfully")
-	case <-time.After(30 * time.Second):
+	case <-time.After(tp.closeTimeout):
 		log.Printf("⚠️ Timeout waiting for trade processing operations to complete")
 		return fmt.Errorf("timeout waiting for operations to complete")
 	}
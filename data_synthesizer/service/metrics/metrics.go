@@ -3,7 +3,9 @@ package metrics
 import (
 	"data_synthesizer/config"
 	"fmt"
+	"log"
 	"net/http"
+	"net/http/pprof"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -30,19 +32,35 @@ var (
 	WebsocketMessageProcessingDuration *prometheus.HistogramVec
 	BroadcastDuration                  *prometheus.HistogramVec
 	BroadcastTimeouts                  *prometheus.CounterVec
+	ShutdownDroppedMessages            prometheus.Counter
 	CredentialSigningDuration          *prometheus.HistogramVec
 	CredentialSigningErrors            *prometheus.CounterVec
 	VeramoAPIDuration                  *prometheus.HistogramVec
 	VeramoAPIRequestsTotal             *prometheus.CounterVec
 	VeramoAPIRequestErrors             *prometheus.CounterVec
 	ActiveTradeProcessors              prometheus.Gauge
+	AsyncQueueDepth                    *prometheus.GaugeVec
+	AsyncWorkerBusySeconds             *prometheus.GaugeVec
 	FinnhubConnectionDuration          prometheus.Histogram
 	FinnhubSubscriptionErrors          *prometheus.CounterVec
+	FinnhubClientRestarts              *prometheus.CounterVec
+	FinnhubReconnects                  *prometheus.CounterVec
+	FinnhubConnected                   prometheus.Gauge
+	FinnhubActiveSource                *prometheus.GaugeVec
+	FinnhubQuotePolls                  *prometheus.CounterVec
+	RecordedBytes                      prometheus.Counter
+	RecordedFramesDropped              prometheus.Counter
+	FinnhubSilenceReconnects           *prometheus.CounterVec
+	FinnhubErrors                      *prometheus.CounterVec
+	FinnhubAPIKeyIndex                 prometheus.Gauge
+	FinnhubCompression                 prometheus.Gauge
+	FinnhubReceivedBytes               *prometheus.CounterVec
+	BuildInfo                          *prometheus.GaugeVec
 )
 
-var METRIC_PREFIX = "data_synthesizer_";
+var METRIC_PREFIX = "data_synthesizer_"
 
-func metricName(name string) string{
+func metricName(name string) string {
 	return fmt.Sprintf("%s%s", METRIC_PREFIX, name)
 }
 
@@ -139,6 +157,14 @@ func initializeMetrics() {
 		[]string{"symbol"},
 	)
 
+	ShutdownDroppedMessages = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name:        metricName("shutdown_dropped_messages_total"),
+			Help:        "Total number of messages not broadcast before the shutdown drain deadline",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+	)
+
 	// Credential signing metrics
 	CredentialSigningDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -197,6 +223,24 @@ func initializeMetrics() {
 		},
 	)
 
+	AsyncQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        metricName("async_queue_depth"),
+			Help:        "Trades waiting in each async worker's queue",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"worker"},
+	)
+
+	AsyncWorkerBusySeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        metricName("async_worker_busy_seconds"),
+			Help:        "Time each async worker has spent handling trades",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"worker"},
+	)
+
 	// Finnhub client metrics
 	FinnhubConnectionDuration = promauto.NewHistogram(
 		prometheus.HistogramOpts{
@@ -215,6 +259,119 @@ func initializeMetrics() {
 		},
 		[]string{"symbol"},
 	)
+
+	FinnhubClientRestarts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        metricName("finnhub_client_restarts_total"),
+			Help:        "Total number of Finnhub client restarts by the supervisor",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"reason"},
+	)
+
+	FinnhubReconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        metricName("finnhub_reconnects_total"),
+			Help:        "Total number of Finnhub reconnect attempts by the client, by result",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"result"},
+	)
+
+	FinnhubConnected = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        metricName("finnhub_connected"),
+			Help:        "1 while the Finnhub WebSocket is connected, 0 otherwise",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+	)
+
+	FinnhubActiveSource = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        metricName("finnhub_active_source"),
+			Help:        "1 for the source Finnhub trades currently come from (websocket or rest), 0 for the other",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"source"},
+	)
+
+	FinnhubQuotePolls = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        metricName("finnhub_quote_polls_total"),
+			Help:        "Total number of Finnhub REST quote requests while polling, by result",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"result"},
+	)
+
+	RecordedBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name:        metricName("recorded_bytes_total"),
+			Help:        "Total bytes of Finnhub frames written to RECORD_FILE",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+	)
+
+	RecordedFramesDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name:        metricName("recorded_frames_dropped_total"),
+			Help:        "Total number of Finnhub frames not recorded because the recorder fell behind",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+	)
+
+	FinnhubSilenceReconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        metricName("finnhub_silence_reconnects_total"),
+			Help:        "Total number of Finnhub reconnects forced because no trades arrived, by reason (silence or startup)",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"reason"},
+	)
+
+	FinnhubErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        metricName("finnhub_errors_total"),
+			Help:        "Total number of error frames received from Finnhub, by reason (rate_limited, invalid_symbol, unauthorized or other)",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"reason"},
+	)
+
+	FinnhubAPIKeyIndex = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        metricName("finnhub_api_key_index"),
+			Help:        "Index in FINNHUB_API_KEYS of the Finnhub API key in use",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+	)
+
+	FinnhubCompression = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        metricName("finnhub_compression"),
+			Help:        "Whether permessage-deflate was negotiated on the current Finnhub connection (1 = yes, 0 = no)",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+	)
+
+	FinnhubReceivedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        metricName("finnhub_received_bytes_total"),
+			Help:        "Total bytes received from Finnhub, by stage (wire: off the network, compressed and with TLS; message: the frames as read)",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"stage"},
+	)
+
+	// Build metrics
+	BuildInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        metricName("build_info"),
+			Help:        "Always 1, labelled with the running build's version and VCS revision",
+			ConstLabels: DefaultMetrics.getDefaultLabels(),
+		},
+		[]string{"version", "revision", "go_version"},
+	)
 }
 
 type defaultMetrics struct {
@@ -230,10 +387,11 @@ func bool_string(val bool) string {
 
 func newDefaultMetrics(cfg *config.Config) *defaultMetrics {
 	defaultLabels := prometheus.Labels{
-		"did_provider":   cfg.DidProvider,
-		"ssi_validation": bool_string(cfg.SSIValidation),
-		"cache_did":      bool_string(cfg.CacheDid),
+		"did_provider":    cfg.DidProvider,
+		"ssi_validation":  bool_string(cfg.SSIValidation),
+		"cache_did":       bool_string(cfg.CacheDid),
 		"processing_mode": cfg.ProcessingMode,
+		"run_duration":    cfg.RunDuration.String(),
 	}
 	return &defaultMetrics{
 		defaultLabels: defaultLabels,
@@ -244,13 +402,32 @@ func (dm *defaultMetrics) getDefaultLabels() prometheus.Labels {
 	return dm.defaultLabels
 }
 
-// StartMetricsServer starts the Prometheus metrics HTTP server
-func StartMetricsServer(port string) {
-	http.Handle("/metrics", promhttp.Handler())
+// StartMetricsServer starts the Prometheus metrics HTTP server on its own
+// mux, serving /metrics and, with enablePprof, /debug/pprof/, and returns
+// it so it can be shut down
+func StartMetricsServer(port string, enablePprof bool) *http.Server {
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: newMetricsMux(enablePprof),
+	}
 	go func() {
-		if err := http.ListenAndServe(":"+port, nil); err != nil {
-			// Log error appropriately in your application
-			panic(err)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
 		}
 	}()
+	return server
+}
+
+// newMetricsMux returns the metrics server's mux
+func newMetricsMux(enablePprof bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
 }
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsMux(t *testing.T) {
+	tests := []struct {
+		path        string
+		enablePprof bool
+		want        int
+	}{
+		{"/metrics", false, http.StatusOK},
+		{"/metrics", true, http.StatusOK},
+		{"/debug/pprof/", false, http.StatusNotFound},
+		{"/debug/pprof/", true, http.StatusOK},
+		{"/debug/pprof/cmdline", true, http.StatusOK},
+		{"/health", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		mux := newMetricsMux(tt.enablePprof)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s with pprof %t = %d, want %d", tt.path, tt.enablePprof, rec.Code, tt.want)
+		}
+	}
+}
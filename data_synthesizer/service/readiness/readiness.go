@@ -0,0 +1,78 @@
+package readiness
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Tracker records how far BootstrapDevice has got, so /ready can keep traffic
+// away until every ticker has its DID and authorization credential
+type Tracker struct {
+	bootstrapped atomic.Bool
+	mu           sync.Mutex
+	symbols      map[string]bool // Ticker -> credential issued
+}
+
+// Response is returned by GET /ready
+type Response struct {
+	Status      string          `json:"status"` // "ready" or "bootstrapping"
+	Credentials int             `json:"credentials"`
+	Expected    int             `json:"expected"`
+	Symbols     map[string]bool `json:"symbols"`
+}
+
+// NewTracker expects one credential for each of the given tickers
+func NewTracker(symbols []string) *Tracker {
+	t := &Tracker{symbols: make(map[string]bool, len(symbols))}
+	for _, symbol := range symbols {
+		t.symbols[symbol] = false
+	}
+	return t
+}
+
+// CredentialCreated marks the ticker's credential as issued
+func (t *Tracker) CredentialCreated(symbol string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.symbols[symbol]; ok {
+		t.symbols[symbol] = true
+	}
+}
+
+// MarkBootstrapped records that BootstrapDevice returned successfully
+func (t *Tracker) MarkBootstrapped() {
+	t.bootstrapped.Store(true)
+}
+
+// Snapshot reports the current readiness
+func (t *Tracker) Snapshot() Response {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resp := Response{
+		Status:   "bootstrapping",
+		Expected: len(t.symbols),
+		Symbols:  make(map[string]bool, len(t.symbols)),
+	}
+	for symbol, created := range t.symbols {
+		resp.Symbols[symbol] = created
+		if created {
+			resp.Credentials++
+		}
+	}
+	if t.bootstrapped.Load() && resp.Credentials == resp.Expected {
+		resp.Status = "ready"
+	}
+	return resp
+}
+
+// Handler serves GET /ready: 200 once bootstrapped, 503 until then
+func (t *Tracker) Handler(w http.ResponseWriter, r *http.Request) {
+	resp := t.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"sync"
 
+	"data_synthesizer/config"
 	"data_synthesizer/models"
 )
 
@@ -19,6 +20,17 @@ type CredentialData struct {
 type IdentityInformation struct {
 	Credentials map[string]CredentialData `json:"credentials"`
 	Client      *VeramoClient
+	mu          sync.RWMutex // Guards Credentials once symbols are added at runtime
+
+	// Credentials are stored under symbols normalized with SYMBOL_FORMAT,
+	// as trades carry them
+	symbolFormat string
+
+	// Used by EnsureCredential for symbols added after bootstrap
+	kms           string
+	provider      string
+	didWebHost    string
+	didWebProject string
 }
 
 type didCreationResult struct {
@@ -27,55 +39,43 @@ type didCreationResult struct {
 	err    error
 }
 
-func BootstrapDevice(vcClient *VeramoClient, kms string, provider string, symbols []string, didWebHost string, didWebProject string) (*IdentityInformation, error) {
+// BootstrapDevice creates a DID and authorization credential for each symbol,
+// normalized with symbolFormat; symbols that normalize alike share one.
+// onCreated, if set, is called with each symbol as its credential is issued.
+func BootstrapDevice(vcClient *VeramoClient, kms string, provider string, symbols []string, symbolFormat string, didWebHost string, didWebProject string, onCreated func(symbol string)) (*IdentityInformation, error) {
 	// 1. Create a DID
 	credentialMap := make(map[string]CredentialData)
 
+	// Symbols by the normalized symbol their credential is created for
+	normalized := make(map[string][]string)
+	for _, symbol := range symbols {
+		key, _ := config.NormalizeSymbol(symbolFormat, symbol)
+		normalized[key] = append(normalized[key], symbol)
+	}
+
 	// Channel to collect results from goroutines
-	resultChan := make(chan didCreationResult, len(symbols))
+	resultChan := make(chan didCreationResult, len(normalized))
 	var wg sync.WaitGroup
 
 	// Launch goroutines for concurrent DID creation
-	for _, symbol := range symbols {
+	for key, group := range normalized {
 		wg.Add(1)
-		go func(sym string) {
+		go func(sym string, group []string) {
 			defer wg.Done()
 
-			var didResp []byte
-			var err error
-			if provider == "did:web" {
-				didWebAlias := CreateDidWebAlias(didWebHost, didWebProject, sym)
-				fmt.Println(didWebAlias)
-				didResp, err = vcClient.CreateDID(didWebAlias, kms, provider)
-			} else {
-				alias := fmt.Sprintf("%s:%s", provider, sym)
-				didResp, err = vcClient.CreateDID(alias, kms, provider)
-			}
-
+			credData, err := createCredential(vcClient, kms, provider, sym, didWebHost, didWebProject)
 			if err != nil {
-				resultChan <- didCreationResult{symbol: sym, err: fmt.Errorf("failed to create DID for %s: %w", sym, err)}
+				resultChan <- didCreationResult{symbol: sym, err: err}
 				return
 			}
 
-			var identityData models.AuthorizationResponse
-			if err := json.Unmarshal(didResp, &identityData); err != nil {
-				resultChan <- didCreationResult{symbol: sym, err: fmt.Errorf("failed to unmarshal response for %s: %w", sym, err)}
-				return
+			if onCreated != nil {
+				for _, symbol := range group {
+					onCreated(symbol)
+				}
 			}
-
-			log.Printf("✔ Created DID: %s for Symbol %s", identityData.DidIdentifier.Alias, sym)
-			log.Printf("🔑 DID: %s", identityData.DidIdentifier.DID)
-			log.Printf("🔑 Authorization: %s", identityData.AuthorizationCredentialJWT)
-
-			credData := CredentialData{
-				DidIdentifier:              identityData.DidIdentifier,
-				DID:                        identityData.DidIdentifier.DID,
-				AuthorizationCredential:    identityData.AuthorizationCredential,
-				AuthorizationCredentialJWT: identityData.AuthorizationCredentialJWT,
-			}
-
 			resultChan <- didCreationResult{symbol: sym, data: credData, err: nil}
-		}(symbol)
+		}(key, group)
 	}
 
 	// Close the channel when all goroutines are done
@@ -93,16 +93,91 @@ func BootstrapDevice(vcClient *VeramoClient, kms string, provider string, symbol
 	}
 
 	return &IdentityInformation{
-		Credentials: credentialMap,
-		Client:      vcClient,
+		Credentials:   credentialMap,
+		Client:        vcClient,
+		symbolFormat:  symbolFormat,
+		kms:           kms,
+		provider:      provider,
+		didWebHost:    didWebHost,
+		didWebProject: didWebProject,
+	}, nil
+}
+
+// createCredential creates a DID and authorization credential for a symbol
+func createCredential(vcClient *VeramoClient, kms string, provider string, symbol string, didWebHost string, didWebProject string) (CredentialData, error) {
+	var didResp []byte
+	var err error
+	if provider == "did:web" {
+		didWebAlias := CreateDidWebAlias(didWebHost, didWebProject, symbol)
+		fmt.Println(didWebAlias)
+		didResp, err = vcClient.CreateDID(didWebAlias, kms, provider)
+	} else {
+		alias := fmt.Sprintf("%s:%s", provider, symbol)
+		didResp, err = vcClient.CreateDID(alias, kms, provider)
+	}
+
+	if err != nil {
+		return CredentialData{}, fmt.Errorf("failed to create DID for %s: %w", symbol, err)
+	}
+
+	var identityData models.AuthorizationResponse
+	if err := json.Unmarshal(didResp, &identityData); err != nil {
+		return CredentialData{}, fmt.Errorf("failed to unmarshal response for %s: %w", symbol, err)
+	}
+
+	log.Printf("✔ Created DID: %s for Symbol %s", identityData.DidIdentifier.Alias, symbol)
+	log.Printf("🔑 DID: %s", identityData.DidIdentifier.DID)
+	log.Printf("🔑 Authorization: %s", identityData.AuthorizationCredentialJWT)
+
+	return CredentialData{
+		DidIdentifier:              identityData.DidIdentifier,
+		DID:                        identityData.DidIdentifier.DID,
+		AuthorizationCredential:    identityData.AuthorizationCredential,
+		AuthorizationCredentialJWT: identityData.AuthorizationCredentialJWT,
 	}, nil
 }
 
+// EnsureCredential creates a DID and authorization credential for a symbol
+// added after bootstrap, reporting whether one was created. Symbols that
+// already have a credential are left as they are.
+func (di *IdentityInformation) EnsureCredential(symbol string) (bool, error) {
+	symbol, _ = config.NormalizeSymbol(di.symbolFormat, symbol)
+	di.mu.RLock()
+	_, exists := di.Credentials[symbol]
+	di.mu.RUnlock()
+	if exists {
+		return false, nil
+	}
+
+	credData, err := createCredential(di.Client, di.kms, di.provider, symbol, di.didWebHost, di.didWebProject)
+	if err != nil {
+		return false, err
+	}
+
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	if _, exists := di.Credentials[symbol]; exists {
+		return false, nil // Created concurrently; keep the first
+	}
+	di.Credentials[symbol] = credData
+	return true, nil
+}
+
+// CredentialCount returns the number of symbols with a credential
+func (di *IdentityInformation) CredentialCount() int {
+	di.mu.RLock()
+	defer di.mu.RUnlock()
+	return len(di.Credentials)
+}
+
 func (di *IdentityInformation) checkCredentials(symbol string) (*CredentialData, error) {
 	if di == nil || di.Credentials == nil {
 		return nil, fmt.Errorf("DeviceIdentity or Credentials is nil")
 	}
+	symbol, _ = config.NormalizeSymbol(di.symbolFormat, symbol)
+	di.mu.RLock()
 	credential, exists := di.Credentials[symbol]
+	di.mu.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("no credentials found for symbol: %s", symbol)
 	}
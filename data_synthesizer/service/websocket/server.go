@@ -1,56 +1,157 @@
 package websocket
 
 import (
+	"context"
 	"log"
 	"net/http"
-	// "time"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"data_synthesizer/service/metrics"
 )
 
+const (
+	// How long a client gets to receive a message before it is dropped
+	writeTimeout = 10 * time.Second
+	// How long each client gets to receive its close frame on shutdown
+	closeFrameTimeout = time.Second
+)
+
 var clients = make(map[*websocket.Conn]bool)
+var clientsMu sync.Mutex
 var Broadcast = make(chan []byte)
 
+// Set once Shutdown starts; new connections are refused from then on
+var closing atomic.Bool
+
+// Hands the broadcaster its shutdown request
+var stopBroadcast = make(chan drainRequest)
+
+type drainRequest struct {
+	ctx     context.Context
+	dropped chan int
+}
+
 var upgrader = websocket.Upgrader{}
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-    upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-    conn, err := upgrader.Upgrade(w, r, nil)
-    if err != nil {
-        log.Printf("WebSocket upgrade failed: %v", err)
-        return
-    }
-    defer func() {
+	if closing.Load() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer func() {
 		conn.Close()
 		metrics.WebsocketConnectionsActive.Dec()
 	}()
-    clients[conn] = true
-    metrics.WebsocketConnectionsActive.Inc()
-
-    for {
-        _, _, err := conn.ReadMessage()
-        if err != nil {
-            delete(clients, conn)
-            break
-        }
-    }
+	clientsMu.Lock()
+	clients[conn] = true
+	clientsMu.Unlock()
+	metrics.WebsocketConnectionsActive.Inc()
+
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			clientsMu.Lock()
+			delete(clients, conn)
+			clientsMu.Unlock()
+			break
+		}
+	}
+}
+
+// send writes a message to every connected client, dropping those that fail
+func send(msg []byte) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for client := range clients {
+		client.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := client.WriteMessage(websocket.TextMessage, msg)
+		if err != nil {
+			log.Printf("Error writing to WebSocket: %v", err)
+			client.Close()
+			delete(clients, client)
+		}
+	}
+}
+
+// broadcaster relays Broadcast to the clients until Shutdown stops it
+func broadcaster() {
+	for {
+		select {
+		case msg := <-Broadcast:
+			send(msg)
+		case req := <-stopBroadcast:
+			req.dropped <- drain(req.ctx)
+			return
+		}
+	}
+}
+
+// drain flushes the messages still waiting on Broadcast until none are left
+// or ctx expires, and returns how many were left undelivered
+func drain(ctx context.Context) int {
+	for {
+		select {
+		case <-ctx.Done():
+			dropped := 0
+			for {
+				select {
+				case <-Broadcast:
+					dropped++
+				default:
+					return dropped
+				}
+			}
+		case msg := <-Broadcast:
+			send(msg)
+		default:
+			return 0
+		}
+	}
+}
+
+// Shutdown refuses new connections, flushes the messages waiting on
+// Broadcast to the clients until ctx expires, then sends every client a
+// close frame and closes it. It returns the number of messages dropped.
+func Shutdown(ctx context.Context) int {
+	closing.Store(true)
+
+	dropped := 0
+	req := drainRequest{ctx: ctx, dropped: make(chan int, 1)}
+	select {
+	case stopBroadcast <- req:
+		dropped = <-req.dropped
+	case <-ctx.Done():
+		log.Printf("⚠️ Broadcaster busy at the drain deadline, closing clients anyway")
+	}
+	if dropped > 0 {
+		metrics.ShutdownDroppedMessages.Add(float64(dropped))
+	}
+
+	// WriteControl and Close are safe alongside a broadcaster still writing
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(closeFrameTimeout)
+	clientsMu.Lock()
+	for client := range clients {
+		if err := client.WriteControl(websocket.CloseMessage, closeMsg, deadline); err != nil {
+			log.Printf("Error sending close frame: %v", err)
+		}
+		client.Close()
+		delete(clients, client)
+	}
+	clientsMu.Unlock()
+	return dropped
 }
 
 func init() {
-    go func() {
-        for {
-            msg := <-Broadcast
-            for client := range clients {
-                // time.Sleep(time.Duration(3000) * time.Millisecond)
-                err := client.WriteMessage(websocket.TextMessage, msg)
-                if err != nil {
-                    log.Printf("Error writing to WebSocket: %v", err)
-                    client.Close()
-                    delete(clients, client)
-                }
-            }
-        }
-    }()
+	go broadcaster()
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audit record outcomes
+const (
+	auditCommitted = "committed"
+	auditFailed    = "failed"
+	auditAbandoned = "abandoned"
+)
+
+// AuditRecord is one line of the audit log, written for every batch item.
+// Hash covers the record together with PrevHash, the hash of the line before
+// it, so editing or dropping a line breaks the chain for every later one.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	DID        string    `json:"did"`
+	Action     string    `json:"action"` // publish, remove or deactivate
+	TargetFile string    `json:"targetFile"`
+	Repo       string    `json:"repo,omitempty"`   // REPO_MAP repository; omitted for the working directory
+	Sha256     string    `json:"sha256,omitempty"` // Committed document; omitted for removals
+	Commit     string    `json:"commit,omitempty"`
+	Outcome    string    `json:"outcome"` // committed, failed or abandoned
+	Error      string    `json:"error,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Actor      string    `json:"actor"` // Credential the request was authenticated with
+	PrevHash   string    `json:"prevHash"`
+	Hash       string    `json:"hash"`
+}
+
+// AuditResponse is returned by GET /audit
+type AuditResponse struct {
+	DID        string        `json:"did"`
+	Records    []AuditRecord `json:"records"`
+	ChainValid bool          `json:"chainValid"` // Every line of the log still hashes to the next one's prevHash
+	ChainError string        `json:"chainError,omitempty"`
+}
+
+// auditLog appends hash-chained records to AUDIT_LOG_FILE, one write per
+// batch flush. Lines are only ever appended, and each write is synced.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	lastHash string
+}
+
+// openAuditLog opens the audit log, continuing the chain from its last line.
+// A last line that can't be parsed is refused rather than silently restarting
+// the chain.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return a, nil
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(last, &record); err != nil || record.Hash == "" {
+		return nil, fmt.Errorf("last line of audit log %s is not a valid record", path)
+	}
+	a.lastHash = record.Hash
+	return a, nil
+}
+
+// hash returns the hex SHA-256 of the record with its Hash field cleared
+func (r AuditRecord) hash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// append chains and writes records, syncing the file before returning
+func (a *auditLog) append(records []AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var buf bytes.Buffer
+	prev := a.lastHash
+	for _, record := range records {
+		record.PrevHash = prev
+		record.Hash = record.hash()
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		prev = record.Hash
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	a.lastHash = prev
+	return nil
+}
+
+// history returns the records for did, along with the first place the chain
+// fails to verify, if any
+func (a *auditLog) history(did string) ([]AuditRecord, string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	var chainProblem string
+	prev := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			if chainProblem == "" {
+				chainProblem = fmt.Sprintf("line %d is not a valid record", line)
+			}
+			prev = ""
+			continue
+		}
+		if chainProblem == "" && (record.PrevHash != prev || record.hash() != record.Hash) {
+			chainProblem = fmt.Sprintf("hash chain broken at line %d", line)
+		}
+		prev = record.Hash
+		if record.DID == did {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	return records, chainProblem, nil
+}
+
+// scan calls fn with every record in the log, oldest first, skipping lines
+// that can't be parsed
+func (a *auditLog) scan(fn func(AuditRecord)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		fn(record)
+	}
+	return scanner.Err()
+}
+
+// auditAction names what a batch item did to its target file
+func auditAction(item BatchItem) string {
+	switch {
+	case item.Remove:
+		return "remove"
+	case item.Deactivate:
+		return "deactivate"
+	default:
+		return "publish"
+	}
+}
+
+// newAuditRecord describes a batch item and its outcome
+func newAuditRecord(item BatchItem, commit, outcome string, itemErr error) AuditRecord {
+	record := AuditRecord{
+		Time:       time.Now().UTC(),
+		DID:        item.ParsedDID.expectedID(),
+		Action:     auditAction(item),
+		TargetFile: item.TargetFile,
+		Repo:       item.Repo.Path,
+		Commit:     commit,
+		Outcome:    outcome,
+		RequestID:  item.RequestID,
+		Actor:      item.Actor,
+	}
+	if item.Document != nil {
+		record.Sha256 = sha256Hex(item.Document)
+	}
+	if itemErr != nil {
+		record.Error = itemErr.Error()
+	}
+	return record
+}
+
+// recordAudit appends records to the audit log, if enabled. A failed write
+// is logged and counted but doesn't fail the batch, which is already pushed.
+func (p *DIDProcessor) recordAudit(records []AuditRecord) {
+	if p.audit == nil || len(records) == 0 {
+		return
+	}
+	if err := p.audit.append(records); err != nil {
+		AuditWriteFailuresTotal.Inc()
+		slog.Error("Failed to write audit log", "path", p.audit.path, "records", len(records), "error", err)
+	}
+}
+
+// actorKey is the context key for the credential a request authenticated with
+type actorKey struct{}
+
+// withActor records the credential a request authenticated with
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFromContext returns the request's credential, or "anonymous" when
+// authentication is disabled
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return "anonymous"
+}
+
+// tokenActor identifies a bearer token by a fingerprint, so the audit log
+// tells tokens apart without storing them
+func tokenActor(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
+
+// handleAudit returns the audit history of one DID
+func (p *DIDProcessor) handleAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if p.audit == nil {
+		p.sendError(w, http.StatusNotFound, "Audit log is disabled (AUDIT_LOG_FILE is not set)")
+		return
+	}
+	did := r.URL.Query().Get("did")
+	if did == "" {
+		p.sendError(w, http.StatusBadRequest, "did query parameter is required")
+		return
+	}
+	parsed, err := parseDID(did)
+	if err != nil {
+		p.sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid DID: %v", err))
+		return
+	}
+
+	records, chainProblem, err := p.audit.history(parsed.expectedID())
+	if err != nil {
+		p.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read audit log: %v", err))
+		return
+	}
+	response := AuditResponse{DID: parsed.expectedID(), Records: records, ChainValid: chainProblem == "", ChainError: chainProblem}
+	if response.Records == nil {
+		response.Records = []AuditRecord{}
+	}
+	json.NewEncoder(w).Encode(response)
+}
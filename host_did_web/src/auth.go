@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the request body, optionally
+// prefixed with "sha256=" as GitHub-style webhooks do
+const signatureHeader = "X-Signature"
+
+// authEnabled reports whether any authentication method is configured
+func (p *DIDProcessor) authEnabled() bool {
+	return p.config.APIToken != "" || p.config.HMACSecret != ""
+}
+
+// requireAuth rejects requests that carry neither a valid bearer token nor a
+// valid body signature. When no credentials are configured it is a no-op.
+func (p *DIDProcessor) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.authEnabled() {
+			next(w, r)
+			return
+		}
+
+		reason := "missing_credentials"
+		if token, ok := bearerToken(r); ok && p.config.APIToken != "" {
+			if p.validToken(token) {
+				next(w, r.WithContext(withActor(r.Context(), tokenActor(token))))
+				return
+			}
+			reason = "invalid_token"
+		}
+
+		if signature := r.Header.Get(signatureHeader); signature != "" && p.config.HMACSecret != "" {
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					p.sendBodyError(w, err)
+				} else {
+					p.sendError(w, http.StatusBadRequest, "Failed to read request body")
+				}
+				return
+			}
+			if validSignature(body, signature, p.config.HMACSecret) {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				next(w, r.WithContext(withActor(r.Context(), "hmac")))
+				return
+			}
+			reason = "invalid_signature"
+		}
+
+		AuthRejectedTotal.WithLabelValues(reason).Inc()
+		loggerFromContext(r.Context()).Warn("🚫 Rejected request",
+			"method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "reason", reason)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="host_did_web"`)
+		p.sendError(w, http.StatusUnauthorized, "Unauthorized")
+	}
+}
+
+// validToken compares the token with API_TOKEN in constant time
+func (p *DIDProcessor) validToken(token string) bool {
+	return p.config.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(p.config.APIToken)) == 1
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// validSignature compares the body's HMAC-SHA256 with the provided signature in constant time
+func validSignature(body []byte, signature, secret string) bool {
+	provided, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(provided, mac.Sum(nil))
+}
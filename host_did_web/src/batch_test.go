@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRemoteURL is the remote of the fake publishing repository, which
+// publishes did:web:alice.github.io:site DIDs
+const testRemoteURL = "https://github.com/alice/site.git"
+
+// newBatchTestProcessor returns a processor publishing to a fake repository
+// backed by a temporary directory
+func newBatchTestProcessor(t *testing.T) (*DIDProcessor, *publishRepo, *fakeGitPublisher) {
+	t.Helper()
+	dir := t.TempDir()
+	git := newFakeGitPublisher(dir, testRemoteURL)
+	repo := &publishRepo{Path: dir, Branch: "gh-pages", git: git, mu: &sync.Mutex{}, claims: newTargetClaims()}
+	p := &DIDProcessor{
+		config: Config{
+			GitRemote:      "origin",
+			GitLockTimeout: time.Second,
+			PagesProviders: defaultPagesProviders,
+			BatchSize:      10,
+			BatchTimeout:   time.Hour,
+			BatchWait:      10 * time.Second,
+		},
+		repos:   map[repoID]*publishRepo{{}: repo},
+		jobs:    newJobStore(time.Minute),
+		pending: &pendingBatch{},
+		batchCh: make(chan BatchItem, 10),
+	}
+	return p, repo, git
+}
+
+// batchItem returns an item publishing did:web:alice.github.io:site:<name>
+// from <name>/did.json, without writing the file
+func batchItem(repo *publishRepo, name string) BatchItem {
+	return BatchItem{
+		TargetFile:       filepath.Join(name, "did.json"),
+		ParsedDID:        &ParsedDID{Original: "did:web:alice.github.io:site:" + name, Host: "alice.github.io", Project: "site", PathSegs: []string{name}},
+		Repo:             repo,
+		HostVerification: HostVerification{Method: hostVerifiedGitHubIO, Provider: "github", User: "alice"},
+		Document:         []byte(`{"id":"did:web:alice.github.io:site:` + name + `"}`),
+	}
+}
+
+// writeBatchItem writes the item's document to the working tree
+func writeBatchItem(t *testing.T, repo *publishRepo, item BatchItem) BatchItem {
+	t.Helper()
+	path := filepath.Join(repo.Path, item.TargetFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, item.Document, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+// readWorkFile returns a file in the working tree, or nil if it doesn't exist
+func readWorkFile(t *testing.T, repo *publishRepo, file string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(repo.Path, file))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func countCalls(calls []string, method string) int {
+	n := 0
+	for _, call := range calls {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBatchCommitsItemsTogether(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	var batch []BatchItem
+	for _, name := range []string{"a", "b", "c"} {
+		batch = append(batch, writeBatchItem(t, repo, batchItem(repo, name)))
+	}
+
+	commit, itemErrs, err := p.performBatchedGitOperations(repo, batch)
+	if err != nil {
+		t.Fatalf("performBatchedGitOperations() error = %v", err)
+	}
+	if commit != "commit-1" {
+		t.Errorf("commit = %q, want commit-1", commit)
+	}
+	for i, itemErr := range itemErrs {
+		if itemErr != nil {
+			t.Errorf("item %d error = %v", i, itemErr)
+		}
+	}
+	calls := git.called()
+	if n := countCalls(calls, "Commit"); n != 1 {
+		t.Errorf("%d commits, want 1", n)
+	}
+	if n := countCalls(calls, "Push"); n != 1 {
+		t.Errorf("%d pushes, want 1", n)
+	}
+	for _, item := range batch {
+		if got := string(git.remote[item.TargetFile]); got != string(item.Document) {
+			t.Errorf("remote %s = %q, want %q", item.TargetFile, got, item.Document)
+		}
+	}
+}
+
+func TestBatchProcessorFlushesAtBatchSize(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	p.config.BatchSize = 2
+	p.batchWG.Add(1)
+	go p.gitBatchProcessor()
+
+	var responses []chan BatchResult
+	for _, name := range []string{"a", "b"} {
+		item := writeBatchItem(t, repo, batchItem(repo, name))
+		item.ResponseCh = make(chan BatchResult, 1)
+		responses = append(responses, item.ResponseCh)
+		p.batchCh <- item
+	}
+
+	// The second item fills the batch, so both are flushed without waiting for BATCH_TIMEOUT
+	for i, responseCh := range responses {
+		select {
+		case result := <-responseCh:
+			if result.Err != nil || result.Commit != "commit-1" {
+				t.Errorf("item %d result = %+v, want commit-1", i, result)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("item %d got no result", i)
+		}
+	}
+	close(p.batchCh)
+	p.batchWG.Wait()
+
+	if n := countCalls(git.called(), "Commit"); n != 1 {
+		t.Errorf("%d commits, want 1", n)
+	}
+}
+
+func TestBatchValidatesItemsBeforeGit(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+
+	valid := writeBatchItem(t, repo, batchItem(repo, "valid"))
+	wrongOwner := batchItem(repo, "wrong-owner")
+	wrongOwner.HostVerification.User = "bob"
+	wrongOwner = writeBatchItem(t, repo, wrongOwner)
+	missing := batchItem(repo, "missing")
+	outside := batchItem(repo, "outside")
+	outside.TargetFile = filepath.Join("..", "outside", "did.json")
+	batch := []BatchItem{wrongOwner, valid, missing, outside}
+
+	commit, itemErrs, err := p.performBatchedGitOperations(repo, batch)
+	if err != nil {
+		t.Fatalf("performBatchedGitOperations() error = %v", err)
+	}
+	if commit == "" {
+		t.Error("valid item wasn't committed")
+	}
+	for i, wantRejected := range []bool{true, false, true, true} {
+		if rejected := errors.Is(itemErrs[i], errBatchItemRejected); rejected != wantRejected {
+			t.Errorf("item %d error = %v, want rejected %t", i, itemErrs[i], wantRejected)
+		}
+	}
+	if !errors.Is(itemErrs[3], errTargetOutsideRepo) {
+		t.Errorf("outside item error = %v, want errTargetOutsideRepo", itemErrs[3])
+	}
+
+	// Rejected files are restored before the branch is touched, and a path
+	// outside the repository is never handed to git
+	calls := git.called()
+	restore, fastForward := slices.Index(calls, "RestoreFiles"), slices.Index(calls, "FastForward")
+	if restore < 0 || fastForward < 0 || restore > fastForward {
+		t.Errorf("calls = %v, want RestoreFiles before FastForward", calls)
+	}
+	if want := []string{wrongOwner.TargetFile, missing.TargetFile}; !slices.Equal(git.restored, want) {
+		t.Errorf("restored %v, want %v", git.restored, want)
+	}
+	if data := readWorkFile(t, repo, wrongOwner.TargetFile); data != nil {
+		t.Errorf("rejected file left in the working tree: %q", data)
+	}
+	if _, ok := git.remote[wrongOwner.TargetFile]; ok {
+		t.Error("rejected file was pushed")
+	}
+	if got := string(git.remote[valid.TargetFile]); got != string(valid.Document) {
+		t.Errorf("remote %s = %q, want %q", valid.TargetFile, got, valid.Document)
+	}
+}
+
+func TestBatchRejectsEveryItemWithoutGit(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	missing := batchItem(repo, "missing")
+
+	commit, itemErrs, err := p.performBatchedGitOperations(repo, []BatchItem{missing})
+	if err != nil || commit != "" {
+		t.Fatalf("performBatchedGitOperations() = %q, %v, want no commit or error", commit, err)
+	}
+	if !errors.Is(itemErrs[0], errBatchItemRejected) {
+		t.Errorf("item error = %v, want rejected", itemErrs[0])
+	}
+	if calls := git.called(); slices.Contains(calls, "FastForward") || slices.Contains(calls, "Commit") {
+		t.Errorf("calls = %v, want no branch operations", calls)
+	}
+}
+
+func TestBatchFailureRollsBack(t *testing.T) {
+	for _, method := range []string{"FastForward", "EnsureBranch", "AddFiles", "Commit", "Push"} {
+		t.Run(method, func(t *testing.T) {
+			p, repo, git := newBatchTestProcessor(t)
+			published := []byte(`{"id":"published"}`)
+			git.remote[filepath.Join("a", "did.json")] = published
+			gitErr := errors.New(method + " failed")
+			git.errs[method] = gitErr
+			a := writeBatchItem(t, repo, batchItem(repo, "a"))
+			b := writeBatchItem(t, repo, batchItem(repo, "b"))
+
+			commit, itemErrs, err := p.performBatchedGitOperations(repo, []BatchItem{a, b})
+			if commit != "" {
+				t.Errorf("commit = %q, want none", commit)
+			}
+			if !errors.Is(err, errBatchRolledBack) || !errors.Is(err, gitErr) {
+				t.Errorf("error = %v, want rolled back %v", err, gitErr)
+			}
+			// The failure is shared by the batch, not pinned on an item
+			for i, itemErr := range itemErrs {
+				if itemErr != nil {
+					t.Errorf("item %d error = %v", i, itemErr)
+				}
+			}
+			if want := []string{a.TargetFile, b.TargetFile}; !slices.Equal(git.rolledBack, want) {
+				t.Errorf("rolled back %v, want %v", git.rolledBack, want)
+			}
+			if len(git.commits) != 0 || git.pushed != 0 {
+				t.Errorf("%d unpushed and %d pushed commits left, want none", len(git.commits), git.pushed)
+			}
+			if got := readWorkFile(t, repo, a.TargetFile); string(got) != string(published) {
+				t.Errorf("%s = %q, want the published %q", a.TargetFile, got, published)
+			}
+			if got := readWorkFile(t, repo, b.TargetFile); got != nil {
+				t.Errorf("unpublished %s left in the working tree: %q", b.TargetFile, got)
+			}
+		})
+	}
+}
+
+func TestBatchDivergedBranchIsNotRolledBack(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	git.errs["FastForward"] = divergedError("origin", "gh-pages", "abc", "def")
+	a := writeBatchItem(t, repo, batchItem(repo, "a"))
+
+	_, _, err := p.performBatchedGitOperations(repo, []BatchItem{a})
+	if !errors.Is(err, errBranchDiverged) || errors.Is(err, errBatchRolledBack) {
+		t.Errorf("error = %v, want errBranchDiverged without a rollback", err)
+	}
+	if slices.Contains(git.called(), "Rollback") {
+		t.Error("diverged branch was rolled back")
+	}
+	if got := readWorkFile(t, repo, a.TargetFile); string(got) != string(a.Document) {
+		t.Errorf("%s = %q, want it left for an operator", a.TargetFile, got)
+	}
+}
+
+func TestBatchMissingRemoteFailsEveryItem(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	git.errs["RemoteInfo"] = errors.New("no such remote")
+	a := writeBatchItem(t, repo, batchItem(repo, "a"))
+
+	_, _, err := p.performBatchedGitOperations(repo, []BatchItem{a})
+	if err == nil {
+		t.Fatal("performBatchedGitOperations() succeeded without a remote")
+	}
+	if calls := git.called(); slices.Contains(calls, "FastForward") || slices.Contains(calls, "RestoreFiles") {
+		t.Errorf("calls = %v, want none after the remote check", calls)
+	}
+}
+
+func TestBatchMixedOutcomes(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	p.config.BatchSize = 3
+	pushErr := errors.New("push failed")
+	git.errs["Push"] = pushErr
+	p.batchWG.Add(1)
+	go p.gitBatchProcessor()
+
+	wrongOwner := batchItem(repo, "wrong-owner")
+	wrongOwner.HostVerification.User = "bob"
+	batch := []BatchItem{
+		writeBatchItem(t, repo, wrongOwner),
+		writeBatchItem(t, repo, batchItem(repo, "a")),
+		writeBatchItem(t, repo, batchItem(repo, "b")),
+	}
+	var results []BatchResult
+	for i := range batch {
+		batch[i].ResponseCh = make(chan BatchResult, 1)
+		p.batchCh <- batch[i]
+	}
+	for i, item := range batch {
+		select {
+		case result := <-item.ResponseCh:
+			results = append(results, result)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("item %d got no result", i)
+		}
+	}
+	close(p.batchCh)
+	p.batchWG.Wait()
+
+	// The rejected item gets its own error, the others share the failed push
+	if err := results[0].Err; !errors.Is(err, errBatchItemRejected) || errors.Is(err, errBatchRolledBack) {
+		t.Errorf("rejected item error = %v, want only its rejection", err)
+	}
+	for i, result := range results[1:] {
+		if !errors.Is(result.Err, errBatchRolledBack) || !errors.Is(result.Err, pushErr) || result.Commit != "" {
+			t.Errorf("item %d result = %+v, want the rolled back push", i+1, result)
+		}
+	}
+	if want := []string{batch[1].TargetFile, batch[2].TargetFile}; !slices.Equal(git.rolledBack, want) {
+		t.Errorf("rolled back %v, want %v", git.rolledBack, want)
+	}
+	if want := []string{batch[0].TargetFile}; !slices.Equal(git.restored, want) {
+		t.Errorf("restored %v, want %v", git.restored, want)
+	}
+	for _, item := range batch {
+		if data := readWorkFile(t, repo, item.TargetFile); data != nil {
+			t.Errorf("%s left in the working tree: %q", item.TargetFile, data)
+		}
+	}
+}
+
+func TestBatchAbandonedItemRestoresTargetFile(t *testing.T) {
+	p, repo, git := newBatchTestProcessor(t)
+	published := []byte(`{"id":"published"}`)
+	tests := []struct {
+		name     string
+		previous []byte
+	}{
+		{"updated", published},
+		{"created", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := batchItem(repo, tt.name)
+			if err := repo.claims.claim(repo.Path, item.TargetFile, item.ParsedDID.Original, item.Document); err != nil {
+				t.Fatal(err)
+			}
+			item = writeBatchItem(t, repo, item)
+			item.Claimed = true
+			item.Previous = pendingFiles{item.TargetFile: tt.previous}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			item.Ctx = ctx
+
+			p.batchWG.Add(1)
+			go p.gitBatchProcessor()
+			p.batchCh <- item
+			close(p.batchCh)
+			p.batchWG.Wait()
+			p.batchCh = make(chan BatchItem, 10)
+
+			if got := readWorkFile(t, repo, item.TargetFile); string(got) != string(tt.previous) {
+				t.Errorf("%s = %q, want %q", item.TargetFile, got, tt.previous)
+			}
+			if repo.claims.held(item.TargetFile) {
+				t.Errorf("%s still claimed", item.TargetFile)
+			}
+		})
+	}
+	if calls := git.called(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none for abandoned items", calls)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+)
+
+// publishCommand runs the publisher once from the command line, for CI
+// jobs, instead of serving HTTP
+const publishCommand = "publish"
+
+// cliActor is recorded in the audit log for DIDs published from the command line
+const cliActor = "cli"
+
+// runPublish publishes the DIDs named on the command line and returns the
+// exit status: 0 when every DID succeeded, 1 when any failed and 2 for bad
+// usage. The DIDs go through processDID and the batch processor like a
+// /process-dids request, but the batch is flushed as soon as every DID has
+// been queued instead of after BATCH_TIMEOUT.
+func (p *DIDProcessor) runPublish(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet(publishCommand, flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the results as JSON, shaped like a /process-dids response")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: host_did_web %s [--json] did [did ...]\n", publishCommand)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	dids := flags.Args()
+	if len(dids) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	// Once every DID is queued, or finished without needing a commit,
+	// closing the channel makes the batch processor flush and exit
+	var pending sync.WaitGroup
+	pending.Add(len(dids))
+	p.batchWG.Add(1)
+	go p.gitBatchProcessor()
+	go func() {
+		pending.Wait()
+		close(p.batchCh)
+	}()
+
+	// An interrupt doesn't cut the batch short: the DIDs already being
+	// processed still reach the commit. A second one exits straight away.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		slog.Warn("Interrupted, waiting for the pending batch to be committed; interrupt again to exit now")
+		if _, ok := <-signals; ok {
+			os.Exit(130)
+		}
+	}()
+
+	ctx := withActor(context.WithValue(context.Background(), requestIDKey{}, newRequestID()), cliActor)
+	results := make([]DIDResult, len(dids))
+	var wg sync.WaitGroup
+	for i, did := range dids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var once sync.Once
+			ready := func() { once.Do(pending.Done) }
+			defer ready()
+			results[i] = p.processListedDID(withProgress(ctx, func(stage string) {
+				if stage == stageQueued {
+					ready()
+				}
+			}), did, ProcessOptions{})
+		}()
+	}
+	wg.Wait()
+	p.batchWG.Wait()
+
+	response, _ := summarizeDIDResults(results)
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(response)
+	} else {
+		p.printPublishTable(stdout, results)
+	}
+	if response.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printPublishTable writes one line per DID: its outcome, target file and
+// commit, or the error it failed with
+func (p *DIDProcessor) printPublishTable(w io.Writer, results []DIDResult) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DID\tRESULT\tFILE\tCOMMIT\tERROR")
+	for _, result := range results {
+		outcome := "published"
+		switch {
+		case !result.Success:
+			outcome = "failed"
+		case result.Unchanged:
+			outcome = "unchanged"
+		case p.config.DryRun:
+			outcome = "dry run"
+		}
+		file, commit := "-", "-"
+		if result.Published != nil {
+			file = result.Published.TargetFile
+			if result.Published.Commit != nil {
+				commit = *result.Published.Commit
+			}
+		}
+		message := "-"
+		if result.Error != "" {
+			message = result.Error
+			if result.Code != "" {
+				message = result.Code + ": " + message
+			}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", result.DID, outcome, file, commit, message)
+	}
+	table.Flush()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// prepareCloneDir makes GIT_CLONE_DIR the working directory, cloning
+// GIT_REPO_URL into it first unless it already holds a repository. The
+// publisher then runs in it exactly as it would in a pre-cloned checkout.
+func prepareCloneDir(config Config) error {
+	dir, err := filepath.Abs(config.GitCloneDir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		slog.Info("Using existing clone", "dir", dir)
+		return os.Chdir(dir)
+	}
+
+	slog.Info("Cloning publishing repository", "url", redactURL(config.GitRepoURL), "dir", dir, "branch", config.Branch)
+	if config.GitBackend == "gogit" {
+		err = cloneGoGit(config, dir)
+	} else {
+		err = cloneCLI(config, dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clone %s into %s: %w", redactURL(config.GitRepoURL), dir, err)
+	}
+	return os.Chdir(dir)
+}
+
+// redactURL hides any password in a repository URL before it is logged
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// cloneCLI clones with the git binary without checking anything out, then
+// checks out BRANCH when the remote has it. Otherwise HEAD is pointed at the
+// unborn branch, so the first commit starts it from an empty tree rather than
+// from the remote's default branch. Like a push, the transfer is limited by
+// GIT_PUSH_TIMEOUT, and GIT_PUSH_TOKEN is supplied the same way, so it isn't
+// stored in the clone's remote URL.
+func cloneCLI(config Config, dir string) error {
+	clone := &gitCommand{
+		Args:        []string{"clone", "--no-checkout", "--origin", config.GitRemote, config.GitRepoURL, dir},
+		credentials: newGitCredentials(config),
+		timeout:     config.GitPushTimeout,
+	}
+	if output, err := clone.CombinedOutput(); err != nil {
+		return withOutput(err, output)
+	}
+
+	inDir := func(args ...string) *gitCommand {
+		return &gitCommand{Args: args, Dir: dir, timeout: config.GitTimeout}
+	}
+	remoteBranch := "refs/remotes/" + config.GitRemote + "/" + config.Branch
+	if err := inDir("rev-parse", "--verify", "--quiet", remoteBranch).Run(); err != nil {
+		if err := inDir("symbolic-ref", "HEAD", "refs/heads/"+config.Branch).Run(); err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", config.Branch, err)
+		}
+		return nil
+	}
+	if err := inDir("checkout", "-B", config.Branch, "--track", config.GitRemote+"/"+config.Branch).Run(); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %w", config.Branch, err)
+	}
+	return nil
+}
+
+// cloneGoGit is cloneCLI for the gogit backend, authenticating the way its
+// pushes do. It initialises the repository and fetches every branch rather
+// than cloning, which needs the remote HEAD to resolve and the remote not to
+// be empty. Like git clone, it only clones into a missing or empty
+// directory, and empties it again if anything fails.
+func cloneGoGit(config Config, dir string) (err error) {
+	entries, statErr := os.ReadDir(dir)
+	if statErr == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty and is not a git repository", dir)
+	}
+	defer func() {
+		if err != nil {
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				os.RemoveAll(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}()
+
+	publisher := &goGitPublisher{
+		sshKeyPath:     config.GitSSHKeyPath,
+		sshKeyPassword: config.GitSSHKeyPassword,
+		pushUsername:   config.GitPushUsername,
+		pushToken:      config.GitPushToken,
+	}
+	auth, err := publisher.auth(config.GitRepoURL)
+	if err != nil {
+		return err
+	}
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return err
+	}
+	remote, err := repo.CreateRemote(&gitconfig.RemoteConfig{Name: config.GitRemote, URLs: []string{config.GitRepoURL}})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.GitPushTimeout)
+	defer cancel()
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", config.GitRemote))},
+		Auth:     auth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return timeoutError(ctx, err, config.GitPushTimeout, "clone")
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(config.Branch)
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w", config.Branch, err)
+	}
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(config.GitRemote, config.Branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %w", config.Branch, err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, remoteRef.Hash())); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %w", config.Branch, err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := wt.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %w", config.Branch, err)
+	}
+	return nil
+}
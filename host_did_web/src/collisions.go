@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// errTargetConflict is returned when a DID resolves to a target file already
+// queued for a different DID with different contents
+var errTargetConflict = errors.New("target file conflict")
+
+// errCodeTargetConflict is the machine-readable code for errTargetConflict
+const errCodeTargetConflict = "target_conflict"
+
+// fileClaim records the DID and contents queued for a target file
+type fileClaim struct {
+	did    string
+	digest string // sha256 of the document; empty for a removal
+	refs   int    // Queued items holding the claim
+}
+
+// targetClaims tracks the target files of items waiting in a git batch, so a
+// document isn't overwritten on disk by another DID before it is committed
+type targetClaims struct {
+	mu       sync.Mutex
+	claims   map[string]*fileClaim
+	foldOnce sync.Once
+	foldCase bool // The filesystem treats paths differing only in case as one file
+}
+
+func newTargetClaims() *targetClaims {
+	return &targetClaims{claims: make(map[string]*fileClaim)}
+}
+
+// key returns the path used to compare target files
+func (c *targetClaims) key(targetFile string) string {
+	key := filepath.ToSlash(filepath.Clean(targetFile))
+	if c.foldCase {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// claim reserves targetFile for did until release is called. The same DID, or
+// identical contents, may claim a file more than once; anything else is a
+// conflict. data is nil for a removal.
+func (c *targetClaims) claim(root, targetFile, did string, data []byte) error {
+	c.foldOnce.Do(func() { c.foldCase = isCaseInsensitiveFS(root) })
+
+	digest := ""
+	if data != nil {
+		digest = sha256Hex(data)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := c.key(targetFile)
+	existing, ok := c.claims[key]
+	if !ok {
+		c.claims[key] = &fileClaim{did: did, digest: digest, refs: 1}
+		return nil
+	}
+	if existing.did != did && (existing.digest != digest || digest == "") {
+		return fmt.Errorf("%w: %s is already queued for %s", errTargetConflict, targetFile, existing.did)
+	}
+	existing.refs++
+	return nil
+}
+
+// release drops one claim on targetFile
+func (c *targetClaims) release(targetFile string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := c.key(targetFile)
+	if existing, ok := c.claims[key]; ok {
+		if existing.refs--; existing.refs <= 0 {
+			delete(c.claims, key)
+		}
+	}
+}
+
+// abandon drops one claim on targetFile like release, calling undo first when
+// it is the last one. undo runs under the lock, so no new claim can write the
+// file until it is done.
+func (c *targetClaims) abandon(targetFile string, undo func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := c.key(targetFile)
+	if existing, ok := c.claims[key]; ok {
+		if existing.refs--; existing.refs <= 0 {
+			undo()
+			delete(c.claims, key)
+		}
+	}
+}
+
+// held reports whether a queued item has claimed targetFile
+func (c *targetClaims) held(targetFile string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.claims[c.key(targetFile)]
+	return ok
+}
+
+// isCaseInsensitiveFS reports whether dir is on a case-insensitive
+// filesystem, such as the macOS default, by looking up a probe file under a
+// different case
+func isCaseInsensitiveFS(dir string) bool {
+	probe, err := os.CreateTemp(dir, ".case-probe-")
+	if err != nil {
+		return false
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	_, err = os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(probe.Name()))))
+	return err == nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// errDeactivationNotPublished is returned when the pushed document doesn't
+// carry "deactivated": true, e.g. because another batch replaced it
+var errDeactivationNotPublished = errors.New("deactivation flag missing from the published document")
+
+// deactivatedOmitFields are the DID Core properties dropped from a
+// deactivated document, so no key or service it listed can still be used
+var deactivatedOmitFields = []string{
+	"verificationMethod",
+	"authentication",
+	"assertionMethod",
+	"keyAgreement",
+	"capabilityInvocation",
+	"capabilityDelegation",
+	"service",
+}
+
+// DeactivateDIDResponse represents the JSON response for DID deactivation
+type DeactivateDIDResponse struct {
+	Success     bool         `json:"success"`
+	Message     string       `json:"message"`
+	Deactivated bool         `json:"deactivated"` // The committed file carries "deactivated": true
+	Unchanged   bool         `json:"unchanged,omitempty"`
+	DryRun      bool         `json:"dryRun,omitempty"`
+	Diff        string       `json:"diff,omitempty"` // Dry run only: unified diff of the target file
+	RolledBack  bool         `json:"rolledBack,omitempty"`
+	Published   *Publication `json:"published,omitempty"`
+	Warnings    []string     `json:"warnings,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Code        string       `json:"code,omitempty"`
+}
+
+// deactivateDocument returns the published document with its verification
+// methods, relationships and services removed and "deactivated": true
+// appended. Everything else, including the id and @context, is kept in its
+// original order. The result is compact; callers format it.
+func deactivateDocument(published []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(published))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("published document is not a JSON object")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("published document is not a JSON object: %w", err)
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("published document is not a JSON object: %w", err)
+		}
+		if key == "deactivated" || slices.Contains(deactivatedOmitFields, key) {
+			continue
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"deactivated":true}`)
+	return buf.Bytes(), nil
+}
+
+// isDeactivated reports whether a document carries "deactivated": true
+func isDeactivated(data []byte) bool {
+	var doc struct {
+		Deactivated bool `json:"deactivated"`
+	}
+	return json.Unmarshal(data, &doc) == nil && doc.Deactivated
+}
+
+func (p *DIDProcessor) handleDeactivateDID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		p.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req DIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.sendBodyError(w, err)
+		return
+	}
+
+	if req.DID == "" {
+		p.sendError(w, http.StatusBadRequest, "DID is required")
+		return
+	}
+
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			p.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	result, err := p.deactivateDID(r.Context(), req.DID, req.CallbackURL)
+	response := DeactivateDIDResponse{
+		DryRun:    p.config.DryRun,
+		Diff:      result.Diff,
+		Unchanged: result.Unchanged,
+		Warnings:  result.Warnings,
+	}
+	if err != nil {
+		status, code := errorStatus(err)
+		response.Code = code
+		response.RolledBack = errors.Is(err, errBatchRolledBack)
+		if errors.Is(err, errQueueFull) {
+			p.setRetryAfter(w)
+		}
+		response.Error = err.Error()
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response.Success = true
+	response.Deactivated = !p.config.DryRun
+	response.Published = p.publication(result)
+	switch {
+	case p.config.DryRun:
+		response.Message = fmt.Sprintf("Dry run: would deactivate %s", result.TargetFile)
+	case result.Unchanged:
+		response.Message = "DID already deactivated, nothing to publish"
+	default:
+		response.Message = "DID deactivated successfully"
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// deactivateDID replaces the published document for a DID with its
+// deactivated form and commits it. Once pushed, the file on the remote
+// branch is read back to confirm it carries the deactivation flag.
+func (p *DIDProcessor) deactivateDID(ctx context.Context, did, callbackURL string) (ProcessResult, error) {
+	var result ProcessResult
+	logger := loggerFromContext(ctx).With("did", did)
+	parsedDID, err := parseDID(did)
+	if err != nil {
+		return result, fmt.Errorf("failed to parse DID: %w", err)
+	}
+	if warning := parsedDID.hostCaseWarning(); warning != "" {
+		result.Warnings = append(result.Warnings, warning)
+	}
+
+	if err := p.validateHost(parsedDID); err != nil {
+		return result, err
+	}
+	repo, err := p.repoFor(parsedDID)
+	if err != nil {
+		return result, err
+	}
+	verification, err := p.verifyHost(ctx, repo, parsedDID)
+	if err != nil {
+		return result, fmt.Errorf("host verification failed: %w", err)
+	}
+	result.HostVerification = verification.Method
+
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return result, fmt.Errorf("failed to prepare publishing directory: %w", err)
+	}
+	targetFile, err := p.determineTargetFile(root, parsedDID)
+	if err != nil {
+		return result, err
+	}
+	localPath := filepath.Join(root, targetFile)
+	logger.Info("Resolved target file", "target_file", targetFile)
+	result.TargetFile = targetFile
+	result.Branch = repo.Branch
+	result.PublishedURL = p.buildPublishedURL(parsedDID)
+
+	// Only a published DID can be deactivated
+	published, err := os.ReadFile(localPath)
+	if os.IsNotExist(err) {
+		return result, fmt.Errorf("%w: %s", errDIDNotFound, targetFile)
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read DID document: %w", err)
+	}
+	deactivated, err := deactivateDocument(published)
+	if err != nil {
+		return result, err
+	}
+	deactivated = p.formatDIDDocument(ctx, deactivated, targetFile)
+	result.Sha256 = sha256Hex(deactivated)
+	// A deactivated document must still belong to its DID
+	if err := checkDocumentID(deactivated, parsedDID); err != nil {
+		return result, err
+	}
+
+	if p.config.DryRun {
+		if err := p.diffDIDDocument(&result, deactivated, localPath, targetFile); err != nil {
+			return result, fmt.Errorf("failed to diff DID document: %w", err)
+		}
+		logger.Info("Dry run: would deactivate DID document", "target_file", targetFile)
+		return result, nil
+	}
+	if isDeactivated(published) {
+		logger.Info("DID document already deactivated", "target_file", targetFile)
+		result.Unchanged = true
+		result.Sha256 = sha256Hex(published)
+		return result, nil
+	}
+
+	if p.queueFull() {
+		return result, p.refuseQueueFull()
+	}
+	if err := repo.claims.claim(root, targetFile, parsedDID.expectedID(), deactivated); err != nil {
+		return result, err
+	}
+	previous := pendingFiles{targetFile: published}
+	if err := p.saveDIDDocument(deactivated, localPath); err != nil {
+		repo.claims.release(targetFile)
+		return result, fmt.Errorf("failed to save DID document: %w", err)
+	}
+
+	batchResult, err := p.enqueueBatchItem(ctx, BatchItem{
+		TargetFile:       targetFile,
+		ParsedDID:        parsedDID,
+		Repo:             repo,
+		HostVerification: verification,
+		Deactivate:       true,
+		RequestID:        requestIDFromContext(ctx),
+		Actor:            actorFromContext(ctx),
+		CallbackURL:      callbackURL,
+		Claimed:          true,
+		Previous:         previous,
+		Document:         deactivated,
+	})
+	if err != nil {
+		p.undoWrite(root, previous, err)
+		return result, fmt.Errorf("git operations failed: %w", err)
+	}
+	result.Commit = batchResult.Commit
+
+	committed, err := repo.git.RemoteFile(p.config.GitRemote, repo.Branch, targetFile)
+	if err != nil {
+		return result, fmt.Errorf("failed to read back %s: %w", targetFile, err)
+	}
+	if !isDeactivated(committed) {
+		return result, fmt.Errorf("%w: %s", errDeactivationNotPublished, targetFile)
+	}
+	logger.Info("🔒 DID deactivated", "target_file", targetFile, "commit", result.Commit)
+	return result, nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// errRetryInProgress is returned when a failed item is retried while its
+// previous retry is still queued
+var errRetryInProgress = errors.New("failed item is already being retried")
+
+// errCodeRetryInProgress is the machine-readable code for errRetryInProgress
+const errCodeRetryInProgress = "retry_in_progress"
+
+// FailedItem is a batch item whose commit or push failed, kept in the
+// dead-letter list until it is retried successfully
+type FailedItem struct {
+	ID         string    `json:"id"`
+	DID        string    `json:"did"`
+	Action     string    `json:"action"` // publish, deactivate or remove
+	TargetFile string    `json:"targetFile"`
+	Repo       string    `json:"repo,omitempty"`   // REPO_MAP repository; omitted for the working directory
+	Branch     string    `json:"branch,omitempty"` // Empty means BRANCH
+	Error      string    `json:"error"`
+	FailedAt   time.Time `json:"failedAt"`            // When the latest attempt failed
+	Attempts   int       `json:"attempts"`            // Failed attempts, counting the original one
+	Retrying   bool      `json:"retrying,omitempty"`  // A retry is queued and hasn't finished yet
+	JobID      string    `json:"jobId,omitempty"`     // Async job of the latest retry
+	RequestID  string    `json:"requestId,omitempty"` // Request that first queued the item
+	Actor      string    `json:"actor,omitempty"`
+
+	// Kept so a retry commits what the original request wrote; not listed
+	Document      []byte          `json:"document,omitempty"`
+	DomainLinkage json.RawMessage `json:"domainLinkage,omitempty"`
+	CallbackURL   string          `json:"callbackUrl,omitempty"`
+}
+
+// listed returns the item as GET /failed shows it, without the document,
+// domain linkage credential and callback URL
+func (f FailedItem) listed() FailedItem {
+	f.Document, f.DomainLinkage, f.CallbackURL = nil, nil, ""
+	return f
+}
+
+// FailedResponse is returned by GET /failed
+type FailedResponse struct {
+	Items    []FailedItem `json:"items"` // Oldest failure first
+	Count    int          `json:"count"`
+	Capacity int          `json:"capacity"` // DEAD_LETTER_MAX
+}
+
+// RetryFailedRequest is the body of POST /failed/retry
+type RetryFailedRequest struct {
+	IDs []string `json:"ids"` // Empty retries every failed item
+}
+
+// RetriedItem is a failed item sent back to the batch queue
+type RetriedItem struct {
+	ID    string `json:"id"`
+	DID   string `json:"did"`
+	JobID string `json:"jobId"` // Poll GET /jobs/{id} for the retry's outcome
+}
+
+// RetryError is a failed item that could not be sent back to the batch queue
+type RetryError struct {
+	ID    string `json:"id"`
+	DID   string `json:"did"`
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// RetryFailedResponse is returned by POST /failed/retry
+type RetryFailedResponse struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message"`
+	Retried []RetriedItem `json:"retried"`
+	Errors  []RetryError  `json:"errors,omitempty"`
+}
+
+// deadLetterList keeps the last DEAD_LETTER_MAX failed batch items, oldest
+// first, and mirrors them to DEAD_LETTER_FILE when it is set
+type deadLetterList struct {
+	mu    sync.Mutex
+	path  string // Empty keeps the list in memory only
+	max   int
+	items []FailedItem
+}
+
+// loadDeadLetters reads the dead-letter file, starting empty when it is
+// missing. Like the queue journal, a file that can't be read is an error.
+// Retries don't survive a restart, so no item is left marked as retrying.
+func loadDeadLetters(path string, max int) (*deadLetterList, error) {
+	l := &deadLetterList{path: path, max: max}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.items); err != nil {
+		return nil, fmt.Errorf("dead-letter file %s is corrupt: %w", path, err)
+	}
+	for i := range l.items {
+		l.items[i].Retrying = false
+	}
+	l.trim()
+	DeadLetterItems.Set(float64(len(l.items)))
+	return l, nil
+}
+
+// add records a failed batch item. An item that was itself a retry updates
+// its entry; when that entry was evicted in the meantime it is added anew.
+func (l *deadLetterList) add(item BatchItem, itemErr error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC()
+	if i := l.index(item.DeadLetterID); i >= 0 {
+		failed := &l.items[i]
+		failed.Error, failed.FailedAt, failed.Retrying = itemErr.Error(), now, false
+		failed.Attempts++
+		l.save()
+		return
+	}
+	id := item.DeadLetterID
+	if id == "" {
+		id = newRequestID()
+	}
+	l.items = append(l.items, FailedItem{
+		ID:            id,
+		DID:           item.ParsedDID.Original,
+		Action:        auditAction(item),
+		TargetFile:    item.TargetFile,
+		Repo:          item.Repo.Path,
+		Branch:        item.Repo.Branch,
+		Error:         itemErr.Error(),
+		FailedAt:      now,
+		Attempts:      1,
+		RequestID:     item.RequestID,
+		Actor:         item.Actor,
+		Document:      item.Document,
+		DomainLinkage: item.DomainLinkage,
+		CallbackURL:   item.CallbackURL,
+	})
+	l.trim()
+	l.save()
+}
+
+// resolve drops the entries a committed batch item settles: the one it
+// retried, and any earlier failure for the same target file, whose retry
+// would now commit a stale document
+func (l *deadLetterList) resolve(item BatchItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := len(l.items)
+	l.items = slices.DeleteFunc(l.items, func(failed FailedItem) bool {
+		return failed.ID == item.DeadLetterID ||
+			failed.TargetFile == item.TargetFile && failed.Repo == item.Repo.Path && failed.Branch == item.Repo.Branch
+	})
+	if len(l.items) != before {
+		l.save()
+	}
+}
+
+// list returns a copy of the items, oldest first
+func (l *deadLetterList) list() []FailedItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.items)
+}
+
+// len returns the number of items in the list
+func (l *deadLetterList) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.items)
+}
+
+// startRetry marks an item as retrying and returns it, refusing one whose
+// previous retry hasn't finished
+func (l *deadLetterList) startRetry(id string) (FailedItem, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := l.index(id)
+	if i < 0 {
+		return FailedItem{}, fmt.Errorf("no failed item %s", id)
+	}
+	if l.items[i].Retrying {
+		return l.items[i], errRetryInProgress
+	}
+	l.items[i].Retrying = true
+	return l.items[i], nil
+}
+
+// finishRetry records a retry's async job, or clears the retrying flag when
+// the item never reached the queue. The batch may already have failed the
+// retry again, so a queued retry doesn't set the flag back.
+func (l *deadLetterList) finishRetry(id, jobID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := l.index(id); i >= 0 {
+		if jobID == "" {
+			l.items[i].Retrying = false
+		} else {
+			l.items[i].JobID = jobID
+		}
+	}
+}
+
+// index returns the position of the item with the given ID, or -1; callers hold l.mu
+func (l *deadLetterList) index(id string) int {
+	if id == "" {
+		return -1
+	}
+	return slices.IndexFunc(l.items, func(item FailedItem) bool { return item.ID == id })
+}
+
+// trim evicts the oldest items beyond max; callers hold l.mu
+func (l *deadLetterList) trim() {
+	if excess := len(l.items) - l.max; excess > 0 {
+		DeadLetterEvictedTotal.Add(float64(excess))
+		l.items = slices.Delete(l.items, 0, excess)
+	}
+}
+
+// save updates the item gauge and, when DEAD_LETTER_FILE is set, writes the
+// list; callers hold l.mu. A write failure only costs the list on restart.
+func (l *deadLetterList) save() {
+	DeadLetterItems.Set(float64(len(l.items)))
+	if l.path == "" {
+		return
+	}
+	data, err := json.Marshal(l.items)
+	if err == nil {
+		err = writeFileSynced(l.path, data)
+	}
+	if err != nil {
+		slog.Warn("Failed to save dead-letter file", "path", l.path, "error", err)
+	}
+}
+
+// recordFailure adds a failed batch item to the dead-letter list, if enabled
+func (p *DIDProcessor) recordFailure(item BatchItem, itemErr error) {
+	if p.deadLetters != nil {
+		p.deadLetters.add(item, itemErr)
+	}
+}
+
+// recordCommitted removes the entries a committed item settles from the
+// dead-letter list, if enabled
+func (p *DIDProcessor) recordCommitted(item BatchItem) {
+	if p.deadLetters != nil {
+		p.deadLetters.resolve(item)
+	}
+}
+
+// handleFailed lists the batch items in the dead-letter list
+func (p *DIDProcessor) handleFailed(w http.ResponseWriter, r *http.Request) {
+	items := p.deadLetters.list()
+	response := FailedResponse{
+		Items:    make([]FailedItem, 0, len(items)),
+		Count:    len(items),
+		Capacity: p.config.DeadLetterMax,
+	}
+	for _, item := range items {
+		response.Items = append(response.Items, item.listed())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleRetryFailed sends failed items back to the batch queue under new
+// async jobs, without waiting for their batch: 202 when every item was
+// queued, 207 on partial failure, 503 when only the full queue stopped them
+// and 500 otherwise. An unknown ID fails the whole request with 404.
+func (p *DIDProcessor) handleRetryFailed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req RetryFailedRequest
+	// An empty body retries every failed item
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		p.sendBodyError(w, err)
+		return
+	}
+
+	ids := req.IDs
+	if len(ids) == 0 {
+		for _, item := range p.deadLetters.list() {
+			ids = append(ids, item.ID)
+		}
+	} else {
+		items := p.deadLetters.list()
+		for _, id := range ids {
+			if !slices.ContainsFunc(items, func(item FailedItem) bool { return item.ID == id }) {
+				p.sendError(w, http.StatusNotFound, fmt.Sprintf("No failed item %s", id))
+				return
+			}
+		}
+	}
+
+	response := RetryFailedResponse{Retried: []RetriedItem{}}
+	queueFull := 0
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+		item, err := p.deadLetters.startRetry(id)
+		jobID := ""
+		if err == nil {
+			jobID, err = p.retryFailed(r.Context(), item)
+			p.deadLetters.finishRetry(id, jobID)
+		}
+		if err != nil {
+			loggerFromContext(r.Context()).Warn("Failed to retry failed batch item", "id", id, "did", item.DID, "error", err)
+			retryErr := RetryError{ID: id, DID: item.DID, Error: err.Error()}
+			switch {
+			case errors.Is(err, errQueueFull):
+				retryErr.Code = errCodeQueueFull
+				queueFull++
+			case errors.Is(err, errRetryInProgress):
+				retryErr.Code = errCodeRetryInProgress
+			case errors.Is(err, errTargetConflict):
+				retryErr.Code = errCodeTargetConflict
+			case errors.Is(err, errRepoNotMapped):
+				retryErr.Code = errCodeRepoNotMapped
+			}
+			response.Errors = append(response.Errors, retryErr)
+			continue
+		}
+		DeadLetterRetriedTotal.Inc()
+		response.Retried = append(response.Retried, RetriedItem{ID: id, DID: item.DID, JobID: jobID})
+	}
+
+	status := http.StatusAccepted
+	switch {
+	case len(response.Errors) == 0:
+		response.Success = true
+		if len(response.Retried) == 0 {
+			status = http.StatusOK
+			response.Message = "No failed items to retry"
+		} else {
+			response.Message = fmt.Sprintf("Queued %d failed items for retry", len(response.Retried))
+		}
+	case len(response.Retried) > 0:
+		status = http.StatusMultiStatus
+		response.Message = fmt.Sprintf("%d of %d failed items could not be retried", len(response.Errors), len(ids))
+	case queueFull == len(response.Errors):
+		p.setRetryAfter(w)
+		status = http.StatusServiceUnavailable
+		response.Message = "Batch queue is full, no failed items were retried"
+	default:
+		status = http.StatusInternalServerError
+		response.Message = "No failed items could be retried"
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// retryFailed writes a failed item's document back to its target file, or
+// removes the file again, and queues the item for the next batch. It returns
+// the async job that reports the retry's outcome.
+func (p *DIDProcessor) retryFailed(ctx context.Context, failed FailedItem) (string, error) {
+	parsedDID, err := parseDID(failed.DID)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse DID: %w", err)
+	}
+	branch := failed.Branch
+	if branch == "" {
+		branch = p.config.Branch
+	}
+	repo, ok := p.repos[repoID{Path: failed.Repo, Branch: branch}]
+	if !ok {
+		return "", fmt.Errorf("%w: repository %s is no longer configured for branch %s", errRepoNotMapped, failed.Repo, branch)
+	}
+	remove := failed.Action == "remove"
+	if !remove && failed.Document == nil {
+		return "", fmt.Errorf("no document was kept for %s", failed.TargetFile)
+	}
+	verification, err := p.verifyHost(ctx, repo, parsedDID)
+	if err != nil {
+		return "", fmt.Errorf("host verification failed: %w", err)
+	}
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare publishing directory: %w", err)
+	}
+
+	if p.queueFull() {
+		return "", p.refuseQueueFull()
+	}
+	if err := repo.claims.claim(root, failed.TargetFile, parsedDID.expectedID(), failed.Document); err != nil {
+		return "", err
+	}
+	previous, err := savePendingFiles(root, []string{failed.TargetFile})
+	if err != nil {
+		repo.claims.release(failed.TargetFile)
+		return "", err
+	}
+	localPath := filepath.Join(root, failed.TargetFile)
+	if remove {
+		err = os.Remove(localPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = p.saveDIDDocument(failed.Document, localPath)
+	}
+	if err != nil {
+		repo.claims.release(failed.TargetFile)
+		return "", fmt.Errorf("failed to write %s: %w", failed.TargetFile, err)
+	}
+
+	jobID, err := p.queueBatchItem(ctx, BatchItem{
+		TargetFile:       failed.TargetFile,
+		ParsedDID:        parsedDID,
+		Repo:             repo,
+		HostVerification: verification,
+		Remove:           remove,
+		Deactivate:       failed.Action == "deactivate",
+		RequestID:        requestIDFromContext(ctx),
+		Actor:            actorFromContext(ctx),
+		CallbackURL:      failed.CallbackURL,
+		Claimed:          true,
+		Previous:         previous,
+		Document:         failed.Document,
+		DomainLinkage:    failed.DomainLinkage,
+		DeadLetterID:     failed.ID,
+	})
+	if err != nil {
+		p.undoWrite(root, previous, err)
+		return "", err
+	}
+	return jobID, nil
+}
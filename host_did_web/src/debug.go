@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PendingItem describes an item in the batch being collected
+type PendingItem struct {
+	DID        string    `json:"did"`
+	TargetFile string    `json:"targetFile"`
+	Repo       string    `json:"repo,omitempty"` // REPO_MAP repository; omitted for the working directory
+	Remove     bool      `json:"remove,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	JobID      string    `json:"jobId,omitempty"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	Abandoned  bool      `json:"abandoned,omitempty"` // The waiting request has gone away
+}
+
+// DebugState is returned by GET /debug/state
+type DebugState struct {
+	QueueLength   int           `json:"queueLength"` // Items sent but not yet picked up by the batch processor
+	QueueCapacity int           `json:"queueCapacity"`
+	Processing    bool          `json:"processing"` // The pending items are being committed and pushed
+	Pending       []PendingItem `json:"pending"`
+	GitLocked     bool          `json:"gitLocked"`             // A batch currently holds a repository's git mutex
+	LockedRepos   []string      `json:"lockedRepos,omitempty"` // Repositories whose git mutex is held, when REPO_MAP is set
+	Goroutines    int           `json:"goroutines"`
+}
+
+// pendingBatch mirrors the batch processor's current batch, which is
+// otherwise local to its goroutine, along with the running totals reported
+// by /stats. One mutex covers both so a snapshot is always coherent.
+type pendingBatch struct {
+	mu         sync.Mutex
+	items      []BatchItem
+	processing bool
+
+	lastFlush  time.Time // When the last non-empty batch finished
+	lastCommit string    // Last commit pushed, in lastRepo
+	lastRepo   string
+	lastPushAt time.Time
+	processed  int64 // Items committed since startup
+	failed     int64 // Items whose batch failed or that were rejected from it
+
+	failedBatches int // Consecutive flushes in which a repository's commit or push failed
+}
+
+// update replaces the snapshot with a copy of batch
+func (b *pendingBatch) update(batch []BatchItem, processing bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = append(b.items[:0], batch...)
+	b.processing = processing
+}
+
+// debugMux serves the pprof handlers and /debug/state. It is only mounted on
+// DEBUG_PORT when ENABLE_DEBUG is true.
+func (p *DIDProcessor) debugMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/state", p.handleDebugState)
+	return mux
+}
+
+// handleDebugState dumps the batch queue and git lock state, to see where a
+// wedged batch processor is stuck
+func (p *DIDProcessor) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	state := DebugState{
+		QueueLength:   len(p.batchCh),
+		QueueCapacity: cap(p.batchCh),
+		Pending:       []PendingItem{},
+		Goroutines:    runtime.NumGoroutine(),
+	}
+
+	p.pending.mu.Lock()
+	state.Processing = p.pending.processing
+	for _, item := range p.pending.items {
+		state.Pending = append(state.Pending, PendingItem{
+			DID:        item.ParsedDID.Original,
+			TargetFile: item.TargetFile,
+			Repo:       item.Repo.Path,
+			Remove:     item.Remove,
+			RequestID:  item.RequestID,
+			JobID:      item.JobID,
+			EnqueuedAt: item.EnqueuedAt,
+			Abandoned:  item.Ctx != nil && item.Ctx.Err() != nil,
+		})
+	}
+	p.pending.mu.Unlock()
+
+	// Probing with TryLock may briefly delay a batch, which is fine for a debug endpoint
+	for _, repo := range p.sortedRepos() {
+		if repo.mu.TryLock() {
+			repo.mu.Unlock()
+			continue
+		}
+		state.GitLocked = true
+		// Branches of a repository share its mutex
+		if repo.Path != "" && !slices.Contains(state.LockedRepos, repo.Path) {
+			state.LockedRepos = append(state.LockedRepos, repo.Path)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// didConfigurationContext is the @context of a DIF Well Known DID Configuration
+const didConfigurationContext = "https://identity.foundation/.well-known/did-configuration/v1"
+
+// errInvalidDomainLinkage is returned for a domain linkage credential that
+// isn't a DomainLinkageCredential for the DID being published
+var errInvalidDomainLinkage = errors.New("invalid domain linkage credential")
+
+// errCodeInvalidDomainLinkage is the machine-readable code for errInvalidDomainLinkage
+const errCodeInvalidDomainLinkage = "invalid_domain_linkage"
+
+// DIDConfiguration is .well-known/did-configuration.json. Each linked DID is
+// a DomainLinkageCredential, either a JSON-LD object or a compact JWT string.
+type DIDConfiguration struct {
+	Context    string            `json:"@context"`
+	LinkedDIDs []json.RawMessage `json:"linked_dids"`
+}
+
+// domainLinkageRequest is POSTed to DOMAIN_LINKAGE_URL to request a credential
+type domainLinkageRequest struct {
+	DID    string `json:"did"`
+	Origin string `json:"origin"`
+}
+
+// didConfigurationPath returns the configuration file relative to the
+// publishing root, or "" when DID_CONFIGURATION is off
+func (p *DIDProcessor) didConfigurationPath() string {
+	if !p.config.DIDConfiguration {
+		return ""
+	}
+	return filepath.Join(p.config.OutputBaseDir, wellKnownDir, "did-configuration.json")
+}
+
+// domainLinkage returns the credential linking the DID to its host: the one
+// supplied with the request, or else one requested from DOMAIN_LINKAGE_URL.
+// It returns nil when DID_CONFIGURATION is off or there is no source.
+func (p *DIDProcessor) domainLinkage(ctx context.Context, parsed *ParsedDID, supplied json.RawMessage) (json.RawMessage, error) {
+	if !p.config.DIDConfiguration {
+		return nil, nil
+	}
+	credential := supplied
+	if credential == nil && p.config.DomainLinkageURL != "" {
+		var err error
+		if credential, err = p.requestDomainLinkage(ctx, parsed); err != nil {
+			return nil, err
+		}
+	}
+	if credential == nil {
+		return nil, nil
+	}
+	if err := checkDomainLinkage(credential, parsed); err != nil {
+		return nil, err
+	}
+	return compactJSON(credential)
+}
+
+// requestDomainLinkage asks DOMAIN_LINKAGE_URL to issue a credential for the
+// DID and its origin. The response body is the credential: a JSON object, a
+// JSON string or a bare compact JWT.
+func (p *DIDProcessor) requestDomainLinkage(ctx context.Context, parsed *ParsedDID) (json.RawMessage, error) {
+	body, err := json.Marshal(domainLinkageRequest{DID: parsed.expectedID(), Origin: "https://" + parsed.Host})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.DomainLinkageURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to request domain linkage credential: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.config.DomainLinkageToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.DomainLinkageToken)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request domain linkage credential: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domain linkage credential: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("domain linkage endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' && data[0] != '"' {
+		// A bare JWT, as served with Content-Type: application/jwt
+		return json.Marshal(string(data))
+	}
+	return data, nil
+}
+
+// linkedDID returns the DID a domain linkage credential is issued for, after
+// checking that it is a DomainLinkageCredential. JWTs are decoded but not
+// verified; verifiers check the signature against the published document.
+func linkedDID(credential json.RawMessage) (string, error) {
+	var vc struct {
+		Type              json.RawMessage `json:"type"`
+		CredentialSubject struct {
+			ID string `json:"id"`
+		} `json:"credentialSubject"`
+	}
+
+	var jwt string
+	if err := json.Unmarshal(credential, &jwt); err == nil {
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			return "", fmt.Errorf("%w: not a compact JWT", errInvalidDomainLinkage)
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", fmt.Errorf("%w: JWT payload is not base64url: %v", errInvalidDomainLinkage, err)
+		}
+		var claims struct {
+			VC json.RawMessage `json:"vc"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil || claims.VC == nil {
+			return "", fmt.Errorf("%w: JWT has no vc claim", errInvalidDomainLinkage)
+		}
+		credential = claims.VC
+	}
+	if err := json.Unmarshal(credential, &vc); err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidDomainLinkage, err)
+	}
+
+	var types []string
+	if err := json.Unmarshal(vc.Type, &types); err != nil {
+		var single string
+		if json.Unmarshal(vc.Type, &single) == nil {
+			types = []string{single}
+		}
+	}
+	if !slices.Contains(types, "DomainLinkageCredential") {
+		return "", fmt.Errorf("%w: type must include DomainLinkageCredential", errInvalidDomainLinkage)
+	}
+	if vc.CredentialSubject.ID == "" {
+		return "", fmt.Errorf("%w: credentialSubject.id is missing", errInvalidDomainLinkage)
+	}
+	return vc.CredentialSubject.ID, nil
+}
+
+// checkDomainLinkage checks that a credential links the DID being published
+func checkDomainLinkage(credential json.RawMessage, parsed *ParsedDID) error {
+	did, err := linkedDID(credential)
+	if err != nil {
+		return err
+	}
+	if did != parsed.expectedID() {
+		return fmt.Errorf("%w: credentialSubject.id is %s, expected %s", errInvalidDomainLinkage, did, parsed.expectedID())
+	}
+	return nil
+}
+
+// compactJSON strips insignificant whitespace so a credential can be
+// embedded in the configuration file as is
+func compactJSON(data []byte) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDomainLinkage, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// updateDIDConfiguration merges the batch into the configuration on the
+// remote branch and writes the result to the working tree, like
+// updateIndex. A new credential replaces the DID's previous entries, and
+// removed or deactivated DIDs lose theirs. It reports whether the file was
+// written, which it isn't when the batch doesn't touch it.
+func (p *DIDProcessor) updateDIDConfiguration(repo *publishRepo, root string, batch []BatchItem) (bool, error) {
+	configFile := p.didConfigurationPath()
+	unlink := make(map[string]bool)
+	var added []json.RawMessage
+	for _, item := range batch {
+		switch {
+		case item.Remove, item.Deactivate:
+			unlink[item.ParsedDID.expectedID()] = true
+		case item.DomainLinkage != nil:
+			unlink[item.ParsedDID.expectedID()] = true
+			added = append(added, item.DomainLinkage)
+		}
+	}
+	if len(unlink) == 0 {
+		return false, nil
+	}
+
+	config := DIDConfiguration{Context: didConfigurationContext}
+	data, err := repo.git.RemoteFile(p.config.GitRemote, repo.Branch, configFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", configFile, err)
+	}
+	if data != nil {
+		// Like the index, a corrupt file is left for an operator
+		if err := json.Unmarshal(data, &config); err != nil {
+			return false, fmt.Errorf("published %s is not a valid DID configuration, refusing to overwrite it: %w", configFile, err)
+		}
+	} else if len(added) == 0 {
+		// Nothing to remove from a file that was never published
+		return false, nil
+	}
+
+	// Entries that can't be parsed, or were added by hand for other DIDs, are kept
+	linked := make([]json.RawMessage, 0, len(config.LinkedDIDs)+len(added))
+	for _, credential := range config.LinkedDIDs {
+		if did, err := linkedDID(credential); err == nil && unlink[did] {
+			continue
+		}
+		linked = append(linked, credential)
+	}
+	config.LinkedDIDs = append(linked, added...)
+
+	formatted, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("failed to encode %s: %w", configFile, err)
+	}
+	if err := p.saveDIDDocument(append(formatted, '\n'), filepath.Join(root, configFile)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// refreshDIDConfiguration merges the batch into the configuration again
+// after a rebase, like refreshIndex, and commits the result if it changed
+func (p *DIDProcessor) refreshDIDConfiguration(repo *publishRepo, batch []BatchItem) error {
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return err
+	}
+	written, err := p.updateDIDConfiguration(repo, root, batch)
+	if err != nil || !written {
+		return err
+	}
+	if err := repo.git.AddFiles([]string{p.didConfigurationPath()}); err != nil {
+		return err
+	}
+	staged, err := repo.git.HasStagedChanges()
+	if err != nil || !staged {
+		return err
+	}
+	return repo.git.Commit(fmt.Sprintf("%s: merge %s", p.config.CommitMsg, filepath.ToSlash(p.didConfigurationPath())))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dry-run change classifications reported in responses
+const (
+	changeAdded     = "added"     // No document exists at the target file yet
+	changeChanged   = "changed"   // The fetched document differs from the existing one
+	changeUnchanged = "unchanged" // The fetched document matches the existing one
+)
+
+// diffContextLines is the number of unchanged lines shown around each hunk
+const diffContextLines = 3
+
+// diffOp is a single line of an edit script: ' ' keep, '-' delete, '+' insert
+type diffOp struct {
+	kind byte
+	line string
+	oldN int // 1-based line number in the old text (kept and deleted lines)
+	newN int // 1-based line number in the new text (kept and inserted lines)
+}
+
+// splitLines splits text into lines without their trailing newlines
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a line edit script from a to b using the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i], oldN: i + 1, newN: j + 1})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			// Prefer deletions so removed lines are listed before their replacements
+			ops = append(ops, diffOp{kind: '-', line: a[i], oldN: i + 1, newN: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j], oldN: i, newN: j + 1})
+			j++
+		}
+	}
+	return ops
+}
+
+// unifiedDiff renders the difference between oldText and newText in unified
+// diff format, or returns an empty string when they are identical
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var b strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are within twice the context of each other
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*diffContextLines {
+				break
+			}
+		}
+		hunkStart := max(start-diffContextLines, 0)
+		hunkEnd := min(end+diffContextLines, len(ops))
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		writeHunk(&b, ops[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+	return b.String()
+}
+
+// writeHunk writes a single hunk header and its lines
+func writeHunk(b *strings.Builder, ops []diffOp) {
+	oldStart, newStart := ops[0].oldN, ops[0].newN
+	var oldCount, newCount int
+	for _, op := range ops {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	// Line numbers of an inserted or deleted first line point one before the hunk
+	if ops[0].kind == '+' {
+		oldStart++
+	}
+	if ops[0].kind == '-' {
+		newStart++
+	}
+	if oldCount == 0 {
+		oldStart--
+	}
+	if newCount == 0 {
+		newStart--
+	}
+
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, op := range ops {
+		fmt.Fprintf(b, "%c%s\n", op.kind, op.line)
+	}
+}
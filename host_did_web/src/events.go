@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// eventSecretHeader carries EVENTS_SECRET on POST /events/did-created
+const eventSecretHeader = "X-Event-Secret"
+
+// errEventQueueFull is returned when EVENT_QUEUE_SIZE events are already
+// waiting for a worker
+var errEventQueueFull = errors.New("event queue is full")
+
+// errCodeEventQueueFull is the machine-readable code for errEventQueueFull
+const errCodeEventQueueFull = "event_queue_full"
+
+// Event outcomes reported by POST /events/did-created
+const (
+	eventQueued    = "queued"    // Handed to a worker to be published
+	eventDuplicate = "duplicate" // The DID was already queued within EVENT_DEDUP_TTL
+	eventIgnored   = "ignored"   // Not a did:web identifier, or its host isn't in ALLOWED_HOSTS
+)
+
+// DIDCreatedEvent is the body of POST /events/did-created: a Veramo agent
+// event carrying the created identifier in data, the identifier itself, or
+// a bare {"did": "..."} envelope
+type DIDCreatedEvent struct {
+	Type     string           `json:"type,omitempty"`
+	DID      string           `json:"did,omitempty"`
+	Provider string           `json:"provider,omitempty"` // Veramo DID provider, e.g. did:web
+	Data     *DIDCreatedEvent `json:"data,omitempty"`
+}
+
+// identifier returns the created DID and its provider, preferring the
+// identifier wrapped in a Veramo event
+func (e DIDCreatedEvent) identifier() (string, string) {
+	if e.Data != nil && e.Data.DID != "" {
+		return e.Data.DID, e.Data.Provider
+	}
+	return e.DID, e.Provider
+}
+
+// DIDCreatedResponse is returned by POST /events/did-created
+type DIDCreatedResponse struct {
+	Success bool   `json:"success"`
+	DID     string `json:"did,omitempty"`
+	Status  string `json:"status"` // queued, duplicate or ignored
+}
+
+// didEvent is a created DID waiting for an event worker
+type didEvent struct {
+	DID         string
+	RequestID   string
+	SpanContext trace.SpanContext // Request that delivered the event
+}
+
+// recentDIDs remembers the DIDs queued within ttl, so an event delivered
+// more than once is only published once
+type recentDIDs struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	ttl  time.Duration
+}
+
+func newRecentDIDs(ttl time.Duration) *recentDIDs {
+	return &recentDIDs{seen: make(map[string]time.Time), ttl: ttl}
+}
+
+// claim records the DID and reports whether it wasn't already recorded
+// within ttl
+func (r *recentDIDs) claim(did string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for seen, at := range r.seen {
+		if now.Sub(at) > r.ttl {
+			delete(r.seen, seen)
+		}
+	}
+	if _, ok := r.seen[did]; ok {
+		return false
+	}
+	r.seen[did] = now
+	return true
+}
+
+// forget drops the DID, so the next event for it is processed again
+func (r *recentDIDs) forget(did string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.seen, did)
+}
+
+// eventQueue hands created DIDs to a fixed pool of workers
+type eventQueue struct {
+	ch     chan didEvent
+	recent *recentDIDs
+}
+
+func newEventQueue(size int, dedupTTL time.Duration) *eventQueue {
+	return &eventQueue{ch: make(chan didEvent, size), recent: newRecentDIDs(dedupTTL)}
+}
+
+// startEventWorkers starts EVENT_WORKERS goroutines publishing queued events
+func (p *DIDProcessor) startEventWorkers() {
+	for range p.config.EventWorkers {
+		go func() {
+			for event := range p.events.ch {
+				p.processDIDEvent(event)
+			}
+		}()
+	}
+}
+
+// requireEventSecret rejects requests whose X-Event-Secret header isn't EVENTS_SECRET
+func (p *DIDProcessor) requireEventSecret(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(eventSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(p.config.EventsSecret)) == 1 {
+			next(w, r.WithContext(withActor(r.Context(), "event")))
+			return
+		}
+
+		reason := "invalid_event_secret"
+		if secret == "" {
+			reason = "missing_event_secret"
+		}
+		AuthRejectedTotal.WithLabelValues(reason).Inc()
+		loggerFromContext(r.Context()).Warn("🚫 Rejected event",
+			"path", r.URL.Path, "remote_addr", r.RemoteAddr, "reason", reason)
+		w.Header().Set("Content-Type", "application/json")
+		p.sendError(w, http.StatusUnauthorized, "Unauthorized")
+	}
+}
+
+// handleDIDCreated queues the DID from a creation event for publishing and
+// answers without waiting for it: 202 when queued, 200 for a duplicate or a
+// DID this service doesn't host, 400 for a malformed one and 503 when the
+// event queue is full
+func (p *DIDProcessor) handleDIDCreated(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var event DIDCreatedEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		p.sendBodyError(w, err)
+		return
+	}
+	did, provider := event.identifier()
+	if did == "" {
+		p.sendError(w, http.StatusBadRequest, "DID is required")
+		return
+	}
+	logger := loggerFromContext(r.Context()).With("did", did, "event_type", event.Type)
+
+	// Veramo reports every identifier it creates; only did:web ones are hosted here
+	if (provider != "" && provider != "did:web") || !strings.HasPrefix(did, "did:web:") {
+		logger.Debug("Ignoring event for a DID that isn't did:web", "provider", provider)
+		DIDEventsTotal.WithLabelValues(eventIgnored).Inc()
+		json.NewEncoder(w).Encode(DIDCreatedResponse{Success: true, DID: did, Status: eventIgnored})
+		return
+	}
+	parsedDID, err := parseDID(did)
+	var syntaxErr *DIDSyntaxError
+	if errors.As(err, &syntaxErr) {
+		p.sendErrorCode(w, http.StatusBadRequest, syntaxErr.Code, err.Error())
+		return
+	}
+	did = parsedDID.expectedID()
+	// Nor are did:web identifiers for hosts another publisher serves
+	if err := p.validateHost(parsedDID); err != nil {
+		logger.Info("Ignoring event for a host that isn't allowed", "error", err)
+		DIDEventsTotal.WithLabelValues(eventIgnored).Inc()
+		json.NewEncoder(w).Encode(DIDCreatedResponse{Success: true, DID: did, Status: eventIgnored})
+		return
+	}
+
+	if !p.events.recent.claim(did) {
+		logger.Info("Ignoring duplicate DID creation event")
+		DIDEventsTotal.WithLabelValues(eventDuplicate).Inc()
+		json.NewEncoder(w).Encode(DIDCreatedResponse{Success: true, DID: did, Status: eventDuplicate})
+		return
+	}
+	queued := didEvent{
+		DID:         did,
+		RequestID:   requestIDFromContext(r.Context()),
+		SpanContext: trace.SpanContextFromContext(r.Context()),
+	}
+	select {
+	case p.events.ch <- queued:
+	default:
+		p.events.recent.forget(did)
+		DIDEventsTotal.WithLabelValues(errCodeEventQueueFull).Inc()
+		p.setRetryAfter(w)
+		p.sendErrorCode(w, http.StatusServiceUnavailable, errCodeEventQueueFull, errEventQueueFull.Error())
+		return
+	}
+
+	logger.Info("Queued DID creation event")
+	DIDEventsTotal.WithLabelValues(eventQueued).Inc()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(DIDCreatedResponse{Success: true, DID: did, Status: eventQueued})
+}
+
+// processDIDEvent publishes a queued DID like an async /process-did request.
+// A DID that fails before reaching the batch queue is forgotten, so a
+// redelivered event tries again.
+func (p *DIDProcessor) processDIDEvent(event didEvent) {
+	ctx := context.WithValue(context.Background(), requestIDKey{}, event.RequestID)
+	ctx = withActor(ctx, "event")
+	ctx = trace.ContextWithRemoteSpanContext(ctx, event.SpanContext)
+	ctx, span := tracer.Start(ctx, "process DID creation event")
+
+	logger := loggerFromContext(ctx).With("did", event.DID)
+	result, err := p.processDID(ctx, event.DID, ProcessOptions{StrictContext: p.config.StrictContext, Async: true})
+	endSpan(span, err)
+	if err != nil {
+		p.events.recent.forget(event.DID)
+		DIDEventsTotal.WithLabelValues("failed").Inc()
+		logger.Warn("Failed to process DID creation event", "error", err)
+		return
+	}
+	logger.Info("Processed DID creation event", "job_id", result.JobID, "unchanged", result.Unchanged)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fetchCacheEntry holds the validators upstream sent for a DID document,
+// along with the digest of the document they describe
+type fetchCacheEntry struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Sha256       string    `json:"sha256"` // Normalized document written to the target file
+	StoredAt     time.Time `json:"storedAt"`
+}
+
+// fetchCache remembers the ETag or Last-Modified of fetched documents per
+// fetch URL, so re-syncs can ask upstream for only what changed. It is saved
+// to FETCH_CACHE_FILE after every update so it survives restarts.
+type fetchCache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	entries map[string]fetchCacheEntry
+}
+
+// loadFetchCache reads the cache file, starting empty when it is missing or
+// unreadable since the cache only saves requests
+func loadFetchCache(path string, ttl time.Duration) *fetchCache {
+	c := &fetchCache{path: path, ttl: ttl, entries: make(map[string]fetchCacheEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read fetch cache, starting empty", "path", path, "error", err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		slog.Warn("Fetch cache is corrupt, starting empty", "path", path, "error", err)
+		c.entries = make(map[string]fetchCacheEntry)
+	}
+	return c
+}
+
+// lookup returns the entry for fetchURL, or nil when there is none, it is
+// older than FETCH_CACHE_TTL, or the file at localPath no longer holds the
+// document it describes. A 304 only means upstream hasn't changed, so the
+// local copy must still match for the pipeline to be skipped.
+func (c *fetchCache) lookup(fetchURL, localPath string) *fetchCacheEntry {
+	c.mu.Lock()
+	entry, ok := c.entries[fetchURL]
+	c.mu.Unlock()
+	if !ok || time.Since(entry.StoredAt) > c.ttl {
+		return nil
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil
+	}
+	normalized, err := normalizeJSON(data)
+	if err != nil || sha256Hex(normalized) != entry.Sha256 {
+		return nil
+	}
+	return &entry
+}
+
+// store records the validators for fetchURL and saves the cache. Responses
+// without an ETag or Last-Modified are not cached.
+func (c *fetchCache) store(fetchURL string, fetched fetchResult, document []byte) {
+	if fetched.ETag == "" && fetched.LastModified == "" {
+		return
+	}
+	normalized, err := normalizeJSON(document)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[fetchURL] = fetchCacheEntry{
+		ETag:         fetched.ETag,
+		LastModified: fetched.LastModified,
+		Sha256:       sha256Hex(normalized),
+		StoredAt:     time.Now(),
+	}
+	if err := c.save(); err != nil {
+		slog.Warn("Failed to save fetch cache", "path", c.path, "error", err)
+	}
+}
+
+// save writes the unexpired entries to the cache file through a temporary
+// file, so a crash never leaves it half-written; callers hold c.mu
+func (c *fetchCache) save() error {
+	for fetchURL, entry := range c.entries {
+		if time.Since(entry.StoredAt) > c.ttl {
+			delete(c.entries, fetchURL)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// defaultFetchCachePath returns the cache file under the user cache
+// directory, outside the publishing repository
+func defaultFetchCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "host_did_web", "fetch-cache.json")
+}
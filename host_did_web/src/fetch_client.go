@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// newFetchClient returns the client used to fetch DID documents from
+// SERVER_URL. FETCH_CA_FILE adds its roots to the system pool and
+// FETCH_INSECURE_SKIP_VERIFY turns verification off; neither touches the
+// service's other outbound requests.
+func newFetchClient(config Config) (*http.Client, error) {
+	if config.FetchCAFile == "" && !config.FetchInsecure {
+		return &http.Client{Timeout: config.FetchTimeout}, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.FetchInsecure}
+	if config.FetchCAFile != "" {
+		pem, err := os.ReadFile(config.FetchCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid FETCH_CA_FILE '%s': no PEM certificates found", config.FetchCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: config.FetchTimeout, Transport: transport}, nil
+}
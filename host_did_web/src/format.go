@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// documentFormat is how DID documents are laid out on disk
+type documentFormat struct {
+	Indent          string // Indentation per level; empty writes compact JSON
+	TrailingNewline bool
+}
+
+// parseDocumentIndent reads DOCUMENT_INDENT: a number of spaces, 0 for
+// compact JSON, or "tab"
+func parseDocumentIndent(value string) (string, error) {
+	if value == "tab" {
+		return "\t", nil
+	}
+	spaces, err := strconv.Atoi(value)
+	if err != nil || spaces < 0 || spaces > 8 {
+		return "", fmt.Errorf("invalid DOCUMENT_INDENT '%s' (expected 0-8 spaces or tab)", value)
+	}
+	return strings.Repeat(" ", spaces), nil
+}
+
+// checkDocumentFileName validates DOCUMENT_FILENAME, which must be a plain
+// file name since the directories come from the DID
+func checkDocumentFileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || filepath.Base(name) != name {
+		return fmt.Errorf("invalid DOCUMENT_FILENAME '%s' (expected a file name without directories)", name)
+	}
+	return nil
+}
+
+// format lays out a JSON document. Unlike re-marshalling it, indenting in
+// place keeps the source's key order and string escapes.
+func (f documentFormat) format(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	var buf bytes.Buffer
+	var err error
+	if f.Indent == "" {
+		err = json.Compact(&buf, data)
+	} else {
+		err = json.Indent(&buf, data, "", f.Indent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.TrailingNewline {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
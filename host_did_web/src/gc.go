@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// GCRequest is the body of POST /gc. DIDs is the complete set of DIDs still
+// published, across every repository; when it is empty the DIDs listed in
+// each repository's published index are kept instead.
+type GCRequest struct {
+	DIDs []string `json:"dids,omitempty"`
+}
+
+// GCResult reports garbage collection in one publishing repository
+type GCResult struct {
+	Repo    string   `json:"repo"`
+	Removed []string `json:"removed"`           // Orphaned documents removed, or that would be in a dry run
+	Kept    int      `json:"kept"`              // Documents belonging to a DID being kept
+	Skipped []string `json:"skipped,omitempty"` // Orphans left alone because a queued item has claimed them
+	Commit  string   `json:"commit,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// GCResponse represents the JSON response for POST /gc
+type GCResponse struct {
+	Success bool       `json:"success"`
+	Message string     `json:"message"`
+	DryRun  bool       `json:"dryRun,omitempty"`
+	Repos   []GCResult `json:"repos"`
+	Error   string     `json:"error,omitempty"`
+	Code    string     `json:"code,omitempty"`
+}
+
+// handleGC removes DID documents under OUTPUT_BASE_DIR that belong to no DID
+// being kept, one commit per repository. In a dry run it only lists them.
+func (p *DIDProcessor) handleGC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req GCRequest
+	// An empty body keeps the indexed DIDs
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		p.sendBodyError(w, err)
+		return
+	}
+
+	if len(req.DIDs) == 0 && p.indexPath() == "" {
+		p.sendError(w, http.StatusBadRequest, "dids is required when INDEX_FILE is disabled")
+		return
+	}
+	keep := make(map[*publishRepo][]*ParsedDID)
+	for _, did := range req.DIDs {
+		parsed, err := parseDID(did)
+		if err != nil {
+			// Dropping a DID from the list would delete its document, so a bad one fails the request
+			var syntaxErr *DIDSyntaxError
+			code := ""
+			if errors.As(err, &syntaxErr) {
+				code = syntaxErr.Code
+			}
+			p.sendErrorCode(w, http.StatusBadRequest, code, fmt.Sprintf("invalid DID %s: %v", did, err))
+			return
+		}
+		repo, err := p.repoFor(parsed)
+		if errors.Is(err, errBranchNotMapped) {
+			p.sendErrorCode(w, http.StatusUnprocessableEntity, errCodeBranchNotMapped, err.Error())
+			return
+		}
+		if err != nil {
+			p.sendErrorCode(w, http.StatusUnprocessableEntity, errCodeRepoNotMapped, err.Error())
+			return
+		}
+		keep[repo] = append(keep[repo], parsed)
+	}
+
+	response := GCResponse{Success: true, DryRun: p.config.DryRun}
+	removed := 0
+	for _, repo := range p.sortedRepos() {
+		result, err := p.collectGarbage(r.Context(), repo, keep[repo], len(req.DIDs) == 0)
+		if err != nil {
+			slog.Error("Garbage collection failed", "repo", repo.name(), "error", err)
+			result.Error = err.Error()
+			response.Success = false
+		}
+		removed += len(result.Removed)
+		response.Repos = append(response.Repos, result)
+	}
+
+	switch {
+	case !response.Success:
+		response.Error = "garbage collection failed"
+		response.Message = "Garbage collection failed for one or more repositories"
+		w.WriteHeader(http.StatusInternalServerError)
+	case p.config.DryRun:
+		response.Message = fmt.Sprintf("Dry run: would remove %d orphaned documents", removed)
+	default:
+		response.Message = fmt.Sprintf("Removed %d orphaned documents", removed)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// collectGarbage removes the orphaned documents in one repository. The
+// documents kept are those of dids, or of the published index when
+// fromIndex is set. Like a batch flush, it holds the repository's locks and
+// starts from the remote branch; a failed commit or push is rolled back.
+func (p *DIDProcessor) collectGarbage(ctx context.Context, repo *publishRepo, dids []*ParsedDID, fromIndex bool) (GCResult, error) {
+	result := GCResult{Repo: repo.name(), Removed: []string{}}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	unlock, err := lockRepo(repo, p.config.GitLockTimeout)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	if !p.config.DryRun {
+		if err := p.checkGitRemote(repo); err != nil {
+			return result, err
+		}
+		if err := repo.git.FastForward(p.config.GitRemote, repo.Branch, nil); err != nil {
+			return result, err
+		}
+		if err := repo.git.EnsureBranch(repo.Branch); err != nil {
+			return result, fmt.Errorf("failed to checkout branch %s: %w", repo.Branch, err)
+		}
+	}
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return result, err
+	}
+
+	var index *DIDIndex
+	if p.indexPath() != "" {
+		if index, err = p.publishedIndex(repo); err != nil {
+			return result, err
+		}
+	}
+	// indexed maps each indexed document to its DID, so removing it can
+	// drop the DID from the index too
+	indexed := make(map[string]string)
+	if index != nil {
+		for did, entry := range index.DIDs {
+			indexed[repo.claims.key(filepath.Join(p.config.OutputBaseDir, filepath.FromSlash(entry.Path)))] = did
+		}
+	}
+
+	keep := make(map[string]bool)
+	switch {
+	case fromIndex && index == nil:
+		// Without an index every document would look orphaned
+		return result, fmt.Errorf("%s has not been published, refusing to remove every document", filepath.ToSlash(p.indexPath()))
+	case fromIndex:
+		for targetFile := range indexed {
+			keep[targetFile] = true
+		}
+	default:
+		for _, parsed := range dids {
+			targetFile, err := p.determineTargetFile(root, parsed)
+			if err != nil {
+				return result, err
+			}
+			keep[repo.claims.key(targetFile)] = true
+		}
+	}
+
+	documents, err := p.findDocuments(root)
+	if err != nil {
+		return result, fmt.Errorf("failed to list DID documents: %w", err)
+	}
+	var orphans []string
+	for _, targetFile := range documents {
+		switch {
+		case keep[repo.claims.key(targetFile)]:
+			result.Kept++
+		case repo.claims.held(targetFile):
+			result.Skipped = append(result.Skipped, filepath.ToSlash(targetFile))
+		default:
+			orphans = append(orphans, targetFile)
+			result.Removed = append(result.Removed, filepath.ToSlash(targetFile))
+		}
+	}
+	if p.config.DryRun || len(orphans) == 0 {
+		return result, nil
+	}
+
+	// Indexed orphans are removed as batch items, so the index and DID
+	// configuration drop them the way they drop removed DIDs
+	var items []BatchItem
+	for _, targetFile := range orphans {
+		did, ok := indexed[repo.claims.key(targetFile)]
+		if !ok {
+			continue
+		}
+		parsed, err := parseDID(did)
+		if err != nil {
+			continue
+		}
+		items = append(items, BatchItem{
+			TargetFile: targetFile,
+			ParsedDID:  parsed,
+			Repo:       repo,
+			Remove:     true,
+			RequestID:  requestIDFromContext(ctx),
+			Actor:      actorFromContext(ctx),
+		})
+	}
+
+	commit, err := p.commitGarbage(repo, root, orphans, items)
+	if err != nil {
+		if errors.Is(err, errBranchDiverged) {
+			return result, err
+		}
+		files := append([]string{}, orphans...)
+		if indexFile := p.indexPath(); indexFile != "" {
+			files = append(files, indexFile)
+		}
+		if configFile := p.didConfigurationPath(); configFile != "" {
+			files = append(files, configFile)
+		}
+		if rollbackErr := repo.git.Rollback(p.config.GitRemote, repo.Branch, files); rollbackErr != nil {
+			slog.Error("Failed to roll back garbage collection", "repo", repo.name(), "error", rollbackErr)
+			return result, err
+		}
+		return result, fmt.Errorf("%w: %w", errBatchRolledBack, err)
+	}
+	result.Commit = commit
+	GCRemovedTotal.Add(float64(len(orphans)))
+
+	audit := make([]AuditRecord, 0, len(items))
+	results := make([]BatchResult, 0, len(items))
+	for _, item := range items {
+		audit = append(audit, newAuditRecord(item, commit, auditCommitted, nil))
+		results = append(results, BatchResult{Commit: commit})
+	}
+	p.recordAudit(audit)
+	p.recordPublishStats(items, results, nil, 0)
+	loggerFromContext(ctx).Info("🧹 Removed orphaned DID documents", "repo", repo.name(), "files", result.Removed, "commit", commit)
+	return result, nil
+}
+
+// commitGarbage deletes the orphans, drops the indexed ones from the index
+// and DID configuration, and commits and pushes the result. It returns the
+// new commit, or "" when none of the orphans were tracked.
+func (p *DIDProcessor) commitGarbage(repo *publishRepo, root string, orphans []string, items []BatchItem) (string, error) {
+	for _, targetFile := range orphans {
+		if err := os.Remove(filepath.Join(root, targetFile)); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to remove %s: %w", targetFile, err)
+		}
+	}
+	if err := repo.git.RemoveFiles(orphans); err != nil {
+		return "", err
+	}
+
+	var filesToAdd []string
+	if indexFile := p.indexPath(); indexFile != "" && len(items) > 0 {
+		if err := p.updateIndex(repo, root, items); err != nil {
+			return "", err
+		}
+		filesToAdd = append(filesToAdd, indexFile)
+	}
+	if configFile := p.didConfigurationPath(); configFile != "" && len(items) > 0 {
+		written, err := p.updateDIDConfiguration(repo, root, items)
+		if err != nil {
+			return "", err
+		}
+		if written {
+			filesToAdd = append(filesToAdd, configFile)
+		}
+	}
+	if len(filesToAdd) > 0 {
+		if err := repo.git.AddFiles(filesToAdd); err != nil {
+			return "", err
+		}
+	}
+
+	staged, err := repo.git.HasStagedChanges()
+	if err != nil || !staged {
+		return "", err
+	}
+	fileList := make([]string, 0, len(orphans))
+	for _, targetFile := range orphans {
+		fileList = append(fileList, "removed "+targetFile)
+	}
+	if err := repo.git.Commit(formatCommitMessage(p.config.GCCommitMsg, fileList)); err != nil {
+		return "", err
+	}
+	if err := p.pushWithRetry(repo, items); err != nil {
+		return "", err
+	}
+	return repo.git.HeadCommit()
+}
+
+// publishedIndex reads the index on the remote branch, returning nil when
+// it hasn't been published
+func (p *DIDProcessor) publishedIndex(repo *publishRepo) (*DIDIndex, error) {
+	indexFile := p.indexPath()
+	data, err := repo.git.RemoteFile(p.config.GitRemote, repo.Branch, indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", indexFile, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var index DIDIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("published %s is not a valid index: %w", indexFile, err)
+	}
+	return &index, nil
+}
+
+// findDocuments lists the DOCUMENT_FILENAME files under OUTPUT_BASE_DIR,
+// relative to root. Nothing else is listed: symlinks aren't followed or
+// listed, and .git directories and nested repositories are skipped.
+func (p *DIDProcessor) findDocuments(root string) ([]string, error) {
+	base := filepath.Join(root, p.config.OutputBaseDir)
+	var documents []string
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == base && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == base {
+				return nil
+			}
+			if _, err := os.Lstat(filepath.Join(path, ".git")); d.Name() == ".git" || err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || d.Name() != p.config.DocumentFileName {
+			return nil
+		}
+		targetFile, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if err := checkWithinRoot(root, targetFile); err != nil {
+			return err
+		}
+		documents = append(documents, targetFile)
+		return nil
+	})
+	return documents, err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newGCTestTree writes documents under OUTPUT_BASE_DIR dids for a kept DID
+// and an orphan, next to files garbage collection must leave alone, and
+// returns every file written
+func newGCTestTree(t *testing.T, dir string) []string {
+	t.Helper()
+	files := []string{
+		"dids/keep/did.json",
+		"dids/orphan/did.json",
+		"dids/orphan/notes.json", // Sibling of an orphan, not a DID document
+		"dids/notes.json",
+		"outside/did.json", // DID document outside OUTPUT_BASE_DIR
+		"did.json",
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(`{"id":"`+file+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// listFiles returns the regular files under dir, relative to it
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestGCRemovesOnlyOrphanedDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		dryRun  bool
+		deleted []string
+	}{
+		{"dry run", true, nil},
+		{"publish", false, []string{"dids/orphan/did.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, repo, git := newBatchTestProcessor(t)
+			p.config.OutputBaseDir = "dids"
+			p.config.DocumentFileName = "did.json"
+			p.config.DryRun = tt.dryRun
+			before := newGCTestTree(t, repo.Path)
+
+			body := `{"dids":["did:web:alice.github.io:site:keep"]}`
+			rec := httptest.NewRecorder()
+			p.handleGC(rec, httptest.NewRequest(http.MethodPost, "/gc", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("POST /gc = %d: %s", rec.Code, rec.Body)
+			}
+			var response GCResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Repos) != 1 {
+				t.Fatalf("repos = %+v, want one", response.Repos)
+			}
+			result := response.Repos[0]
+			if want := []string{"dids/orphan/did.json"}; !slices.Equal(result.Removed, want) {
+				t.Errorf("removed = %v, want %v", result.Removed, want)
+			}
+			if result.Kept != 1 {
+				t.Errorf("kept = %d, want 1", result.Kept)
+			}
+
+			after := listFiles(t, repo.Path)
+			var deleted []string
+			for _, file := range before {
+				if !slices.Contains(after, file) {
+					deleted = append(deleted, file)
+				}
+			}
+			if !slices.Equal(deleted, tt.deleted) {
+				t.Errorf("deleted %v, want %v", deleted, tt.deleted)
+			}
+			if committed := slices.Contains(git.called(), "Commit"); committed == tt.dryRun {
+				t.Errorf("committed = %t, want %t", committed, !tt.dryRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,715 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cliGitPublisher implements GitPublisher by shelling out to the git binary
+type cliGitPublisher struct {
+	branch       string // Branch published from the dedicated worktree
+	useWorktree  bool   // Publish from a dedicated worktree instead of the current checkout
+	worktreePath string // Worktree location; empty means inside the git directory
+	signing      string // Commit signing mode: off, gpg or ssh
+	signingKey   string // GPG key ID or SSH key path passed to --gpg-sign
+	identity     commitIdentity
+	timeout      time.Duration   // Limit for each git command
+	pushTimeout  time.Duration   // Limit for git push
+	dir          string          // Repository checkout; empty means the current directory
+	credentials  *gitCredentials // HTTPS credentials from GIT_PUSH_TOKEN; nil leaves authentication to git
+
+	mu    sync.Mutex
+	root  string // Directory git runs in; resolved on first use, empty means the current directory
+	ready bool
+}
+
+// gitWaitDelay is how long a killed git gets to exit before its pipes are
+// closed anyway, since children such as ssh can hold them open
+const gitWaitDelay = 5 * time.Second
+
+// defaultPushUsername is sent with GIT_PUSH_TOKEN when GIT_PUSH_USERNAME is
+// unset; GitHub accepts any non-empty username with a token
+const defaultPushUsername = "x-access-token"
+
+// credentialHelper answers git's requests for HTTPS credentials from the
+// environment of the command it runs under, and ignores requests to store
+// or erase them. Git only asks for HTTP(S) remotes, so SSH is unaffected.
+const credentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$HOST_DID_WEB_GIT_USERNAME" "$HOST_DID_WEB_GIT_TOKEN"; }; f`
+
+// gitCredentials are the HTTPS credentials given to the git binary through
+// credentialHelper for each command. The token is passed in the command's
+// environment, so it never reaches .git/config, the remote URL or the
+// command line.
+type gitCredentials struct {
+	username string
+	token    string
+}
+
+// newGitCredentials returns the GIT_PUSH_USERNAME/GIT_PUSH_TOKEN
+// credentials, or nil when no token is set
+func newGitCredentials(config Config) *gitCredentials {
+	if config.GitPushToken == "" {
+		return nil
+	}
+	username := config.GitPushUsername
+	if username == "" {
+		username = defaultPushUsername
+	}
+	return &gitCredentials{username: username, token: config.GitPushToken}
+}
+
+// redact hides the token in git output, in case a remote echoes it back
+func (c *gitCredentials) redact(output []byte) []byte {
+	if c == nil {
+		return output
+	}
+	return bytes.ReplaceAll(output, []byte(c.token), []byte("***"))
+}
+
+// gitCommand is a git invocation that is killed once its timeout elapses
+type gitCommand struct {
+	Args        []string
+	Dir         string
+	Env         []string
+	credentials *gitCredentials
+	timeout     time.Duration
+}
+
+// run runs the command and returns its stdout, along with stderr when
+// combined is set. Otherwise stderr is added to the returned error.
+func (c *gitCommand) run(combined bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	args, env := c.Args, c.Env
+	if c.credentials != nil {
+		// The empty helper drops any configured ones, so none of them stores the token
+		args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper}, args...)
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(slices.Clip(env),
+			"HOST_DID_WEB_GIT_USERNAME="+c.credentials.username,
+			"HOST_DID_WEB_GIT_TOKEN="+c.credentials.token,
+			"GIT_TERMINAL_PROMPT=0")
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = c.Dir
+	cmd.Env = env
+	cmd.WaitDelay = gitWaitDelay
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if combined {
+		cmd.Stderr = &stdout
+	}
+	err := cmd.Run()
+	output := c.credentials.redact(stdout.Bytes())
+	if err := timeoutError(ctx, err, c.timeout, "git "+c.Args[0]); errors.Is(err, errGitTimeout) {
+		return output, err
+	}
+	if err != nil && !combined {
+		if msg := strings.TrimSpace(string(c.credentials.redact(stderr.Bytes()))); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+	return output, err
+}
+
+func (c *gitCommand) Run() error {
+	_, err := c.run(false)
+	return err
+}
+
+func (c *gitCommand) Output() ([]byte, error) {
+	return c.run(false)
+}
+
+func (c *gitCommand) CombinedOutput() ([]byte, error) {
+	return c.run(true)
+}
+
+// withOutput adds git's combined output to err, if it printed anything
+func withOutput(err error, output []byte) error {
+	if msg := strings.TrimSpace(string(output)); msg != "" {
+		return fmt.Errorf("%w (%s)", err, msg)
+	}
+	return err
+}
+
+// commandIn returns a git command that runs in dir
+func (g *cliGitPublisher) commandIn(dir string, args ...string) *gitCommand {
+	return &gitCommand{Args: args, Dir: dir, credentials: g.credentials, timeout: g.timeout}
+}
+
+// command returns a git command that runs in the publishing directory
+func (g *cliGitPublisher) command(args ...string) *gitCommand {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.commandIn(g.root, args...)
+}
+
+// repoCommand returns a git command that runs in the repository checkout,
+// which differs from the publishing directory when a worktree is used
+func (g *cliGitPublisher) repoCommand(args ...string) *gitCommand {
+	return g.commandIn(g.dir, args...)
+}
+
+// WorkDir returns the directory DID documents are written to. With a worktree
+// configured it is created on first use, unless the current checkout is
+// already on the publishing branch.
+func (g *cliGitPublisher) WorkDir() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ready {
+		return g.workDir(), nil
+	}
+	if !g.useWorktree {
+		g.ready = true
+		return g.workDir(), nil
+	}
+
+	current, err := g.repoCommand("symbolic-ref", "--short", "HEAD").Output()
+	if err == nil && strings.TrimSpace(string(current)) == g.branch {
+		// A branch can only be checked out once, so publish in place
+		g.ready = true
+		return g.workDir(), nil
+	}
+
+	path, err := g.resolveWorktreePath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+		if err := g.addWorktree(path); err != nil {
+			return "", err
+		}
+	}
+
+	g.root = path
+	g.ready = true
+	return g.workDir(), nil
+}
+
+// workDir returns the resolved publishing directory; callers hold g.mu
+func (g *cliGitPublisher) workDir() string {
+	if g.root == "" {
+		return "."
+	}
+	return g.root
+}
+
+// resolveWorktreePath returns the configured worktree path, defaulting to a
+// directory inside the git directory so it never shows up in git status
+func (g *cliGitPublisher) resolveWorktreePath() (string, error) {
+	if g.worktreePath != "" {
+		// A relative path is relative to the repository
+		path := g.worktreePath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(g.dir, path)
+		}
+		return filepath.Abs(path)
+	}
+	output, err := g.repoCommand("rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate git directory: %w", err)
+	}
+	return filepath.Join(strings.TrimSpace(string(output)), "publish-worktrees", g.branch), nil
+}
+
+// addWorktree creates a worktree for the branch, starting an empty orphan
+// branch when it doesn't exist yet
+func (g *cliGitPublisher) addWorktree(path string) error {
+	// Forget worktrees whose directories were deleted
+	g.repoCommand("worktree", "prune").Run()
+
+	if g.repoCommand("rev-parse", "--verify", "--quiet", "refs/heads/"+g.branch).Run() == nil {
+		if output, err := g.repoCommand("worktree", "add", path, g.branch).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add worktree for %s: %w (%s)", g.branch, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	if output, err := g.repoCommand("worktree", "add", "--detach", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add worktree for %s: %w (%s)", g.branch, err, strings.TrimSpace(string(output)))
+	}
+	if err := g.commandIn(path, "checkout", "--orphan", g.branch).Run(); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w", g.branch, err)
+	}
+	if err := g.commandIn(path, "rm", "-r", "-f", "--quiet", "--ignore-unmatch", ".").Run(); err != nil {
+		return fmt.Errorf("failed to clear worktree for %s: %w", g.branch, err)
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) RemoteInfo(remote string) (remoteInfo, error) {
+	cmd := g.command("remote", "get-url", remote)
+	output, err := cmd.Output()
+	if err != nil {
+		return remoteInfo{}, fmt.Errorf("failed to get remote URL: %w", err)
+	}
+	return remoteInfo{Name: remote, URL: strings.TrimSpace(string(output))}, nil
+}
+
+func (g *cliGitPublisher) CheckRemote(remote, branch string) error {
+	cmd := g.command("ls-remote", "--heads", remote, branch)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ls-remote %s failed: %w: %s", remote, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) FastForward(remote, branch string, files []string) error {
+	dir, err := g.WorkDir()
+	if err != nil {
+		return err
+	}
+
+	refSpec := fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch)
+	fetch := g.command("fetch", remote, refSpec)
+	fetch.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := fetch.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "couldn't find remote ref") {
+			// Nothing published yet; the first push creates the branch
+			return nil
+		}
+		return fmt.Errorf("failed to fetch %s/%s: %w", remote, branch, withOutput(err, output))
+	}
+
+	upstream, err := g.revParse("refs/remotes/" + remote + "/" + branch)
+	if err != nil {
+		return err
+	}
+	local, _ := g.revParse("refs/heads/" + branch)
+	if local == upstream {
+		return nil
+	}
+	if local != "" {
+		if g.command("merge-base", "--is-ancestor", upstream, local).Run() == nil {
+			// Only ahead; the push publishes the local commits
+			return nil
+		}
+		if g.command("merge-base", "--is-ancestor", local, upstream).Run() != nil {
+			return divergedError(remote, branch, local, upstream)
+		}
+	}
+
+	current, err := g.command("symbolic-ref", "--short", "HEAD").Output()
+	if err != nil || strings.TrimSpace(string(current)) != branch {
+		// Not checked out here: move the ref and let EnsureBranch check it out
+		if err := g.command("update-ref", "refs/heads/"+branch, upstream).Run(); err != nil {
+			return fmt.Errorf("failed to update %s to %s: %w", branch, upstream, err)
+		}
+		g.command("branch", "--set-upstream-to", remote+"/"+branch, branch).Run()
+		return nil
+	}
+
+	// Set the batch's files aside so they can't block the fast-forward, then
+	// put them back on top of the new tip
+	saved, err := savePendingFiles(dir, files)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if local != "" && g.command("cat-file", "-e", "HEAD:./"+file).Run() == nil {
+			g.command("checkout", "HEAD", "--", file).Run()
+		} else if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to set aside %s: %w", file, err)
+		}
+	}
+
+	var output []byte
+	if local == "" {
+		// An unborn branch, such as a fresh orphan worktree, has nothing to merge
+		output, err = g.command("reset", "--quiet", "--hard", upstream).CombinedOutput()
+	} else {
+		output, err = g.command("merge", "--ff-only", "--quiet", upstream).CombinedOutput()
+	}
+	if restoreErr := saved.restore(dir); restoreErr != nil {
+		return restoreErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fast-forward %s to %s: %w (%s)", branch, upstream, err, strings.TrimSpace(string(output)))
+	}
+	g.command("branch", "--set-upstream-to", remote+"/"+branch, branch).Run()
+	return nil
+}
+
+// revParse resolves a revision to a commit SHA, returning "" if it doesn't exist
+func (g *cliGitPublisher) revParse(rev string) (string, error) {
+	output, err := g.command("rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (g *cliGitPublisher) RemoteFile(remote, branch, file string) ([]byte, error) {
+	if _, err := g.WorkDir(); err != nil {
+		return nil, err
+	}
+	// ./ makes the path relative to the directory git runs in
+	object := fmt.Sprintf("%s/%s:./%s", remote, branch, filepath.ToSlash(file))
+	if err := g.command("cat-file", "-e", object).Run(); err != nil {
+		return nil, nil
+	}
+	data, err := g.command("cat-file", "blob", object).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", object, err)
+	}
+	return data, nil
+}
+
+func (g *cliGitPublisher) EnsureBranch(branch string) error {
+	if _, err := g.WorkDir(); err != nil {
+		return err
+	}
+
+	// symbolic-ref also works on unborn branches, such as a fresh orphan worktree
+	if current, err := g.command("symbolic-ref", "--short", "HEAD").Output(); err == nil &&
+		strings.TrimSpace(string(current)) == branch {
+		return nil
+	}
+
+	checkBranch := g.command("rev-parse", "--verify", branch)
+	if err := checkBranch.Run(); err != nil {
+		if err := g.command("checkout", "-b", branch).Run(); err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", branch, err)
+		}
+	} else {
+		if err := g.command("checkout", branch).Run(); err != nil {
+			return fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+		}
+	}
+
+	return nil
+}
+
+func (g *cliGitPublisher) AddFiles(files []string) error {
+	addArgs := append([]string{"add"}, files...)
+	if err := g.command(addArgs...).Run(); err != nil {
+		return fmt.Errorf("failed to add files: %w", err)
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) RemoveFiles(files []string) error {
+	rmArgs := append([]string{"rm", "--cached", "--quiet", "--ignore-unmatch", "--"}, files...)
+	if err := g.command(rmArgs...).Run(); err != nil {
+		return fmt.Errorf("failed to remove files: %w", err)
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) HasStagedChanges() (bool, error) {
+	// git diff --quiet exits 1 when there are differences
+	err := g.command("diff", "--cached", "--quiet").Run()
+	if err == nil {
+		return false, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	return false, fmt.Errorf("failed to check staged changes: %w", err)
+}
+
+func (g *cliGitPublisher) Commit(message string) error {
+	args := append(cliSigningArgs(g.signing, g.signingKey), "-m", message)
+	cmd, err := g.committingCommand(args...)
+	if err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+	return nil
+}
+
+// committingCommand returns a git command that records the configured commit
+// identity, failing when there is none and git has no user configured
+func (g *cliGitPublisher) committingCommand(args ...string) (*gitCommand, error) {
+	cmd := g.command(args...)
+	if g.identity.isSet() {
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+g.identity.AuthorName,
+			"GIT_AUTHOR_EMAIL="+g.identity.AuthorEmail,
+			"GIT_COMMITTER_NAME="+g.identity.CommitterName,
+			"GIT_COMMITTER_EMAIL="+g.identity.CommitterEmail)
+	} else if !g.hasConfiguredIdentity() {
+		return nil, errNoCommitIdentity
+	}
+	return cmd, nil
+}
+
+// hasConfiguredIdentity reports whether git has user.name and user.email
+// set, rather than guessing an identity from the user and hostname
+func (g *cliGitPublisher) hasConfiguredIdentity() bool {
+	for _, key := range []string{"user.name", "user.email"} {
+		output, err := g.command("config", "--get", key).Output()
+		if err != nil || strings.TrimSpace(string(output)) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *cliGitPublisher) HeadCommit() (string, error) {
+	output, err := g.command("rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (g *cliGitPublisher) LastCommit() (*commitInfo, error) {
+	if g.command("rev-parse", "--verify", "--quiet", "HEAD").Run() != nil {
+		return nil, nil
+	}
+	output, err := g.command("log", "-1", "--format=%H%x00%P%x00%an%x00%ae%x00%at%x00%B", "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	fields := strings.SplitN(string(output), "\x00", 6)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("failed to read HEAD commit: unexpected git log output")
+	}
+	authorTime, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	return &commitInfo{
+		SHA:         fields[0],
+		Parents:     len(strings.Fields(fields[1])),
+		AuthorName:  fields[2],
+		AuthorEmail: fields[3],
+		AuthorTime:  time.Unix(authorTime, 0),
+		Message:     strings.TrimSpace(fields[5]),
+	}, nil
+}
+
+func (g *cliGitPublisher) AmendCommit(message string) error {
+	// --amend keeps the original author and author date
+	args := append(cliSigningArgs(g.signing, g.signingKey), "--amend", "-m", message)
+	cmd, err := g.committingCommand(args...)
+	if err != nil {
+		return fmt.Errorf("git commit --amend failed: %w", err)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git commit --amend failed: %w", err)
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) ResetSoft(commit string) error {
+	if err := g.command("reset", "--quiet", "--soft", commit).Run(); err != nil {
+		return fmt.Errorf("failed to reset to %s: %w", commit, err)
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) Push(remote, branch string) error {
+	push := g.command("push", "-u", remote, branch)
+	push.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	push.timeout = g.pushTimeout
+	output, err := push.CombinedOutput()
+	if err != nil {
+		if isPushRejection(string(output)) {
+			return fmt.Errorf("failed to push to %s: %w (%v)", branch, errPushRejected, err)
+		}
+		return fmt.Errorf("failed to push to %s: %w", branch, withOutput(err, output))
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) ForcePush(remote, branch, expected string) error {
+	lease := fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", branch, expected)
+	push := g.command("push", "-u", lease, remote, branch)
+	push.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	push.timeout = g.pushTimeout
+	output, err := push.CombinedOutput()
+	if err != nil {
+		if isPushRejection(string(output)) {
+			return fmt.Errorf("failed to force push to %s: %w (%v)", branch, errPushRejected, err)
+		}
+		return fmt.Errorf("failed to force push to %s: %w", branch, withOutput(err, output))
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) Rollback(remote, branch string, files []string) error {
+	dir, err := g.WorkDir()
+	if err != nil {
+		return err
+	}
+
+	// Best effort: the failure being rolled back may be a network error
+	refSpec := fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch)
+	g.command("fetch", remote, refSpec).Run()
+
+	upstream := fmt.Sprintf("%s/%s", remote, branch)
+	if g.command("rev-parse", "--verify", "--quiet", upstream).Run() != nil {
+		// Nothing published yet, so only unstage the files
+		return g.unstage(files)
+	}
+
+	// Resetting another branch to the remote tip would drop its own commits
+	if current, err := g.command("symbolic-ref", "--short", "HEAD").Output(); err != nil ||
+		strings.TrimSpace(string(current)) != branch {
+		return g.RestoreFiles(remote, branch, files)
+	}
+
+	if err := g.command("reset", "--quiet", "--mixed", upstream).Run(); err != nil {
+		return fmt.Errorf("failed to reset to %s: %w", upstream, err)
+	}
+	for _, file := range files {
+		if g.command("cat-file", "-e", upstream+":./"+file).Run() != nil {
+			// The file was never published
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", file, err)
+			}
+			continue
+		}
+		if err := g.command("checkout", upstream, "--", file).Run(); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) RestoreFiles(remote, branch string, files []string) error {
+	dir, err := g.WorkDir()
+	if err != nil {
+		return err
+	}
+
+	// Unstaged against HEAD, so another branch checked out never gets the
+	// remote branch's versions staged
+	if err := g.unstage(files); err != nil {
+		return err
+	}
+	upstream := fmt.Sprintf("%s/%s", remote, branch)
+	if g.command("rev-parse", "--verify", "--quiet", upstream).Run() != nil {
+		return nil
+	}
+	for _, file := range files {
+		if g.command("cat-file", "-e", upstream+":./"+file).Run() != nil {
+			// The file was never published
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", file, err)
+			}
+			continue
+		}
+		if err := g.command("restore", "--source="+upstream, "--worktree", "--", file).Run(); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// unstage resets the index entries of files to HEAD
+func (g *cliGitPublisher) unstage(files []string) error {
+	resetArgs := append([]string{"reset", "--quiet", "--"}, files...)
+	if err := g.command(resetArgs...).Run(); err != nil {
+		return fmt.Errorf("failed to unstage files: %w", err)
+	}
+	return nil
+}
+
+func (g *cliGitPublisher) Sync(remote, branch string, resolve map[string][]byte) error {
+	refSpec := fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch)
+	if err := g.command("fetch", remote, refSpec).Run(); err != nil {
+		return fmt.Errorf("failed to fetch %s/%s: %w", remote, branch, err)
+	}
+
+	upstream := fmt.Sprintf("%s/%s", remote, branch)
+	rebase, err := g.committingCommand("rebase", "--autostash", upstream)
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	// The rebase stops at each local commit that conflicts; resolve it and carry on
+	err = rebase.Run()
+	for err != nil {
+		if err := g.resolveConflicts(resolve, err); err != nil {
+			g.command("rebase", "--abort").Run()
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+		cont, err2 := g.committingCommand("rebase", "--continue")
+		if err2 != nil {
+			g.command("rebase", "--abort").Run()
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err2)
+		}
+		// Keep the replayed commit's message rather than opening an editor
+		if cont.Env == nil {
+			cont.Env = os.Environ()
+		}
+		cont.Env = append(cont.Env, "GIT_EDITOR=true")
+		err = cont.Run()
+	}
+	return nil
+}
+
+// resolveConflicts gives each conflicted file its contents in resolve and
+// stages it. stopErr, why the rebase stopped, is returned when nothing
+// conflicts, and errRebaseConflict when a file outside resolve does.
+func (g *cliGitPublisher) resolveConflicts(resolve map[string][]byte, stopErr error) error {
+	output, err := g.command("diff", "--name-only", "--diff-filter=U", "-z").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list conflicts: %w", err)
+	}
+	var conflicted, outside []string
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file == "" {
+			continue
+		}
+		conflicted = append(conflicted, file)
+		if _, ok := resolve[filepath.FromSlash(file)]; !ok {
+			outside = append(outside, file)
+		}
+	}
+	if len(conflicted) == 0 {
+		return stopErr
+	}
+	if len(outside) > 0 {
+		return fmt.Errorf("%w in files outside the batch: %s", errRebaseConflict, strings.Join(outside, ", "))
+	}
+
+	root, err := g.WorkDir()
+	if err != nil {
+		return err
+	}
+	for _, file := range conflicted {
+		contents := resolve[filepath.FromSlash(file)]
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if contents == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to resolve %s: %w", file, err)
+			}
+			if err := g.command("rm", "--cached", "--quiet", "--ignore-unmatch", "--", file).Run(); err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", file, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+		if err := g.command("add", "--", file).Run(); err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+	}
+	return nil
+}
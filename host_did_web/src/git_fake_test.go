@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// fakeGitPublisher is an in-memory GitPublisher. Files are read from and
+// restored to a real working directory, while the index, local commits and
+// the remote branch are kept in memory. Every call is recorded by method
+// name, and errs makes a method fail.
+type fakeGitPublisher struct {
+	mu      sync.Mutex
+	dir     string
+	url     string
+	calls   []string
+	errs    map[string]error
+	staged  pendingFiles   // Staged contents by path; nil for a removal
+	commits []pendingFiles // Unpushed commits, oldest first
+	remote  pendingFiles   // Files on the remote branch
+	pushed  int            // Commits pushed so far
+	// Paths given to Rollback and RestoreFiles
+	rolledBack, restored []string
+}
+
+func newFakeGitPublisher(dir, url string) *fakeGitPublisher {
+	return &fakeGitPublisher{
+		dir:    dir,
+		url:    url,
+		errs:   make(map[string]error),
+		staged: make(pendingFiles),
+		remote: make(pendingFiles),
+	}
+}
+
+// call records the method and returns its injected error
+func (g *fakeGitPublisher) call(method string) error {
+	g.calls = append(g.calls, method)
+	return g.errs[method]
+}
+
+// called returns the recorded calls
+func (g *fakeGitPublisher) called() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.calls)
+}
+
+// restorePaths writes the remote versions of files to the working directory
+func (g *fakeGitPublisher) restorePaths(files []string) error {
+	saved := make(pendingFiles, len(files))
+	for _, file := range files {
+		saved[file] = g.remote[file]
+		delete(g.staged, file)
+	}
+	return saved.restore(g.dir)
+}
+
+func (g *fakeGitPublisher) WorkDir() (string, error) {
+	return g.dir, nil
+}
+
+func (g *fakeGitPublisher) RemoteInfo(remote string) (remoteInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("RemoteInfo"); err != nil {
+		return remoteInfo{}, err
+	}
+	return remoteInfo{Name: remote, URL: g.url}, nil
+}
+
+func (g *fakeGitPublisher) CheckRemote(remote, branch string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("CheckRemote")
+}
+
+func (g *fakeGitPublisher) FastForward(remote, branch string, files []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("FastForward")
+}
+
+func (g *fakeGitPublisher) RemoteFile(remote, branch, file string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("RemoteFile"); err != nil {
+		return nil, err
+	}
+	return g.remote[file], nil
+}
+
+func (g *fakeGitPublisher) EnsureBranch(branch string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("EnsureBranch")
+}
+
+func (g *fakeGitPublisher) AddFiles(files []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("AddFiles"); err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(g.dir, file))
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", file, err)
+		}
+		g.staged[file] = data
+	}
+	return nil
+}
+
+func (g *fakeGitPublisher) RemoveFiles(files []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("RemoveFiles"); err != nil {
+		return err
+	}
+	for _, file := range files {
+		g.staged[file] = nil
+	}
+	return nil
+}
+
+func (g *fakeGitPublisher) HasStagedChanges() (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("HasStagedChanges"); err != nil {
+		return false, err
+	}
+	return len(g.staged) > 0, nil
+}
+
+func (g *fakeGitPublisher) Commit(message string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("Commit"); err != nil {
+		return err
+	}
+	g.commits = append(g.commits, g.staged)
+	g.staged = make(pendingFiles)
+	return nil
+}
+
+func (g *fakeGitPublisher) HeadCommit() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("HeadCommit"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("commit-%d", g.pushed+len(g.commits)), nil
+}
+
+func (g *fakeGitPublisher) LastCommit() (*commitInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return nil, g.call("LastCommit")
+}
+
+func (g *fakeGitPublisher) AmendCommit(message string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("AmendCommit")
+}
+
+func (g *fakeGitPublisher) ResetSoft(commit string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("ResetSoft")
+}
+
+func (g *fakeGitPublisher) Push(remote, branch string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("Push"); err != nil {
+		return err
+	}
+	for _, commit := range g.commits {
+		for file, data := range commit {
+			if data == nil {
+				delete(g.remote, file)
+			} else {
+				g.remote[file] = data
+			}
+		}
+	}
+	g.pushed += len(g.commits)
+	g.commits = nil
+	return nil
+}
+
+func (g *fakeGitPublisher) ForcePush(remote, branch, expected string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("ForcePush")
+}
+
+func (g *fakeGitPublisher) Sync(remote, branch string, resolve map[string][]byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.call("Sync")
+}
+
+func (g *fakeGitPublisher) Rollback(remote, branch string, files []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("Rollback"); err != nil {
+		return err
+	}
+	g.rolledBack = append(g.rolledBack, files...)
+	g.commits = nil
+	g.staged = make(pendingFiles)
+	return g.restorePaths(files)
+}
+
+func (g *fakeGitPublisher) RestoreFiles(remote, branch string, files []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.call("RestoreFiles"); err != nil {
+		return err
+	}
+	g.restored = append(g.restored, files...)
+	return g.restorePaths(files)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// githubEntry is a file change staged for the next commit
+type githubEntry struct {
+	content string
+	remove  bool
+}
+
+// githubAPIPublisher implements GitPublisher with the GitHub REST API, so no
+// local repository, git binary or SSH key is needed. Documents are still
+// written to workDir so they can be diffed, and each batch is committed as a
+// single tree through the git data API.
+type githubAPIPublisher struct {
+	apiURL  string
+	owner   string
+	repo    string
+	token   string
+	workDir string
+	client  *http.Client
+
+	identity commitIdentity // Recorded on commits; empty means the token's user
+
+	branch  string                 // Branch passed to EnsureBranch
+	staged  map[string]githubEntry // Changes for the next commit, kept until pushed
+	message string                 // Message of the pending commit
+	commit  string                 // Created but not yet pushed commit
+	parent  string                 // Branch tip the pending commit builds on; empty for a new branch
+	head    string                 // Last commit pushed by this publisher
+}
+
+func newGitHubAPIPublisher(config Config) (*githubAPIPublisher, error) {
+	owner, repo, ok := strings.Cut(config.GitHubRepo, "/")
+	if !ok || owner == "" || repo == "" {
+		return nil, fmt.Errorf("invalid GITHUB_REPO '%s' (expected owner/repo)", config.GitHubRepo)
+	}
+	if config.GitHubToken == "" {
+		return nil, fmt.Errorf("GITHUB_TOKEN is required for the github backend")
+	}
+	return &githubAPIPublisher{
+		apiURL:   strings.TrimSuffix(config.GitHubAPIURL, "/"),
+		owner:    owner,
+		repo:     repo,
+		token:    config.GitHubToken,
+		workDir:  config.GitHubWorkDir,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		staged:   make(map[string]githubEntry),
+		identity: config.GitIdentity,
+	}, nil
+}
+
+// githubAPIError is a non-2xx response from the GitHub API
+type githubAPIError struct {
+	Status  int
+	Message string
+}
+
+func (e *githubAPIError) Error() string {
+	return fmt.Sprintf("GitHub API returned HTTP %d: %s", e.Status, e.Message)
+}
+
+// call sends a request to the repository's API and decodes the JSON response into out
+func (g *githubAPIPublisher) call(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	endpoint := fmt.Sprintf("%s/repos/%s/%s%s", g.apiURL, g.owner, g.repo, path)
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &githubAPIError{Status: resp.StatusCode, Message: apiErr.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// branchTip returns the commit the branch points at, or "" if it doesn't exist
+func (g *githubAPIPublisher) branchTip(branch string) (string, error) {
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	err := g.call(http.MethodGet, "/git/ref/heads/"+branch, nil, &ref)
+	var apiErr *githubAPIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get branch %s: %w", branch, err)
+	}
+	return ref.Object.SHA, nil
+}
+
+func (g *githubAPIPublisher) WorkDir() (string, error) {
+	if err := os.MkdirAll(g.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	return g.workDir, nil
+}
+
+// RemoteInfo returns the repository's HTTPS URL regardless of the remote
+// name, so host/repo validation works the same as with a local clone
+func (g *githubAPIPublisher) RemoteInfo(remote string) (remoteInfo, error) {
+	return remoteInfo{Name: remote, URL: fmt.Sprintf("https://github.com/%s/%s.git", g.owner, g.repo)}, nil
+}
+
+// CheckRemote checks that the repository is reachable with the token
+func (g *githubAPIPublisher) CheckRemote(remote, branch string) error {
+	return g.call(http.MethodGet, "", nil, nil)
+}
+
+// FastForward is a no-op: every commit is built on the branch's current tip
+func (g *githubAPIPublisher) FastForward(remote, branch string, files []string) error {
+	return nil
+}
+
+func (g *githubAPIPublisher) EnsureBranch(branch string) error {
+	// A missing branch is created by the first push
+	if _, err := g.branchTip(branch); err != nil {
+		return err
+	}
+	g.branch = branch
+	return nil
+}
+
+func (g *githubAPIPublisher) AddFiles(files []string) error {
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(g.workDir, file))
+		if err != nil {
+			return fmt.Errorf("failed to add files: %w", err)
+		}
+		g.staged[filepath.ToSlash(file)] = githubEntry{content: string(data)}
+	}
+	return nil
+}
+
+func (g *githubAPIPublisher) RemoveFiles(files []string) error {
+	for _, file := range files {
+		g.staged[filepath.ToSlash(file)] = githubEntry{remove: true}
+	}
+	return nil
+}
+
+func (g *githubAPIPublisher) HasStagedChanges() (bool, error) {
+	return len(g.staged) > 0, nil
+}
+
+func (g *githubAPIPublisher) Commit(message string) error {
+	g.message = message
+	return g.createCommit()
+}
+
+// createCommit builds a tree from the staged changes on top of the current
+// branch tip and creates a commit for it. Nothing is created when the tree
+// is unchanged.
+func (g *githubAPIPublisher) createCommit() error {
+	g.commit = ""
+	g.head = ""
+	tip, err := g.branchTip(g.branch)
+	if err != nil {
+		return err
+	}
+	g.parent = tip
+
+	treeRequest := map[string]interface{}{}
+	var baseTree string
+	if tip != "" {
+		var parent struct {
+			Tree struct {
+				SHA string `json:"sha"`
+			} `json:"tree"`
+		}
+		if err := g.call(http.MethodGet, "/git/commits/"+tip, nil, &parent); err != nil {
+			return fmt.Errorf("git commit failed: %w", err)
+		}
+		baseTree = parent.Tree.SHA
+		treeRequest["base_tree"] = baseTree
+	}
+
+	var entries []map[string]interface{}
+	for path, entry := range g.staged {
+		treeEntry := map[string]interface{}{"path": path, "mode": "100644", "type": "blob"}
+		if entry.remove {
+			if tip == "" {
+				continue
+			}
+			treeEntry["sha"] = nil // A null sha deletes the path
+		} else {
+			treeEntry["content"] = entry.content
+		}
+		entries = append(entries, treeEntry)
+	}
+	treeRequest["tree"] = entries
+
+	var tree struct {
+		SHA string `json:"sha"`
+	}
+	if err := g.call(http.MethodPost, "/git/trees", treeRequest, &tree); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+	if tree.SHA == baseTree {
+		g.staged = make(map[string]githubEntry)
+		return nil
+	}
+
+	parents := []string{}
+	if tip != "" {
+		parents = append(parents, tip)
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	commitRequest := map[string]interface{}{"message": g.message, "tree": tree.SHA, "parents": parents}
+	if g.identity.isSet() {
+		commitRequest["author"] = map[string]string{"name": g.identity.AuthorName, "email": g.identity.AuthorEmail}
+		commitRequest["committer"] = map[string]string{"name": g.identity.CommitterName, "email": g.identity.CommitterEmail}
+	}
+	if err := g.call(http.MethodPost, "/git/commits", commitRequest, &commit); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+	g.commit = commit.SHA
+	return nil
+}
+
+func (g *githubAPIPublisher) HeadCommit() (string, error) {
+	if g.head != "" {
+		return g.head, nil
+	}
+	return g.branchTip(g.branch)
+}
+
+// errAmendUnsupported is returned by the github backend's amend operations:
+// the refs API can force a branch update but not under a lease
+var errAmendUnsupported = errors.New("amending commits is not supported by the github backend")
+
+// LastCommit returns nil, so batches are never squashed into the previous commit
+func (g *githubAPIPublisher) LastCommit() (*commitInfo, error) {
+	return nil, nil
+}
+
+func (g *githubAPIPublisher) AmendCommit(message string) error {
+	return errAmendUnsupported
+}
+
+func (g *githubAPIPublisher) ResetSoft(commit string) error {
+	return errAmendUnsupported
+}
+
+func (g *githubAPIPublisher) ForcePush(remote, branch, expected string) error {
+	return errAmendUnsupported
+}
+
+// Push moves the branch to the pending commit without forcing; GitHub
+// refuses the update with 422 when the branch has moved on
+func (g *githubAPIPublisher) Push(remote, branch string) error {
+	if g.commit == "" {
+		return nil
+	}
+
+	var err error
+	if g.parent == "" {
+		err = g.call(http.MethodPost, "/git/refs", map[string]interface{}{"ref": "refs/heads/" + branch, "sha": g.commit}, nil)
+	} else {
+		err = g.call(http.MethodPatch, "/git/refs/heads/"+branch, map[string]interface{}{"sha": g.commit, "force": false}, nil)
+	}
+	var apiErr *githubAPIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnprocessableEntity {
+		return fmt.Errorf("failed to push to %s: %w (%v)", branch, errPushRejected, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push to %s: %w", branch, err)
+	}
+
+	g.head = g.commit
+	g.commit = ""
+	g.staged = make(map[string]githubEntry)
+	return nil
+}
+
+// Rollback discards the staged changes and any unpushed commit, which never
+// reached the branch, and restores the local files from the branch
+func (g *githubAPIPublisher) Rollback(remote, branch string, files []string) error {
+	g.commit = ""
+	g.staged = make(map[string]githubEntry)
+	return g.restoreLocal(branch, files)
+}
+
+// RestoreFiles drops the files from the staged changes and restores the
+// local files from the branch
+func (g *githubAPIPublisher) RestoreFiles(remote, branch string, files []string) error {
+	for _, file := range files {
+		delete(g.staged, filepath.ToSlash(file))
+	}
+	return g.restoreLocal(branch, files)
+}
+
+// restoreLocal writes the files' versions on the branch to the work
+// directory, deleting the ones it doesn't have
+func (g *githubAPIPublisher) restoreLocal(branch string, files []string) error {
+	for _, file := range files {
+		localPath := filepath.Join(g.workDir, file)
+		contents, err := g.fileContents(branch, filepath.ToSlash(file))
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+		if contents == nil {
+			// The file was never published
+			if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", file, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+		if err := os.WriteFile(localPath, contents, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// RemoteFile reads the file from the branch through the API, which is always current
+func (g *githubAPIPublisher) RemoteFile(remote, branch, file string) ([]byte, error) {
+	return g.fileContents(branch, filepath.ToSlash(file))
+}
+
+// fileContents returns a file's contents on the branch, or nil if it doesn't exist
+func (g *githubAPIPublisher) fileContents(branch, path string) ([]byte, error) {
+	var file struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	err := g.call(http.MethodGet, fmt.Sprintf("/contents/%s?ref=%s", path, url.QueryEscape(branch)), nil, &file)
+	var apiErr *githubAPIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.Encoding != "base64" {
+		return nil, fmt.Errorf("unexpected content encoding '%s'", file.Encoding)
+	}
+	// GitHub wraps the base64 content across lines
+	return base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+}
+
+// Sync rebuilds the pending commit on top of the branch's new tip. Only the
+// batch's files are staged, and they win over whatever the remote changed,
+// so there is nothing to resolve.
+func (g *githubAPIPublisher) Sync(remote, branch string, resolve map[string][]byte) error {
+	if err := g.createCommit(); err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", branch, err)
+	}
+	return nil
+}
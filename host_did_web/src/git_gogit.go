@@ -0,0 +1,887 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// goGitPublisher implements GitPublisher with go-git, so no git binary is needed
+type goGitPublisher struct {
+	sshKeyPath     string
+	sshKeyPassword string
+	pushUsername   string
+	pushToken      string
+	signer         git.Signer // Signs commits; nil when signing is off
+	identity       commitIdentity
+	timeout        time.Duration // Limit for each fetch or ls-remote
+	pushTimeout    time.Duration // Limit for each push
+	dir            string        // Repository checkout to publish from; empty means the current directory
+}
+
+// fetch fetches the remote branch into its remote-tracking ref, giving up
+// once GIT_TIMEOUT elapses
+func (g *goGitPublisher) fetch(repo *git.Repository, remote, branch string, auth transport.AuthMethod) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	refSpec := gitconfig.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch))
+	err := repo.FetchContext(ctx, &git.FetchOptions{RemoteName: remote, RefSpecs: []gitconfig.RefSpec{refSpec}, Auth: auth})
+	return timeoutError(ctx, err, g.timeout, "fetch "+remote)
+}
+
+// workDir returns the checkout directory
+func (g *goGitPublisher) workDir() string {
+	if g.dir == "" {
+		return "."
+	}
+	return g.dir
+}
+
+// open opens the repository containing the checkout directory
+func (g *goGitPublisher) open() (*git.Repository, *git.Worktree, error) {
+	repo, err := git.PlainOpenWithOptions(g.workDir(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open worktree: %w", err)
+	}
+	return repo, wt, nil
+}
+
+// repoPath converts a path relative to the checkout directory into one
+// relative to the worktree root, which is what go-git expects
+func (g *goGitPublisher) repoPath(wt *git.Worktree, file string) (string, error) {
+	abs, err := filepath.Abs(filepath.Join(g.workDir(), file))
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(wt.Filesystem.Root())
+	if err != nil {
+		return "", err
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(dir, filepath.Base(abs))
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// WorkDir returns the checkout directory; go-git cannot add linked
+// worktrees, so this backend always publishes from the checkout itself
+func (g *goGitPublisher) WorkDir() (string, error) {
+	return g.workDir(), nil
+}
+
+func (g *goGitPublisher) RemoteInfo(remote string) (remoteInfo, error) {
+	url, err := g.remoteURL(remote)
+	if err != nil {
+		return remoteInfo{}, err
+	}
+	return remoteInfo{Name: remote, URL: url}, nil
+}
+
+// remoteURL returns the first URL configured for the named remote
+func (g *goGitPublisher) remoteURL(remote string) (string, error) {
+	repo, _, err := g.open()
+	if err != nil {
+		return "", err
+	}
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote URL: %w", err)
+	}
+	urls := r.Config().URLs
+	if len(urls) == 0 {
+		return "", fmt.Errorf("failed to get remote URL: remote '%s' has no URL", remote)
+	}
+	return urls[0], nil
+}
+
+func (g *goGitPublisher) CheckRemote(remote, branch string) error {
+	repo, _, err := g.open()
+	if err != nil {
+		return err
+	}
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return fmt.Errorf("failed to get remote: %w", err)
+	}
+	remoteURL, err := g.remoteURL(remote)
+	if err != nil {
+		return err
+	}
+	auth, err := g.auth(remoteURL)
+	if err != nil {
+		return err
+	}
+	// An empty repository lists no references but is still reachable
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	if _, err := r.ListContext(ctx, &git.ListOptions{Auth: auth}); err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return fmt.Errorf("ls-remote %s failed: %w", remote, timeoutError(ctx, err, g.timeout, "ls-remote "+remote))
+	}
+	return nil
+}
+
+func (g *goGitPublisher) FastForward(remote, branch string, files []string) error {
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	remoteURL, err := g.remoteURL(remote)
+	if err != nil {
+		return err
+	}
+	auth, err := g.auth(remoteURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s/%s: %w", remote, branch, err)
+	}
+
+	err = g.fetch(repo, remote, branch, auth)
+	if errors.Is(err, git.NoMatchingRefSpecError{}) {
+		// Nothing published yet; the first push creates the branch
+		return nil
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch %s/%s: %w", remote, branch, err)
+	}
+
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s/%s: %w", remote, branch, err)
+	}
+	upstream := remoteRef.Hash()
+	branchRef := plumbing.NewBranchReferenceName(branch)
+
+	var local plumbing.Hash
+	if ref, err := repo.Reference(branchRef, true); err == nil {
+		local = ref.Hash()
+	}
+	if local == upstream {
+		return nil
+	}
+	if !local.IsZero() {
+		localCommit, err := repo.CommitObject(local)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", branch, err)
+		}
+		upstreamCommit, err := repo.CommitObject(upstream)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s/%s: %w", remote, branch, err)
+		}
+		if ahead, err := upstreamCommit.IsAncestor(localCommit); err == nil && ahead {
+			// Only ahead; the push publishes the local commits
+			return nil
+		}
+		if behind, err := localCommit.IsAncestor(upstreamCommit); err != nil || !behind {
+			return divergedError(remote, branch, local.String(), upstream.String())
+		}
+	}
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
+	if head.Type() != plumbing.SymbolicReference || head.Target() != branchRef {
+		// Not checked out: move the ref and let EnsureBranch check it out
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, upstream)); err != nil {
+			return fmt.Errorf("failed to update %s to %s: %w", branch, upstream, err)
+		}
+		return nil
+	}
+
+	// Set the batch's files aside so they can't block the fast-forward, then
+	// put them back on top of the new tip
+	saved, err := savePendingFiles(g.workDir(), files)
+	if err != nil {
+		return err
+	}
+	var headTree *object.Tree
+	if !local.IsZero() {
+		if commit, err := repo.CommitObject(local); err == nil {
+			headTree, _ = commit.Tree()
+		}
+	}
+	for _, file := range files {
+		if err := g.setAsideFile(wt, headTree, file); err != nil {
+			return err
+		}
+	}
+
+	// An unborn branch has no HEAD to merge with, so reset it outright
+	mode := git.MergeReset
+	if local.IsZero() {
+		mode = git.HardReset
+	}
+	err = wt.Reset(&git.ResetOptions{Commit: upstream, Mode: mode})
+	if restoreErr := saved.restore(g.workDir()); restoreErr != nil {
+		return restoreErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fast-forward %s to %s: %w", branch, upstream, err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) RemoteFile(remote, branch, file string) ([]byte, error) {
+	repo, wt, err := g.open()
+	if err != nil {
+		return nil, err
+	}
+	ref, err := repo.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s/%s: %w", remote, branch, err)
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s/%s: %w", remote, branch, err)
+	}
+	path, err := g.repoPath(wt, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", file, err)
+	}
+	entry, err := commit.File(path)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	contents, err := entry.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return []byte(contents), nil
+}
+
+// setAsideFile restores a file to its version in headTree, or removes it
+// when headTree is nil or doesn't contain it
+func (g *goGitPublisher) setAsideFile(wt *git.Worktree, headTree *object.Tree, file string) error {
+	path, err := g.repoPath(wt, file)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", file, err)
+	}
+	if headTree != nil {
+		if entry, err := headTree.File(path); err == nil {
+			contents, err := entry.Contents()
+			if err != nil {
+				return fmt.Errorf("failed to set aside %s: %w", file, err)
+			}
+			return os.WriteFile(filepath.Join(g.workDir(), file), []byte(contents), 0644)
+		}
+	}
+	if err := os.Remove(filepath.Join(g.workDir(), file)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to set aside %s: %w", file, err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) EnsureBranch(branch string) error {
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	branchRef := plumbing.NewBranchReferenceName(branch)
+
+	// Read HEAD without resolving it so unborn branches are handled too
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
+	if head.Type() == plumbing.SymbolicReference && head.Target() == branchRef {
+		return nil
+	}
+
+	_, err = repo.Reference(branchRef, true)
+	exists := err == nil
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+	}
+
+	// On a repository with no commits there is nothing to check out;
+	// point HEAD at the new branch so the first commit lands there
+	if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) && !exists {
+		if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", branch, err)
+		}
+		return nil
+	}
+
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: branchRef, Create: !exists, Keep: true}); err != nil {
+		if exists {
+			return fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+		}
+		return fmt.Errorf("failed to create branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) AddFiles(files []string) error {
+	_, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path, err := g.repoPath(wt, file)
+		if err != nil {
+			return fmt.Errorf("failed to add files: %w", err)
+		}
+		if _, err := wt.Add(path); err != nil {
+			return fmt.Errorf("failed to add files: %w", err)
+		}
+	}
+	return nil
+}
+
+func (g *goGitPublisher) RemoveFiles(files []string) error {
+	_, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path, err := g.repoPath(wt, file)
+		if err != nil {
+			return fmt.Errorf("failed to remove files: %w", err)
+		}
+		if _, err := wt.Remove(path); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return fmt.Errorf("failed to remove files: %w", err)
+		}
+	}
+	return nil
+}
+
+func (g *goGitPublisher) HasStagedChanges() (bool, error) {
+	_, wt, err := g.open()
+	if err != nil {
+		return false, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return false, fmt.Errorf("failed to check staged changes: %w", err)
+	}
+	for _, fileStatus := range status {
+		if fileStatus.Staging != git.Unmodified && fileStatus.Staging != git.Untracked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (g *goGitPublisher) Commit(message string) error {
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+
+	options := &git.CommitOptions{Signer: g.signer}
+	if g.identity.isSet() {
+		now := time.Now()
+		options.Author = &object.Signature{Name: g.identity.AuthorName, Email: g.identity.AuthorEmail, When: now}
+		options.Committer = &object.Signature{Name: g.identity.CommitterName, Email: g.identity.CommitterEmail, When: now}
+	} else {
+		// Otherwise author and committer are read from the git config, like the CLI does
+		cfg, err := repo.ConfigScoped(gitconfig.SystemScope)
+		if err != nil {
+			return fmt.Errorf("git commit failed: %w", err)
+		}
+		if (cfg.Author.Email == "" && cfg.User.Email == "") || (cfg.Author.Name == "" && cfg.User.Name == "") {
+			return fmt.Errorf("git commit failed: %w", errNoCommitIdentity)
+		}
+	}
+	if _, err := wt.Commit(message, options); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) HeadCommit() (string, error) {
+	repo, _, err := g.open()
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return head.Hash().String(), nil
+}
+
+func (g *goGitPublisher) LastCommit() (*commitInfo, error) {
+	repo, _, err := g.open()
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	return &commitInfo{
+		SHA:         commit.Hash.String(),
+		Parents:     commit.NumParents(),
+		AuthorName:  commit.Author.Name,
+		AuthorEmail: commit.Author.Email,
+		AuthorTime:  commit.Author.When,
+		Message:     strings.TrimSpace(commit.Message),
+	}, nil
+}
+
+// AmendCommit needs GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL for the committer,
+// since the author is copied from the amended commit
+func (g *goGitPublisher) AmendCommit(message string) error {
+	if !g.identity.isSet() {
+		return fmt.Errorf("git commit --amend failed: %w", errNoCommitIdentity)
+	}
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("git commit --amend failed: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("git commit --amend failed: %w", err)
+	}
+
+	author := commit.Author
+	options := &git.CommitOptions{
+		Signer:    g.signer,
+		Amend:     true,
+		Author:    &author,
+		Committer: &object.Signature{Name: g.identity.CommitterName, Email: g.identity.CommitterEmail, When: time.Now()},
+	}
+	if _, err := wt.Commit(message, options); err != nil {
+		return fmt.Errorf("git commit --amend failed: %w", err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) ResetSoft(commit string) error {
+	_, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	if err := wt.Reset(&git.ResetOptions{Commit: plumbing.NewHash(commit), Mode: git.SoftReset}); err != nil {
+		return fmt.Errorf("failed to reset to %s: %w", commit, err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) Push(remote, branch string) error {
+	return g.push(remote, branch, nil)
+}
+
+func (g *goGitPublisher) ForcePush(remote, branch, expected string) error {
+	return g.push(remote, branch, &git.ForceWithLease{
+		RefName: plumbing.NewBranchReferenceName(branch),
+		Hash:    plumbing.NewHash(expected),
+	})
+}
+
+// push pushes the branch, overwriting the remote branch under lease when
+// one is given, and tracks it like push -u
+func (g *goGitPublisher) push(remote, branch string, lease *git.ForceWithLease) error {
+	repo, _, err := g.open()
+	if err != nil {
+		return err
+	}
+	remoteURL, err := g.remoteURL(remote)
+	if err != nil {
+		return fmt.Errorf("failed to push to %s: %w", branch, err)
+	}
+	auth, err := g.auth(remoteURL)
+	if err != nil {
+		return fmt.Errorf("failed to push to %s: %w", branch, err)
+	}
+
+	refSpec := gitconfig.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", branch, branch))
+	ctx, cancel := context.WithTimeout(context.Background(), g.pushTimeout)
+	defer cancel()
+	err = repo.PushContext(ctx, &git.PushOptions{
+		RemoteName:     remote,
+		RefSpecs:       []gitconfig.RefSpec{refSpec},
+		Auth:           auth,
+		ForceWithLease: lease,
+	})
+	err = timeoutError(ctx, err, g.pushTimeout, "push "+remote)
+	if err != nil && (errors.Is(err, git.ErrNonFastForwardUpdate) || isPushRejection(err.Error())) {
+		return fmt.Errorf("failed to push to %s: %w (%v)", branch, errPushRejected, err)
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to push to %s: %w", branch, err)
+	}
+
+	// Equivalent of push -u: track the remote branch
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("failed to set upstream for %s: %w", branch, err)
+	}
+	cfg.Branches[branch] = &gitconfig.Branch{
+		Name:   branch,
+		Remote: remote,
+		Merge:  plumbing.NewBranchReferenceName(branch),
+	}
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to set upstream for %s: %w", branch, err)
+	}
+	return nil
+}
+
+func (g *goGitPublisher) Rollback(remote, branch string, files []string) error {
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+
+	// Best effort: the failure being rolled back may be a network error
+	if remoteURL, err := g.remoteURL(remote); err == nil {
+		if auth, err := g.auth(remoteURL); err == nil {
+			g.fetch(repo, remote, branch, auth)
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		path, err := g.repoPath(wt, file)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+		paths = append(paths, path)
+	}
+
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if err != nil {
+		// Nothing published yet, so only unstage the files
+		return g.unstage(repo, wt, paths)
+	}
+
+	// Resetting another branch to the remote tip would drop its own commits
+	if head, err := repo.Head(); err != nil || head.Name() != plumbing.NewBranchReferenceName(branch) {
+		if err := g.unstage(repo, wt, paths); err != nil {
+			return err
+		}
+		return g.restorePaths(repo, wt, remoteRef.Hash(), paths)
+	}
+
+	if err := wt.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.MixedReset}); err != nil {
+		return fmt.Errorf("failed to reset to %s/%s: %w", remote, branch, err)
+	}
+	return g.restorePaths(repo, wt, remoteRef.Hash(), paths)
+}
+
+func (g *goGitPublisher) RestoreFiles(remote, branch string, files []string) error {
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		path, err := g.repoPath(wt, file)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+		paths = append(paths, path)
+	}
+
+	// Unstaged against HEAD, so another branch checked out never gets the
+	// remote branch's versions staged
+	if err := g.unstage(repo, wt, paths); err != nil {
+		return err
+	}
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if err != nil {
+		return nil
+	}
+	return g.restorePaths(repo, wt, remoteRef.Hash(), paths)
+}
+
+// restorePaths writes the versions of paths in commit to the working tree,
+// deleting the ones it doesn't have. The index is left alone.
+func (g *goGitPublisher) restorePaths(repo *git.Repository, wt *git.Worktree, hash plumbing.Hash, paths []string) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	root := wt.Filesystem.Root()
+	for _, path := range paths {
+		fullPath := filepath.Join(root, filepath.FromSlash(path))
+		file, err := tree.File(path)
+		if errors.Is(err, object.ErrFileNotFound) {
+			// The file was never published
+			if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		contents, err := file.Contents()
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// unstage resets the index entries for paths to HEAD, or drops them when
+// the branch has no commits yet
+func (g *goGitPublisher) unstage(repo *git.Repository, wt *git.Worktree, paths []string) error {
+	if _, err := repo.Head(); err == nil {
+		if err := wt.Reset(&git.ResetOptions{Mode: git.MixedReset, Files: paths}); err != nil {
+			return fmt.Errorf("failed to unstage files: %w", err)
+		}
+		return nil
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("failed to unstage files: %w", err)
+	}
+	for _, path := range paths {
+		idx.Remove(path)
+	}
+	if err := repo.Storer.SetIndex(idx); err != nil {
+		return fmt.Errorf("failed to unstage files: %w", err)
+	}
+	return nil
+}
+
+// Sync emulates "git pull --rebase": go-git has no rebase, so the files
+// changed by local commits are captured, the branch is reset to the remote
+// tip, and the captured versions are re-applied as a single commit. A file
+// the remote changed too is a conflict: files in resolve take their contents
+// there, and any other file aborts before anything is reset.
+func (g *goGitPublisher) Sync(remote, branch string, resolve map[string][]byte) error {
+	repo, wt, err := g.open()
+	if err != nil {
+		return err
+	}
+	remoteURL, err := g.remoteURL(remote)
+	if err != nil {
+		return err
+	}
+	auth, err := g.auth(remoteURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s/%s: %w", remote, branch, err)
+	}
+
+	err = g.fetch(repo, remote, branch, auth)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch %s/%s: %w", remote, branch, err)
+	}
+
+	upstream := fmt.Sprintf("%s/%s", remote, branch)
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", upstream, err)
+	}
+	headRef, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	headCommit, err := repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	remoteCommit, err := repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	if isAncestor, err := remoteCommit.IsAncestor(headCommit); err == nil && isAncestor {
+		return nil
+	}
+
+	// Collect the files changed by local commits since the common ancestor
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	var baseTree *object.Tree
+	if bases, err := headCommit.MergeBase(remoteCommit); err == nil && len(bases) > 0 {
+		if baseTree, err = bases[0].Tree(); err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+	}
+	changes, err := object.DiffTree(baseTree, headTree)
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+
+	type localChange struct {
+		path     string
+		contents []byte
+		deleted  bool
+	}
+	var local []localChange
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+		if action == merkletrie.Delete {
+			local = append(local, localChange{path: change.From.Name, deleted: true})
+			continue
+		}
+		file, err := headTree.File(change.To.Name)
+		if err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+		contents, err := file.Contents()
+		if err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+		local = append(local, localChange{path: change.To.Name, contents: []byte(contents)})
+	}
+
+	// Files the remote changed differently since the common ancestor conflict
+	remoteTree, err := remoteCommit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	remoteChanges, err := object.DiffTree(baseTree, remoteTree)
+	if err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	changedRemotely := make(map[string]bool)
+	for _, change := range remoteChanges {
+		changedRemotely[change.From.Name] = true
+		changedRemotely[change.To.Name] = true
+	}
+	var outside []string
+	for i, change := range local {
+		if !changedRemotely[change.path] {
+			continue
+		}
+		if contents, ok := resolve[filepath.FromSlash(change.path)]; ok {
+			local[i] = localChange{path: change.path, contents: contents, deleted: contents == nil}
+			continue
+		}
+		theirs, err := remoteTree.File(change.path)
+		switch {
+		case errors.Is(err, object.ErrFileNotFound):
+			if change.deleted {
+				continue
+			}
+		case err != nil:
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		case !change.deleted:
+			if contents, err := theirs.Contents(); err == nil && contents == string(change.contents) {
+				continue
+			}
+		}
+		outside = append(outside, change.path)
+	}
+	if len(outside) > 0 {
+		return fmt.Errorf("failed to rebase onto %s: %w in files outside the batch: %s", upstream, errRebaseConflict, strings.Join(outside, ", "))
+	}
+
+	// Move onto the remote tip and re-apply the local versions
+	if err := wt.Reset(&git.ResetOptions{Commit: remoteCommit.Hash, Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	root := wt.Filesystem.Root()
+	for _, change := range local {
+		fullPath := filepath.Join(root, filepath.FromSlash(change.path))
+		if change.deleted {
+			if _, err := wt.Remove(change.path); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+		if err := os.WriteFile(fullPath, change.contents, 0644); err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+		if _, err := wt.Add(change.path); err != nil {
+			return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+		}
+	}
+
+	// Like a rebase, keep the author and record the configured committer
+	author := headCommit.Author
+	options := &git.CommitOptions{Author: &author, AllowEmptyCommits: true, Signer: g.signer}
+	if g.identity.isSet() {
+		options.Committer = &object.Signature{Name: g.identity.CommitterName, Email: g.identity.CommitterEmail, When: time.Now()}
+	}
+	if _, err := wt.Commit(headCommit.Message, options); err != nil {
+		return fmt.Errorf("failed to rebase onto %s: %w", upstream, err)
+	}
+	return nil
+}
+
+// auth selects push credentials based on the remote URL's transport
+func (g *goGitPublisher) auth(remoteURL string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		if _, err := os.Stat(g.sshKeyPath); err == nil {
+			return gitssh.NewPublicKeysFromFile(user, g.sshKeyPath, g.sshKeyPassword)
+		}
+		// Fall back to a running ssh-agent
+		return gitssh.NewSSHAgentAuth(user)
+	case "http", "https":
+		if g.pushToken == "" {
+			return nil, nil
+		}
+		username := g.pushUsername
+		if username == "" {
+			username = defaultPushUsername
+		}
+		return &githttp.BasicAuth{Username: username, Password: g.pushToken}, nil
+	default:
+		// Local and file remotes need no credentials
+		return nil, nil
+	}
+}
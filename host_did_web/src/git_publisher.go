@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GitPublisher abstracts the repository operations needed to publish DID documents
+type GitPublisher interface {
+	// WorkDir returns the directory published files are written to and git
+	// runs in, preparing it on first use
+	WorkDir() (string, error)
+	// RemoteInfo describes the named remote, including its fetch URL
+	RemoteInfo(remote string) (remoteInfo, error)
+	// CheckRemote verifies the remote is reachable with the configured
+	// credentials, like a lightweight ls-remote
+	CheckRemote(remote, branch string) error
+	// FastForward fetches the remote branch and fast-forwards the local branch
+	// to it, creating the local branch when it only exists on the remote. The
+	// given files, written for the pending batch, keep their contents. A
+	// local branch that has diverged is reported as errBranchDiverged.
+	FastForward(remote, branch string, files []string) error
+	// RemoteFile returns a file's contents on the remote branch as of the
+	// last fetch, or nil if the branch or file doesn't exist
+	RemoteFile(remote, branch, file string) ([]byte, error)
+	// EnsureBranch checks out the branch, creating it if it doesn't exist
+	EnsureBranch(branch string) error
+	// AddFiles stages the given files
+	AddFiles(files []string) error
+	// RemoveFiles stages the removal of the given files, ignoring untracked paths
+	RemoveFiles(files []string) error
+	// HasStagedChanges reports whether the index differs from HEAD
+	HasStagedChanges() (bool, error)
+	// Commit records the staged changes with the given message
+	Commit(message string) error
+	// HeadCommit returns the SHA of the commit checked out at HEAD
+	HeadCommit() (string, error)
+	// LastCommit describes the commit checked out at HEAD, or returns nil
+	// when the branch has no commits yet
+	LastCommit() (*commitInfo, error)
+	// AmendCommit replaces the commit at HEAD with one recording the staged
+	// changes as well, keeping its parent, author and author date
+	AmendCommit(message string) error
+	// ResetSoft moves the branch back to commit, keeping the index and the
+	// working tree as they are
+	ResetSoft(commit string) error
+	// Push pushes the branch to the remote and sets it as upstream.
+	// A non-fast-forward rejection is reported as errPushRejected.
+	Push(remote, branch string) error
+	// ForcePush overwrites the remote branch with the local one, but only
+	// while the remote still points at expected (push --force-with-lease).
+	// A remote that has moved on is reported as errPushRejected.
+	ForcePush(remote, branch, expected string) error
+	// Sync fetches the remote branch and rebases local commits onto it. A
+	// conflicting file in resolve, keyed by path relative to WorkDir, is
+	// given its contents there, or deleted when they are nil. A conflict on
+	// any other file aborts the rebase with errRebaseConflict.
+	Sync(remote, branch string, resolve map[string][]byte) error
+	// Rollback discards unpushed commits and staged changes after a failed
+	// batch, moving the branch back to the remote tip and restoring the
+	// given files to their published versions. When another branch is
+	// checked out, HEAD is left alone and only the files are restored, as by
+	// RestoreFiles.
+	Rollback(remote, branch string, files []string) error
+	// RestoreFiles unstages the given files and restores them to their
+	// versions on the remote branch as of the last fetch, deleting ones it
+	// doesn't have. HEAD and other staged files are left alone. When the
+	// branch isn't on the remote yet, the files are only unstaged.
+	RestoreFiles(remote, branch string, files []string) error
+}
+
+// remoteInfo describes a remote configured for the publishing repository
+type remoteInfo struct {
+	Name string
+	URL  string // Fetch URL, checked against the DID's host and repository
+}
+
+// errPushRejected is returned when the remote refuses a non-fast-forward push
+var errPushRejected = errors.New("push rejected by remote")
+
+// errBranchDiverged is returned when the local branch has commits the remote
+// branch doesn't and vice versa, so it can neither be fast-forwarded nor pushed
+var errBranchDiverged = errors.New("local branch has diverged from the remote")
+
+// errRebaseConflict is returned when rebasing onto the remote branch
+// conflicts on files the batch doesn't own, so there's no right version to keep
+var errRebaseConflict = errors.New("rebase conflict")
+
+// errGitTimeout is returned when a git command or go-git transfer runs past
+// GIT_TIMEOUT (GIT_PUSH_TIMEOUT for pushes) and is killed
+var errGitTimeout = errors.New("git operation timed out")
+
+// errCodeGitTimeout is the machine-readable code for errGitTimeout
+const errCodeGitTimeout = "git_timeout"
+
+// timeoutError replaces err with errGitTimeout when ctx's deadline has passed,
+// since the error from a killed command says little about why it stopped
+func timeoutError(ctx context.Context, err error, timeout time.Duration, op string) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %s", errGitTimeout, timeout, op)
+	}
+	return err
+}
+
+// errNoCommitIdentity is returned when a commit has no author to record:
+// GIT_AUTHOR_NAME/GIT_AUTHOR_EMAIL are unset and git has no user configured
+var errNoCommitIdentity = errors.New("no commit identity configured: set GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL, or git config user.name and user.email")
+
+// commitIdentity is the author and committer recorded on commits. When it is
+// empty the backend falls back to the git config.
+type commitIdentity struct {
+	AuthorName     string
+	AuthorEmail    string
+	CommitterName  string
+	CommitterEmail string
+}
+
+func (i commitIdentity) isSet() bool {
+	return i.AuthorEmail != ""
+}
+
+// commitInfo describes a commit considered for squashing
+type commitInfo struct {
+	SHA         string
+	Parents     int
+	AuthorName  string
+	AuthorEmail string
+	AuthorTime  time.Time // Kept when the commit is amended
+	Message     string
+}
+
+// loadCommitIdentity reads GIT_AUTHOR_NAME/GIT_AUTHOR_EMAIL and the committer
+// equivalents. Each name and email must be set together, and the committer
+// defaults to the author (and the author to the committer).
+func loadCommitIdentity() (commitIdentity, error) {
+	identity := commitIdentity{
+		AuthorName:     os.Getenv("GIT_AUTHOR_NAME"),
+		AuthorEmail:    os.Getenv("GIT_AUTHOR_EMAIL"),
+		CommitterName:  os.Getenv("GIT_COMMITTER_NAME"),
+		CommitterEmail: os.Getenv("GIT_COMMITTER_EMAIL"),
+	}
+	if (identity.AuthorName == "") != (identity.AuthorEmail == "") {
+		return commitIdentity{}, fmt.Errorf("GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL must be set together")
+	}
+	if (identity.CommitterName == "") != (identity.CommitterEmail == "") {
+		return commitIdentity{}, fmt.Errorf("GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL must be set together")
+	}
+	if identity.CommitterEmail == "" {
+		identity.CommitterName, identity.CommitterEmail = identity.AuthorName, identity.AuthorEmail
+	}
+	if identity.AuthorEmail == "" {
+		identity.AuthorName, identity.AuthorEmail = identity.CommitterName, identity.CommitterEmail
+	}
+	return identity, nil
+}
+
+// divergedError names both tips of a diverged branch
+func divergedError(remote, branch, local, upstream string) error {
+	return fmt.Errorf("%w: %s is at %s but %s/%s is at %s", errBranchDiverged, branch, local, remote, branch, upstream)
+}
+
+// pendingFiles holds the working tree contents of a batch's files while the
+// branch is moved underneath them; nil means the file doesn't exist
+type pendingFiles map[string][]byte
+
+// savePendingFiles reads the files, relative to dir, into memory
+func savePendingFiles(dir string, files []string) (pendingFiles, error) {
+	saved := make(pendingFiles, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		saved[file] = data
+	}
+	return saved, nil
+}
+
+// restore writes the saved contents back, removing files that didn't exist
+func (saved pendingFiles) restore(dir string) error {
+	for file, data := range saved {
+		path := filepath.Join(dir, file)
+		if data == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to restore %s: %w", file, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// isPushRejection reports whether git push output describes a non-fast-forward rejection
+func isPushRejection(output string) bool {
+	return strings.Contains(output, "[rejected]") ||
+		strings.Contains(output, "non-fast-forward") ||
+		strings.Contains(output, "fetch first")
+}
+
+// newGitPublisher returns the GitPublisher for the configured backend,
+// publishing branch from the repository checkout at dir ("" for the current
+// directory)
+func newGitPublisher(config Config, dir, branch string) (GitPublisher, error) {
+	switch config.GitBackend {
+	case "", "cli":
+		if err := checkCLISigningKey(config.GitSigning, config.GitSigningKey); err != nil {
+			return nil, err
+		}
+		return &cliGitPublisher{
+			branch:       branch,
+			useWorktree:  config.GitWorktree,
+			worktreePath: config.GitWorktreePath,
+			signing:      config.GitSigning,
+			signingKey:   config.GitSigningKey,
+			identity:     config.GitIdentity,
+			timeout:      config.GitTimeout,
+			pushTimeout:  config.GitPushTimeout,
+			dir:          dir,
+			root:         dir,
+			credentials:  newGitCredentials(config),
+		}, nil
+	case "gogit":
+		signer, err := loadGoGitSigner(config.GitSigning, config.GitSigningKey, config.GitSigningKeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		return &goGitPublisher{
+			sshKeyPath:     config.GitSSHKeyPath,
+			sshKeyPassword: config.GitSSHKeyPassword,
+			pushUsername:   config.GitPushUsername,
+			pushToken:      config.GitPushToken,
+			signer:         signer,
+			identity:       config.GitIdentity,
+			timeout:        config.GitTimeout,
+			pushTimeout:    config.GitPushTimeout,
+			dir:            dir,
+		}, nil
+	case "github":
+		if config.GitSigning != signingOff {
+			return nil, fmt.Errorf("commit signing is not supported by the github backend")
+		}
+		if dir != "" {
+			return nil, fmt.Errorf("REPO_MAP is not supported by the github backend")
+		}
+		return newGitHubAPIPublisher(config)
+	default:
+		return nil, fmt.Errorf("unknown git backend '%s' (expected cli, gogit or github)", config.GitBackend)
+	}
+}
+
+// defaultSSHKeyPath returns the SSH private key mounted into the container
+func defaultSSHKeyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "id_rsa")
+}
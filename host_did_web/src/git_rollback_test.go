@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runGit runs git in dir and returns its trimmed output
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// writeFile writes a file relative to dir
+func writeFile(t *testing.T, dir, file, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newRollbackRepo returns a checkout of main, one commit ahead of its
+// remote, whose gh-pages branch publishes did.json
+func newRollbackRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	base := t.TempDir()
+	remote := filepath.Join(base, "remote.git")
+	dir := filepath.Join(base, "work")
+	runGit(t, base, "init", "-q", "--bare", remote)
+	runGit(t, base, "init", "-q", "-b", "main", dir)
+	runGit(t, dir, "remote", "add", "origin", remote)
+
+	writeFile(t, dir, "README.md", "main")
+	runGit(t, dir, "add", "README.md")
+	runGit(t, dir, "commit", "-q", "-m", "main")
+	runGit(t, dir, "push", "-q", "origin", "main")
+
+	runGit(t, dir, "checkout", "-q", "-b", "gh-pages")
+	writeFile(t, dir, "did.json", "published")
+	runGit(t, dir, "add", "did.json")
+	runGit(t, dir, "commit", "-q", "-m", "publish")
+	runGit(t, dir, "push", "-q", "origin", "gh-pages")
+
+	runGit(t, dir, "checkout", "-q", "main")
+	writeFile(t, dir, "notes.md", "unpushed")
+	runGit(t, dir, "add", "notes.md")
+	runGit(t, dir, "commit", "-q", "-m", "unpushed work on main")
+	return dir
+}
+
+// testPublishers returns the backends that run git against a local checkout
+func testPublishers(dir string) map[string]GitPublisher {
+	return map[string]GitPublisher{
+		"cli":   &cliGitPublisher{dir: dir, root: dir, branch: "gh-pages", timeout: time.Minute, pushTimeout: time.Minute},
+		"gogit": &goGitPublisher{dir: dir, timeout: time.Minute, pushTimeout: time.Minute},
+	}
+}
+
+func TestRollbackLeavesOtherBranch(t *testing.T) {
+	for _, name := range []string{"cli", "gogit"} {
+		t.Run(name, func(t *testing.T) {
+			dir := newRollbackRepo(t)
+			git := testPublishers(dir)[name]
+			head := runGit(t, dir, "rev-parse", "HEAD")
+			writeFile(t, dir, "did.json", "pending")
+			writeFile(t, dir, "new.json", "pending")
+			runGit(t, dir, "add", "new.json")
+
+			if err := git.Rollback("origin", "gh-pages", []string{"did.json", "new.json"}); err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+
+			// main keeps its unpushed commit; only the batch's files are restored
+			if got := runGit(t, dir, "rev-parse", "HEAD"); got != head {
+				t.Errorf("HEAD moved from %s to %s", head, got)
+			}
+			if got := runGit(t, dir, "symbolic-ref", "--short", "HEAD"); got != "main" {
+				t.Errorf("checked out %s, want main", got)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "did.json")); string(data) != "published" {
+				t.Errorf("did.json = %q, want published", data)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "new.json")); !os.IsNotExist(err) {
+				t.Errorf("new.json left behind: %v", err)
+			}
+			if staged := runGit(t, dir, "diff", "--cached", "--name-only"); staged != "" {
+				t.Errorf("staged = %q, want nothing", staged)
+			}
+		})
+	}
+}
+
+func TestRollbackOnPublishingBranch(t *testing.T) {
+	for _, name := range []string{"cli", "gogit"} {
+		t.Run(name, func(t *testing.T) {
+			dir := newRollbackRepo(t)
+			git := testPublishers(dir)[name]
+			runGit(t, dir, "checkout", "-q", "gh-pages")
+			writeFile(t, dir, "did.json", "pending")
+			writeFile(t, dir, "new.json", "pending")
+			runGit(t, dir, "add", "did.json", "new.json")
+			runGit(t, dir, "commit", "-q", "-m", "failed batch")
+
+			if err := git.Rollback("origin", "gh-pages", []string{"did.json", "new.json"}); err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+
+			// The unpushed batch commit is discarded
+			if got, want := runGit(t, dir, "rev-parse", "HEAD"), runGit(t, dir, "rev-parse", "origin/gh-pages"); got != want {
+				t.Errorf("HEAD = %s, want origin/gh-pages at %s", got, want)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "did.json")); string(data) != "published" {
+				t.Errorf("did.json = %q, want published", data)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "new.json")); !os.IsNotExist(err) {
+				t.Errorf("new.json left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestRestoreFilesKeepsOtherStagedFiles(t *testing.T) {
+	for _, name := range []string{"cli", "gogit"} {
+		t.Run(name, func(t *testing.T) {
+			dir := newRollbackRepo(t)
+			git := testPublishers(dir)[name]
+			runGit(t, dir, "checkout", "-q", "gh-pages")
+			runGit(t, dir, "fetch", "-q", "origin")
+			head := runGit(t, dir, "rev-parse", "HEAD")
+			writeFile(t, dir, "did.json", "rejected")
+			writeFile(t, dir, "new.json", "rejected")
+			writeFile(t, dir, "ok.json", "accepted")
+			runGit(t, dir, "add", "did.json", "new.json", "ok.json")
+
+			if err := git.RestoreFiles("origin", "gh-pages", []string{"did.json", "new.json"}); err != nil {
+				t.Fatalf("RestoreFiles() error = %v", err)
+			}
+
+			if got := runGit(t, dir, "rev-parse", "HEAD"); got != head {
+				t.Errorf("HEAD moved from %s to %s", head, got)
+			}
+			if staged := runGit(t, dir, "diff", "--cached", "--name-only"); staged != "ok.json" {
+				t.Errorf("staged = %q, want only ok.json", staged)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "did.json")); string(data) != "published" {
+				t.Errorf("did.json = %q, want published", data)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "new.json")); !os.IsNotExist(err) {
+				t.Errorf("new.json left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestBatchRejectionIsAttributedToItsItem(t *testing.T) {
+	dir := newRollbackRepo(t)
+	runGit(t, dir, "checkout", "-q", "gh-pages")
+	git := testPublishers(dir)["cli"].(*cliGitPublisher)
+	git.identity = commitIdentity{AuthorName: "Test", AuthorEmail: "test@example.com", CommitterName: "Test", CommitterEmail: "test@example.com"}
+	p, repo, _ := newBatchTestProcessor(t)
+	repo.Path, repo.git = dir, git
+
+	// The local remote matches no Pages host, so only the item that needs
+	// its owner checked is rejected
+	accepted := batchItem(repo, "accepted")
+	accepted.HostVerification = HostVerification{Method: hostAssumed}
+	accepted = writeBatchItem(t, repo, accepted)
+	rejected := batchItem(repo, "rejected")
+	rejected.TargetFile = "did.json"
+	rejected = writeBatchItem(t, repo, rejected)
+
+	commit, itemErrs, err := p.performBatchedGitOperations(repo, []BatchItem{rejected, accepted})
+	if err != nil {
+		t.Fatalf("performBatchedGitOperations() error = %v", err)
+	}
+	if !errors.Is(itemErrs[0], errBatchItemRejected) {
+		t.Errorf("rejected item error = %v, want errBatchItemRejected", itemErrs[0])
+	}
+	if itemErrs[1] != nil {
+		t.Errorf("accepted item error = %v", itemErrs[1])
+	}
+	if got := runGit(t, dir, "rev-parse", "origin/gh-pages"); got != commit {
+		t.Errorf("origin/gh-pages = %s, want the batch commit %s", got, commit)
+	}
+	if got := runGit(t, dir, "show", "origin/gh-pages:"+accepted.TargetFile); got != string(accepted.Document) {
+		t.Errorf("pushed %s = %q, want %q", accepted.TargetFile, got, accepted.Document)
+	}
+	if got := runGit(t, dir, "show", "origin/gh-pages:did.json"); got != "published" {
+		t.Errorf("pushed did.json = %q, want published", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "did.json")); string(data) != "published" {
+		t.Errorf("did.json = %q, want published", data)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testUpstream serves DID documents by URL path as SERVER_URL, answering 404
+// for anything it doesn't hold
+type testUpstream struct {
+	mu        sync.Mutex
+	documents map[string]string
+	fetches   int
+}
+
+func (u *testUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fetches++
+	document, ok := u.documents[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(document))
+}
+
+// serve makes the upstream serve document for did
+func (u *testUpstream) serve(t *testing.T, did, document string) {
+	t.Helper()
+	parsed, err := parseDID(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.documents["/"+parsed.urlPath()+"/did.json"] = document
+}
+
+// fetched returns how many requests the upstream has answered
+func (u *testUpstream) fetched() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.fetches
+}
+
+// newHandlerTestProcessor returns a sandboxed processor fetching from a test
+// upstream and running its batch processor against the fake publisher
+func newHandlerTestProcessor(t *testing.T) (*DIDProcessor, *fakeGitPublisher, *testUpstream) {
+	t.Helper()
+	p, repo, _ := newSandboxProcessor(t)
+	git := repo.git.(*fakeGitPublisher)
+	upstream := &testUpstream{documents: make(map[string]string)}
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	p.config.ServerURL = server.URL
+	p.fetchClient = server.Client()
+	p.config.BatchTimeout = 10 * time.Millisecond
+
+	p.batchWG.Add(1)
+	go p.gitBatchProcessor()
+	t.Cleanup(func() {
+		close(p.batchCh)
+		p.batchWG.Wait()
+	})
+	return p, git, upstream
+}
+
+// didDocument returns a DID Core document for did with one Ed25519 key
+func didDocument(did string) string {
+	return `{
+  "@context": ["https://www.w3.org/ns/did/v1"],
+  "id": "` + did + `",
+  "verificationMethod": [{
+    "id": "` + did + `#key-1",
+    "type": "Ed25519VerificationKey2020",
+    "controller": "` + did + `",
+    "publicKeyMultibase": "z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+  }],
+  "authentication": ["` + did + `#key-1"]
+}`
+}
+
+// serveJSON sends a JSON request to handler and decodes the response into v
+func serveJSON(t *testing.T, handler http.HandlerFunc, method, path string, body any, v any) int {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, path, strings.NewReader(string(data))))
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s %s response %q: %v", method, path, rec.Body, err)
+	}
+	return rec.Code
+}
+
+func TestProcessDIDs(t *testing.T) {
+	const (
+		a       = "did:web:alice.github.io:site:a"
+		b       = "did:web:alice.github.io:site:b"
+		missing = "did:web:alice.github.io:site:missing"
+		other   = "did:web:bob.github.io:site:c"
+	)
+	tests := []struct {
+		name      string
+		dids      []string
+		status    int
+		succeeded []bool // Per DID, in request order
+	}{
+		{"all published", []string{a, b}, http.StatusOK, []bool{true, true}},
+		{"upstream 404", []string{a, missing, b}, http.StatusMultiStatus, []bool{true, false, true}},
+		{"host not allowed", []string{other, a}, http.StatusMultiStatus, []bool{false, true}},
+		{"empty DID", []string{a, ""}, http.StatusMultiStatus, []bool{true, false}},
+		{"bad syntax", []string{"did:key:z6Mk", a}, http.StatusMultiStatus, []bool{false, true}},
+		{"nothing published", []string{missing, other}, http.StatusInternalServerError, []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, git, upstream := newHandlerTestProcessor(t)
+			upstream.serve(t, a, didDocument(a))
+			upstream.serve(t, b, didDocument(b))
+
+			var response DIDsResponse
+			status := serveJSON(t, p.handleProcessDIDs, http.MethodPost, "/process-dids", DIDsRequest{DIDs: tt.dids}, &response)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if len(response.Results) != len(tt.dids) {
+				t.Fatalf("%d results, want one per DID: %+v", len(response.Results), response.Results)
+			}
+			// A failed DID doesn't stop the others from being published
+			succeeded := 0
+			for i, result := range response.Results {
+				if result.DID != tt.dids[i] {
+					t.Errorf("result %d is for %q, want %q", i, result.DID, tt.dids[i])
+				}
+				if result.Success != tt.succeeded[i] {
+					t.Errorf("%q success = %t, want %t (error %q)", tt.dids[i], result.Success, tt.succeeded[i], result.Error)
+				}
+				if result.Success {
+					succeeded++
+					if result.Published == nil || result.Published.Commit == nil {
+						t.Errorf("%q published = %+v, want its commit", tt.dids[i], result.Published)
+					}
+				} else if result.Error == "" {
+					t.Errorf("%q failed without an error", tt.dids[i])
+				}
+			}
+			if response.Succeeded != succeeded || response.Failed != len(tt.dids)-succeeded {
+				t.Errorf("succeeded %d, failed %d, want %d and %d", response.Succeeded, response.Failed, succeeded, len(tt.dids)-succeeded)
+			}
+			if got := len(git.remote); got != succeeded {
+				t.Errorf("%d documents pushed, want %d", got, succeeded)
+			}
+		})
+	}
+}
+
+func TestProcessDIDsLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxDID int
+		count  int
+		status int
+	}{
+		{"at the limit", 3, 3, http.StatusOK},
+		{"over the limit", 3, 4, http.StatusRequestEntityTooLarge},
+		{"unlimited", 0, 4, http.StatusOK},
+		{"none", 3, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, git, upstream := newHandlerTestProcessor(t)
+			p.config.MaxDIDs = tt.maxDID
+			var dids []string
+			for i := range tt.count {
+				did := "did:web:alice.github.io:site:doc" + string(rune('a'+i))
+				upstream.serve(t, did, didDocument(did))
+				dids = append(dids, did)
+			}
+
+			var response DIDsResponse
+			status := serveJSON(t, p.handleProcessDIDs, http.MethodPost, "/process-dids", DIDsRequest{DIDs: dids}, &response)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d (%s)", status, tt.status, response.Message)
+			}
+			// A rejected request is refused before anything is fetched
+			if tt.status != http.StatusOK {
+				if n := upstream.fetched(); n != 0 {
+					t.Errorf("%d fetches, want none", n)
+				}
+				if calls := git.called(); len(calls) != 0 {
+					t.Errorf("git calls = %v, want none", calls)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveDID(t *testing.T) {
+	const did = "did:web:alice.github.io:site:a"
+	tests := []struct {
+		name      string
+		did       string
+		published bool
+		dryRun    bool
+		status    int
+		existed   bool
+		removed   bool
+	}{
+		{"published", did, true, false, http.StatusOK, true, true},
+		{"published in a dry run", did, true, true, http.StatusOK, true, false},
+		{"missing file", did, false, false, http.StatusNotFound, false, false},
+		{"missing file in a dry run", did, false, true, http.StatusNotFound, false, false},
+		{"bad syntax", "did:web:", true, false, http.StatusBadRequest, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, git, _ := newHandlerTestProcessor(t)
+			p.config.DryRun = tt.dryRun
+			targetFile := filepath.Join("a", "did.json")
+			if tt.published {
+				git.remote[targetFile] = []byte(didDocument(did))
+				path := filepath.Join(git.dir, targetFile)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, git.remote[targetFile], 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var response RemoveDIDResponse
+			status := serveJSON(t, p.handleProcessDID, http.MethodDelete, "/process-did", DIDRequest{DID: tt.did}, &response)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d (%s)", status, tt.status, response.Error)
+			}
+			if response.Existed != tt.existed {
+				t.Errorf("existed = %t, want %t", response.Existed, tt.existed)
+			}
+			if response.Success != (tt.status == http.StatusOK) {
+				t.Errorf("success = %t with status %d", response.Success, status)
+			}
+			_, onDisk := os.Stat(filepath.Join(git.dir, targetFile))
+			if removed := tt.published && os.IsNotExist(onDisk); removed != tt.removed {
+				t.Errorf("removed from the working tree = %t, want %t", removed, tt.removed)
+			}
+			if _, pushed := git.remote[targetFile]; tt.published && pushed == tt.removed {
+				t.Errorf("still on the remote = %t, want %t", pushed, !tt.removed)
+			}
+		})
+	}
+}
+
+func TestStrictValidation(t *testing.T) {
+	const did = "did:web:alice.github.io:site:a"
+	// No controller on the key, and authentication references a key that
+	// isn't there
+	document := strings.NewReplacer(
+		`"controller": "`+did+`",`, "",
+		`"authentication": ["`+did+`#key-1"]`, `"authentication": ["`+did+`#key-2"], "service": []`,
+	).Replace(didDocument(did))
+	tests := []struct {
+		name   string
+		strict bool
+		status int
+		pushed int
+	}{
+		{"strict", true, http.StatusUnprocessableEntity, 0},
+		{"warnings only", false, http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, git, upstream := newHandlerTestProcessor(t)
+			p.config.StrictValidation = tt.strict
+			upstream.serve(t, did, document)
+
+			var response DIDResponse
+			status := serveJSON(t, p.handleProcessDID, http.MethodPost, "/process-did", DIDRequest{DID: did}, &response)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d (%s)", status, tt.status, response.Error)
+			}
+			// Every problem is listed, whether or not it failed the request
+			if len(response.ValidationErrors) != 2 {
+				t.Errorf("validation errors = %q, want the missing controller and unknown key", response.ValidationErrors)
+			}
+			if len(response.MethodErrors) != 1 || response.MethodErrors[0].Path != "verificationMethod[0]" || response.MethodErrors[0].ID != did+"#key-1" {
+				t.Errorf("method errors = %+v, want verificationMethod[0] only", response.MethodErrors)
+			}
+			if got := len(git.remote); got != tt.pushed {
+				t.Errorf("%d documents pushed, want %d", got, tt.pushed)
+			}
+			// Fields the validator doesn't know are published untouched
+			for _, published := range git.remote {
+				if !strings.Contains(string(published), `"service":[]`) {
+					t.Errorf("published document lost its service field:\n%s", published)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessDIDRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"not did:web", `{"did":"did:key:z6Mk"}`, http.StatusBadRequest, errCodeDIDNotWeb},
+		{"too long", `{"did":"did:web:alice.github.io:site:` + strings.Repeat("a", maxDIDLength) + `"}`, http.StatusBadRequest, errCodeDIDTooLong},
+		{"too many segments", `{"did":"did:web:alice.github.io` + strings.Repeat(":a", maxDIDSegments) + `"}`, http.StatusBadRequest, errCodeDIDTooManySegments},
+		{"missing host", `{"did":"did:web:"}`, http.StatusBadRequest, errCodeDIDEmptySegment},
+		{"empty segment", `{"did":"did:web:alice.github.io::a"}`, http.StatusBadRequest, errCodeDIDEmptySegment},
+		{"invalid character", `{"did":"did:web:alice.github.io:site:a b"}`, http.StatusBadRequest, errCodeDIDInvalidChar},
+		{"invalid host", `{"did":"did:web:-alice.github.io:site"}`, http.StatusBadRequest, errCodeDIDInvalidHost},
+		{"dot segment", `{"did":"did:web:alice.github.io:site:."}`, http.StatusBadRequest, errCodeDIDInvalidPath},
+		{"dot-dot segment", `{"did":"did:web:alice.github.io:site:.."}`, http.StatusBadRequest, errCodeDIDInvalidPath},
+		{"invalid JSON", `{"did":`, http.StatusBadRequest, errCodeInvalidJSON},
+		{"body too large", `{"did":"did:web:alice.github.io:site:a","pad":"` + strings.Repeat("a", 8192) + `"}`, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, git, upstream := newHandlerTestProcessor(t)
+			p.config.MaxBodyBytes = 4096
+
+			rec := httptest.NewRecorder()
+			p.limitBody(p.handleProcessDID)(rec, httptest.NewRequest(http.MethodPost, "/process-did", strings.NewReader(tt.body)))
+			var response DIDResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("response %q: %v", rec.Body, err)
+			}
+			if rec.Code != tt.status || response.Code != tt.code {
+				t.Errorf("got %d %q, want %d %q (%s)", rec.Code, response.Code, tt.status, tt.code, response.Error)
+			}
+			// Rejected before anything is fetched or written
+			if n := upstream.fetched(); n != 0 {
+				t.Errorf("%d fetches, want none", n)
+			}
+			if calls := git.called(); len(calls) != 0 {
+				t.Errorf("git calls = %v, want none", calls)
+			}
+		})
+	}
+}
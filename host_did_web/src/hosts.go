@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Host verification methods reported in responses
+const (
+	hostVerifiedGitHubIO  = "github.io"  // User derived from a *.github.io host
+	hostVerifiedGitLabIO  = "gitlab.io"  // User derived from a *.gitlab.io host
+	hostVerifiedMapped    = "mapped"     // User/repo taken from HOST_REPO_MAP
+	hostVerifiedCNAMEFile = "cname-file" // Repository CNAME file declares the host
+	hostVerifiedDNS       = "dns-cname"  // Host's DNS CNAME points at a Pages site
+	hostVerifiedPagesHost = "pages-host" // User derived from the Pages host of a GIT_REMOTE_PATTERNS entry
+	hostAssumed           = "assumed"    // No verification was possible
+)
+
+// pagesProvider is a git host whose Pages sites are served from a host
+// named after the repository owner, such as user.github.io
+type pagesProvider struct {
+	Name      string
+	Label     string           // Name used in messages
+	PagesHost string           // Pages host template; {user} stands for the repository owner
+	Method    string           // Host verification method for hosts matching PagesHost
+	patterns  []*regexp.Regexp // Remote URLs, with user and repo named groups
+}
+
+// defaultPagesProviders lists the built-in Pages hosts, tried after any
+// configured in GIT_REMOTE_PATTERNS
+var defaultPagesProviders = []pagesProvider{
+	{
+		Name: "github", Label: "GitHub", PagesHost: "{user}.github.io", Method: hostVerifiedGitHubIO,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^git@github\.com:(?P<user>[^/]+)/(?P<repo>[^/]+)$`),
+			regexp.MustCompile(`^https://github\.com/(?P<user>[^/]+)/(?P<repo>[^/]+)$`),
+		},
+	},
+	{
+		Name: "gitlab", Label: "GitLab", PagesHost: "{user}.gitlab.io", Method: hostVerifiedGitLabIO,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^git@gitlab\.com:(?P<user>[^/]+)/(?P<repo>[^/]+)$`),
+			regexp.MustCompile(`^https://gitlab\.com/(?P<user>[^/]+)/(?P<repo>[^/]+)$`),
+		},
+	},
+}
+
+// parseRemotePatterns parses GIT_REMOTE_PATTERNS: whitespace-separated
+// entries of the form name|regex|pages-host. The regex must have user and
+// repo named groups, and the Pages host template must contain {user} once.
+// Entries sharing a name and Pages host add patterns to one provider.
+func parseRemotePatterns(value string) ([]pagesProvider, error) {
+	var providers []pagesProvider
+	for _, entry := range strings.Fields(value) {
+		name, rest, ok := strings.Cut(entry, "|")
+		sep := strings.LastIndex(rest, "|")
+		if !ok || sep < 0 || name == "" {
+			return nil, fmt.Errorf("invalid remote pattern '%s' (expected name|regex|pages-host)", entry)
+		}
+		expr, pagesHost := rest[:sep], strings.ToLower(rest[sep+1:])
+		for _, builtin := range defaultPagesProviders {
+			if name == builtin.Name {
+				return nil, fmt.Errorf("invalid remote pattern '%s': name %s is used by a built-in pattern", entry, name)
+			}
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote pattern '%s': %w", entry, err)
+		}
+		if pattern.SubexpIndex("user") < 0 || pattern.SubexpIndex("repo") < 0 {
+			return nil, fmt.Errorf("invalid remote pattern '%s': regex needs (?P<user>...) and (?P<repo>...) groups", entry)
+		}
+		if strings.Count(pagesHost, "{user}") != 1 {
+			return nil, fmt.Errorf("invalid remote pattern '%s': pages host must contain {user} once", entry)
+		}
+
+		i := slices.IndexFunc(providers, func(provider pagesProvider) bool { return provider.Name == name })
+		if i < 0 {
+			providers = append(providers, pagesProvider{Name: name, Label: name, PagesHost: pagesHost, Method: hostVerifiedPagesHost})
+			i = len(providers) - 1
+		} else if providers[i].PagesHost != pagesHost {
+			return nil, fmt.Errorf("invalid remote pattern '%s': %s already serves Pages from %s", entry, name, providers[i].PagesHost)
+		}
+		providers[i].patterns = append(providers[i].patterns, pattern)
+	}
+	return append(providers, defaultPagesProviders...), nil
+}
+
+// pagesUser returns the provider and user of a Pages host, such as
+// user.github.io
+func (p *DIDProcessor) pagesUser(host string) (*pagesProvider, string, bool) {
+	for i := range p.config.PagesProviders {
+		provider := &p.config.PagesProviders[i]
+		prefix, suffix, _ := strings.Cut(provider.PagesHost, "{user}")
+		rest, ok := strings.CutPrefix(host, prefix)
+		if !ok {
+			continue
+		}
+		if user, ok := strings.CutSuffix(rest, suffix); ok && user != "" {
+			return provider, user, true
+		}
+	}
+	return nil, "", false
+}
+
+// parseRemoteURL returns the provider, owner and repository of a remote URL,
+// trying each provider's patterns in turn. Credentials in an HTTPS URL, such
+// as those added to a GIT_REPO_URL clone, are ignored.
+func (p *DIDProcessor) parseRemoteURL(remoteURL string) (*pagesProvider, string, string, error) {
+	match := remoteURL
+	if u, err := url.Parse(remoteURL); err == nil && u.User != nil && u.Host != "" {
+		u.User = nil
+		match = u.String()
+	}
+
+	var tried []string
+	for i := range p.config.PagesProviders {
+		provider := &p.config.PagesProviders[i]
+		for _, pattern := range provider.patterns {
+			if matches := pattern.FindStringSubmatch(match); matches != nil {
+				user := matches[pattern.SubexpIndex("user")]
+				repo := strings.TrimSuffix(matches[pattern.SubexpIndex("repo")], ".git")
+				return provider, user, repo, nil
+			}
+			tried = append(tried, pattern.String())
+		}
+	}
+	return nil, "", "", fmt.Errorf("remote %s matches no remote URL pattern (tried %s)", redactURL(remoteURL), strings.Join(tried, ", "))
+}
+
+// HostVerification records how a DID host was tied to the publishing repository
+type HostVerification struct {
+	Method   string
+	Provider string // Git host the repository must be on; empty means any
+	User     string // Expected repository owner; empty when it cannot be derived
+	Repo     string // Expected repository name; empty means the DID project
+}
+
+// HostRepo is the owner and repository expected to publish a DID host
+type HostRepo struct {
+	User string
+	Repo string // Empty means the repo must match the DID project
+}
+
+// parseHostList splits a comma-separated host list, lowercasing each entry.
+// A port may be written as in the DID, so localhost%3A4443 is localhost:4443.
+func parseHostList(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = decodeHost(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// decodeHost trims, percent-decodes and lowercases a configured host,
+// keeping it as written when it isn't valid percent-encoding
+func decodeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if decoded, err := url.PathUnescape(host); err == nil {
+		host = decoded
+	}
+	return strings.ToLower(host)
+}
+
+// parseHostRepoMap parses entries of the form host=user/repo or host=user
+func parseHostRepoMap(value string) (map[string]HostRepo, error) {
+	mapping := make(map[string]HostRepo)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, target, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(host) == "" || strings.TrimSpace(target) == "" {
+			return nil, fmt.Errorf("invalid host repo mapping '%s' (expected host=user/repo)", entry)
+		}
+		user, repo, _ := strings.Cut(strings.TrimSpace(target), "/")
+		mapping[decodeHost(host)] = HostRepo{User: user, Repo: repo}
+	}
+	return mapping, nil
+}
+
+// matchesHostPattern reports whether host matches an exact host or a
+// wildcard suffix pattern such as *.example.org. Ports must match too: a
+// pattern without one only matches hosts without one, and a :* port, as in
+// localhost:*, matches any port.
+func matchesHostPattern(host, pattern string) bool {
+	host, port, _ := strings.Cut(strings.ToLower(host), ":")
+	pattern, patternPort, _ := strings.Cut(pattern, ":")
+	if patternPort != port && (patternPort != "*" || port == "") {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// validateHost checks the DID host against the configured allowed hosts
+func (p *DIDProcessor) validateHost(parsed *ParsedDID) error {
+	for _, pattern := range p.config.AllowedHosts {
+		if matchesHostPattern(parsed.Host, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host '%s' is not allowed (allowed hosts: %s)",
+		parsed.Host, strings.Join(p.config.AllowedHosts, ", "))
+}
+
+// verifyHost determines which repository is expected to publish the DID's
+// host. Pages hosts, such as user.github.io, derive the user from the host
+// name and HOST_REPO_MAP entries are trusted as configured. Other hosts are
+// verified according to CNAME_VERIFICATION: "file" requires the repository's
+// CNAME file to declare the host, "dns" requires the host's DNS CNAME to point
+// at a Pages host, and "off" accepts the host without verification. A host
+// with a port, such as a local Pages emulator, is never a Pages site or a
+// CNAME, so unless it is mapped it is accepted without verification; only
+// ALLOWED_HOSTS entries naming a port let such hosts through.
+func (p *DIDProcessor) verifyHost(ctx context.Context, repo *publishRepo, parsed *ParsedDID) (HostVerification, error) {
+	logger := loggerFromContext(ctx).With("host", parsed.Host)
+	if expected, ok := p.config.HostRepoMap[parsed.Host]; ok {
+		return HostVerification{Method: hostVerifiedMapped, User: expected.User, Repo: expected.Repo}, nil
+	}
+	if parsed.port() != "" {
+		logger.Info("Host has a port, skipping Pages and CNAME verification; map it in HOST_REPO_MAP to check the remote")
+		return HostVerification{Method: hostAssumed}, nil
+	}
+	if provider, user, ok := p.pagesUser(parsed.Host); ok {
+		return HostVerification{Method: provider.Method, Provider: provider.Name, User: user}, nil
+	}
+
+	switch p.config.CNAMEVerification {
+	case "file":
+		root, err := repo.git.WorkDir()
+		if err != nil {
+			return HostVerification{}, fmt.Errorf("failed to prepare publishing directory: %w", err)
+		}
+		data, err := os.ReadFile(filepath.Join(root, p.config.CNAMEFile))
+		if err != nil {
+			return HostVerification{}, fmt.Errorf("failed to read CNAME file %s: %w", p.config.CNAMEFile, err)
+		}
+		declared := strings.ToLower(strings.TrimSpace(string(data)))
+		if declared != parsed.Host {
+			return HostVerification{}, fmt.Errorf("CNAME file declares %s, not %s", declared, parsed.Host)
+		}
+		logger.Info("✅ Host verified by CNAME file", "cname_file", p.config.CNAMEFile)
+		return HostVerification{Method: hostVerifiedCNAMEFile}, nil
+	case "dns":
+		cname, err := net.LookupCNAME(parsed.Host)
+		if err != nil {
+			return HostVerification{}, fmt.Errorf("failed to look up CNAME for %s: %w", parsed.Host, err)
+		}
+		target := strings.ToLower(strings.TrimSuffix(cname, "."))
+		provider, user, ok := p.pagesUser(target)
+		if !ok {
+			return HostVerification{}, fmt.Errorf("CNAME for %s points to %s, not a Pages site", parsed.Host, target)
+		}
+		logger.Info("✅ Host verified by DNS CNAME", "cname", target)
+		return HostVerification{Method: hostVerifiedDNS, Provider: provider.Name, User: user}, nil
+	default:
+		logger.Warn("⚠️ Host not verified, assuming it is served by this repository")
+		return HostVerification{Method: hostAssumed}, nil
+	}
+}
+
+// validateHostRepo checks that the remote repository matches the one the
+// item's host verification expects
+func (p *DIDProcessor) validateHostRepo(item BatchItem, remoteURL string) error {
+	verification := item.HostVerification
+	logger := loggerForRequest(item.RequestID).With("host", item.ParsedDID.Host, "method", verification.Method)
+	if verification.Method == hostAssumed || verification.Method == hostVerifiedCNAMEFile {
+		// Nothing to compare: either unverified, or the repository's own
+		// CNAME file already ties it to the host
+		logger.Info("Skipping user/repo validation")
+		return nil
+	}
+
+	provider, remoteUser, remoteRepo, err := p.parseRemoteURL(remoteURL)
+	if err != nil {
+		return err
+	}
+
+	// A github.io DID can't be published from a GitLab remote, or vice versa
+	if verification.Provider != "" && provider.Name != verification.Provider {
+		return fmt.Errorf("provider mismatch: %s is served by %s but the remote is on %s",
+			item.ParsedDID.Host, p.providerLabel(verification.Provider), provider.Label)
+	}
+
+	// Validate username matches expected
+	if !strings.EqualFold(remoteUser, verification.User) {
+		return fmt.Errorf("%s username mismatch: expected %s, got %s", provider.Label, verification.User, remoteUser)
+	}
+
+	// Validate repo name matches the mapping, or the project by default.
+	// Bare-domain DIDs are served from the user site repository.
+	expectedRepo := verification.Repo
+	if expectedRepo == "" {
+		expectedRepo = item.ParsedDID.Project
+		if item.ParsedDID.IsWellKnown() {
+			expectedRepo = item.ParsedDID.hostname()
+		}
+	}
+	if !strings.EqualFold(remoteRepo, expectedRepo) {
+		return fmt.Errorf("repo name mismatch: expected %s, got %s", expectedRepo, remoteRepo)
+	}
+
+	logger.Info("✅ Validation passed", "provider", provider.Name, "user", remoteUser, "repo", remoteRepo)
+	return nil
+}
+
+// providerLabel returns the display name of a provider
+func (p *DIDProcessor) providerLabel(name string) string {
+	for _, provider := range p.config.PagesProviders {
+		if provider.Name == name {
+			return provider.Label
+		}
+	}
+	return name
+}
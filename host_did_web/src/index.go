@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DIDIndex is the machine-readable listing of published DIDs kept in
+// INDEX_FILE, keyed by DID
+type DIDIndex struct {
+	DIDs map[string]DIDIndexEntry `json:"dids"`
+}
+
+// DIDIndexEntry describes one published DID document
+type DIDIndexEntry struct {
+	Path        string    `json:"path"` // Relative to the index file
+	URL         string    `json:"url"`
+	Sha256      string    `json:"sha256"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Deactivated bool      `json:"deactivated,omitempty"`
+}
+
+// indexPath returns the index file relative to the publishing root, or ""
+// when the index is disabled
+func (p *DIDProcessor) indexPath() string {
+	if p.config.IndexFile == "" {
+		return ""
+	}
+	return filepath.Join(p.config.OutputBaseDir, p.config.IndexFile)
+}
+
+// updateIndex merges the batch into the index on the remote branch and
+// writes the result to the working tree. Merging with the published index,
+// rather than the local file or a fresh listing, keeps entries written by
+// other instances and older versions. Removals drop their entry.
+func (p *DIDProcessor) updateIndex(repo *publishRepo, root string, batch []BatchItem) error {
+	indexFile := p.indexPath()
+	index := DIDIndex{DIDs: make(map[string]DIDIndexEntry)}
+	data, err := repo.git.RemoteFile(p.config.GitRemote, repo.Branch, indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", indexFile, err)
+	}
+	if data != nil {
+		// A corrupt index is left for an operator rather than replaced by a partial one
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("published %s is not a valid index, refusing to overwrite it: %w", indexFile, err)
+		}
+		if index.DIDs == nil {
+			index.DIDs = make(map[string]DIDIndexEntry)
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, item := range batch {
+		did := item.ParsedDID.expectedID()
+		if item.Remove {
+			delete(index.DIDs, did)
+			continue
+		}
+
+		document, err := os.ReadFile(filepath.Join(root, item.TargetFile))
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", item.TargetFile, err)
+		}
+		sitePath, err := filepath.Rel(p.config.OutputBaseDir, item.TargetFile)
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", item.TargetFile, err)
+		}
+		entry := DIDIndexEntry{
+			Path:        filepath.ToSlash(sitePath),
+			URL:         p.buildPublishedURL(item.ParsedDID),
+			Sha256:      sha256Hex(document),
+			UpdatedAt:   now,
+			Deactivated: item.Deactivate,
+		}
+		// A forced re-publish of the same document doesn't count as an update
+		if existing, ok := index.DIDs[did]; ok && existing.Sha256 == entry.Sha256 && existing.Path == entry.Path {
+			entry.UpdatedAt = existing.UpdatedAt
+		}
+		index.DIDs[did] = entry
+	}
+
+	formatted, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", indexFile, err)
+	}
+	return p.saveDIDDocument(append(formatted, '\n'), filepath.Join(root, indexFile))
+}
+
+// refreshIndex merges the batch into the index again after a rebase onto
+// commits pushed elsewhere, since the rebase resolves a conflicting index in
+// favour of this batch's version, and commits the result if it changed
+func (p *DIDProcessor) refreshIndex(repo *publishRepo, batch []BatchItem) error {
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return err
+	}
+	if err := p.updateIndex(repo, root, batch); err != nil {
+		return err
+	}
+	if err := repo.git.AddFiles([]string{p.indexPath()}); err != nil {
+		return err
+	}
+	staged, err := repo.git.HasStagedChanges()
+	if err != nil || !staged {
+		return err
+	}
+	return repo.git.Commit(fmt.Sprintf("%s: merge %s", p.config.CommitMsg, filepath.ToSlash(p.indexPath())))
+}
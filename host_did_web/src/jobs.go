@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Job states reported by GET /jobs/{id}
+const (
+	jobPending   = "pending"   // Queued, waiting for its batch to flush
+	jobCommitted = "committed" // Pushed to the branch
+	jobVerified  = "verified"  // Pushed and served on the public URL (VERIFY_PUBLISH)
+	jobFailed    = "failed"    // The batch failed
+)
+
+// Job tracks an asynchronously published DID until its batch flushes
+type Job struct {
+	ID         string `json:"id"`
+	DID        string `json:"did"`
+	TargetFile string `json:"targetFile"`
+	Status     string `json:"status"`
+	Commit     string `json:"commit,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	Verification string    `json:"verification,omitempty"` // VERIFY_PUBLISH only: pending, ok or timeout
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// jobStore keeps async jobs in memory and evicts them once they are older than ttl
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	ttl  time.Duration
+}
+
+func newJobStore(ttl time.Duration) *jobStore {
+	return &jobStore{jobs: make(map[string]*Job), ttl: ttl}
+}
+
+// create registers a pending job and returns its ID
+func (s *jobStore) create(did, targetFile string) string {
+	now := time.Now()
+	job := &Job{
+		ID:         newRequestID(),
+		DID:        did,
+		TargetFile: targetFile,
+		Status:     jobPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return job.ID
+}
+
+// get returns a copy of the job so callers never race with updates
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// complete records the outcome of the batch a job was part of
+func (s *jobStore) complete(id, commit string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		job.Status = jobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = jobCommitted
+		job.Commit = commit
+	}
+	job.UpdatedAt = time.Now()
+}
+
+// verify records the state of the public URL check of a committed job.
+// Once the document is served the job moves to the verified state.
+func (s *jobStore) verify(id, verification string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	job.Verification = verification
+	if verification == verifyOK {
+		job.Status = jobVerified
+	}
+	job.UpdatedAt = time.Now()
+}
+
+// evictExpired drops jobs last updated more than ttl ago
+func (s *jobStore) evictExpired() {
+	cutoff := time.Now().Add(-s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.UpdatedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// evictLoop periodically evicts expired jobs
+func (s *jobStore) evictLoop() {
+	ticker := time.NewTicker(max(s.ttl/10, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		s.evictExpired()
+	}
+}
+
+// handleJobStatus reports the state of an async job
+func (p *DIDProcessor) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	job, ok := p.jobs.get(r.PathValue("id"))
+	if !ok {
+		p.sendError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalEntry is a batch item the batch processor hasn't finished with yet
+type JournalEntry struct {
+	DID         string    `json:"did"`
+	TargetFile  string    `json:"targetFile"`
+	Repo        string    `json:"repo,omitempty"`   // REPO_MAP repository; omitted for the working directory
+	Branch      string    `json:"branch,omitempty"` // Empty means BRANCH
+	Remove      bool      `json:"remove,omitempty"`
+	Deactivate  bool      `json:"deactivate,omitempty"`
+	RequestID   string    `json:"requestId,omitempty"`
+	Actor       string    `json:"actor,omitempty"`
+	CallbackURL string    `json:"callbackUrl,omitempty"`
+	EnqueuedAt  time.Time `json:"enqueuedAt"`
+
+	DomainLinkage json.RawMessage `json:"domainLinkage,omitempty"`
+	DeadLetterID  string          `json:"deadLetterId,omitempty"` // Dead-letter entry the item retries
+}
+
+// batchJournal records queued batch items in QUEUE_JOURNAL_FILE until their
+// batch has been handled, so items written to disk but lost to a restart
+// are committed on the next start. Entries are keyed by BatchItem.JournalID.
+type batchJournal struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]JournalEntry
+}
+
+// loadBatchJournal reads the journal, starting empty when it is missing.
+// Unlike the fetch cache, a journal that can't be read is an error: starting
+// empty would silently drop the items it holds.
+func loadBatchJournal(path string) (*batchJournal, error) {
+	j := &batchJournal{path: path, entries: make(map[string]JournalEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue journal: %w", err)
+	}
+	if err := json.Unmarshal(data, &j.entries); err != nil {
+		return nil, fmt.Errorf("queue journal %s is corrupt: %w", path, err)
+	}
+	return j, nil
+}
+
+// add records an item about to be sent to the batch processor
+func (j *batchJournal) add(item BatchItem) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[item.JournalID] = JournalEntry{
+		DID:         item.ParsedDID.Original,
+		TargetFile:  item.TargetFile,
+		Repo:        item.Repo.Path,
+		Branch:      item.Repo.Branch,
+		Remove:      item.Remove,
+		Deactivate:  item.Deactivate,
+		RequestID:   item.RequestID,
+		Actor:       item.Actor,
+		CallbackURL: item.CallbackURL,
+		EnqueuedAt:  item.EnqueuedAt,
+
+		DomainLinkage: item.DomainLinkage,
+		DeadLetterID:  item.DeadLetterID,
+	}
+	return j.save()
+}
+
+// remove forgets the given items, skipping the write when none were recorded
+func (j *batchJournal) remove(items []BatchItem) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	removed := false
+	for _, item := range items {
+		if _, ok := j.entries[item.JournalID]; ok {
+			delete(j.entries, item.JournalID)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	if err := j.save(); err != nil {
+		slog.Warn("Failed to save queue journal", "path", j.path, "error", err)
+	}
+}
+
+// save writes the journal; callers hold j.mu
+func (j *batchJournal) save() error {
+	data, err := json.Marshal(j.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(j.path, data)
+}
+
+// writeFileSynced writes a file through a synced temporary file and a
+// rename, so a crash leaves either the old or the new contents
+func writeFileSynced(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// journalItem records a batch item before it is sent to the batch processor.
+// The item gets a JournalID even when the journal is disabled, so removal
+// can always look it up.
+func (p *DIDProcessor) journalItem(item *BatchItem) error {
+	item.JournalID = newRequestID()
+	if p.journal == nil {
+		return nil
+	}
+	if err := p.journal.add(*item); err != nil {
+		return fmt.Errorf("failed to record batch item in queue journal: %w", err)
+	}
+	return nil
+}
+
+// forgetItems removes handled batch items from the journal, if enabled
+func (p *DIDProcessor) forgetItems(items []BatchItem) {
+	if p.journal != nil {
+		p.journal.remove(items)
+	}
+}
+
+// replayJournal sends the items left in the journal by the previous run to
+// the batch processor, in the order they were first enqueued. It runs before
+// the server starts, so they are claimed before any new request. Items whose
+// DID, repository or file no longer fit are dropped with a warning.
+func (p *DIDProcessor) replayJournal() {
+	p.journal.mu.Lock()
+	ids := make([]string, 0, len(p.journal.entries))
+	entries := make(map[string]JournalEntry, len(p.journal.entries))
+	for id, entry := range p.journal.entries {
+		ids = append(ids, id)
+		entries[id] = entry
+	}
+	p.journal.mu.Unlock()
+	sort.Slice(ids, func(a, b int) bool { return entries[ids[a]].EnqueuedAt.Before(entries[ids[b]].EnqueuedAt) })
+
+	var dropped []BatchItem
+	for _, id := range ids {
+		entry := entries[id]
+		logger := loggerForRequest(entry.RequestID).With("did", entry.DID, "target_file", entry.TargetFile)
+		item, err := p.replayItem(entry)
+		if err != nil {
+			logger.Warn("Dropping queue journal entry", "error", err)
+			dropped = append(dropped, BatchItem{JournalID: id})
+			continue
+		}
+		item.JournalID = id
+		if p.deadLetters != nil && item.DeadLetterID != "" {
+			p.deadLetters.startRetry(item.DeadLetterID)
+		}
+		logger.Info("Replaying queued batch item from journal", "enqueued_at", entry.EnqueuedAt)
+		JournalReplayedTotal.Inc()
+		p.batchCh <- item
+	}
+	p.forgetItems(dropped)
+}
+
+// replayItem rebuilds the batch item for a journal entry
+func (p *DIDProcessor) replayItem(entry JournalEntry) (BatchItem, error) {
+	parsedDID, err := parseDID(entry.DID)
+	if err != nil {
+		return BatchItem{}, err
+	}
+	branch := entry.Branch
+	if branch == "" {
+		branch = p.config.Branch
+	}
+	repo, ok := p.repos[repoID{Path: entry.Repo, Branch: branch}]
+	if !ok {
+		return BatchItem{}, fmt.Errorf("%w: repository %s is no longer configured for branch %s", errRepoNotMapped, entry.Repo, branch)
+	}
+	verification, err := p.verifyHost(context.Background(), repo, parsedDID)
+	if err != nil {
+		return BatchItem{}, fmt.Errorf("host verification failed: %w", err)
+	}
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return BatchItem{}, fmt.Errorf("failed to prepare publishing directory: %w", err)
+	}
+
+	var document []byte
+	if !entry.Remove {
+		document, err = os.ReadFile(filepath.Join(root, entry.TargetFile))
+		if err != nil {
+			return BatchItem{}, fmt.Errorf("failed to read DID document: %w", err)
+		}
+	}
+	if err := repo.claims.claim(root, entry.TargetFile, parsedDID.expectedID(), document); err != nil {
+		return BatchItem{}, err
+	}
+	return BatchItem{
+		TargetFile:       entry.TargetFile,
+		ParsedDID:        parsedDID,
+		Repo:             repo,
+		HostVerification: verification,
+		Remove:           entry.Remove,
+		Deactivate:       entry.Deactivate,
+		RequestID:        entry.RequestID,
+		Actor:            entry.Actor,
+		CallbackURL:      entry.CallbackURL,
+		EnqueuedAt:       entry.EnqueuedAt,
+		Document:         document,
+		DomainLinkage:    entry.DomainLinkage,
+		Claimed:          true,
+		DeadLetterID:     entry.DeadLetterID,
+	}, nil
+}
+
+// defaultJournalPath returns the journal file under the user cache
+// directory, next to the fetch cache and outside the publishing repository
+func defaultJournalPath() string {
+	return filepath.Join(filepath.Dir(defaultFetchCachePath()), "queue-journal.json")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestIDHeader is read from incoming requests and echoed on responses
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// setupLogger installs a structured logger writing to w as the slog and log default
+func setupLogger(w io.Writer, level, format string) error {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL '%s': %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: slogLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT '%s' (expected json or text)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// newRequestID returns a random identifier for correlating log lines
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDFromContext returns the request ID stored in ctx, if any
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// loggerFromContext returns the default logger annotated with the request ID in ctx
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	return loggerForRequest(requestIDFromContext(ctx))
+}
+
+// loggerForRequest returns the default logger annotated with a request ID
+func loggerForRequest(requestID string) *slog.Logger {
+	if requestID == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", requestID)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// withRequestID assigns every request an ID (reusing X-Request-ID when the
+// client sends one), stores it in the request context, and logs the outcome
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		loggerForRequest(requestID).Debug("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr)
+	})
+}
@@ -2,105 +2,887 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Config holds the service configuration
 type Config struct {
-	ServerURL    string
-	Branch       string
-	GitRemote    string
-	CommitMsg    string
-	DryRun       bool
-	Port         string
-	BatchTimeout time.Duration // How long to wait before flushing batch
-	BatchSize    int           // Maximum files per batch
+	ServerURL           string
+	Branch              string
+	GitRemote           string
+	CommitMsg           string
+	DeactivateCommitMsg string // Commit message prefix for batches that only deactivate DIDs
+	GCCommitMsg         string // Commit message prefix for garbage collection
+	OutputBaseDir       string // Directory inside the repository documents are written under; empty is the root
+	DocumentFileName    string // Name of each published document file, normally did.json
+	DocumentFormat      documentFormat
+	IndexFile           string // Index of published DIDs, relative to OutputBaseDir; empty disables it
+	DryRun              bool
+	AsyncMode           bool          // Process every request asynchronously, as if ?async=true
+	JobTTL              time.Duration // How long finished async jobs are kept
+	ReadyCacheTTL       time.Duration // How long a /ready result is reused before re-checking
+	MaxFailedBatches    int           // Consecutive failed batches /healthz tolerates before answering 503
+	WriteOnDryRun       bool          // Write fetched documents to disk even in dry-run mode
+	StrictValidation    bool          // Reject DID documents that fail DID Core validation
+	StrictContext       bool          // Reject DID documents missing a required @context
+	RequiredContexts    []string      // Context URLs every DID document must list
+	MethodTypes         []string      // verificationMethod types accepted by validation; empty accepts any
+	Port                string
+	TLSCertFile         string // Serve HTTPS with this certificate when TLSKeyFile is also set
+	TLSKeyFile          string
+	HealthHTTPPort      string // Plain HTTP port for /health and /ready alongside HTTPS; empty disables it
+	EnableDebug         bool   // Serve pprof and /debug/state on DebugPort
+	DebugPort           string
+	LogLevel            string              // debug, info, warn or error
+	LogFormat           string              // json or text
+	Tracing             bool                // Export OpenTelemetry spans; configured by the standard OTEL_ variables
+	BatchTimeout        time.Duration       // How long to wait before flushing batch
+	BatchWait           time.Duration       // How long a request waits for its batch to be committed
+	FetchTimeout        time.Duration       // Timeout for fetching DID documents upstream
+	FetchRetries        int                 // Retries after a connection error, 404 or 5xx from upstream
+	FetchRetryDelay     time.Duration       // Initial delay between fetch retries, doubled each attempt
+	FetchCacheFile      string              // ETag/Last-Modified cache for conditional fetches
+	FetchCacheTTL       time.Duration       // How long cached validators are used; 0 disables conditional fetches
+	FetchCAFile         string              // Extra root CAs, in PEM, trusted when fetching from SERVER_URL
+	FetchInsecure       bool                // Skip TLS verification when fetching from SERVER_URL
+	AuditLogFile        string              // Append-only JSONL record of every batch item; empty disables it
+	QueueJournalFile    string              // Batch items not yet handled, replayed on startup; empty disables it
+	DeadLetterMax       int                 // Failed batch items kept for GET /failed and retry; 0 disables the list
+	DeadLetterFile      string              // Where the failed batch items are persisted; empty keeps them in memory
+	BatchSize           int                 // Maximum files per batch
+	QueueCapacity       int                 // Items the batch queue holds before requests are refused with 503
+	DIDConfiguration    bool                // Publish domain linkage credentials in .well-known/did-configuration.json
+	DomainLinkageURL    string              // Endpoint that issues domain linkage credentials; empty means the request must supply one
+	DomainLinkageToken  string              // Bearer token sent to DomainLinkageURL
+	MaxDIDs             int                 // Maximum DIDs accepted by a single /process-dids request
+	MaxBodyBytes        int64               // Maximum request body size
+	AllowedHosts        []string            // Exact hosts or *.suffix patterns accepted in DIDs
+	HostRepoMap         map[string]HostRepo // Expected user/repo for hosts that aren't a Pages domain
+	PagesProviders      []pagesProvider     // GIT_REMOTE_PATTERNS entries followed by the built-in GitHub and GitLab ones
+	RepoMap             []RepoRoute         // Local repository per host/project; empty publishes everything from the working directory
+	BranchMap           []BranchRoute       // Branch per host/project; empty publishes everything to Branch
+
+	CNAMEVerification string // How to verify custom domains: off, file or dns
+	CNAMEFile         string // CNAME file checked when CNAMEVerification is "file"
+
+	APIToken   string // Bearer token required by mutating endpoints
+	HMACSecret string // Shared secret for X-Signature body signatures
+
+	EventsSecret   string        // Shared secret for X-Event-Secret on /events/did-created; empty disables the endpoint
+	EventWorkers   int           // Workers publishing DIDs from creation events
+	EventQueueSize int           // Creation events waiting for a worker before new ones are refused
+	EventDedupTTL  time.Duration // How long a DID from a creation event is remembered to drop duplicates
+
+	RateLimitRPS   float64 // Requests per second allowed on mutating endpoints; 0 disables rate limiting
+	RateLimitBurst int     // Requests allowed in a burst above the steady rate
+	RateLimitKey   string  // global, ip or token
+
+	PushRetries      int           // Rebase-and-retry attempts after a rejected push
+	PushRetryBackoff time.Duration // Initial delay between push retries, doubled each attempt
+	SquashWindow     time.Duration // Amend this service's last commit when it is younger than this; 0 always commits anew
+
+	GitBackend            string        // "cli" (git binary) or "gogit" (pure Go)
+	GitRepoURL            string        // Repository cloned into GitCloneDir at startup; empty uses the working directory
+	GitCloneDir           string        // Where GitRepoURL is cloned; it becomes the working directory
+	GitWorktree           bool          // Publish from a dedicated worktree of Branch (cli backend)
+	GitWorktreePath       string        // Worktree location; empty means inside the git directory
+	GitTimeout            time.Duration // Limit for a single git command or go-git fetch
+	GitPushTimeout        time.Duration // Limit for a single push, which can take longer
+	GitLockTimeout        time.Duration // How long a batch flush waits for another process's publish lock
+	GitSSHKeyPath         string        // SSH private key used by the gogit backend
+	GitSSHKeyPassword     string
+	GitPushUsername       string // HTTPS username sent with GitPushToken
+	GitPushToken          string // HTTPS token used by the cli and gogit backends; SSH remotes ignore it
+	GitSigning            string // Commit signing: off, gpg or ssh
+	GitSigningKey         string // GPG key ID (cli) or key file (SSH, or armored GPG for gogit)
+	GitSigningKeyPassword string
+	GitIdentity           commitIdentity // Commit author and committer; empty falls back to the git config
+	GitHubToken           string         // API token used by the github backend
+	GitHubRepo            string         // owner/repo published to by the github backend
+	GitHubAPIURL          string         // GitHub REST API base URL
+	GitHubWorkDir         string         // Local directory the github backend writes documents to
+
+	WebhookURL          string        // Default callback for batch results when a request sets none
+	WebhookRetries      int           // Delivery retries after a failed webhook POST
+	WebhookRetryBackoff time.Duration // Initial delay between webhook retries, doubled each attempt
+	WebhookTimeout      time.Duration // Timeout for a single webhook POST
+
+	VerifyPublish         bool          // Poll the public URL after each push until it serves the document
+	VerifyPublishTimeout  time.Duration // Give up verifying after this long
+	VerifyPublishInterval time.Duration // Initial delay between polls, doubled each attempt
+	VerifyPublishWait     time.Duration // How long a synchronous request waits for verification
 }
 
 // DIDRequest represents the JSON request body
 type DIDRequest struct {
-	DID string `json:"did"`
+	DID          string          `json:"did"`
+	CallbackURL  string          `json:"callbackUrl,omitempty"`  // Notified once the document is pushed
+	Document     json.RawMessage `json:"document,omitempty"`     // Push mode: publish this document instead of fetching it
+	ValidateOnly bool            `json:"validateOnly,omitempty"` // Run every check and report the result without writing or publishing
+
+	DomainLinkageCredential json.RawMessage `json:"domainLinkageCredential,omitempty"` // DID_CONFIGURATION: JSON-LD or JWT credential to publish
 }
 
 // DIDResponse represents the JSON response
 type DIDResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Error   string `json:"error,omitempty"`
+	Success          bool   `json:"success"`
+	Message          string `json:"message"`
+	HostVerification string `json:"hostVerification,omitempty"`
+	DryRun           bool   `json:"dryRun,omitempty"`
+	ValidateOnly     bool   `json:"validateOnly,omitempty"`
+	Unchanged        bool   `json:"unchanged,omitempty"` // Published document already matched; nothing was committed
+	Change           string `json:"change,omitempty"`    // Dry run and validate only: added, changed or unchanged
+	Diff             string `json:"diff,omitempty"`      // Dry run and validate only: unified diff of the target file
+	Error            string `json:"error,omitempty"`
+	Code             string `json:"code,omitempty"` // Machine-readable error code
+
+	ValidationErrors []string         `json:"validationErrors,omitempty"`         // DID Core problems (warnings unless STRICT_VALIDATION)
+	MethodErrors     []MethodProblems `json:"verificationMethodErrors,omitempty"` // The validation errors grouped by verification method
+	Warnings         []string         `json:"warnings,omitempty"`                 // Non-fatal problems with the request, such as a mixed-case host
+	MissingContexts  []string         `json:"missingContexts,omitempty"`          // Required @context entries the document lacks
+	JobID            string           `json:"jobId,omitempty"`                    // Async mode only: poll GET /jobs/{id}
+	RolledBack       bool             `json:"rolledBack,omitempty"`               // The git batch failed and was undone; safe to retry
+	FetchAttempts    int              `json:"fetchAttempts,omitempty"`            // Requests made to the upstream server
+	Published        *Publication     `json:"published,omitempty"`                // Where the document is (or would be) served
+}
+
+// Publication describes where a processed DID document lives once published.
+// Commit is null in dry-run, async and unchanged responses.
+type Publication struct {
+	URL          string  `json:"url"`
+	TargetFile   string  `json:"targetFile"`
+	Branch       string  `json:"branch"`
+	Sha256       string  `json:"sha256,omitempty"` // SHA-256 of the exact bytes written, or already published when unchanged
+	Commit       *string `json:"commit"`
+	Signed       bool    `json:"signed"`                 // The commit was signed (GIT_SIGNING)
+	Verification string  `json:"verification,omitempty"` // VERIFY_PUBLISH only: pending, ok or timeout
+}
+
+// ProcessResult describes the outcome of processing a single DID
+type ProcessResult struct {
+	HostVerification string
+	TargetFile       string
+	Branch           string // Branch the document is published to
+	Unchanged        bool   // The published document already matched the fetched one
+	Sha256           string // SHA-256 of the document as written, or as already published when unchanged
+	Change           string // Set in dry-run and validate-only mode only
+	Diff             string // Set in dry-run and validate-only mode only
+	ValidationErrors []string
+	MethodErrors     []MethodProblems // The validation errors of each verification method
+	MissingContexts  []string         // Required contexts the document lacks, when not rejected for them
+	Warnings         []string
+	JobID            string // Set when the document was queued asynchronously
+	FetchAttempts    int    // Requests made to fetch the document upstream
+	PublishedURL     string // URL the DID resolves to
+	Commit           string // Commit that published the document, if it was pushed
+	Verification     string // Public URL check once pushed: pending, ok or timeout
+}
+
+// DIDsRequest represents the JSON request body for batch processing
+type DIDsRequest struct {
+	DIDs        []string `json:"dids"`
+	CallbackURL string   `json:"callbackUrl,omitempty"` // Notified once per DID when its batch is pushed
+}
+
+// ProcessOptions are per-request settings for processDID
+type ProcessOptions struct {
+	Force         bool   // Publish even when the document is unchanged
+	StrictContext bool   // Reject documents missing a required @context
+	CallbackURL   string // Webhook notified when the batch is pushed
+	Async         bool   // Queue the git batch and return without waiting for it
+	ValidateOnly  bool   // Stop after validation and report what would change
+
+	Document      json.RawMessage // Supplied by the caller; nil means fetch it from SERVER_URL
+	DomainLinkage json.RawMessage // Supplied by the caller; nil means request it from DOMAIN_LINKAGE_URL
+}
+
+// DIDResult represents the outcome for a single DID in a batch request
+type DIDResult struct {
+	DID              string `json:"did"`
+	Success          bool   `json:"success"`
+	HostVerification string `json:"hostVerification,omitempty"`
+	Unchanged        bool   `json:"unchanged,omitempty"`
+	Change           string `json:"change,omitempty"`
+	Diff             string `json:"diff,omitempty"`
+	Error            string `json:"error,omitempty"`
+	Code             string `json:"code,omitempty"`
+
+	ValidationErrors []string         `json:"validationErrors,omitempty"`
+	MethodErrors     []MethodProblems `json:"verificationMethodErrors,omitempty"`
+	Warnings         []string         `json:"warnings,omitempty"`
+	MissingContexts  []string         `json:"missingContexts,omitempty"`
+	JobID            string           `json:"jobId,omitempty"`
+	RolledBack       bool             `json:"rolledBack,omitempty"`
+	Published        *Publication     `json:"published,omitempty"`
+}
+
+// DIDsResponse represents the JSON response for batch processing
+type DIDsResponse struct {
+	Success   bool        `json:"success"`
+	Message   string      `json:"message"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Results   []DIDResult `json:"results,omitempty"` // Omitted from the summary line of an NDJSON stream
+
+	queueFull int // Results refused with queue_full
+}
+
+// RemoveDIDResponse represents the JSON response for DID removal
+type RemoveDIDResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	Existed    bool   `json:"existed"`
+	TargetFile string `json:"targetFile,omitempty"`
+	DryRun     bool   `json:"dryRun,omitempty"`
+	RolledBack bool   `json:"rolledBack,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Code       string `json:"code,omitempty"`
+}
+
+// DIDStatusResponse represents the JSON response for a DID status check
+type DIDStatusResponse struct {
+	DID          string `json:"did"`
+	TargetFile   string `json:"targetFile"`
+	PublishedURL string `json:"publishedUrl"`
+	Exists       bool   `json:"exists"`
+	InSync       bool   `json:"inSync"`
+	LocalSha256  string `json:"localSha256,omitempty"`
+	RemoteSha256 string `json:"remoteSha256,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// errDIDNotFound is returned when a DID has no published document to remove
+var errDIDNotFound = errors.New("DID document not found")
+
+// errTargetOutsideRepo is returned when a target file would resolve outside
+// the publishing root, e.g. through a symlinked OUTPUT_BASE_DIR
+var errTargetOutsideRepo = errors.New("target file is outside the repository")
+
+// errCodeTargetOutsideRepo is the machine-readable code for errTargetOutsideRepo
+const errCodeTargetOutsideRepo = "target_outside_repo"
+
+// errBatchRolledBack is returned to every item of a batch whose commit or push
+// failed and was rolled back; the request can be retried as is
+var errBatchRolledBack = errors.New("git batch failed and was rolled back")
+
+// errBatchItemRejected is returned to a single item dropped from its batch
+// because it failed validation; the rest of the batch is still published
+var errBatchItemRejected = errors.New("rejected from git batch")
+
+// errCodeItemRejected is the machine-readable code for errBatchItemRejected
+const errCodeItemRejected = "batch_item_rejected"
+
+// errContentMismatch is returned to an item whose document, read back from
+// the pushed branch, isn't byte for byte the one written to disk
+var errContentMismatch = errors.New("committed document does not match the written one")
+
+// errCodeContentMismatch is the machine-readable code for errContentMismatch
+const errCodeContentMismatch = "content_mismatch"
+
+// errQueueFull is returned without waiting when the batch queue holds
+// QUEUE_CAPACITY items; nothing is written to disk
+var errQueueFull = errors.New("batch queue is full")
+
+// errCodeQueueFull is the machine-readable code for errQueueFull
+const errCodeQueueFull = "queue_full"
+
+// BatchResult is sent back to a waiting request once its batch finishes
+type BatchResult struct {
+	Commit string        // Commit pushed for the batch
+	Check  *publishCheck // Verification of the public URL; nil unless VERIFY_PUBLISH
+	Err    error
 }
 
 // BatchItem represents a file to be committed
 type BatchItem struct {
-	TargetFile string
-	ParsedDID  *ParsedDID
-	ResponseCh chan error // Channel to send result back to request handler
+	TargetFile       string
+	ParsedDID        *ParsedDID
+	Repo             *publishRepo      // Repository the item is committed to
+	HostVerification HostVerification  // How the host was tied to the repository
+	Remove           bool              // Stage the file's removal instead of its contents
+	Deactivate       bool              // Document is the deactivated form of the published one
+	RequestID        string            // ID of the HTTP request that queued the item
+	Actor            string            // Credential the request authenticated with, for the audit log
+	Ctx              context.Context   // Request context; the item is abandoned once it is done
+	CallbackURL      string            // Webhook notified with the batch result
+	JobID            string            // Async job updated with the batch result
+	EnqueuedAt       time.Time         // When the item was sent to the batch processor
+	Document         []byte            // Committed document, compared with the public URL by VERIFY_PUBLISH
+	DomainLinkage    json.RawMessage   // Credential merged into the DID configuration; nil leaves it alone
+	Claimed          bool              // TargetFile is claimed and must be released once the batch is done
+	Previous         pendingFiles      // TargetFile before the item wrote it, restored if the item is abandoned; nil leaves it
+	JournalID        string            // Key of the item's queue journal entry
+	SpanContext      trace.SpanContext // Span of the request that queued the item, linked from the batch flush
+	DeadLetterID     string            // Dead-letter entry the item retries; empty for a new item
+	ResponseCh       chan BatchResult  // Channel to send result back to request handler
 }
 
 // DIDProcessor handles the DID document processing
 type DIDProcessor struct {
-	config  Config
-	gitMux  sync.Mutex     // Mutex to serialize git operations
-	batchCh chan BatchItem // Channel for batching git operations
-	batchWG sync.WaitGroup // Wait group for graceful shutdown
+	config        Config
+	repos         map[repoID]*publishRepo // Publishing repositories by REPO_MAP path and branch; "" is the working directory
+	fetchClient   *http.Client            // Client used to fetch DID documents from SERVER_URL
+	httpClient    *http.Client            // Client used for publish checks and domain linkage requests
+	fetchCache    *fetchCache             // Validators for conditional fetches; nil when disabled
+	audit         *auditLog               // Audit log of batch items; nil when disabled
+	journal       *batchJournal           // Queued batch items not yet handled; nil when disabled
+	deadLetters   *deadLetterList         // Failed batch items kept for retry; nil when disabled
+	publishStats  *publishStats           // Publishing activity per host and project; nil in the publish command
+	events        *eventQueue             // DID creation events awaiting a worker; nil when disabled
+	webhookClient *http.Client            // Client used to deliver batch webhooks
+	jobs          *jobStore               // Async jobs awaiting or reporting their batch result
+	limiter       *rateLimiter            // Rate limiter for mutating endpoints; nil when disabled
+	ready         *readinessCache         // Last /ready result
+	pending       *pendingBatch           // Snapshot of the batch being collected, for /debug/state and /stats
+	startedAt     time.Time               // When the service started
+	batchCh       chan BatchItem          // Channel for batching git operations
+	batchWG       sync.WaitGroup          // Wait group for graceful shutdown
 }
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
+	// "host_did_web publish did..." publishes once and exits, keeping stdout for its results
+	publishMode := len(os.Args) > 1 && os.Args[1] == publishCommand
+	logOutput := io.Writer(os.Stdout)
+	if publishMode {
+		logOutput = os.Stderr
+	}
+
+	envErr := godotenv.Load()
+	config, err := loadConfig()
+	if err == nil {
+		err = setupLogger(logOutput, config.LogLevel, config.LogFormat)
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if envErr != nil {
+		slog.Info("No .env file found, using environment variables")
+	}
+	shutdownTracing, err := setupTracing(context.Background(), config.Tracing)
+	if err != nil {
+		slog.Error("Invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+	if config.GitRepoURL != "" {
+		if err := prepareCloneDir(config); err != nil {
+			slog.Error("Failed to prepare publishing repository", "error", err)
+			os.Exit(1)
+		}
+	}
+	repos, err := newPublishRepos(config)
+	if err != nil {
+		slog.Error("Invalid git configuration", "error", err)
+		os.Exit(1)
+	}
+	fetchClient, err := newFetchClient(config)
+	if err != nil {
+		slog.Error("Invalid fetch TLS configuration", "error", err)
+		os.Exit(1)
 	}
-	config := loadConfig()
 	processor := &DIDProcessor{
-		config:  config,
-		batchCh: make(chan BatchItem, 100), // Buffer for batch items
+		config:        config,
+		repos:         repos,
+		fetchClient:   fetchClient,
+		httpClient:    &http.Client{Timeout: config.FetchTimeout},
+		webhookClient: &http.Client{Timeout: config.WebhookTimeout},
+		jobs:          newJobStore(config.JobTTL),
+		ready:         &readinessCache{ttl: config.ReadyCacheTTL},
+		pending:       &pendingBatch{},
+		startedAt:     time.Now(),
+		batchCh:       make(chan BatchItem, config.QueueCapacity),
+	}
+
+	if config.FetchCacheTTL > 0 {
+		processor.fetchCache = loadFetchCache(config.FetchCacheFile, config.FetchCacheTTL)
+	}
+	// A one-shot run leaves nothing queued, and mustn't replay a server's queue
+	if config.QueueJournalFile != "" && !publishMode {
+		processor.journal, err = loadBatchJournal(config.QueueJournalFile)
+		if err != nil {
+			slog.Error("Invalid queue journal", "error", err)
+			os.Exit(1)
+		}
+	}
+	// A one-shot run reports its failures in its exit status instead
+	if config.DeadLetterMax > 0 && !publishMode {
+		processor.deadLetters, err = loadDeadLetters(config.DeadLetterFile, config.DeadLetterMax)
+		if err != nil {
+			slog.Error("Invalid dead-letter file", "error", err)
+			os.Exit(1)
+		}
+	}
+	if config.AuditLogFile != "" {
+		processor.audit, err = openAuditLog(config.AuditLogFile)
+		if err != nil {
+			slog.Error("Invalid audit log", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if !publishMode {
+		processor.publishStats = newPublishStats()
+		processor.seedPublishStats()
+	}
+
+	if publishMode {
+		status := processor.runPublish(os.Args[2:], os.Stdout)
+		// The process exits straight away, so flush the run's spans first
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+		os.Exit(status)
+	}
+
+	if config.RateLimitRPS > 0 {
+		processor.limiter = newRateLimiter(config.RateLimitRPS, config.RateLimitBurst)
+		go processor.limiter.evictLoop()
 	}
 
 	// Start the git batch processor
 	processor.batchWG.Add(1)
 	go processor.gitBatchProcessor()
+	go processor.jobs.evictLoop()
+	if processor.journal != nil {
+		processor.replayJournal()
+	}
+	if config.EventsSecret != "" {
+		processor.events = newEventQueue(config.EventQueueSize, config.EventDedupTTL)
+		processor.startEventWorkers()
+	}
 
-	http.HandleFunc("/process-did", processor.handleProcessDID)
-	http.HandleFunc("/health", handleHealth)
+	// A dedicated mux keeps the pprof handlers, which register themselves on
+	// http.DefaultServeMux, off the main port
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process-did", traced(processor.rateLimit(processor.limitBody(processor.requireAuth(processor.handleProcessDID)))))
+	mux.HandleFunc("/process-dids", traced(processor.rateLimit(processor.limitBody(processor.requireAuth(processor.handleProcessDIDs)))))
+	mux.HandleFunc("/deactivate-did", traced(processor.rateLimit(processor.limitBody(processor.requireAuth(processor.handleDeactivateDID)))))
+	mux.HandleFunc("POST /gc", traced(processor.rateLimit(processor.limitBody(processor.requireAuth(processor.handleGC)))))
+	mux.HandleFunc("GET /did-status", traced(processor.rateLimit(processor.requireAuth(processor.handleDIDStatus))))
+	mux.HandleFunc("GET /jobs/{id}", processor.requireAuth(processor.handleJobStatus))
+	mux.HandleFunc("GET /stats", processor.requireAuth(processor.handleStats))
+	mux.HandleFunc("GET /stats/publish", processor.requireAuth(processor.handlePublishStats))
+	mux.HandleFunc("GET /audit", processor.requireAuth(processor.handleAudit))
+	if processor.deadLetters != nil {
+		mux.HandleFunc("GET /failed", processor.requireAuth(processor.handleFailed))
+		mux.HandleFunc("POST /failed/retry", processor.rateLimit(processor.limitBody(processor.requireAuth(processor.handleRetryFailed))))
+	}
+	if processor.events != nil {
+		mux.HandleFunc("POST /events/did-created", traced(processor.rateLimit(processor.limitBody(processor.requireEventSecret(processor.handleDIDCreated)))))
+	}
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("GET /healthz", processor.handleHealthz)
+	mux.HandleFunc("GET /ready", processor.handleReady)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	slog.Info("Starting DID Web Service",
+		"port", config.Port,
+		"server_url", config.ServerURL,
+		"branch", config.Branch,
+		"branch_map", len(config.BranchMap) > 0,
+		"output_base_dir", config.OutputBaseDir,
+		"index_file", config.IndexFile,
+		"did_configuration", config.DIDConfiguration,
+		"repositories", len(repos),
+		"dry_run", config.DryRun,
+		"async_mode", config.AsyncMode,
+		"write_on_dry_run", config.WriteOnDryRun,
+		"strict_validation", config.StrictValidation,
+		"strict_context", config.StrictContext,
+		"required_contexts", strings.Join(config.RequiredContexts, ", "),
+		"git_backend", config.GitBackend,
+		"git_worktree", config.GitWorktree,
+		"git_signing", config.GitSigning,
+		"git_author", config.GitIdentity.AuthorEmail,
+		"squash_window", config.SquashWindow,
+		"batch_timeout", config.BatchTimeout,
+		"batch_wait", config.BatchWait,
+		"fetch_timeout", config.FetchTimeout,
+		"fetch_cache_ttl", config.FetchCacheTTL,
+		"fetch_ca_file", config.FetchCAFile,
+		"batch_size", config.BatchSize,
+		"max_dids", config.MaxDIDs,
+		"allowed_hosts", strings.Join(config.AllowedHosts, ", "),
+		"cname_verification", config.CNAMEVerification,
+		"rate_limit_rps", config.RateLimitRPS,
+		"rate_limit_burst", config.RateLimitBurst,
+		"rate_limit_key", config.RateLimitKey,
+		"webhook_url", config.WebhookURL,
+		"did_events", processor.events != nil,
+		"tracing", config.Tracing,
+		"log_level", config.LogLevel)
+	if config.FetchInsecure {
+		slog.Warn("⚠️ FETCH_INSECURE_SKIP_VERIFY is set: TLS certificates from SERVER_URL are NOT verified")
+	}
+	if !processor.authEnabled() {
+		slog.Warn("⚠️ API_TOKEN and HMAC_SECRET are unset: mutating endpoints are unauthenticated")
+	}
 
-	log.Printf("Starting DID Web Service on port %s", config.Port)
-	log.Printf("Server URL: %s", config.ServerURL)
-	log.Printf("Branch: %s", config.Branch)
-	log.Printf("Dry Run: %t", config.DryRun)
-	log.Printf("Batch Timeout: %v", config.BatchTimeout)
-	log.Printf("Batch Size: %d", config.BatchSize)
+	if config.HealthHTTPPort != "" {
+		// Some load balancers can only probe over plain HTTP
+		healthMux := http.NewServeMux()
+		healthMux.HandleFunc("/health", handleHealth)
+		healthMux.HandleFunc("GET /healthz", processor.handleHealthz)
+		healthMux.HandleFunc("GET /ready", processor.handleReady)
+		go func() {
+			slog.Info("Serving health checks over HTTP", "port", config.HealthHTTPPort)
+			err := http.ListenAndServe(":"+config.HealthHTTPPort, withRequestID(healthMux))
+			slog.Error("Health server stopped", "error", err)
+			os.Exit(1)
+		}()
+	}
+
+	if config.EnableDebug {
+		go func() {
+			slog.Warn("🐞 Serving pprof and /debug/state; don't expose this port publicly", "port", config.DebugPort)
+			err := http.ListenAndServe(":"+config.DebugPort, processor.debugMux())
+			slog.Error("Debug server stopped", "error", err)
+			os.Exit(1)
+		}()
+	}
 
-	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+	server := &http.Server{
+		Addr:      ":" + config.Port,
+		Handler:   withRequestID(mux),
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	if config.TLSCertFile != "" {
+		slog.Info("🔒 Serving HTTPS", "port", config.Port, "cert_file", config.TLSCertFile)
+		err = server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	} else {
+		slog.Warn("Serving plain HTTP; set TLS_CERT_FILE and TLS_KEY_FILE to enable HTTPS", "port", config.Port)
+		err = server.ListenAndServe()
+	}
+	slog.Error("Server stopped", "error", err)
+	os.Exit(1)
 }
 
-func loadConfig() Config {
+func loadConfig() (Config, error) {
 	batchTimeout, _ := time.ParseDuration(getEnv("BATCH_TIMEOUT", "5s"))
-	batchSize := 10
-	if size := getEnv("BATCH_SIZE", "10"); size != "" {
-		fmt.Sscanf(size, "%d", &batchSize)
+	gitTimeout, err := time.ParseDuration(getEnv("GIT_TIMEOUT", "2m"))
+	if err != nil || gitTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid GIT_TIMEOUT '%s'", getEnv("GIT_TIMEOUT", "2m"))
+	}
+	gitPushTimeout, err := time.ParseDuration(getEnv("GIT_PUSH_TIMEOUT", "5m"))
+	if err != nil || gitPushTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid GIT_PUSH_TIMEOUT '%s'", getEnv("GIT_PUSH_TIMEOUT", "5m"))
+	}
+	gitLockTimeout, err := time.ParseDuration(getEnv("GIT_LOCK_TIMEOUT", "10s"))
+	if err != nil || gitLockTimeout < 0 {
+		return Config{}, fmt.Errorf("invalid GIT_LOCK_TIMEOUT '%s'", getEnv("GIT_LOCK_TIMEOUT", "10s"))
+	}
+	pushRetryBackoff, _ := time.ParseDuration(getEnv("PUSH_RETRY_BACKOFF", "1s"))
+	batchWait, err := time.ParseDuration(getEnv("BATCH_WAIT_TIMEOUT", "30s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid BATCH_WAIT_TIMEOUT: %w", err)
+	}
+	fetchTimeout, err := time.ParseDuration(getEnv("FETCH_TIMEOUT", "10s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid FETCH_TIMEOUT: %w", err)
+	}
+	jobTTL, err := time.ParseDuration(getEnv("JOB_TTL", "1h"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid JOB_TTL: %w", err)
+	}
+	readyCacheTTL, err := time.ParseDuration(getEnv("READY_CACHE_TTL", "30s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid READY_CACHE_TTL: %w", err)
+	}
+	fetchRetryDelay, err := time.ParseDuration(getEnv("FETCH_RETRY_DELAY", "500ms"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid FETCH_RETRY_DELAY: %w", err)
+	}
+	fetchCacheTTL, err := time.ParseDuration(getEnv("FETCH_CACHE_TTL", "24h"))
+	if err != nil || fetchCacheTTL < 0 {
+		return Config{}, fmt.Errorf("invalid FETCH_CACHE_TTL '%s'", getEnv("FETCH_CACHE_TTL", "24h"))
+	}
+	verifyPublishTimeout, err := time.ParseDuration(getEnv("VERIFY_PUBLISH_TIMEOUT", "10m"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid VERIFY_PUBLISH_TIMEOUT: %w", err)
+	}
+	verifyPublishInterval, err := time.ParseDuration(getEnv("VERIFY_PUBLISH_INTERVAL", "5s"))
+	if err != nil || verifyPublishInterval <= 0 {
+		return Config{}, fmt.Errorf("invalid VERIFY_PUBLISH_INTERVAL '%s'", getEnv("VERIFY_PUBLISH_INTERVAL", "5s"))
+	}
+	verifyPublishWait, err := time.ParseDuration(getEnv("VERIFY_PUBLISH_WAIT", "3s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid VERIFY_PUBLISH_WAIT: %w", err)
+	}
+	webhookRetryBackoff, err := time.ParseDuration(getEnv("WEBHOOK_RETRY_BACKOFF", "1s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid WEBHOOK_RETRY_BACKOFF: %w", err)
+	}
+	webhookTimeout, err := time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid WEBHOOK_TIMEOUT: %w", err)
+	}
+	webhookURL := getEnv("WEBHOOK_URL", "")
+	if webhookURL != "" {
+		if err := validateCallbackURL(webhookURL); err != nil {
+			return Config{}, fmt.Errorf("invalid WEBHOOK_URL: %w", err)
+		}
 	}
 
-	return Config{
-		ServerURL:    getEnv("SERVER_URL", "http://localhost:3332"),
-		Branch:       getEnv("BRANCH", "gh-pages"),
-		GitRemote:    getEnv("GIT_REMOTE", "origin"),
-		CommitMsg:    getEnv("COMMIT_MSG", "chore (did): update did:web documents"),
-		DryRun:       getEnv("DRY_RUN", "false") == "true",
-		Port:         getEnv("PORT", "8080"),
-		BatchTimeout: batchTimeout,
-		BatchSize:    batchSize,
+	gitSigning, gitSigningKey := getEnv("GIT_SIGNING", signingOff), getEnv("GIT_SIGNING_KEY", "")
+	if gitSigning != signingOff && gitSigning != signingGPG && gitSigning != signingSSH {
+		return Config{}, fmt.Errorf("invalid GIT_SIGNING '%s' (expected off, gpg or ssh)", gitSigning)
+	}
+	if gitSigning != signingOff && gitSigningKey == "" {
+		return Config{}, fmt.Errorf("GIT_SIGNING_KEY is required when GIT_SIGNING is %s", gitSigning)
+	}
+
+	outputBaseDir, err := cleanOutputBaseDir(getEnv("OUTPUT_BASE_DIR", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	documentFileName := getEnv("DOCUMENT_FILENAME", "did.json")
+	if err := checkDocumentFileName(documentFileName); err != nil {
+		return Config{}, err
+	}
+	documentIndent, err := parseDocumentIndent(getEnv("DOCUMENT_INDENT", "2"))
+	if err != nil {
+		return Config{}, err
+	}
+	format := documentFormat{Indent: documentIndent, TrailingNewline: getEnv("DOCUMENT_TRAILING_NEWLINE", "false") == "true"}
+
+	// Unlike most settings, an explicitly empty INDEX_FILE means disabled
+	rawIndexFile, ok := os.LookupEnv("INDEX_FILE")
+	if !ok {
+		rawIndexFile = "index.json"
+	}
+	indexFile := filepath.Clean(filepath.FromSlash(rawIndexFile))
+	if rawIndexFile == "" || indexFile == "." {
+		indexFile = ""
+	} else if !filepath.IsLocal(indexFile) {
+		return Config{}, fmt.Errorf("invalid INDEX_FILE '%s' (expected a relative path)", rawIndexFile)
+	}
+	queueCapacity := getEnvInt("QUEUE_CAPACITY", 100)
+	if queueCapacity < 1 {
+		return Config{}, fmt.Errorf("invalid QUEUE_CAPACITY %d (expected at least 1)", queueCapacity)
+	}
+	maxFailedBatches := getEnvInt("HEALTH_MAX_FAILED_BATCHES", 3)
+	if maxFailedBatches < 0 {
+		return Config{}, fmt.Errorf("invalid HEALTH_MAX_FAILED_BATCHES %d (expected 0 or more)", maxFailedBatches)
+	}
+	eventWorkers := getEnvInt("EVENT_WORKERS", 4)
+	if eventWorkers < 1 {
+		return Config{}, fmt.Errorf("invalid EVENT_WORKERS %d (expected at least 1)", eventWorkers)
+	}
+	eventQueueSize := getEnvInt("EVENT_QUEUE_SIZE", 100)
+	if eventQueueSize < 1 {
+		return Config{}, fmt.Errorf("invalid EVENT_QUEUE_SIZE %d (expected at least 1)", eventQueueSize)
+	}
+	eventDedupTTL, err := time.ParseDuration(getEnv("EVENT_DEDUP_TTL", "10m"))
+	if err != nil || eventDedupTTL < 0 {
+		return Config{}, fmt.Errorf("invalid EVENT_DEDUP_TTL '%s'", getEnv("EVENT_DEDUP_TTL", "10m"))
+	}
+	deadLetterMax := getEnvInt("DEAD_LETTER_MAX", 1000)
+	if deadLetterMax < 0 {
+		return Config{}, fmt.Errorf("invalid DEAD_LETTER_MAX %d (expected 0 or more)", deadLetterMax)
+	}
+	// Like INDEX_FILE, an empty QUEUE_JOURNAL_FILE disables the journal
+	queueJournalFile, ok := os.LookupEnv("QUEUE_JOURNAL_FILE")
+	if !ok {
+		queueJournalFile = defaultJournalPath()
+	}
+	// An empty VERIFICATION_METHOD_TYPES accepts any verification method type
+	methodTypes, ok := os.LookupEnv("VERIFICATION_METHOD_TYPES")
+	if !ok {
+		methodTypes = defaultVerificationMethodTypes
 	}
+
+	gitIdentity, err := loadCommitIdentity()
+	if err != nil {
+		return Config{}, err
+	}
+	squashWindow, err := time.ParseDuration(getEnv("SQUASH_WINDOW", "0"))
+	if err != nil || squashWindow < 0 {
+		return Config{}, fmt.Errorf("invalid SQUASH_WINDOW '%s'", getEnv("SQUASH_WINDOW", "0"))
+	}
+	if squashWindow > 0 && getEnv("GIT_BACKEND", "cli") == "github" {
+		return Config{}, fmt.Errorf("SQUASH_WINDOW is not supported by the github backend")
+	}
+	// Only commits authored by this identity are ever amended
+	if squashWindow > 0 && !gitIdentity.isSet() {
+		return Config{}, fmt.Errorf("SQUASH_WINDOW requires GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL")
+	}
+
+	tlsCertFile, tlsKeyFile := getEnv("TLS_CERT_FILE", ""), getEnv("TLS_KEY_FILE", "")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return Config{}, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	rateLimitRPS, err := strconv.ParseFloat(getEnv("RATE_LIMIT_RPS", "0"), 64)
+	if err != nil || rateLimitRPS < 0 {
+		return Config{}, fmt.Errorf("invalid RATE_LIMIT_RPS '%s'", getEnv("RATE_LIMIT_RPS", "0"))
+	}
+	rateLimitKey := getEnv("RATE_LIMIT_KEY", rateLimitIP)
+	if rateLimitKey != rateLimitGlobal && rateLimitKey != rateLimitIP && rateLimitKey != rateLimitToken {
+		return Config{}, fmt.Errorf("invalid RATE_LIMIT_KEY '%s' (expected global, ip or token)", rateLimitKey)
+	}
+
+	hostRepoMap, err := parseHostRepoMap(getEnv("HOST_REPO_MAP", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	pagesProviders, err := parseRemotePatterns(getEnv("GIT_REMOTE_PATTERNS", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	repoMap, err := parseRepoMap(getEnv("REPO_MAP", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	if len(repoMap) > 0 && filepath.IsAbs(getEnv("GIT_WORKTREE_PATH", "")) {
+		// Every repository would share the one worktree
+		return Config{}, fmt.Errorf("GIT_WORKTREE_PATH must be relative when REPO_MAP is set")
+	}
+	branchMap, err := parseBranchMap(getEnv("BRANCH_MAP", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	gitRepoURL := getEnv("GIT_REPO_URL", "")
+	if gitRepoURL != "" && len(repoMap) > 0 {
+		return Config{}, fmt.Errorf("GIT_REPO_URL can't be combined with REPO_MAP")
+	}
+	if gitRepoURL != "" && getEnv("GIT_BACKEND", "cli") == "github" {
+		return Config{}, fmt.Errorf("GIT_REPO_URL is not supported by the github backend")
+	}
+	cnameVerification := getEnv("CNAME_VERIFICATION", "off")
+	if cnameVerification != "off" && cnameVerification != "file" && cnameVerification != "dns" {
+		return Config{}, fmt.Errorf("invalid CNAME_VERIFICATION '%s' (expected off, file or dns)", cnameVerification)
+	}
+
+	return Config{
+		ServerURL:           getEnv("SERVER_URL", "http://localhost:3332"),
+		Branch:              getEnv("BRANCH", "gh-pages"),
+		GitRemote:           getEnv("GIT_REMOTE", "origin"),
+		CommitMsg:           getEnv("COMMIT_MSG", "chore (did): update did:web documents"),
+		DeactivateCommitMsg: getEnv("DEACTIVATE_COMMIT_MSG", "chore (did): deactivate did:web documents"),
+		GCCommitMsg:         getEnv("GC_COMMIT_MSG", "chore (did): remove orphaned did:web documents"),
+		DryRun:              getEnv("DRY_RUN", "false") == "true",
+		AsyncMode:           getEnv("ASYNC_MODE", "false") == "true",
+		JobTTL:              jobTTL,
+		ReadyCacheTTL:       readyCacheTTL,
+		MaxFailedBatches:    maxFailedBatches,
+		WriteOnDryRun:       getEnv("WRITE_ON_DRY_RUN", "false") == "true",
+		StrictValidation:    getEnv("STRICT_VALIDATION", "false") == "true",
+		StrictContext:       getEnv("STRICT_CONTEXT", "false") == "true",
+		RequiredContexts:    parseContextList(getEnv("REQUIRED_CONTEXTS", didCoreContext)),
+		MethodTypes:         parseContextList(methodTypes),
+		Port:                getEnv("PORT", "8080"),
+		TLSCertFile:         tlsCertFile,
+		TLSKeyFile:          tlsKeyFile,
+		HealthHTTPPort:      getEnv("HEALTH_HTTP_PORT", ""),
+		EnableDebug:         getEnv("ENABLE_DEBUG", "false") == "true",
+		DebugPort:           getEnv("DEBUG_PORT", "6060"),
+		OutputBaseDir:       outputBaseDir,
+		DocumentFileName:    documentFileName,
+		DocumentFormat:      format,
+		IndexFile:           indexFile,
+		LogLevel:            getEnv("LOG_LEVEL", "info"),
+		LogFormat:           getEnv("LOG_FORMAT", "json"),
+		Tracing:             tracingEnabled(),
+		BatchTimeout:        batchTimeout,
+		BatchWait:           batchWait,
+		FetchTimeout:        fetchTimeout,
+		FetchRetries:        getEnvInt("FETCH_RETRIES", 3),
+		FetchRetryDelay:     fetchRetryDelay,
+		FetchCacheFile:      getEnv("FETCH_CACHE_FILE", defaultFetchCachePath()),
+		FetchCacheTTL:       fetchCacheTTL,
+		FetchCAFile:         getEnv("FETCH_CA_FILE", ""),
+		FetchInsecure:       getEnv("FETCH_INSECURE_SKIP_VERIFY", "false") == "true",
+		AuditLogFile:        getEnv("AUDIT_LOG_FILE", ""),
+		QueueJournalFile:    queueJournalFile,
+		DeadLetterMax:       deadLetterMax,
+		DeadLetterFile:      getEnv("DEAD_LETTER_FILE", ""),
+		BatchSize:           getEnvInt("BATCH_SIZE", 10),
+		QueueCapacity:       queueCapacity,
+		DIDConfiguration:    getEnv("DID_CONFIGURATION", "false") == "true",
+		DomainLinkageURL:    getEnv("DOMAIN_LINKAGE_URL", ""),
+		DomainLinkageToken:  getEnv("DOMAIN_LINKAGE_TOKEN", ""),
+		MaxDIDs:             getEnvInt("MAX_DIDS_PER_REQUEST", 50),
+		MaxBodyBytes:        int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+		AllowedHosts:        parseHostList(getEnv("ALLOWED_HOSTS", "*.github.io,*.gitlab.io")),
+		HostRepoMap:         hostRepoMap,
+		PagesProviders:      pagesProviders,
+		RepoMap:             repoMap,
+		BranchMap:           branchMap,
+
+		CNAMEVerification: cnameVerification,
+		CNAMEFile:         getEnv("CNAME_FILE", "CNAME"),
+
+		APIToken:   getEnv("API_TOKEN", ""),
+		HMACSecret: getEnv("HMAC_SECRET", ""),
+
+		EventsSecret:   getEnv("EVENTS_SECRET", ""),
+		EventWorkers:   eventWorkers,
+		EventQueueSize: eventQueueSize,
+		EventDedupTTL:  eventDedupTTL,
+
+		RateLimitRPS:   rateLimitRPS,
+		RateLimitBurst: max(getEnvInt("RATE_LIMIT_BURST", 10), 1),
+		RateLimitKey:   rateLimitKey,
+
+		PushRetries:      getEnvInt("PUSH_RETRIES", 3),
+		PushRetryBackoff: pushRetryBackoff,
+		SquashWindow:     squashWindow,
+
+		GitBackend:            getEnv("GIT_BACKEND", "cli"),
+		GitRepoURL:            gitRepoURL,
+		GitCloneDir:           getEnv("GIT_CLONE_DIR", "repo"),
+		GitTimeout:            gitTimeout,
+		GitPushTimeout:        gitPushTimeout,
+		GitLockTimeout:        gitLockTimeout,
+		GitWorktree:           getEnv("GIT_WORKTREE", "true") == "true",
+		GitWorktreePath:       getEnv("GIT_WORKTREE_PATH", ""),
+		GitSSHKeyPath:         getEnv("GIT_SSH_KEY_PATH", defaultSSHKeyPath()),
+		GitSSHKeyPassword:     getEnv("GIT_SSH_KEY_PASSWORD", ""),
+		GitPushUsername:       getEnv("GIT_PUSH_USERNAME", ""),
+		GitPushToken:          getEnv("GIT_PUSH_TOKEN", ""),
+		GitSigning:            gitSigning,
+		GitSigningKey:         gitSigningKey,
+		GitSigningKeyPassword: getEnv("GIT_SIGNING_KEY_PASSWORD", ""),
+		GitIdentity:           gitIdentity,
+		GitHubToken:           getEnv("GITHUB_TOKEN", ""),
+		GitHubRepo:            getEnv("GITHUB_REPO", ""),
+		GitHubAPIURL:          getEnv("GITHUB_API_URL", "https://api.github.com"),
+		GitHubWorkDir:         getEnv("GITHUB_WORK_DIR", "."),
+
+		WebhookURL:          webhookURL,
+		WebhookRetries:      getEnvInt("WEBHOOK_RETRIES", 2),
+		WebhookRetryBackoff: webhookRetryBackoff,
+		WebhookTimeout:      webhookTimeout,
+
+		VerifyPublish:         getEnv("VERIFY_PUBLISH", "false") == "true",
+		VerifyPublishTimeout:  verifyPublishTimeout,
+		VerifyPublishInterval: verifyPublishInterval,
+		VerifyPublishWait:     verifyPublishWait,
+	}, nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -110,6 +892,14 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value := defaultValue
+	if raw := os.Getenv(key); raw != "" {
+		fmt.Sscanf(raw, "%d", &value)
+	}
+	return value
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
@@ -118,6 +908,11 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 func (p *DIDProcessor) handleProcessDID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if r.Method == http.MethodDelete {
+		p.handleRemoveDID(w, r)
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		p.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
@@ -125,7 +920,7 @@ func (p *DIDProcessor) handleProcessDID(w http.ResponseWriter, r *http.Request)
 
 	var req DIDRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		p.sendError(w, http.StatusBadRequest, "Invalid JSON request")
+		p.sendBodyError(w, err)
 		return
 	}
 
@@ -134,92 +929,962 @@ func (p *DIDProcessor) handleProcessDID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := p.processDID(req.DID); err != nil {
-		p.sendError(w, http.StatusInternalServerError, err.Error())
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			p.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	opts := p.processOptions(r, req.CallbackURL)
+	if len(req.Document) > 0 && string(req.Document) != "null" {
+		if trimmed := bytes.TrimSpace(req.Document); trimmed[0] != '{' {
+			p.sendErrorCode(w, http.StatusBadRequest, errCodeInvalidJSON, "document must be a JSON object")
+			return
+		}
+		opts.Document = req.Document
+	}
+	if len(req.DomainLinkageCredential) > 0 && string(req.DomainLinkageCredential) != "null" {
+		opts.DomainLinkage = req.DomainLinkageCredential
+	}
+	if req.ValidateOnly {
+		opts.ValidateOnly = true
+	}
+	result, err := p.processDID(r.Context(), req.DID, opts)
+	if err != nil {
+		status, code := errorStatus(err)
+		response := DIDResponse{Success: false, Error: err.Error(), Code: code, RolledBack: errors.Is(err, errBatchRolledBack)}
+		var contextErr *ContextError
+		if errors.As(err, &contextErr) {
+			response.MissingContexts = contextErr.Missing
+		}
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			response.ValidationErrors = validationErr.Problems
+			response.MethodErrors = validationErr.Methods
+		}
+		if errors.Is(err, errQueueFull) {
+			p.setRetryAfter(w)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
 	response := DIDResponse{
-		Success: true,
-		Message: "DID document processed successfully",
+		Success:          true,
+		Message:          "DID document processed successfully",
+		HostVerification: result.HostVerification,
+		DryRun:           p.config.DryRun,
+		ValidateOnly:     opts.ValidateOnly,
+		Unchanged:        result.Unchanged,
+		Change:           result.Change,
+		Diff:             result.Diff,
+		ValidationErrors: result.ValidationErrors,
+		MethodErrors:     result.MethodErrors,
+		Warnings:         result.Warnings,
+		MissingContexts:  result.MissingContexts,
+		JobID:            result.JobID,
+		FetchAttempts:    result.FetchAttempts,
+		Published:        p.publication(result),
+	}
+	switch {
+	case opts.ValidateOnly && result.Change != "":
+		response.Message = fmt.Sprintf("Validated: %s would be %s", result.TargetFile, result.Change)
+	case p.config.DryRun:
+		response.Message = fmt.Sprintf("Dry run: %s is %s", result.TargetFile, result.Change)
+	case result.Unchanged:
+		response.Message = "DID document unchanged, nothing to publish"
+	case result.JobID != "":
+		response.Message = "DID document queued for publishing"
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if result.FetchAttempts > 1 {
+		response.Message += fmt.Sprintf(" (fetched after %d attempts)", result.FetchAttempts)
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
+func (p *DIDProcessor) handleRemoveDID(w http.ResponseWriter, r *http.Request) {
+	var req DIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.sendBodyError(w, err)
+		return
+	}
+
+	if req.DID == "" {
+		p.sendError(w, http.StatusBadRequest, "DID is required")
+		return
+	}
+
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			p.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	targetFile, err := p.removeDID(r.Context(), req.DID, req.CallbackURL)
+	response := RemoveDIDResponse{
+		Existed:    !errors.Is(err, errDIDNotFound),
+		TargetFile: targetFile,
+		DryRun:     p.config.DryRun,
+	}
+	if err != nil {
+		status, code := errorStatus(err)
+		response.Code = code
+		response.RolledBack = errors.Is(err, errBatchRolledBack)
+		if errors.Is(err, errQueueFull) {
+			p.setRetryAfter(w)
+		}
+		response.Error = err.Error()
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response.Success = true
+	if p.config.DryRun {
+		response.Message = fmt.Sprintf("Dry run: would remove %s", targetFile)
+	} else {
+		response.Message = "DID document removed successfully"
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (p *DIDProcessor) handleDIDStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	did := r.URL.Query().Get("did")
+	if did == "" {
+		p.sendError(w, http.StatusBadRequest, "DID is required")
+		return
+	}
+
+	parsedDID, err := parseDID(did)
+	var syntaxErr *DIDSyntaxError
+	if errors.As(err, &syntaxErr) {
+		p.sendErrorCode(w, http.StatusBadRequest, syntaxErr.Code, fmt.Sprintf("failed to parse DID: %v", err))
+		return
+	}
+
+	status, err := p.checkDIDStatus(r.Context(), parsedDID)
+	if errors.Is(err, errRepoNotMapped) {
+		p.sendErrorCode(w, http.StatusUnprocessableEntity, errCodeRepoNotMapped, err.Error())
+		return
+	}
+	if errors.Is(err, errBranchNotMapped) {
+		p.sendErrorCode(w, http.StatusUnprocessableEntity, errCodeBranchNotMapped, err.Error())
+		return
+	}
+	if err != nil {
+		status.Error = err.Error()
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func (p *DIDProcessor) handleProcessDIDs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		p.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req DIDsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.sendBodyError(w, err)
+		return
+	}
+
+	if len(req.DIDs) == 0 {
+		p.sendError(w, http.StatusBadRequest, "At least one DID is required")
+		return
+	}
+
+	if p.config.MaxDIDs > 0 && len(req.DIDs) > p.config.MaxDIDs {
+		p.sendError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Too many DIDs: got %d, maximum is %d", len(req.DIDs), p.config.MaxDIDs))
+		return
+	}
+
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			p.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	opts := p.processOptions(r, req.CallbackURL)
+	if acceptsNDJSON(r) {
+		p.streamProcessDIDs(w, r, req.DIDs, opts)
+		return
+	}
+
+	// Process all DIDs concurrently so they land in the same git batch
+	results := make([]DIDResult, len(req.DIDs))
+	var wg sync.WaitGroup
+	for i, did := range req.DIDs {
+		wg.Add(1)
+		go func(i int, did string) {
+			defer wg.Done()
+			results[i] = p.processListedDID(r.Context(), did, opts)
+		}(i, did)
+	}
+	wg.Wait()
+
+	response, status := summarizeDIDResults(results)
+	if response.queueFull > 0 {
+		p.setRetryAfter(w)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// processListedDID processes one DID of a /process-dids request and
+// describes the outcome
+func (p *DIDProcessor) processListedDID(ctx context.Context, did string, opts ProcessOptions) DIDResult {
+	didResult := DIDResult{DID: did, Success: true}
+	if did == "" {
+		didResult.Success = false
+		didResult.Error = "DID is required"
+		return didResult
+	}
+	result, err := p.processDID(ctx, did, opts)
+	if err != nil {
+		loggerFromContext(ctx).Error("Failed to process DID", "did", did, "error", err)
+		didResult.Success = false
+		didResult.Error = err.Error()
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			didResult.ValidationErrors = validationErr.Problems
+			didResult.MethodErrors = validationErr.Methods
+		}
+		_, didResult.Code = errorStatus(err)
+		var contextErr *ContextError
+		if errors.As(err, &contextErr) {
+			didResult.MissingContexts = contextErr.Missing
+		}
+		didResult.RolledBack = errors.Is(err, errBatchRolledBack)
+		return didResult
+	}
+	didResult.HostVerification = result.HostVerification
+	didResult.Unchanged = result.Unchanged
+	didResult.Change = result.Change
+	didResult.Diff = result.Diff
+	didResult.ValidationErrors = result.ValidationErrors
+	didResult.MethodErrors = result.MethodErrors
+	didResult.Warnings = result.Warnings
+	didResult.MissingContexts = result.MissingContexts
+	didResult.JobID = result.JobID
+	didResult.Published = p.publication(result)
+	return didResult
+}
+
+// summarizeDIDResults counts a /process-dids request's results and picks its
+// status: 200 when everything succeeded, 207 on partial failure, 500 when
+// nothing succeeded
+func summarizeDIDResults(results []DIDResult) (DIDsResponse, int) {
+	response := DIDsResponse{Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+		if result.Code == errCodeQueueFull {
+			response.queueFull++
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case response.Failed == 0:
+		response.Success = true
+		response.Message = "All DID documents processed successfully"
+	case response.Succeeded > 0:
+		status = http.StatusMultiStatus
+		response.Message = fmt.Sprintf("%d of %d DID documents failed", response.Failed, len(results))
+	case response.queueFull == response.Failed:
+		// Nothing failed except for the full queue, so the whole request can be retried
+		status = http.StatusServiceUnavailable
+		response.Message = "Batch queue is full, no DID documents were processed"
+	default:
+		status = http.StatusInternalServerError
+		response.Message = "No DID documents were processed successfully"
+	}
+	return response, status
+}
+
+// processOptions reads the per-request processing options from the query string
+func (p *DIDProcessor) processOptions(r *http.Request, callbackURL string) ProcessOptions {
+	// ?strictContext=true|false overrides STRICT_CONTEXT for this request
+	strictContext := p.config.StrictContext
+	if value := r.URL.Query().Get("strictContext"); value != "" {
+		strictContext = value == "true"
+	}
+	return ProcessOptions{
+		Force:         r.URL.Query().Get("force") == "true",
+		StrictContext: strictContext,
+		CallbackURL:   callbackURL,
+		Async:         p.config.AsyncMode || r.URL.Query().Get("async") == "true",
+		ValidateOnly:  r.URL.Query().Get("validateOnly") == "true",
+	}
+}
+
+// publication describes where a successfully processed document is published
+func (p *DIDProcessor) publication(result ProcessResult) *Publication {
+	if result.TargetFile == "" {
+		return nil
+	}
+	publication := &Publication{
+		URL:        result.PublishedURL,
+		TargetFile: filepath.ToSlash(result.TargetFile),
+		Branch:     result.Branch,
+		Sha256:     result.Sha256,
+	}
+	if result.Commit != "" {
+		publication.Commit = &result.Commit
+		publication.Signed = p.config.GitSigning != signingOff
+		publication.Verification = result.Verification
+	}
+	return publication
+}
+
+// errorStatus maps an error from processing or removing a DID to the HTTP
+// status and machine-readable code it is reported with; the code is empty
+// when there is none. Errors wrapping several sentinels, such as a rejected
+// batch item outside the repository, take the first that matches.
+func errorStatus(err error) (int, string) {
+	var syntaxErr *DIDSyntaxError
+	var contextErr *ContextError
+	var validationErr *ValidationError
+	switch {
+	case errors.Is(err, errDIDNotFound):
+		return http.StatusNotFound, ""
+	case errors.As(err, &syntaxErr):
+		return http.StatusBadRequest, syntaxErr.Code
+	case errors.Is(err, errTargetOutsideRepo):
+		return http.StatusBadRequest, errCodeTargetOutsideRepo
+	case errors.As(err, &contextErr):
+		return http.StatusUnprocessableEntity, errCodeMissingContext
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity, ""
+	case errors.Is(err, errIDMismatch):
+		return http.StatusUnprocessableEntity, errCodeIDMismatch
+	case errors.Is(err, errRepoNotMapped):
+		return http.StatusUnprocessableEntity, errCodeRepoNotMapped
+	case errors.Is(err, errBranchNotMapped):
+		return http.StatusUnprocessableEntity, errCodeBranchNotMapped
+	case errors.Is(err, errInvalidDomainLinkage):
+		return http.StatusUnprocessableEntity, errCodeInvalidDomainLinkage
+	case errors.Is(err, errTargetConflict):
+		return http.StatusConflict, errCodeTargetConflict
+	case errors.Is(err, errBatchItemRejected):
+		return http.StatusUnprocessableEntity, errCodeItemRejected
+	case errors.Is(err, errContentMismatch):
+		return http.StatusInternalServerError, errCodeContentMismatch
+	case errors.Is(err, errQueueFull):
+		return http.StatusServiceUnavailable, errCodeQueueFull
+	case errors.Is(err, errBatchRolledBack) && errors.Is(err, errGitTimeout):
+		return http.StatusServiceUnavailable, errCodeGitTimeout
+	case errors.Is(err, errBatchRolledBack):
+		return http.StatusServiceUnavailable, ""
+	case errors.Is(err, errGitTimeout):
+		return http.StatusInternalServerError, errCodeGitTimeout
+	default:
+		return http.StatusInternalServerError, ""
+	}
+}
+
 func (p *DIDProcessor) sendError(w http.ResponseWriter, status int, message string) {
+	p.sendErrorCode(w, status, "", message)
+}
+
+// sendErrorCode sends an error response with a machine-readable code
+func (p *DIDProcessor) sendErrorCode(w http.ResponseWriter, status int, code, message string) {
 	w.WriteHeader(status)
 	response := DIDResponse{
 		Success: false,
 		Error:   message,
+		Code:    code,
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
-func (p *DIDProcessor) processDID(did string) error {
+// sendBodyError reports a request body that could not be read or decoded
+func (p *DIDProcessor) sendBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		p.sendErrorCode(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge,
+			fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+		return
+	}
+	p.sendErrorCode(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON request")
+}
+
+// limitBody caps the request body at MAX_BODY_BYTES; reads past the cap fail
+// with *http.MaxBytesError
+func (p *DIDProcessor) limitBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, p.config.MaxBodyBytes)
+		next(w, r)
+	}
+}
+
+// processDID fetches, saves and publishes the document for a DID. Unless
+// opts.Force is set, a document identical to the published one is neither
+// rewritten nor committed.
+func (p *DIDProcessor) processDID(ctx context.Context, did string, opts ProcessOptions) (ProcessResult, error) {
+	var result ProcessResult
+	logger := loggerFromContext(ctx).With("did", did)
+
 	// Parse DID
+	_, span := tracer.Start(ctx, "parse DID", trace.WithAttributes(attribute.String("did", did)))
 	parsedDID, err := parseDID(did)
 	if err != nil {
-		return fmt.Errorf("failed to parse DID: %w", err)
+		endSpan(span, err)
+		return result, fmt.Errorf("failed to parse DID: %w", err)
+	}
+	if warning := parsedDID.hostCaseWarning(); warning != "" {
+		logger.Warn("DID host is not lower case", "host", parsedDID.Host)
+		result.Warnings = append(result.Warnings, warning)
 	}
 
 	// Validate host
-	if !strings.HasSuffix(strings.ToLower(parsedDID.Host), ".github.io") {
-		return fmt.Errorf("host '%s' is not a github.io host", parsedDID.Host)
+	err = p.validateHost(parsedDID)
+	endSpan(span, err)
+	if err != nil {
+		return result, err
+	}
+	repo, err := p.repoFor(parsedDID)
+	if err != nil {
+		return result, err
+	}
+	verification, err := p.verifyHost(ctx, repo, parsedDID)
+	if err != nil {
+		return result, fmt.Errorf("host verification failed: %w", err)
 	}
+	result.HostVerification = verification.Method
 
-	// Build fetch URL
+	// Determine target file path
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return result, fmt.Errorf("failed to prepare publishing directory: %w", err)
+	}
+	targetFile, err := p.determineTargetFile(root, parsedDID)
+	if err != nil {
+		return result, err
+	}
+	localPath := filepath.Join(root, targetFile)
+	logger.Info("Resolved target file", "target_file", targetFile)
+	result.TargetFile = targetFile
+	result.Branch = repo.Branch
+	result.PublishedURL = p.buildPublishedURL(parsedDID)
+
+	// Use the document from the request (push mode), or fetch it upstream
+	didDoc := []byte(opts.Document)
 	fetchURL := p.buildFetchURL(parsedDID)
-	log.Printf("Fetching DID document from: %s", fetchURL)
+	var fetched fetchResult
+	if didDoc != nil {
+		logger.Info("Using DID document supplied in the request")
+	} else {
+		// Only ask for changes when a 304 may skip the publish: never when
+		// forced, and never in dry-run or validate-only mode, which report
+		// the full diff
+		var cached *fetchCacheEntry
+		if p.fetchCache != nil && !opts.Force && !p.config.DryRun && !opts.ValidateOnly {
+			cached = p.fetchCache.lookup(fetchURL, localPath)
+		}
+		logger.Info("Fetching DID document", "url", fetchURL, "conditional", cached != nil)
+
+		var attempts int
+		fetchCtx, span := tracer.Start(ctx, "fetch DID document", trace.WithAttributes(
+			attribute.String("url.full", fetchURL),
+			attribute.Bool("fetch.conditional", cached != nil),
+		))
+		fetched, attempts, err = p.fetchDIDDocument(fetchCtx, fetchURL, parsedDID.Host, cached)
+		span.SetAttributes(attribute.Int("fetch.attempts", attempts))
+		if fetched.Status != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", fetched.Status))
+		}
+		endSpan(span, err)
+		result.FetchAttempts = attempts
+		if err != nil {
+			return result, fmt.Errorf("failed to fetch DID document: %w", err)
+		}
+		if fetched.Status == http.StatusNotModified {
+			logger.Info("DID document not modified upstream, skipping publish", "target_file", targetFile)
+			FetchNotModifiedTotal.Inc()
+			result.Unchanged = true
+			return result, nil
+		}
+		didDoc = fetched.Body
+	}
+	reportProgress(ctx, stageFetched)
 
-	// Fetch DID document
-	didDoc, err := p.fetchDIDDocument(fetchURL, parsedDID.Host)
-	if err != nil {
-		return fmt.Errorf("failed to fetch DID document: %w", err)
+	formatted := p.formatDIDDocument(ctx, didDoc, targetFile)
+
+	// Remember the fetch's validators once its document is known to be
+	// published. An async job may still fail, so its document is only cached
+	// when a later run finds it unchanged.
+	cacheFetch := func() {
+		if p.fetchCache != nil && opts.Document == nil && !p.config.DryRun {
+			p.fetchCache.store(fetchURL, fetched, formatted)
+		}
 	}
 
-	// Determine target file path
-	targetFile := p.determineTargetFile(parsedDID)
-	log.Printf("Target file: %s", targetFile)
+	// A supplied document must belong to the DID even without STRICT_VALIDATION,
+	// since nothing upstream vouches for it
+	if opts.Document != nil {
+		if err := checkDocumentID(formatted, parsedDID); err != nil {
+			return result, err
+		}
+	}
 
-	// Save DID document
-	if err := p.saveDIDDocument(didDoc, targetFile); err != nil {
-		return fmt.Errorf("failed to save DID document: %w", err)
+	// Check required JSON-LD contexts
+	if missing := missingContexts(formatted, p.config.RequiredContexts); len(missing) > 0 {
+		if opts.StrictContext {
+			return result, &ContextError{Missing: missing}
+		}
+		logger.Warn("DID document is missing required contexts", "missing_contexts", missing)
+		result.MissingContexts = missing
 	}
 
-	// Validate DID document ID
-	if err := p.validateDIDDocumentID(targetFile, parsedDID); err != nil {
-		log.Printf("Warning: %v", err)
+	// Validate against the DID Core structure
+	if problems, methods := validateDIDDocument(formatted, parsedDID, p.config.MethodTypes); len(problems) > 0 {
+		if p.config.StrictValidation {
+			return result, &ValidationError{Problems: problems, Methods: methods}
+		}
+		logger.Warn("DID document failed validation", "problems", problems)
+		result.ValidationErrors = problems
+		result.MethodErrors = methods
+	}
+	result.Sha256 = sha256Hex(formatted)
+
+	if opts.ValidateOnly {
+		// Like a dry run, but regardless of DRY_RUN and WRITE_ON_DRY_RUN, and
+		// a supplied domain linkage credential is checked too
+		if opts.DomainLinkage != nil {
+			if err := checkDomainLinkage(opts.DomainLinkage, parsedDID); err != nil {
+				return result, err
+			}
+		}
+		if err := p.diffDIDDocument(&result, formatted, localPath, targetFile); err != nil {
+			return result, fmt.Errorf("failed to diff DID document: %w", err)
+		}
+		logger.Info("Validate only: skipping write and git operations", "change", result.Change)
+		return result, nil
 	}
 
-	// Git operations (batched)
+	if p.config.DryRun {
+		// Report what would change before anything is written
+		if err := p.diffDIDDocument(&result, formatted, localPath, targetFile); err != nil {
+			return result, fmt.Errorf("failed to diff DID document: %w", err)
+		}
+		logger.Info("Dry run: skipping git operations", "change", result.Change)
+		if !p.config.WriteOnDryRun {
+			return result, nil
+		}
+	}
+
+	// Skip re-publishing a document that hasn't changed, unless it brings a
+	// new domain linkage credential
+	if !opts.Force && opts.DomainLinkage == nil && p.isUnchanged(formatted, localPath) {
+		logger.Info("DID document unchanged, skipping publish", "target_file", targetFile)
+		cacheFetch()
+		result.Unchanged = true
+		// Only the layout can differ, so report the bytes already published
+		if existing, err := os.ReadFile(localPath); err == nil {
+			result.Sha256 = sha256Hex(existing)
+		}
+		return result, nil
+	}
+
+	// Obtained before anything is written, so a failure leaves nothing behind
+	var linkage json.RawMessage
 	if !p.config.DryRun {
-		if err := p.batchGitOperation(targetFile, parsedDID); err != nil {
-			return fmt.Errorf("git operations failed: %w", err)
+		if linkage, err = p.domainLinkage(ctx, parsedDID, opts.DomainLinkage); err != nil {
+			return result, err
 		}
-	} else {
-		log.Println("Dry run: skipping git operations")
 	}
 
+	// Reserve the target file so another DID in the same batch can't overwrite it
+	var previous pendingFiles
+	if !p.config.DryRun {
+		if p.queueFull() {
+			return result, p.refuseQueueFull()
+		}
+		if err := repo.claims.claim(root, targetFile, parsedDID.expectedID(), formatted); err != nil {
+			return result, err
+		}
+		// Kept to undo the write if the queue fills up before the item is sent
+		if previous, err = savePendingFiles(root, []string{targetFile}); err != nil {
+			repo.claims.release(targetFile)
+			return result, err
+		}
+	}
+
+	// Save DID document
+	_, span = tracer.Start(ctx, "save DID document", trace.WithAttributes(attribute.String("target_file", targetFile)))
+	err = p.saveDIDDocument(formatted, localPath)
+	endSpan(span, err)
+	if err != nil {
+		if !p.config.DryRun {
+			repo.claims.release(targetFile)
+		}
+		return result, fmt.Errorf("failed to save DID document: %w", err)
+	}
+	reportProgress(ctx, stageWritten)
+
+	// Git operations (batched)
+	switch {
+	case p.config.DryRun:
+	case opts.Async:
+		jobID, err := p.queueGitOperation(ctx, repo, targetFile, parsedDID, verification, opts.CallbackURL, formatted, linkage, previous)
+		if err != nil {
+			p.undoWrite(root, previous, err)
+			return result, fmt.Errorf("git operations failed: %w", err)
+		}
+		logger.Info("Queued async job", "job_id", jobID)
+		result.JobID = jobID
+	default:
+		batchResult, err := p.batchGitOperation(ctx, repo, targetFile, parsedDID, verification, opts.CallbackURL, formatted, linkage, previous)
+		if err != nil {
+			p.undoWrite(root, previous, err)
+			return result, fmt.Errorf("git operations failed: %w", err)
+		}
+		result.Commit = batchResult.Commit
+		cacheFetch()
+		if batchResult.Check != nil {
+			// Report whatever is known after a short wait; the check keeps running
+			result.Verification = batchResult.Check.wait(p.config.VerifyPublishWait)
+		}
+	}
+
+	return result, nil
+}
+
+// isUnchanged reports whether the file at localPath already holds the same
+// JSON document, ignoring key order and insignificant whitespace
+func (p *DIDProcessor) isUnchanged(formatted []byte, localPath string) bool {
+	existing, err := os.ReadFile(localPath)
+	if err != nil {
+		return false
+	}
+	existingNormalized, err := normalizeJSON(existing)
+	if err != nil {
+		return false
+	}
+	fetchedNormalized, err := normalizeJSON(formatted)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(existingNormalized, fetchedNormalized)
+}
+
+// diffDIDDocument records how the formatted document differs from the
+// existing file at localPath, if any; targetFile names it in the diff
+func (p *DIDProcessor) diffDIDDocument(result *ProcessResult, formatted []byte, localPath, targetFile string) error {
+	existing, err := os.ReadFile(localPath)
+	if os.IsNotExist(err) {
+		result.Change = changeAdded
+		result.Diff = unifiedDiff("/dev/null", "b/"+targetFile, "", string(formatted))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	result.Diff = unifiedDiff("a/"+targetFile, "b/"+targetFile, string(existing), string(formatted))
+	if result.Diff == "" {
+		result.Change = changeUnchanged
+	} else {
+		result.Change = changeChanged
+	}
 	return nil
 }
 
-// batchGitOperation adds the file to the batch queue and waits for completion
-func (p *DIDProcessor) batchGitOperation(targetFile string, parsedDID *ParsedDID) error {
-	responseCh := make(chan error, 1)
+// checkDIDStatus compares the locally published document for a DID with the
+// document currently served by the upstream server.
+func (p *DIDProcessor) checkDIDStatus(ctx context.Context, parsedDID *ParsedDID) (DIDStatusResponse, error) {
+	status := DIDStatusResponse{
+		DID:          parsedDID.Original,
+		PublishedURL: p.buildPublishedURL(parsedDID),
+	}
+	repo, err := p.repoFor(parsedDID)
+	if err != nil {
+		return status, err
+	}
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return status, fmt.Errorf("failed to prepare publishing directory: %w", err)
+	}
+	targetFile, err := p.determineTargetFile(root, parsedDID)
+	if err != nil {
+		return status, err
+	}
+	status.TargetFile = targetFile
+
+	var localNormalized []byte
+	if data, err := os.ReadFile(filepath.Join(root, targetFile)); err == nil {
+		status.Exists = true
+		localNormalized, err = normalizeJSON(data)
+		if err != nil {
+			loggerFromContext(ctx).Warn("Local document is not valid JSON", "target_file", targetFile, "error", err)
+			localNormalized = data
+		}
+		status.LocalSha256 = sha256Hex(localNormalized)
+	} else if !os.IsNotExist(err) {
+		return status, fmt.Errorf("failed to read local DID document: %w", err)
+	}
 
-	batchItem := BatchItem{
-		TargetFile: targetFile,
-		ParsedDID:  parsedDID,
-		ResponseCh: responseCh,
+	remote, _, err := p.fetchDIDDocument(ctx, p.buildFetchURL(parsedDID), parsedDID.Host, nil)
+	if err != nil {
+		return status, fmt.Errorf("failed to fetch DID document: %w", err)
+	}
+	remoteNormalized, err := normalizeJSON(remote.Body)
+	if err != nil {
+		return status, fmt.Errorf("remote DID document is not valid JSON: %w", err)
+	}
+	status.RemoteSha256 = sha256Hex(remoteNormalized)
+	status.InSync = status.Exists && status.LocalSha256 == status.RemoteSha256
+
+	return status, nil
+}
+
+// removeDID deletes the published document for a DID and commits the removal.
+// It returns the target file that was (or in dry-run mode would be) removed.
+func (p *DIDProcessor) removeDID(ctx context.Context, did, callbackURL string) (string, error) {
+	logger := loggerFromContext(ctx).With("did", did)
+	parsedDID, err := parseDID(did)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse DID: %w", err)
+	}
+
+	if err := p.validateHost(parsedDID); err != nil {
+		return "", err
 	}
+	repo, err := p.repoFor(parsedDID)
+	if err != nil {
+		return "", err
+	}
+	verification, err := p.verifyHost(ctx, repo, parsedDID)
+	if err != nil {
+		return "", fmt.Errorf("host verification failed: %w", err)
+	}
+
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare publishing directory: %w", err)
+	}
+	targetFile, err := p.determineTargetFile(root, parsedDID)
+	if err != nil {
+		return "", err
+	}
+	localPath := filepath.Join(root, targetFile)
+	logger.Info("Resolved target file", "target_file", targetFile)
+
+	if _, err := os.Stat(localPath); err != nil {
+		if os.IsNotExist(err) {
+			return targetFile, fmt.Errorf("%w: %s", errDIDNotFound, targetFile)
+		}
+		return targetFile, fmt.Errorf("failed to stat DID document: %w", err)
+	}
+
+	if p.config.DryRun {
+		logger.Info("Dry run: would remove DID document", "target_file", targetFile)
+		return targetFile, nil
+	}
+
+	if p.queueFull() {
+		return targetFile, p.refuseQueueFull()
+	}
+	if err := repo.claims.claim(root, targetFile, parsedDID.expectedID(), nil); err != nil {
+		return targetFile, err
+	}
+	previous, err := savePendingFiles(root, []string{targetFile})
+	if err != nil {
+		repo.claims.release(targetFile)
+		return targetFile, err
+	}
+	if err := os.Remove(localPath); err != nil {
+		repo.claims.release(targetFile)
+		return targetFile, fmt.Errorf("failed to remove DID document: %w", err)
+	}
+
+	if _, err := p.enqueueBatchItem(ctx, BatchItem{
+		TargetFile:       targetFile,
+		ParsedDID:        parsedDID,
+		Repo:             repo,
+		HostVerification: verification,
+		Remove:           true,
+		RequestID:        requestIDFromContext(ctx),
+		Actor:            actorFromContext(ctx),
+		CallbackURL:      callbackURL,
+		Claimed:          true,
+		Previous:         previous,
+	}); err != nil {
+		p.undoWrite(root, previous, err)
+		return targetFile, fmt.Errorf("git operations failed: %w", err)
+	}
+
+	return targetFile, nil
+}
+
+// batchGitOperation adds the file to the batch queue, waits for completion
+// and returns the batch result for it. previous is the file before it was
+// written, restored if the item is abandoned.
+func (p *DIDProcessor) batchGitOperation(ctx context.Context, repo *publishRepo, targetFile string, parsedDID *ParsedDID, verification HostVerification, callbackURL string, document, linkage []byte, previous pendingFiles) (BatchResult, error) {
+	return p.enqueueBatchItem(ctx, BatchItem{
+		TargetFile:       targetFile,
+		ParsedDID:        parsedDID,
+		Repo:             repo,
+		HostVerification: verification,
+		RequestID:        requestIDFromContext(ctx),
+		Actor:            actorFromContext(ctx),
+		CallbackURL:      callbackURL,
+		Claimed:          true,
+		Previous:         previous,
+		Document:         document,
+		DomainLinkage:    linkage,
+	})
+}
+
+// queueGitOperation adds the file to the batch queue under a new async job
+// and returns the job ID without waiting for the batch
+func (p *DIDProcessor) queueGitOperation(ctx context.Context, repo *publishRepo, targetFile string, parsedDID *ParsedDID, verification HostVerification, callbackURL string, document, linkage []byte, previous pendingFiles) (string, error) {
+	return p.queueBatchItem(ctx, BatchItem{
+		TargetFile:       targetFile,
+		ParsedDID:        parsedDID,
+		Repo:             repo,
+		HostVerification: verification,
+		RequestID:        requestIDFromContext(ctx),
+		Actor:            actorFromContext(ctx),
+		CallbackURL:      callbackURL,
+		Claimed:          true,
+		Previous:         previous,
+		Document:         document,
+		DomainLinkage:    linkage,
+	})
+}
 
-	// Send to batch processor
+// queueBatchItem sends a claimed item to the batch processor under a new
+// async job and returns the job ID. The claim is released if it can't be sent.
+func (p *DIDProcessor) queueBatchItem(ctx context.Context, batchItem BatchItem) (string, error) {
+	jobID := p.jobs.create(batchItem.ParsedDID.Original, batchItem.TargetFile)
+	batchItem.JobID = jobID
+	batchItem.SpanContext = trace.SpanContextFromContext(ctx)
+	batchItem.EnqueuedAt = time.Now()
+	if err := p.journalItem(&batchItem); err != nil {
+		p.jobs.complete(jobID, "", err)
+		batchItem.Repo.claims.release(batchItem.TargetFile)
+		return "", err
+	}
+
+	// The item outlives the request, so it carries no context and is never abandoned
+	select {
+	case p.batchCh <- batchItem:
+		reportProgress(ctx, stageQueued)
+		return jobID, nil
+	default:
+		err := p.refuseQueueFull()
+		p.jobs.complete(jobID, "", err)
+		batchItem.Repo.claims.release(batchItem.TargetFile)
+		p.forgetItems([]BatchItem{batchItem})
+		return "", err
+	}
+}
+
+// queueFull reports whether the batch queue is at QUEUE_CAPACITY. It is
+// checked before anything is written, and the send itself never blocks, so
+// a request that loses the race is still refused.
+func (p *DIDProcessor) queueFull() bool {
+	return len(p.batchCh) >= cap(p.batchCh)
+}
+
+// refuseQueueFull counts a request refused because the queue is full
+func (p *DIDProcessor) refuseQueueFull() error {
+	QueueFullTotal.Inc()
+	slog.Warn("🚦 Batch queue full, refusing item", "capacity", cap(p.batchCh))
+	return errQueueFull
+}
+
+// undoWrite restores a target file written for an item the queue refused,
+// so the working tree doesn't drift from what's committed
+func (p *DIDProcessor) undoWrite(root string, previous pendingFiles, err error) {
+	if !errors.Is(err, errQueueFull) {
+		return
+	}
+	if restoreErr := previous.restore(root); restoreErr != nil {
+		slog.Error("Failed to restore target file after a full queue", "error", restoreErr)
+	}
+}
+
+// restoreAbandoned puts back the target file an abandoned item wrote, so the
+// next batch doesn't commit a document nobody is waiting for
+func (p *DIDProcessor) restoreAbandoned(item BatchItem) {
+	if item.Previous == nil {
+		return
+	}
+	root, err := item.Repo.git.WorkDir()
+	if err == nil {
+		err = item.Previous.restore(root)
+	}
+	if err != nil {
+		loggerForRequest(item.RequestID).Error("Failed to restore target file of an abandoned batch item",
+			"target_file", item.TargetFile, "error", err)
+	}
+}
+
+// setRetryAfter tells a client refused by a full queue to retry once the
+// next batch has had time to flush
+func (p *DIDProcessor) setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(p.config.BatchTimeout.Seconds())))))
+}
+
+// enqueueBatchItem sends an item to the batch processor and waits for its
+// result, giving up when ctx is cancelled or BatchWait elapses. A full queue
+// is reported as errQueueFull straight away.
+func (p *DIDProcessor) enqueueBatchItem(ctx context.Context, batchItem BatchItem) (result BatchResult, err error) {
+	ctx, span := tracer.Start(ctx, "queue wait", trace.WithAttributes(attribute.String("target_file", batchItem.TargetFile)))
+	defer func() { endSpan(span, err) }()
+	batchItem.SpanContext = span.SpanContext()
+
+	// Buffered so the batch processor never blocks on an abandoned item
+	responseCh := make(chan BatchResult, 1)
+	batchItem.ResponseCh = responseCh
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.BatchWait)
+	defer cancel()
+	batchItem.Ctx = ctx
+	batchItem.EnqueuedAt = time.Now()
+	if err := p.journalItem(&batchItem); err != nil {
+		if batchItem.Claimed {
+			batchItem.Repo.claims.release(batchItem.TargetFile)
+		}
+		return BatchResult{}, err
+	}
+
+	// Send to batch processor, refusing rather than waiting when it's backed up
 	select {
 	case p.batchCh <- batchItem:
-		// Wait for response
-		return <-responseCh
-	case <-time.After(30 * time.Second):
-		return fmt.Errorf("timeout waiting for git batch processor")
+		reportProgress(ctx, stageQueued)
+	default:
+		if batchItem.Claimed {
+			batchItem.Repo.claims.release(batchItem.TargetFile)
+		}
+		p.forgetItems([]BatchItem{batchItem})
+		return BatchResult{}, p.refuseQueueFull()
+	}
+
+	// Wait for response
+	select {
+	case result = <-responseCh:
+		return result, result.Err
+	case <-ctx.Done():
+		return BatchResult{}, fmt.Errorf("abandoned waiting for git batch: %w", ctx.Err())
 	}
 }
 
@@ -227,31 +1892,185 @@ func (p *DIDProcessor) batchGitOperation(targetFile string, parsedDID *ParsedDID
 func (p *DIDProcessor) gitBatchProcessor() {
 	defer p.batchWG.Done()
 
-	var batch []BatchItem
-	ticker := time.NewTicker(p.config.BatchTimeout)
-	defer ticker.Stop()
+	var batch []BatchItem
+	ticker := time.NewTicker(p.config.BatchTimeout)
+	defer ticker.Stop()
+
+	processBatch := func() {
+		// Drop items whose request has already gone away
+		live := batch[:0]
+		abandoned := 0
+		var audit []AuditRecord
+		var dropped []BatchItem
+		for _, item := range batch {
+			if item.Ctx != nil && item.Ctx.Err() != nil {
+				abandoned++
+				BatchItemsAbandonedTotal.Inc()
+				loggerForRequest(item.RequestID).Warn("Dropping abandoned batch item",
+					"target_file", item.TargetFile, "error", item.Ctx.Err())
+				abandonErr := fmt.Errorf("abandoned before commit: %w", item.Ctx.Err())
+				p.notifyWebhook(item, "", abandonErr)
+				audit = append(audit, newAuditRecord(item, "", auditAbandoned, abandonErr))
+				dropped = append(dropped, item)
+				if item.Claimed {
+					item.Repo.claims.abandon(item.TargetFile, func() { p.restoreAbandoned(item) })
+				}
+				continue
+			}
+			live = append(live, item)
+		}
+		batch = live
+		if len(batch) == 0 {
+			p.recordAudit(audit)
+			p.forgetItems(dropped)
+			p.pending.finish(nil, nil, abandoned)
+			p.recordPublishStats(nil, nil, dropped, 0)
+			return
+		}
+		p.pending.update(batch, true)
+
+		requestIDs := make([]string, 0, len(batch))
+		for _, item := range batch {
+			requestIDs = append(requestIDs, item.RequestID)
+		}
+		logger := slog.Default().With("batch_size", len(batch), "request_ids", requestIDs)
+		logger.Info("Processing git batch")
+
+		// The flush gets its own trace, linked to the request of every item in it
+		links := make([]trace.Link, 0, len(batch))
+		for _, item := range batch {
+			if item.SpanContext.IsValid() {
+				links = append(links, trace.Link{SpanContext: item.SpanContext})
+			}
+		}
+		flushCtx, flushSpan := tracer.Start(context.Background(), "git batch flush",
+			trace.WithNewRoot(), trace.WithLinks(links...),
+			trace.WithAttributes(attribute.Int("batch.size", len(batch))))
+
+		// Each repository gets its own commit and push, run side by side
+		flushStart := time.Now()
+		commits := make([]string, len(batch))
+		itemErrs := make([]error, len(batch))
+		batchErrs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for repo, indexes := range groupByRepo(batch) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				items := make([]BatchItem, len(indexes))
+				for j, i := range indexes {
+					items[j] = batch[i]
+				}
+				_, span := tracer.Start(flushCtx, "git commit and push", trace.WithAttributes(
+					attribute.String("repo", repo.name()),
+					attribute.Int("batch.size", len(items)),
+				))
+				commit, errs, err := p.performBatchedGitOperations(repo, items)
+				span.SetAttributes(attribute.String("commit", commit))
+				endSpan(span, err)
+				if errors.Is(err, errRepoLocked) {
+					logger.Warn("Repository locked by another process, retrying batch on the next tick", "repo", repo.name(), "error", err)
+				} else if err != nil {
+					logger.Error("Git batch failed", "repo", repo.name(), "error", err)
+				}
+				for j, i := range indexes {
+					commits[i], itemErrs[i], batchErrs[i] = commit, errs[j], err
+				}
+			}()
+		}
+		wg.Wait()
+		flushDuration := time.Since(flushStart)
+
+		results := make([]BatchResult, 0, len(batch))
+		var handled, retry []BatchItem
+		for i, item := range batch {
+			// Another process held the repository; nothing was touched, so
+			// the item keeps its claim and waits for the next flush
+			if errors.Is(batchErrs[i], errRepoLocked) {
+				retry = append(retry, item)
+				continue
+			}
+			handled = append(handled, item)
+
+			if item.Claimed {
+				item.Repo.claims.release(item.TargetFile)
+			}
+
+			// Rejected items carry their own error; the rest share their repository's outcome
+			result := BatchResult{Commit: commits[i], Err: batchErrs[i]}
+			if itemErrs[i] != nil {
+				result = BatchResult{Err: itemErrs[i]}
+			}
+			if result.Err != nil {
+				result.Commit = ""
+				loggerForRequest(item.RequestID).Error("Git batch failed for item",
+					"target_file", item.TargetFile, "error", result.Err)
+			}
+
+			// Items rejected for their own content would fail again, so only
+			// a failed commit or push is kept for retry, along with retries
+			// that failed once more
+			switch {
+			case result.Err == nil:
+				p.recordCommitted(item)
+			case itemErrs[i] == nil || item.DeadLetterID != "":
+				p.recordFailure(item, result.Err)
+			}
 
-	processBatch := func() {
-		if len(batch) == 0 {
-			return
-		}
+			outcome := auditCommitted
+			if result.Err != nil {
+				outcome = auditFailed
+			}
+			audit = append(audit, newAuditRecord(item, result.Commit, outcome, result.Err))
 
-		log.Printf("Processing git batch with %d items", len(batch))
+			p.notifyWebhook(item, result.Commit, result.Err)
+			if item.JobID != "" {
+				p.jobs.complete(item.JobID, result.Commit, result.Err)
+			}
+			if result.Err == nil && p.config.VerifyPublish && !item.Remove {
+				result.Check = p.startPublishCheck(item)
+			}
 
-		// Process the batch
-		err := p.performBatchedGitOperations(batch)
+			results = append(results, result)
 
-		// Send results back to all waiting requests
-		for _, item := range batch {
+			// The buffered channel never blocks, even if the request stopped waiting
 			select {
-			case item.ResponseCh <- err:
+			case item.ResponseCh <- result:
 			default:
-				// Channel might be closed if request timed out
 			}
 		}
 
-		// Clear the batch
-		batch = batch[:0]
+		// Only a repository's commit or push failing counts against /healthz,
+		// not items rejected for their own content
+		if len(handled) > 0 {
+			gitFailed := false
+			for _, err := range batchErrs {
+				if err != nil && !errors.Is(err, errRepoLocked) {
+					gitFailed = true
+				}
+			}
+			p.pending.recordFlush(gitFailed)
+		}
+		var flushErr error
+		for _, err := range batchErrs {
+			if err != nil {
+				flushErr = err
+				break
+			}
+		}
+		endSpan(flushSpan, flushErr)
+
+		p.recordAudit(audit)
+		// Handled one way or another: committed, rolled back or abandoned
+		p.forgetItems(append(dropped, handled...))
+
+		// Clear the batch, keeping only items postponed by a locked repository
+		p.pending.finish(handled, results, abandoned)
+		p.recordPublishStats(handled, results, dropped, flushDuration)
+		batch = append(batch[:0], retry...)
+		if len(batch) > 0 {
+			p.pending.update(batch, false)
+		}
 		ticker.Reset(p.config.BatchTimeout)
 	}
 
@@ -265,6 +2084,7 @@ func (p *DIDProcessor) gitBatchProcessor() {
 			}
 
 			batch = append(batch, item)
+			p.pending.update(batch, false)
 
 			// Process batch if it reaches max size
 			if len(batch) >= p.config.BatchSize {
@@ -278,179 +2098,650 @@ func (p *DIDProcessor) gitBatchProcessor() {
 	}
 }
 
+// groupByRepo returns the indexes of the batch's items for each repository
+func groupByRepo(batch []BatchItem) map[*publishRepo][]int {
+	groups := make(map[*publishRepo][]int)
+	for i, item := range batch {
+		groups[item.Repo] = append(groups[item.Repo], i)
+	}
+	return groups
+}
+
 // performBatchedGitOperations performs git operations for a batch of files
-func (p *DIDProcessor) performBatchedGitOperations(batch []BatchItem) error {
+// in one repository and returns the commit the branch points at afterwards.
+// Items that fail validation are dropped and get their own error in the
+// returned slice, indexed like batch; the final error is shared by every
+// remaining item.
+func (p *DIDProcessor) performBatchedGitOperations(repo *publishRepo, batch []BatchItem) (string, []error, error) {
+	itemErrs := make([]error, len(batch))
 	if len(batch) == 0 {
-		return nil
+		return "", itemErrs, nil
 	}
 
-	// Lock git operations to prevent concurrent git commands
-	p.gitMux.Lock()
-	defer p.gitMux.Unlock()
+	// Lock git operations to prevent concurrent git commands in the repository
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	// And against other processes sharing the repository
+	unlock, err := lockRepo(repo, p.config.GitLockTimeout)
+	if err != nil {
+		return "", itemErrs, err
+	}
+	defer unlock()
 
-	log.Printf("🔒 Acquired git lock for batch of %d files", len(batch))
+	slog.Debug("🔒 Acquired git lock", "repo", repo.name(), "batch_size", len(batch))
 
-	// Validate all items in batch first
-	var validatedItems []BatchItem
-	seenHosts := make(map[string]bool)
+	// A missing remote affects every item
+	if err := p.checkGitRemote(repo); err != nil {
+		return "", itemErrs, err
+	}
+	remoteURL, err := p.getRemoteURL(repo)
+	if err != nil {
+		return "", itemErrs, err
+	}
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return "", itemErrs, err
+	}
 
-	for _, item := range batch {
-		// Check if remote exists (only once per batch)
-		if err := p.checkGitRemote(); err != nil {
-			return err
+	// Validate each item, dropping the ones that can't be published from the commit
+	var validatedItems []BatchItem
+	var rejectedFiles []string
+	for i, item := range batch {
+		if err := p.validateBatchItem(item, root, remoteURL); err != nil {
+			itemErrs[i] = fmt.Errorf("%w: %w", errBatchItemRejected, err)
+			// Never hand git a path outside the working tree to restore
+			if !errors.Is(err, errTargetOutsideRepo) {
+				rejectedFiles = append(rejectedFiles, item.TargetFile)
+			}
+			continue
 		}
+		validatedItems = append(validatedItems, item)
+	}
 
-		// Get remote URL and validate (only once per host)
-		hostKey := item.ParsedDID.HostLower
-		if !seenHosts[hostKey] {
-			remoteURL, err := p.getRemoteURL()
-			if err != nil {
-				return err
-			}
+	// Restore rejected files so they don't linger unpublished in the working
+	// tree, leaving HEAD and the accepted items' files as they are
+	if len(rejectedFiles) > 0 {
+		if err := repo.git.RestoreFiles(p.config.GitRemote, repo.Branch, rejectedFiles); err != nil {
+			slog.Error("Failed to restore rejected files", "files", rejectedFiles, "error", err)
+		} else {
+			slog.Warn("Dropped rejected items from git batch", "files", rejectedFiles)
+		}
+	}
+	if len(validatedItems) == 0 {
+		return "", itemErrs, nil
+	}
 
-			ghUser, ghRepo, err := p.parseGitHubURL(remoteURL)
-			if err != nil {
-				return err
-			}
+	// Perform batched git operations, undoing them on failure so the next
+	// batch starts from the published state. A diverged branch is left
+	// alone for an operator to resolve.
+	if err := p.executeBatchedGitCommands(repo, validatedItems); err != nil {
+		if errors.Is(err, errBranchDiverged) {
+			return "", itemErrs, err
+		}
+		files := make([]string, 0, len(validatedItems)+1)
+		for _, item := range validatedItems {
+			files = append(files, item.TargetFile)
+		}
+		if indexFile := p.indexPath(); indexFile != "" {
+			files = append(files, indexFile)
+		}
+		if configFile := p.didConfigurationPath(); configFile != "" {
+			files = append(files, configFile)
+		}
+		if rollbackErr := repo.git.Rollback(p.config.GitRemote, repo.Branch, files); rollbackErr != nil {
+			slog.Error("Failed to roll back git batch", "error", rollbackErr)
+			return "", itemErrs, fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		slog.Warn("Rolled back git batch", "files", files, "error", err)
+		return "", itemErrs, fmt.Errorf("%w: %w", errBatchRolledBack, err)
+	}
 
-			// Validate GitHub username matches expected
-			expectedUser := strings.TrimSuffix(item.ParsedDID.HostLower, ".github.io")
-			if !strings.EqualFold(ghUser, expectedUser) {
-				return fmt.Errorf("GitHub username mismatch: expected %s, got %s", expectedUser, ghUser)
-			}
+	commit, err := repo.git.HeadCommit()
+	if err != nil {
+		return "", itemErrs, err
+	}
+	p.checkCommittedDocuments(repo, batch, itemErrs, commit)
 
-			// Validate repo name matches project
-			if !strings.EqualFold(ghRepo, item.ParsedDID.Project) {
-				return fmt.Errorf("repo name mismatch: expected %s, got %s", item.ParsedDID.Project, ghRepo)
-			}
+	slog.Info("✅ Pushed batch", "repo", repo.name(), "files", len(validatedItems), "branch", repo.Branch, "commit", commit,
+		"signed", p.config.GitSigning != signingOff)
+	return commit, itemErrs, nil
+}
 
-			seenHosts[hostKey] = true
-			log.Printf("✅ Validation passed for host %s (user: %s, repo: %s)", hostKey, ghUser, ghRepo)
+// checkCommittedDocuments reads each published document back from the
+// pushed branch and fails the items whose committed bytes differ from those
+// written. Only the last item for a file is checked, since it overwrote any
+// earlier one in the batch.
+func (p *DIDProcessor) checkCommittedDocuments(repo *publishRepo, batch []BatchItem, itemErrs []error, commit string) {
+	last := make(map[string]int)
+	for i, item := range batch {
+		if itemErrs[i] == nil && !item.Remove && item.Document != nil {
+			last[repo.claims.key(item.TargetFile)] = i
+		}
+	}
+	for _, i := range last {
+		item := batch[i]
+		committed, err := repo.git.RemoteFile(p.config.GitRemote, repo.Branch, item.TargetFile)
+		if err != nil {
+			itemErrs[i] = fmt.Errorf("failed to read back %s from commit %s: %w", item.TargetFile, commit, err)
+			continue
+		}
+		if got, want := sha256Hex(committed), sha256Hex(item.Document); got != want {
+			loggerForRequest(item.RequestID).Error("Committed document differs from the written one",
+				"target_file", item.TargetFile, "commit", commit, "sha256", got, "expected_sha256", want)
+			itemErrs[i] = fmt.Errorf("%w: %s in commit %s has sha256 %s, expected %s",
+				errContentMismatch, item.TargetFile, commit, got, want)
 		}
-
-		validatedItems = append(validatedItems, item)
 	}
+}
 
-	// Perform batched git operations
-	if err := p.executeBatchedGitCommands(validatedItems); err != nil {
+// validateBatchItem checks that an item can be published from this
+// repository, that its file stays inside it and, unless it is a removal,
+// that the file is on disk. Items replayed from the journal or retried from
+// the dead-letter list are checked here as well.
+func (p *DIDProcessor) validateBatchItem(item BatchItem, root, remoteURL string) error {
+	if err := checkWithinRoot(root, item.TargetFile); err != nil {
 		return err
 	}
-
-	log.Printf("✅ Pushed batch of %d files to %s", len(validatedItems), p.config.Branch)
+	if err := p.validateHostRepo(item, remoteURL); err != nil {
+		return err
+	}
+	if !item.Remove {
+		if _, err := os.Stat(filepath.Join(root, item.TargetFile)); err != nil {
+			return fmt.Errorf("missing file %s: %w", item.TargetFile, err)
+		}
+	}
 	return nil
 }
 
 // executeBatchedGitCommands executes git commands for multiple files at once
-func (p *DIDProcessor) executeBatchedGitCommands(batch []BatchItem) error {
+func (p *DIDProcessor) executeBatchedGitCommands(repo *publishRepo, batch []BatchItem) error {
+	// Catch up with commits pushed elsewhere so the batch isn't built on a stale base
+	files := make([]string, 0, len(batch))
+	for _, item := range batch {
+		files = append(files, item.TargetFile)
+	}
+	if err := repo.git.FastForward(p.config.GitRemote, repo.Branch, files); err != nil {
+		return err
+	}
+
 	// Checkout branch
-	if err := checkoutOrCreateBranch(p.config.Branch); err != nil {
-		return fmt.Errorf("failed to checkout branch %s: %w", p.config.Branch, err)
+	if err := repo.git.EnsureBranch(repo.Branch); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %w", repo.Branch, err)
 	}
 
-	// Add all files
-	var filesToAdd []string
+	// Split the batch into files to add and files to remove
+	var filesToAdd, filesToRemove []string
 	for _, item := range batch {
-		filesToAdd = append(filesToAdd, item.TargetFile)
+		if item.Remove {
+			filesToRemove = append(filesToRemove, item.TargetFile)
+		} else {
+			filesToAdd = append(filesToAdd, item.TargetFile)
+		}
+	}
+
+	// Update the DID index in the same commit as the documents
+	if indexFile := p.indexPath(); indexFile != "" {
+		root, err := repo.git.WorkDir()
+		if err != nil {
+			return err
+		}
+		if err := p.updateIndex(repo, root, batch); err != nil {
+			return err
+		}
+		filesToAdd = append(filesToAdd, indexFile)
+	}
+
+	// And the DID configuration, when the batch links or unlinks a DID
+	if p.didConfigurationPath() != "" {
+		root, err := repo.git.WorkDir()
+		if err != nil {
+			return err
+		}
+		written, err := p.updateDIDConfiguration(repo, root, batch)
+		if err != nil {
+			return err
+		}
+		if written {
+			filesToAdd = append(filesToAdd, p.didConfigurationPath())
+		}
 	}
 
 	// Add all files in one command
-	addArgs := append([]string{"add"}, filesToAdd...)
-	if err := exec.Command("git", addArgs...).Run(); err != nil {
-		return fmt.Errorf("failed to add files: %w", err)
+	if len(filesToAdd) > 0 {
+		if err := repo.git.AddFiles(filesToAdd); err != nil {
+			return err
+		}
+	}
+
+	// Stage removals; the files are already gone from the working tree
+	if len(filesToRemove) > 0 {
+		if err := repo.git.RemoveFiles(filesToRemove); err != nil {
+			return err
+		}
 	}
 
 	// Check if there are any staged changes
-	cmd := exec.Command("git", "diff", "--cached", "--quiet")
-	if err := cmd.Run(); err == nil {
+	staged, err := repo.git.HasStagedChanges()
+	if err != nil {
+		return err
+	}
+	if !staged {
 		// No staged changes, skip commit
-		log.Println("No staged changes in batch, skipping commit")
+		slog.Info("No staged changes in batch, skipping commit")
 		return nil
 	}
 
 	// Create commit message listing all files
-	var fileList []string
-	for _, item := range batch {
-		fileList = append(fileList, item.TargetFile)
+	prefix, fileList := p.batchCommitFiles(batch)
+
+	// Fold the batch into the previous commit when SQUASH_WINDOW allows
+	if p.config.SquashWindow > 0 {
+		squashed, err := p.squashCommit(repo, prefix, fileList)
+		if err != nil || squashed {
+			return err
+		}
 	}
-	commitMsg := fmt.Sprintf("%s (%d files): %s", p.config.CommitMsg, len(batch), strings.Join(fileList, ", "))
 
 	// Commit all changes
-	if err := exec.Command("git", "commit", "-m", commitMsg).Run(); err != nil {
-		return fmt.Errorf("git commit failed: %w", err)
+	if err := repo.git.Commit(formatCommitMessage(prefix, fileList)); err != nil {
+		return err
 	}
 
-	// Push
-	if err := exec.Command("git", "push", "-u", p.config.GitRemote, p.config.Branch).Run(); err != nil {
-		return fmt.Errorf("failed to push to %s: %w", p.config.Branch, err)
+	// Push, rebasing onto the remote branch if the push is rejected
+	if err := p.pushWithRetry(repo, batch); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// pushWithRetry pushes the branch and, when the remote rejects the push as
+// non-fast-forward, rebases onto the remote branch and tries again with backoff
+func (p *DIDProcessor) pushWithRetry(repo *publishRepo, batch []BatchItem) error {
+	backoff := p.config.PushRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := repo.git.Push(p.config.GitRemote, repo.Branch)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errPushRejected) || attempt > p.config.PushRetries {
+			return err
+		}
+
+		slog.Warn("Push rejected, rebasing onto remote branch",
+			"attempt", attempt, "max_retries", p.config.PushRetries,
+			"repo", repo.name(), "remote", p.config.GitRemote, "branch", repo.Branch, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+
+		resolve, err := p.batchFiles(repo, batch)
+		if err != nil {
+			return fmt.Errorf("push rejected and rebase failed: %w", err)
+		}
+		if err := repo.git.Sync(p.config.GitRemote, repo.Branch, resolve); err != nil {
+			return fmt.Errorf("push rejected and rebase failed: %w", err)
+		}
+		if p.indexPath() != "" {
+			if err := p.refreshIndex(repo, batch); err != nil {
+				return fmt.Errorf("push rejected and index merge failed: %w", err)
+			}
+		}
+		if p.didConfigurationPath() != "" {
+			if err := p.refreshDIDConfiguration(repo, batch); err != nil {
+				return fmt.Errorf("push rejected and DID configuration merge failed: %w", err)
+			}
+		}
+	}
+}
+
+// batchFiles returns the files a batch owns with the contents they must have
+// if a rebase conflicts on them: each document as the batch wrote it (nil for
+// a removal), and the index and DID configuration as committed, since both
+// are merged with the remote versions again afterwards
+func (p *DIDProcessor) batchFiles(repo *publishRepo, batch []BatchItem) (map[string][]byte, error) {
+	root, err := repo.git.WorkDir()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	var generated []string
+	for _, item := range batch {
+		switch {
+		case item.Remove:
+			files[item.TargetFile] = nil
+		case item.Document != nil:
+			files[item.TargetFile] = item.Document
+		default:
+			generated = append(generated, item.TargetFile)
+		}
+	}
+	for _, file := range []string{p.indexPath(), p.didConfigurationPath()} {
+		if file != "" {
+			generated = append(generated, file)
+		}
+	}
+	for _, file := range generated {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if err == nil {
+			files[file] = data
+		}
+	}
+	return files, nil
+}
+
 type ParsedDID struct {
-	Original  string
-	Host      string
-	Project   string
-	PathSegs  []string
-	HostLower string
+	Original      string
+	Host          string // Percent-decoded and lower-cased, including any port (e.g. localhost:3000)
+	Project       string // Percent-decoded; empty for bare-domain DIDs
+	PathSegs      []string
+	MixedCaseHost bool // The submitted host wasn't lower case
+}
+
+// wellKnownDir is where bare-domain did:web documents are served from
+const wellKnownDir = ".well-known"
+
+// Limits on DIDs accepted by parseDID
+const (
+	maxDIDLength   = 2048 // Characters in the whole DID
+	maxDIDSegments = 16   // Colon-separated segments after did:web:, including the host
+)
+
+// Machine-readable codes for request body errors
+const (
+	errCodeBodyTooLarge = "body_too_large"
+	errCodeInvalidJSON  = "invalid_json"
+)
+
+// Machine-readable codes for DIDSyntaxError
+const (
+	errCodeDIDNotWeb          = "did_not_web"
+	errCodeDIDTooLong         = "did_too_long"
+	errCodeDIDTooManySegments = "did_too_many_segments"
+	errCodeDIDEmptySegment    = "did_empty_segment"
+	errCodeDIDInvalidChar     = "did_invalid_character"
+	errCodeDIDInvalidEncoding = "did_invalid_encoding"
+	errCodeDIDInvalidHost     = "did_invalid_host"
+	errCodeDIDInvalidPath     = "did_invalid_path_segment"
+)
+
+// DIDSyntaxError is returned by parseDID for a DID that doesn't match the did:web grammar
+type DIDSyntaxError struct {
+	Code    string
+	Message string
+}
+
+func (e *DIDSyntaxError) Error() string {
+	return e.Message
 }
 
+// didIDCharPattern matches the encoded characters allowed in a method-specific
+// id segment: ALPHA / DIGIT / "." / "-" / "_" / pct-encoded
+var didIDCharPattern = regexp.MustCompile(`^([A-Za-z0-9._-]|%[0-9A-Fa-f]{2})+$`)
+
+// didHostPattern matches a decoded did:web host with an optional port
+var didHostPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*(:[0-9]{1,5})?$`)
+
 func parseDID(did string) (*ParsedDID, error) {
+	syntaxError := func(code, format string, args ...interface{}) error {
+		return &DIDSyntaxError{Code: code, Message: fmt.Sprintf(format, args...)}
+	}
+
 	if !strings.HasPrefix(did, "did:web:") {
-		return nil, fmt.Errorf("not a did:web DID: %s", did)
+		return nil, syntaxError(errCodeDIDNotWeb, "not a did:web DID: %s", did)
+	}
+	if len(did) > maxDIDLength {
+		return nil, syntaxError(errCodeDIDTooLong, "DID is longer than %d characters", maxDIDLength)
 	}
 
 	parts := strings.Split(did, ":")
-	if len(parts) < 4 {
-		return nil, fmt.Errorf("DID missing project segment: %s", did)
+	if len(parts)-2 > maxDIDSegments {
+		return nil, syntaxError(errCodeDIDTooManySegments, "DID has more than %d segments", maxDIDSegments)
+	}
+
+	// The method-specific identifier is percent-encoded; a port is written as %3A
+	decoded := make([]string, 0, len(parts)-2)
+	for i, part := range parts[2:] {
+		if part == "" {
+			if i == 0 {
+				return nil, syntaxError(errCodeDIDEmptySegment, "DID missing host: %s", did)
+			}
+			return nil, syntaxError(errCodeDIDEmptySegment, "DID has an empty segment: %s", did)
+		}
+		if !didIDCharPattern.MatchString(part) {
+			return nil, syntaxError(errCodeDIDInvalidChar, "invalid characters in DID segment '%s'", part)
+		}
+		segment, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, syntaxError(errCodeDIDInvalidEncoding, "invalid percent-encoding in DID segment '%s': %v", part, err)
+		}
+		decoded = append(decoded, segment)
 	}
 
+	if !didHostPattern.MatchString(decoded[0]) {
+		return nil, syntaxError(errCodeDIDInvalidHost, "invalid DID host '%s'", decoded[0])
+	}
+	// Path segments become directories under the repository root, so none
+	// may step out of it, into .git, or smuggle control characters into
+	// file names and git output
+	for _, segment := range decoded[1:] {
+		if segment == "." || segment == ".." || strings.EqualFold(segment, ".git") ||
+			strings.ContainsAny(segment, "/\\") || strings.IndexFunc(segment, unicode.IsControl) >= 0 {
+			return nil, syntaxError(errCodeDIDInvalidPath, "invalid DID path segment %q", segment)
+		}
+	}
+
+	// Hosts are case-insensitive, so the did:web spec makes lower case the
+	// canonical form; path segments keep their case
+	host := strings.ToLower(decoded[0])
 	parsed := &ParsedDID{
-		Original:  did,
-		Host:      parts[2],
-		Project:   parts[3],
-		PathSegs:  parts[4:],
-		HostLower: strings.ToLower(parts[2]),
+		Original:      did,
+		Host:          host,
+		MixedCaseHost: host != decoded[0],
+	}
+	if len(decoded) > 1 {
+		parsed.Project = decoded[1]
+		parsed.PathSegs = decoded[2:]
 	}
 
 	return parsed, nil
 }
 
+// hostCaseWarning returns the warning reported for a DID whose host wasn't
+// lower case, or "" when it was
+func (parsed *ParsedDID) hostCaseWarning() string {
+	if !parsed.MixedCaseHost {
+		return ""
+	}
+	return fmt.Sprintf("DID host should be lower case; %s is published as %s", parsed.Original, parsed.expectedID())
+}
+
+// encodeDIDSegment percent-encodes a decoded segment for use in a did:web
+// identifier, including the colon that separates a host from its port
+func encodeDIDSegment(segment string) string {
+	return strings.ReplaceAll(url.PathEscape(segment), ":", "%3A")
+}
+
+// hostname returns the DID's host without its port
+func (parsed *ParsedDID) hostname() string {
+	hostname, _, _ := strings.Cut(parsed.Host, ":")
+	return hostname
+}
+
+// port returns the DID's port, or "" when the host has none
+func (parsed *ParsedDID) port() string {
+	_, port, _ := strings.Cut(parsed.Host, ":")
+	return port
+}
+
+// IsWellKnown reports whether the DID is a bare domain resolving to /.well-known/did.json
+func (parsed *ParsedDID) IsWellKnown() bool {
+	return parsed.Project == ""
+}
+
+// urlPath returns the URL path the DID document is served under, without did.json
+func (parsed *ParsedDID) urlPath() string {
+	if parsed.IsWellKnown() {
+		return wellKnownDir
+	}
+	segments := []string{url.PathEscape(parsed.Project)}
+	for _, seg := range parsed.PathSegs {
+		segments = append(segments, url.PathEscape(seg))
+	}
+	return strings.Join(segments, "/")
+}
+
+// expectedID returns the canonical, percent-encoded id the DID document must declare
+func (parsed *ParsedDID) expectedID() string {
+	segments := []string{"did", "web", encodeDIDSegment(parsed.Host)}
+	if !parsed.IsWellKnown() {
+		segments = append(segments, encodeDIDSegment(parsed.Project))
+		for _, seg := range parsed.PathSegs {
+			segments = append(segments, encodeDIDSegment(seg))
+		}
+	}
+	return strings.Join(segments, ":")
+}
+
 func (p *DIDProcessor) buildFetchURL(parsed *ParsedDID) string {
-	urlPath := parsed.Project
-	if len(parsed.PathSegs) > 0 {
-		urlPath = urlPath + "/" + strings.Join(parsed.PathSegs, "/")
+	return fmt.Sprintf("%s/%s/did.json", p.config.ServerURL, parsed.urlPath())
+}
+
+// buildPublishedURL returns the public Pages URL the document is served
+// from, which is where the DID resolves to while DOCUMENT_FILENAME is did.json
+func (p *DIDProcessor) buildPublishedURL(parsed *ParsedDID) string {
+	return fmt.Sprintf("https://%s/%s/%s", parsed.Host, parsed.urlPath(), p.config.DocumentFileName)
+}
+
+// normalizeJSON re-encodes a JSON document compactly with sorted keys so
+// documents can be compared regardless of key order and whitespace. Numbers
+// keep their original text, so large values aren't rounded to float64.
+func normalizeJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var jsonObj interface{}
+	if err := dec.Decode(&jsonObj); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON document")
+	}
+	return json.Marshal(jsonObj)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fetchResult is a successful upstream response. Status is 304 with no body
+// when a conditional request found the document unchanged.
+type fetchResult struct {
+	Body         []byte
+	Status       int
+	ETag         string
+	LastModified string
+}
+
+// fetchDIDDocument fetches a DID document, retrying with exponential backoff
+// on connection errors, 404 and 5xx responses, which the upstream server
+// returns briefly after a DID is created. With a cached entry the request is
+// conditional. It returns the number of attempts made.
+func (p *DIDProcessor) fetchDIDDocument(ctx context.Context, url, host string, cached *fetchCacheEntry) (fetchResult, int, error) {
+	logger := loggerFromContext(ctx).With("url", url)
+	delay := p.config.FetchRetryDelay
+	for attempt := 1; ; attempt++ {
+		fetched, err := p.fetchOnce(ctx, url, host, cached)
+		status := fetched.Status
+		if err == nil {
+			logger.Debug("Fetched DID document", "attempt", attempt, "status", status)
+			return fetched, attempt, nil
+		}
+
+		retryable := status == http.StatusNotFound || status >= 500 || (status == 0 && ctx.Err() == nil)
+		if !retryable || attempt > p.config.FetchRetries {
+			logger.Warn("Giving up fetching DID document", "attempts", attempt, "status", status, "error", err)
+			return fetchResult{}, attempt, fmt.Errorf("%w (after %d attempts)", err, attempt)
+		}
+
+		logger.Info("Fetching DID document failed, retrying",
+			"attempt", attempt, "status", status, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fetchResult{}, attempt, fmt.Errorf("%w (after %d attempts)", ctx.Err(), attempt)
+		}
+		delay *= 2
 	}
-	return fmt.Sprintf("%s/%s/did.json", p.config.ServerURL, urlPath)
 }
 
-func (p *DIDProcessor) fetchDIDDocument(url, host string) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// fetchOnce makes a single request for a DID document. The result's status
+// is zero when no response was received.
+func (p *DIDProcessor) fetchOnce(ctx context.Context, url, host string, cached *fetchCacheEntry) (fetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return fetchResult{}, err
 	}
 
 	req.Host = host
 	req.Header.Set("Host", host)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
 
-	log.Printf("Making request to %s with Host header: %s", url, host)
+	// The upstream server joins the request's trace
+	injectTraceContext(ctx, req.Header)
 
-	resp, err := client.Do(req)
+	loggerFromContext(ctx).Debug("Making request", "url", url, "host", host)
+
+	resp, err := p.fetchClient.Do(req)
 	if err != nil {
-		return nil, err
+		return fetchResult{}, err
 	}
 	defer resp.Body.Close()
 
+	fetched := fetchResult{
+		Status:       resp.StatusCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return fetched, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		return fetched, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
 	}
 
-	return io.ReadAll(resp.Body)
+	fetched.Body, err = io.ReadAll(resp.Body)
+	return fetched, err
 }
 
-func (p *DIDProcessor) determineTargetFile(parsed *ParsedDID) string {
-	cwd, _ := os.Getwd()
+// determineTargetFile returns the DID document path relative to the
+// publishing root, under OUTPUT_BASE_DIR when one is set. Only the file's
+// location changes; the published URL and expected id still follow the DID.
+func (p *DIDProcessor) determineTargetFile(root string, parsed *ParsedDID) (string, error) {
+	targetFile := filepath.Join(p.config.OutputBaseDir, p.sitePath(root, parsed))
+	if err := checkWithinRoot(root, targetFile); err != nil {
+		return "", err
+	}
+	return targetFile, nil
+}
+
+// sitePath returns the DID document path relative to the root of the site
+func (p *DIDProcessor) sitePath(root string, parsed *ParsedDID) string {
+	// Bare-domain DIDs live at the root of the site
+	if parsed.IsWellKnown() {
+		return filepath.Join(wellKnownDir, p.config.DocumentFileName)
+	}
+
+	cwd, _ := filepath.Abs(root)
 	trimmedSegs := make([]string, len(parsed.PathSegs))
 	copy(trimmedSegs, parsed.PathSegs)
 
@@ -471,119 +2762,97 @@ func (p *DIDProcessor) determineTargetFile(parsed *ParsedDID) string {
 		targetDir = "."
 	}
 
-	return filepath.Join(targetDir, "did.json")
+	return filepath.Join(targetDir, p.config.DocumentFileName)
 }
 
-func (p *DIDProcessor) saveDIDDocument(data []byte, targetFile string) error {
-	dir := filepath.Dir(targetFile)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+// cleanOutputBaseDir normalizes OUTPUT_BASE_DIR, which must be a relative
+// path inside the repository
+func cleanOutputBaseDir(dir string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(dir))
+	if dir == "" || cleaned == "." {
+		return "", nil
 	}
-
-	var formattedData []byte
-	var jsonObj interface{}
-	if err := json.Unmarshal(data, &jsonObj); err == nil {
-		if formatted, err := json.MarshalIndent(jsonObj, "", "  "); err == nil {
-			formattedData = formatted
-		} else {
-			formattedData = data
-		}
-	} else {
-		log.Println("Warning: invalid JSON, saving raw")
-		formattedData = data
+	if !filepath.IsLocal(cleaned) {
+		return "", fmt.Errorf("invalid OUTPUT_BASE_DIR '%s' (expected a relative path inside the repository)", dir)
 	}
-
-	return os.WriteFile(targetFile, formattedData, 0644)
-}
-
-func (p *DIDProcessor) validateDIDDocumentID(targetFile string, parsed *ParsedDID) error {
-	data, err := os.ReadFile(targetFile)
-	if err != nil {
-		return err
+	if first, _, _ := strings.Cut(filepath.ToSlash(cleaned), "/"); first == ".git" {
+		return "", fmt.Errorf("invalid OUTPUT_BASE_DIR '%s' (cannot be inside .git)", dir)
 	}
+	return cleaned, nil
+}
 
-	var doc map[string]interface{}
-	if err := json.Unmarshal(data, &doc); err != nil {
-		return fmt.Errorf("could not parse DID document for validation")
+// checkWithinRoot verifies that targetFile stays inside root and out of
+// its .git directory, including after resolving any symlinked directories
+// on the way. Control characters are refused too, as parseDID does, since
+// items replayed from the journal never went through it.
+func checkWithinRoot(root, targetFile string) error {
+	if !filepath.IsLocal(targetFile) {
+		return fmt.Errorf("%w: %s", errTargetOutsideRepo, targetFile)
 	}
-
-	docID, ok := doc["id"].(string)
-	if !ok || docID == "" {
-		return fmt.Errorf("no 'id' field found in DID document")
+	if strings.IndexFunc(targetFile, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: %q contains control characters", errTargetOutsideRepo, targetFile)
 	}
-
-	expectedID := fmt.Sprintf("did:web:%s:%s", parsed.Host, parsed.Project)
-	if len(parsed.PathSegs) > 0 {
-		expectedID = expectedID + ":" + strings.Join(parsed.PathSegs, ":")
+	for _, elem := range strings.Split(filepath.ToSlash(targetFile), "/") {
+		if strings.EqualFold(elem, ".git") {
+			return fmt.Errorf("%w: %s is inside .git", errTargetOutsideRepo, targetFile)
+		}
 	}
 
-	if docID != expectedID {
-		return fmt.Errorf("DID doc id mismatch: got %s, expected %s", docID, expectedID)
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve publishing root: %w", err)
 	}
-
-	return nil
-}
-
-func (p *DIDProcessor) checkGitRemote() error {
-	cmd := exec.Command("git", "remote", "get-url", p.config.GitRemote)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("remote '%s' not found", p.config.GitRemote)
+	// Resolve the deepest directory that already exists; the rest is
+	// created by the write
+	dir := filepath.Join(root, filepath.Dir(targetFile))
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			rel, err := filepath.Rel(resolvedRoot, resolved)
+			if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+				return fmt.Errorf("%w: %s resolves to %s", errTargetOutsideRepo, targetFile, resolved)
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) || dir == filepath.Clean(root) {
+			return fmt.Errorf("failed to resolve %s: %w", targetFile, err)
+		}
+		dir = filepath.Dir(dir)
 	}
-	return nil
 }
 
-func (p *DIDProcessor) getRemoteURL() (string, error) {
-	cmd := exec.Command("git", "remote", "get-url", p.config.GitRemote)
-	output, err := cmd.Output()
+// formatDIDDocument lays the document out as DOCUMENT_INDENT and
+// DOCUMENT_TRAILING_NEWLINE ask, falling back to the raw bytes when it is
+// not valid JSON
+func (p *DIDProcessor) formatDIDDocument(ctx context.Context, data []byte, targetFile string) []byte {
+	formatted, err := p.config.DocumentFormat.format(data)
 	if err != nil {
-		return "", fmt.Errorf("failed to get remote URL: %w", err)
+		loggerFromContext(ctx).Warn("Invalid JSON, saving raw", "target_file", targetFile)
+		return data
 	}
-	return strings.TrimSpace(string(output)), nil
+	return formatted
 }
 
-func (p *DIDProcessor) parseGitHubURL(remoteURL string) (user, repo string, err error) {
-	// SSH form: git@github.com:User/Repo.git
-	sshRegex := regexp.MustCompile(`^git@github\.com:([^/]+)/([^/]+)(\.git)?$`)
-	if matches := sshRegex.FindStringSubmatch(remoteURL); len(matches) >= 3 {
-		user = matches[1]
-		repo = strings.TrimSuffix(matches[2], ".git")
-		return user, repo, nil
-	}
-
-	// HTTPS form: https://github.com/User/Repo(.git)
-	httpsRegex := regexp.MustCompile(`^https://github\.com/([^/]+)/([^/]+)(\.git)?$`)
-	if matches := httpsRegex.FindStringSubmatch(remoteURL); len(matches) >= 3 {
-		user = matches[1]
-		repo = strings.TrimSuffix(matches[2], ".git")
-		return user, repo, nil
+func (p *DIDProcessor) saveDIDDocument(data []byte, targetFile string) error {
+	dir := filepath.Dir(targetFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
 
-	return "", "", fmt.Errorf("remote is not a GitHub SSH/HTTPS URL: %s", remoteURL)
+	return os.WriteFile(targetFile, data, 0644)
 }
 
-func checkoutOrCreateBranch(branch string) error {
-	var out bytes.Buffer
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to get current branch: %w", err)
-	}
-	currentBranch := strings.TrimSpace(out.String())
-
-	if currentBranch == branch {
-		return nil
+func (p *DIDProcessor) checkGitRemote(repo *publishRepo) error {
+	if _, err := repo.git.RemoteInfo(p.config.GitRemote); err != nil {
+		return fmt.Errorf("remote '%s' not found", p.config.GitRemote)
 	}
+	return nil
+}
 
-	checkBranch := exec.Command("git", "rev-parse", "--verify", branch)
-	if err := checkBranch.Run(); err != nil {
-		if err := exec.Command("git", "checkout", "-b", branch).Run(); err != nil {
-			return fmt.Errorf("failed to create branch %s: %w", branch, err)
-		}
-	} else {
-		if err := exec.Command("git", "checkout", branch).Run(); err != nil {
-			return fmt.Errorf("failed to checkout branch %s: %w", branch, err)
-		}
+func (p *DIDProcessor) getRemoteURL(repo *publishRepo) (string, error) {
+	info, err := repo.git.RemoteInfo(p.config.GitRemote)
+	if err != nil {
+		return "", err
 	}
-
-	return nil
+	return info.URL, nil
 }
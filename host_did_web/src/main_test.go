@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newSandboxProcessor returns a processor publishing alice.github.io DIDs to
+// a fake repository at sandbox/repo, so files written outside the
+// repository still land in the sandbox where they can be found
+func newSandboxProcessor(t *testing.T) (*DIDProcessor, *publishRepo, string) {
+	t.Helper()
+	p, repo, git := newBatchTestProcessor(t)
+	sandbox := t.TempDir()
+	root := filepath.Join(sandbox, "repo")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	repo.Path, git.dir = root, root
+	p.config.AllowedHosts = []string{"alice.github.io"}
+	p.config.DocumentFileName = "did.json"
+	return p, repo, sandbox
+}
+
+// assertNothingWritten fails the test if any file was written in the sandbox
+func assertNothingWritten(t *testing.T, sandbox string) {
+	t.Helper()
+	filepath.WalkDir(sandbox, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() && d.Name() != repoLockFile {
+			t.Errorf("wrote %s", path)
+		}
+		return err
+	})
+}
+
+func TestHostileDIDsAreNotSaved(t *testing.T) {
+	tests := []struct {
+		name string
+		did  string
+		code string
+	}{
+		{"dot-dot segments", "did:web:alice.github.io:site:..:..:etc", errCodeDIDInvalidPath},
+		{"encoded dot-dot segments", "did:web:alice.github.io:site:%2e%2e:%2E%2E:etc", errCodeDIDInvalidPath},
+		{"encoded dot-dot project", "did:web:alice.github.io:%2e%2e", errCodeDIDInvalidPath},
+		{"encoded dot segment", "did:web:alice.github.io:site:%2e", errCodeDIDInvalidPath},
+		{"encoded slash", "did:web:alice.github.io:site:..%2F..%2Fetc", errCodeDIDInvalidPath},
+		{"lower-case encoded slash", "did:web:alice.github.io:site:a%2fb", errCodeDIDInvalidPath},
+		{"encoded backslash", "did:web:alice.github.io:site:..%5C..%5Cetc", errCodeDIDInvalidPath},
+		{"literal slash", "did:web:alice.github.io:site/../etc", errCodeDIDInvalidChar},
+		{"newline", "did:web:alice.github.io:site:a%0Ab", errCodeDIDInvalidPath},
+		{"NUL", "did:web:alice.github.io:site:a%00b", errCodeDIDInvalidPath},
+		{"terminal escape", "did:web:alice.github.io:site:%1B%5B31m", errCodeDIDInvalidPath},
+		{"DEL", "did:web:alice.github.io:site:a%7F", errCodeDIDInvalidPath},
+		{".git project", "did:web:alice.github.io:.git", errCodeDIDInvalidPath},
+		{".git segment", "did:web:alice.github.io:site:.git:hooks", errCodeDIDInvalidPath},
+		{"upper-case .git segment", "did:web:alice.github.io:site:.GIT", errCodeDIDInvalidPath},
+		{"encoded .git segment", "did:web:alice.github.io:site:%2Egit", errCodeDIDInvalidPath},
+		{"encoded dot-dot host", "did:web:%2e%2e:site", errCodeDIDInvalidHost},
+		{"encoded slash in host", "did:web:alice.github.io%2F..:site", errCodeDIDInvalidHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, sandbox := newSandboxProcessor(t)
+			_, err := p.processDID(context.Background(), tt.did, ProcessOptions{Document: []byte(`{"id":"x"}`)})
+			var syntaxErr *DIDSyntaxError
+			if !errors.As(err, &syntaxErr) || syntaxErr.Code != tt.code {
+				t.Errorf("processDID(%q) error = %v, want %s", tt.did, err, tt.code)
+			}
+			assertNothingWritten(t, sandbox)
+		})
+	}
+}
+
+func TestSymlinkedOutputBaseDirIsNotSaved(t *testing.T) {
+	p, repo, sandbox := newSandboxProcessor(t)
+	if err := os.Mkdir(filepath.Join(sandbox, "outside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "outside"), filepath.Join(repo.Path, "site")); err != nil {
+		t.Fatal(err)
+	}
+	p.config.OutputBaseDir = "site"
+
+	_, err := p.processDID(context.Background(), "did:web:alice.github.io:site:doc", ProcessOptions{Document: []byte(`{"id":"x"}`)})
+	if !errors.Is(err, errTargetOutsideRepo) {
+		t.Errorf("processDID() error = %v, want errTargetOutsideRepo", err)
+	}
+	assertNothingWritten(t, sandbox)
+
+	// A symlink that stays inside the repository is fine
+	if err := os.Mkdir(filepath.Join(repo.Path, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("public", filepath.Join(repo.Path, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	p.config.OutputBaseDir = "linked"
+	parsed, err := parseDID("did:web:alice.github.io:site:doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.determineTargetFile(repo.Path, parsed); err != nil {
+		t.Errorf("determineTargetFile() under a symlink inside the repository: %v", err)
+	}
+}
+
+func TestHostileTargetFilesAreNotAdded(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		outside bool
+	}{
+		{"dot-dot", filepath.Join("..", "did.json"), true},
+		{"nested dot-dot", filepath.Join("site", "..", "..", "did.json"), true},
+		{"absolute", filepath.Join(string(filepath.Separator), "etc", "did.json"), true},
+		{".git", filepath.Join(".git", "did.json"), true},
+		{"nested upper-case .git", filepath.Join("site", ".GIT", "config"), true},
+		{"newline", filepath.Join("site", "a\nb", "did.json"), true},
+		{"terminal escape", filepath.Join("site", "\x1b[31m", "did.json"), true},
+		{"symlink out of the repository", filepath.Join("escape", "did.json"), true},
+		{"literal percent-encoding", filepath.Join("%2e%2e", "%2F", "did.json"), false},
+		{"symlink inside the repository", filepath.Join("linked", "did.json"), false},
+	}
+	p, repo, sandbox := newSandboxProcessor(t)
+	if err := os.Mkdir(filepath.Join(sandbox, "outside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "outside"), filepath.Join(repo.Path, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(repo.Path, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("public", filepath.Join(repo.Path, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Removals skip the file check, so only the path decides
+			item := batchItem(repo, "doc")
+			item.TargetFile = tt.target
+			item.Remove = true
+			item.HostVerification = HostVerification{Method: hostAssumed}
+			err := p.validateBatchItem(item, repo.Path, testRemoteURL)
+			if outside := errors.Is(err, errTargetOutsideRepo); outside != tt.outside {
+				t.Errorf("validateBatchItem(%q) error = %v, want outside %t", tt.target, err, tt.outside)
+			}
+		})
+	}
+}
+
+func TestParseDIDCase(t *testing.T) {
+	tests := []struct {
+		name       string
+		did        string
+		host       string
+		project    string
+		pathSegs   []string
+		expectedID string
+		mixedCase  bool
+	}{
+		{"lower-case host", "did:web:alice.github.io:site:Doc", "alice.github.io", "site", []string{"Doc"}, "did:web:alice.github.io:site:Doc", false},
+		{"mixed-case host", "did:web:Alice.GitHub.io:site:doc", "alice.github.io", "site", []string{"doc"}, "did:web:alice.github.io:site:doc", true},
+		{"mixed-case path", "did:web:alice.github.io:Site:Docs:V1", "alice.github.io", "Site", []string{"Docs", "V1"}, "did:web:alice.github.io:Site:Docs:V1", false},
+		{"mixed-case host and path", "did:web:ALICE.GITHUB.IO:Site:Doc", "alice.github.io", "Site", []string{"Doc"}, "did:web:alice.github.io:Site:Doc", true},
+		{"encoded upper-case host", "did:web:%41lice.github.io:site", "alice.github.io", "site", nil, "did:web:alice.github.io:site", true},
+		{"mixed-case host with port", "did:web:LocalHost%3A3000:site", "localhost:3000", "site", nil, "did:web:localhost%3A3000:site", true},
+		{"mixed-case bare domain", "did:web:Example.COM", "example.com", "", nil, "did:web:example.com", true},
+		{"lower-case percent-encoding", "did:web:example.com:caf%c3%a9", "example.com", "café", nil, "did:web:example.com:caf%C3%A9", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseDID(tt.did)
+			if err != nil {
+				t.Fatalf("parseDID(%q) error = %v", tt.did, err)
+			}
+			if parsed.Host != tt.host || parsed.Project != tt.project || !slices.Equal(parsed.PathSegs, tt.pathSegs) {
+				t.Errorf("parseDID(%q) = host %q, project %q, path %q; want %q, %q, %q",
+					tt.did, parsed.Host, parsed.Project, parsed.PathSegs, tt.host, tt.project, tt.pathSegs)
+			}
+			if parsed.MixedCaseHost != tt.mixedCase {
+				t.Errorf("MixedCaseHost = %t, want %t", parsed.MixedCaseHost, tt.mixedCase)
+			}
+			if got := parsed.expectedID(); got != tt.expectedID {
+				t.Errorf("expectedID() = %q, want %q", got, tt.expectedID)
+			}
+
+			warning := parsed.hostCaseWarning()
+			if !tt.mixedCase {
+				if warning != "" {
+					t.Errorf("hostCaseWarning() = %q, want none", warning)
+				}
+				return
+			}
+			if !strings.Contains(warning, tt.did) || !strings.Contains(warning, tt.expectedID) {
+				t.Errorf("hostCaseWarning() = %q, want it to name %s and %s", warning, tt.did, tt.expectedID)
+			}
+		})
+	}
+}
+
+func TestMixedCaseDIDTargetFile(t *testing.T) {
+	p, repo, _ := newSandboxProcessor(t)
+	for _, did := range []string{"did:web:Alice.GitHub.io:site:Docs:V1", "did:web:alice.github.io:site:Docs:V1"} {
+		parsed, err := parseDID(did)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The host's case doesn't matter, but path segments keep theirs
+		target, err := p.determineTargetFile(repo.Path, parsed)
+		if want := filepath.Join("Docs", "V1", "did.json"); err != nil || target != want {
+			t.Errorf("determineTargetFile(%q) = %q, %v, want %q", did, target, err, want)
+		}
+		if got, want := p.buildPublishedURL(parsed), "https://alice.github.io/site/Docs/V1/did.json"; got != want {
+			t.Errorf("buildPublishedURL(%q) = %q, want %q", did, got, want)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not found", errDIDNotFound, http.StatusNotFound, ""},
+		{"syntax", fmt.Errorf("failed to parse DID: %w", &DIDSyntaxError{Code: errCodeDIDInvalidPath}), http.StatusBadRequest, errCodeDIDInvalidPath},
+		{"outside repository", errTargetOutsideRepo, http.StatusBadRequest, errCodeTargetOutsideRepo},
+		{"rejected outside repository", fmt.Errorf("%w: %w", errBatchItemRejected, errTargetOutsideRepo), http.StatusBadRequest, errCodeTargetOutsideRepo},
+		{"missing context", &ContextError{}, http.StatusUnprocessableEntity, errCodeMissingContext},
+		{"invalid document", &ValidationError{}, http.StatusUnprocessableEntity, ""},
+		{"id mismatch", errIDMismatch, http.StatusUnprocessableEntity, errCodeIDMismatch},
+		{"repository not mapped", errRepoNotMapped, http.StatusUnprocessableEntity, errCodeRepoNotMapped},
+		{"branch not mapped", errBranchNotMapped, http.StatusUnprocessableEntity, errCodeBranchNotMapped},
+		{"invalid domain linkage", errInvalidDomainLinkage, http.StatusUnprocessableEntity, errCodeInvalidDomainLinkage},
+		{"target conflict", errTargetConflict, http.StatusConflict, errCodeTargetConflict},
+		{"rejected", fmt.Errorf("%w: missing file", errBatchItemRejected), http.StatusUnprocessableEntity, errCodeItemRejected},
+		{"content mismatch", errContentMismatch, http.StatusInternalServerError, errCodeContentMismatch},
+		{"queue full", errQueueFull, http.StatusServiceUnavailable, errCodeQueueFull},
+		{"rolled back", fmt.Errorf("%w: push failed", errBatchRolledBack), http.StatusServiceUnavailable, ""},
+		{"rolled back after timeout", fmt.Errorf("%w: %w", errBatchRolledBack, errGitTimeout), http.StatusServiceUnavailable, errCodeGitTimeout},
+		{"timeout", errGitTimeout, http.StatusInternalServerError, errCodeGitTimeout},
+		{"other", errors.New("boom"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, code := errorStatus(tt.err); status != tt.status || code != tt.code {
+				t.Errorf("errorStatus(%v) = %d, %q, want %d, %q", tt.err, status, code, tt.status, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var METRIC_PREFIX = "host_did_web_"
+
+func metricName(name string) string {
+	return fmt.Sprintf("%s%s", METRIC_PREFIX, name)
+}
+
+var (
+	AuthRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName("auth_rejected_total"),
+			Help: "Total number of requests rejected by authentication",
+		},
+		[]string{"reason"},
+	)
+
+	RateLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName("rate_limited_total"),
+			Help: "Total number of requests rejected by the rate limiter",
+		},
+		[]string{"path"},
+	)
+
+	FetchNotModifiedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("fetch_not_modified_total"),
+			Help: "Total number of conditional DID fetches answered 304 Not Modified, skipping the publish",
+		},
+	)
+
+	JournalReplayedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("journal_replayed_total"),
+			Help: "Total number of batch items replayed from the queue journal on startup",
+		},
+	)
+
+	QueueFullTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("batch_queue_full_total"),
+			Help: "Total number of requests refused because the batch queue was full",
+		},
+	)
+
+	BatchItemsAbandonedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("batch_items_abandoned_total"),
+			Help: "Total number of batch items dropped because their request was cancelled",
+		},
+	)
+
+	PublishVerificationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName("publish_verifications_total"),
+			Help: "Total number of public URL checks after a push, by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	PublishVerificationDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    metricName("publish_verification_seconds"),
+			Help:    "Time from push until the public URL served the committed document",
+			Buckets: []float64{5, 15, 30, 60, 120, 300, 600},
+		},
+	)
+
+	GitLockWaitSeconds = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    metricName("git_lock_wait_seconds"),
+			Help:    "Time a batch flush waited for the repository's cross-process publish lock",
+			Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60},
+		},
+	)
+
+	GitLockTimeoutsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("git_lock_timeouts_total"),
+			Help: "Total number of batch flushes postponed because another process held the publish lock",
+		},
+	)
+
+	GCRemovedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("gc_removed_total"),
+			Help: "Total number of orphaned DID documents removed by garbage collection",
+		},
+	)
+
+	AuditWriteFailuresTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("audit_write_failures_total"),
+			Help: "Total number of batches whose audit records could not be written",
+		},
+	)
+
+	WebhookFailuresTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("webhook_failures_total"),
+			Help: "Total number of webhook deliveries that failed after all retries",
+		},
+	)
+
+	DIDEventsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName("did_events_total"),
+			Help: "Total number of DID creation events received on /events/did-created, by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	CommitsSquashedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName("commits_squashed_total"),
+			Help: "Total number of batches folded into the previous commit under SQUASH_WINDOW, by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	DeadLetterItems = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metricName("dead_letter_items"),
+			Help: "Number of failed batch items in the dead-letter list awaiting retry",
+		},
+	)
+
+	DeadLetterRetriedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("dead_letter_retried_total"),
+			Help: "Total number of failed batch items sent back to the batch queue by POST /failed/retry",
+		},
+	)
+
+	DeadLetterEvictedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName("dead_letter_evicted_total"),
+			Help: "Total number of failed batch items evicted from the dead-letter list once DEAD_LETTER_MAX was reached",
+		},
+	)
+)
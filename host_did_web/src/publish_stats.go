@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// publishStatsWindow is how far back failuresLast24h counts
+const publishStatsWindow = 24 * time.Hour
+
+// wellKnownProject keys bare-domain DIDs, which are published under .well-known
+const wellKnownProject = ".well-known"
+
+// ProjectPublishStats summarizes the publishing activity of one project
+type ProjectPublishStats struct {
+	Documents       int        `json:"documents"`     // DIDs currently published, deactivated ones included
+	LastPublishAt   *time.Time `json:"lastPublishAt"` // Null until a document is committed
+	LastCommit      string     `json:"lastCommit,omitempty"`
+	FailuresLast24h int        `json:"failuresLast24h"` // Items rejected, rolled back or abandoned
+	AvgFlushSeconds *float64   `json:"avgFlushSeconds"` // Null until a batch with the project has flushed since startup
+}
+
+// PublishStatsResponse is returned by GET /stats/publish
+type PublishStatsResponse struct {
+	Hosts       map[string]map[string]ProjectPublishStats `json:"hosts"`      // By DID host, then project
+	SeededFrom  []string                                  `json:"seededFrom"` // index and/or audit
+	GeneratedAt time.Time                                 `json:"generatedAt"`
+}
+
+// projectKey identifies a project by its DID host and first path segment
+type projectKey struct {
+	host    string
+	project string
+}
+
+func projectKeyFor(parsed *ParsedDID) projectKey {
+	if parsed.IsWellKnown() {
+		return projectKey{host: parsed.Host, project: wellKnownProject}
+	}
+	return projectKey{host: parsed.Host, project: parsed.Project}
+}
+
+// projectActivity is the running tally behind a ProjectPublishStats
+type projectActivity struct {
+	dids         map[string]bool
+	lastPublish  time.Time
+	lastCommit   string
+	failures     []time.Time // Within publishStatsWindow, oldest first
+	flushSeconds float64
+	flushes      int
+}
+
+// prune drops failures older than publishStatsWindow
+func (a *projectActivity) prune(now time.Time) {
+	cutoff := now.Add(-publishStatsWindow)
+	i := 0
+	for i < len(a.failures) && a.failures[i].Before(cutoff) {
+		i++
+	}
+	a.failures = a.failures[i:]
+}
+
+// publishStats keeps per-project publishing activity in memory, updated as
+// batches flush, so GET /stats/publish never walks the repository
+type publishStats struct {
+	mu         sync.Mutex
+	projects   map[projectKey]*projectActivity
+	seededFrom []string
+}
+
+func newPublishStats() *publishStats {
+	return &publishStats{projects: make(map[projectKey]*projectActivity)}
+}
+
+// project returns the tally for the DID's project, creating it; callers hold mu
+func (s *publishStats) project(parsed *ParsedDID) *projectActivity {
+	key := projectKeyFor(parsed)
+	activity, ok := s.projects[key]
+	if !ok {
+		activity = &projectActivity{dids: make(map[string]bool)}
+		s.projects[key] = activity
+	}
+	return activity
+}
+
+// committed records a committed action on a DID; callers hold mu
+func (s *publishStats) committed(parsed *ParsedDID, action, commit string, at time.Time) {
+	activity := s.project(parsed)
+	if action == "remove" {
+		delete(activity.dids, parsed.expectedID())
+	} else {
+		activity.dids[parsed.expectedID()] = true
+	}
+	if !at.Before(activity.lastPublish) {
+		activity.lastPublish = at
+		if commit != "" {
+			activity.lastCommit = commit
+		}
+	}
+}
+
+// failed records a failed or abandoned item; callers hold mu
+func (s *publishStats) failed(parsed *ParsedDID, at time.Time) {
+	activity := s.project(parsed)
+	if time.Since(at) > publishStatsWindow {
+		return
+	}
+	activity.failures = append(activity.failures, at)
+}
+
+// record tallies the items a batch handled, indexed like results, the items
+// abandoned before it and, when the batch was flushed, how long that took
+func (s *publishStats) record(items []BatchItem, results []BatchResult, abandoned []BatchItem, flush time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	flushed := make(map[*projectActivity]bool)
+	for i, item := range items {
+		if results[i].Err != nil {
+			s.failed(item.ParsedDID, now)
+		} else {
+			s.committed(item.ParsedDID, auditAction(item), results[i].Commit, now)
+		}
+		flushed[s.project(item.ParsedDID)] = true
+	}
+	for _, item := range abandoned {
+		s.failed(item.ParsedDID, now)
+	}
+	if flush <= 0 {
+		return
+	}
+	for activity := range flushed {
+		activity.flushSeconds += flush.Seconds()
+		activity.flushes++
+	}
+}
+
+// seedIndex counts the DIDs listed in a repository's index as published
+func (s *publishStats) seedIndex(index DIDIndex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for did, entry := range index.DIDs {
+		parsed, err := parseDID(did)
+		if err != nil {
+			continue
+		}
+		s.committed(parsed, "publish", "", entry.UpdatedAt)
+	}
+}
+
+// seedAudit replays an audit record. When an index was read the published
+// DIDs are already known, so only times, commits and failures are taken.
+func (s *publishStats) seedAudit(record AuditRecord, indexed bool) {
+	parsed, err := parseDID(record.DID)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record.Outcome != auditCommitted {
+		s.failed(parsed, record.Time)
+		return
+	}
+	activity := s.project(parsed)
+	published := activity.dids[parsed.expectedID()]
+	s.committed(parsed, record.Action, record.Commit, record.Time)
+	if indexed {
+		// The index is current; keep its listing
+		if published {
+			activity.dids[parsed.expectedID()] = true
+		} else {
+			delete(activity.dids, parsed.expectedID())
+		}
+	}
+}
+
+// snapshot returns the stats as GET /stats/publish reports them
+func (s *publishStats) snapshot() PublishStatsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	response := PublishStatsResponse{
+		Hosts:       make(map[string]map[string]ProjectPublishStats),
+		SeededFrom:  append([]string{}, s.seededFrom...),
+		GeneratedAt: now.UTC(),
+	}
+	for key, activity := range s.projects {
+		activity.prune(now)
+		stats := ProjectPublishStats{
+			Documents:       len(activity.dids),
+			LastCommit:      activity.lastCommit,
+			FailuresLast24h: len(activity.failures),
+		}
+		if !activity.lastPublish.IsZero() {
+			lastPublish := activity.lastPublish.UTC()
+			stats.LastPublishAt = &lastPublish
+		}
+		if activity.flushes > 0 {
+			avg := activity.flushSeconds / float64(activity.flushes)
+			stats.AvgFlushSeconds = &avg
+		}
+		if response.Hosts[key.host] == nil {
+			response.Hosts[key.host] = make(map[string]ProjectPublishStats)
+		}
+		response.Hosts[key.host][key.project] = stats
+	}
+	return response
+}
+
+// seedPublishStats fills the publish stats from each repository's index
+// file and then the audit log, whichever are enabled. Either being
+// unreadable is logged and skipped; the stats then start from what's left.
+func (p *DIDProcessor) seedPublishStats() {
+	indexed := false
+	if indexFile := p.indexPath(); indexFile != "" {
+		for _, repo := range p.repos {
+			root, err := repo.git.WorkDir()
+			if err != nil {
+				slog.Warn("Failed to seed publish stats from the index", "repo", repo.name(), "error", err)
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(root, indexFile))
+			if os.IsNotExist(err) {
+				continue
+			}
+			var index DIDIndex
+			if err == nil {
+				err = json.Unmarshal(data, &index)
+			}
+			if err != nil {
+				slog.Warn("Failed to seed publish stats from the index", "repo", repo.name(), "error", err)
+				continue
+			}
+			p.publishStats.seedIndex(index)
+			indexed = true
+		}
+	}
+	if indexed {
+		p.publishStats.seededFrom = append(p.publishStats.seededFrom, "index")
+	}
+
+	if p.audit != nil {
+		err := p.audit.scan(func(record AuditRecord) {
+			p.publishStats.seedAudit(record, indexed)
+		})
+		if err != nil {
+			slog.Warn("Failed to seed publish stats from the audit log", "path", p.audit.path, "error", err)
+		} else {
+			p.publishStats.seededFrom = append(p.publishStats.seededFrom, "audit")
+		}
+	}
+}
+
+// recordPublishStats tallies a batch in the publish stats, if kept
+func (p *DIDProcessor) recordPublishStats(items []BatchItem, results []BatchResult, abandoned []BatchItem, flush time.Duration) {
+	if p.publishStats == nil {
+		return
+	}
+	p.publishStats.record(items, results, abandoned, flush)
+}
+
+// handlePublishStats reports publishing activity per DID host and project
+func (p *DIDProcessor) handlePublishStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.publishStats.snapshot())
+}
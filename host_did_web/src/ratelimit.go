@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limit keys selecting which requests share a token bucket
+const (
+	rateLimitGlobal = "global" // One bucket for every client
+	rateLimitIP     = "ip"     // One bucket per client IP
+	rateLimitToken  = "token"  // One bucket per valid bearer token, falling back to the client IP
+)
+
+// tokenBucket holds the tokens left for one key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token-bucket limiter refilling rate tokens per second up to burst
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the key's bucket. When none is left it returns
+// false and how long until the next token is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+}
+
+// evictIdle drops buckets that have refilled completely, which behave
+// exactly like a new bucket
+func (l *rateLimiter) evictIdle() {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// evictLoop periodically evicts idle buckets
+func (l *rateLimiter) evictLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.evictIdle()
+	}
+}
+
+// rateLimitKey returns the bucket key for a request. Only a token that
+// authenticates gets its own bucket: a made-up one would get a fresh bucket
+// on every request, so it shares its client IP's instead.
+func (p *DIDProcessor) rateLimitKey(r *http.Request) string {
+	switch p.config.RateLimitKey {
+	case rateLimitGlobal:
+		return ""
+	case rateLimitToken:
+		if token, ok := bearerToken(r); ok && p.validToken(token) {
+			return tokenActor(token)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimit rejects requests over the configured rate with 429 before they
+// touch the disk or the batch queue. When rate limiting is off it is a no-op.
+func (p *DIDProcessor) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p.limiter == nil {
+			next(w, r)
+			return
+		}
+
+		allowed, wait := p.limiter.allow(p.rateLimitKey(r))
+		if allowed {
+			next(w, r)
+			return
+		}
+
+		RateLimitedTotal.WithLabelValues(r.URL.Path).Inc()
+		loggerFromContext(r.Context()).Warn("🚦 Rate limited request",
+			"method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "retry_after", wait)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		p.sendError(w, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded, retry in %s", wait.Round(time.Millisecond)))
+	}
+}
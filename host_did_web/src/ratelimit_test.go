@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	p := &DIDProcessor{config: Config{RateLimitKey: rateLimitToken, APIToken: "secret"}}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{"valid token", "Bearer secret", tokenActor("secret")},
+		{"unknown token", "Bearer made-up", "ip:192.0.2.1"},
+		{"empty token", "Bearer ", "ip:192.0.2.1"},
+		{"no token", "", "ip:192.0.2.1"},
+		{"basic auth", "Basic c2VjcmV0", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/process-did", nil)
+			r.RemoteAddr = "192.0.2.1:5000"
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if got := p.rateLimitKey(r); got != tt.want {
+				t.Errorf("rateLimitKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitUnverifiedTokensShareBucket(t *testing.T) {
+	p := &DIDProcessor{
+		config:  Config{RateLimitKey: rateLimitToken, APIToken: "secret"},
+		limiter: newRateLimiter(0.001, 2),
+	}
+
+	// Each request makes up a new token, which must not earn a new bucket
+	var codes []int
+	for _, token := range []string{"a", "b", "c"} {
+		r := httptest.NewRequest("POST", "/process-did", nil)
+		r.RemoteAddr = "192.0.2.1:5000"
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		p.rateLimit(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+		t.Errorf("status codes = %v, want [200 200 429]", codes)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DependencyStatus reports whether a single dependency is reachable
+type DependencyStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is returned by GET /ready
+type ReadinessResponse struct {
+	Ready        bool               `json:"ready"`
+	CheckedAt    time.Time          `json:"checkedAt"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// readinessCache holds the last readiness result so probes don't run
+// ls-remote on every request
+type readinessCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	response ReadinessResponse
+}
+
+// handleReady reports whether the git remotes and the upstream server are
+// reachable, answering 503 when either is not. Unlike /health it can fail.
+func (p *DIDProcessor) handleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := p.readiness(r.Context())
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// readiness returns the cached readiness result, re-running the checks once
+// it is older than READY_CACHE_TTL
+func (p *DIDProcessor) readiness(ctx context.Context) ReadinessResponse {
+	p.ready.mu.Lock()
+	defer p.ready.mu.Unlock()
+	if !p.ready.response.CheckedAt.IsZero() && time.Since(p.ready.response.CheckedAt) < p.ready.ttl {
+		return p.ready.response
+	}
+
+	// Run the checks concurrently so one slow dependency doesn't delay the others
+	type dependencyCheck struct {
+		name  string
+		check func(context.Context) error
+	}
+	var checks []dependencyCheck
+	for _, repo := range p.sortedRepos() {
+		// REPO_MAP repositories are told apart by path, and BRANCH_MAP ones by branch too
+		name := "git_remote"
+		if id := repo.name(); id != "." {
+			name += ":" + id
+		}
+		checks = append(checks, dependencyCheck{name, func(ctx context.Context) error { return p.checkRemoteReady(ctx, repo) }})
+	}
+	checks = append(checks, dependencyCheck{"upstream", p.checkUpstreamReady})
+	statuses := make([]DependencyStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = DependencyStatus{Name: c.name, Healthy: true}
+			if err := c.check(ctx); err != nil {
+				statuses[i].Healthy = false
+				statuses[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	response := ReadinessResponse{Ready: true, CheckedAt: time.Now(), Dependencies: statuses}
+	for _, status := range statuses {
+		if !status.Healthy {
+			response.Ready = false
+			loggerFromContext(ctx).Warn("Dependency not ready", "dependency", status.Name, "error", status.Error)
+		}
+	}
+	p.ready.response = response
+	return response
+}
+
+// checkRemoteReady checks that the repository's git remote is configured and reachable
+func (p *DIDProcessor) checkRemoteReady(ctx context.Context, repo *publishRepo) error {
+	if err := p.checkGitRemote(repo); err != nil {
+		return err
+	}
+	return repo.git.CheckRemote(p.config.GitRemote, repo.Branch)
+}
+
+// checkUpstreamReady checks that SERVER_URL answers; any non-5xx response counts
+func (p *DIDProcessor) checkUpstreamReady(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.config.ServerURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.fetchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// errRepoLocked is returned when another process, such as a second replica
+// sharing the volume, holds a repository's publish lock past GIT_LOCK_TIMEOUT
+var errRepoLocked = errors.New("repository is locked by another process")
+
+// repoLockFile is the advisory lock file taken in each repository for the
+// duration of a batch flush
+const repoLockFile = ".git-publish.lock"
+
+// repoLockPoll is how often a held lock is retried
+const repoLockPoll = 50 * time.Millisecond
+
+// lockRepo takes an exclusive flock on the repository's lock file, retrying
+// until timeout. The in-process repo.mu only orders this process's batches;
+// the flock orders them against every other process on the same volume.
+// The returned function releases the lock.
+func lockRepo(repo *publishRepo, timeout time.Duration) (func(), error) {
+	path := filepath.Join(repo.Path, repoLockFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	start := time.Now()
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if time.Since(start) >= timeout {
+			f.Close()
+			GitLockWaitSeconds.Observe(time.Since(start).Seconds())
+			GitLockTimeoutsTotal.Inc()
+			return nil, fmt.Errorf("%w: %s still held after %s", errRepoLocked, path, timeout)
+		}
+		time.Sleep(repoLockPoll)
+	}
+	GitLockWaitSeconds.Observe(time.Since(start).Seconds())
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
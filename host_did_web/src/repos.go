@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errRepoNotMapped is returned for a DID whose host has no REPO_MAP entry
+var errRepoNotMapped = errors.New("no repository mapped")
+
+// errCodeRepoNotMapped is the machine-readable code for errRepoNotMapped
+const errCodeRepoNotMapped = "repo_not_mapped"
+
+// errBranchNotMapped is returned for a DID whose host has no BRANCH_MAP entry
+var errBranchNotMapped = errors.New("no branch mapped")
+
+// errCodeBranchNotMapped is the machine-readable code for errBranchNotMapped
+const errCodeBranchNotMapped = "branch_not_mapped"
+
+// RepoRoute maps a DID host, optionally narrowed to one project, to the local
+// repository its documents are published from
+type RepoRoute struct {
+	Host    string // Lower-case and percent-decoded, including any port
+	Project string // Percent-decoded; empty matches every DID on the host
+	Path    string // Repository checkout, relative to the working directory or absolute
+}
+
+// BranchRoute maps a DID host, optionally narrowed to one project, to the
+// branch its documents are published to
+type BranchRoute struct {
+	Host    string // Lower-case and percent-decoded, including any port
+	Project string // Percent-decoded; empty matches every DID on the host
+	Branch  string
+}
+
+// publishRepo is a branch of a repository DID documents are published to.
+// Each one has its own git backend and target file claims, so batches for
+// different repositories never wait on each other. Branches of the same
+// repository share its lock and are published from their own worktrees.
+type publishRepo struct {
+	Path   string // REPO_MAP path; empty for the working directory
+	Branch string // BRANCH_MAP branch, or BRANCH
+	git    GitPublisher
+	mu     *sync.Mutex // Serializes git operations on this repository
+	claims *targetClaims
+
+	branchMapped bool // BRANCH_MAP is set, so the branch is part of the name
+}
+
+// name identifies the repository in logs and responses
+func (r *publishRepo) name() string {
+	name := r.Path
+	if name == "" {
+		name = "."
+	}
+	if r.branchMapped {
+		name += "@" + r.Branch
+	}
+	return name
+}
+
+// repoID identifies a publishing repository by path and branch
+type repoID struct {
+	Path   string
+	Branch string
+}
+
+// parseRepoMap parses REPO_MAP, a comma-separated list of host[:project]=path
+// entries. Keys are written as in the DID, so a port is %3A.
+func parseRepoMap(value string) ([]RepoRoute, error) {
+	var routes []RepoRoute
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, path, ok := strings.Cut(entry, "=")
+		key, path = strings.TrimSpace(key), strings.TrimSpace(path)
+		if !ok || key == "" || path == "" {
+			return nil, fmt.Errorf("invalid repo mapping '%s' (expected host[:project]=path)", entry)
+		}
+		host, project, err := parseRouteKey("repo", entry, key)
+		if err != nil {
+			return nil, err
+		}
+
+		route := RepoRoute{Host: host, Project: project, Path: filepath.Clean(path)}
+		id := route.Host + ":" + route.Project
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate repo mapping for '%s'", key)
+		}
+		seen[id] = true
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// parseBranchMap parses BRANCH_MAP, a comma-separated list of
+// host[:project]=branch entries keyed like REPO_MAP
+func parseBranchMap(value string) ([]BranchRoute, error) {
+	var routes []BranchRoute
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, branch, ok := strings.Cut(entry, "=")
+		key, branch = strings.TrimSpace(key), strings.TrimSpace(branch)
+		if !ok || key == "" || branch == "" || strings.ContainsAny(branch, " \t") {
+			return nil, fmt.Errorf("invalid branch mapping '%s' (expected host[:project]=branch)", entry)
+		}
+		host, project, err := parseRouteKey("branch", entry, key)
+		if err != nil {
+			return nil, err
+		}
+
+		route := BranchRoute{Host: host, Project: project, Branch: branch}
+		id := route.Host + ":" + route.Project
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate branch mapping for '%s'", key)
+		}
+		seen[id] = true
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// parseRouteKey decodes the host[:project] key of a REPO_MAP or BRANCH_MAP entry
+func parseRouteKey(kind, entry, key string) (string, string, error) {
+	host, project, _ := strings.Cut(key, ":")
+	decodedHost, err := url.PathUnescape(host)
+	if err != nil || decodedHost == "" {
+		return "", "", fmt.Errorf("invalid %s mapping '%s': bad host '%s'", kind, entry, host)
+	}
+	decodedProject, err := url.PathUnescape(project)
+	if err != nil || strings.Contains(decodedProject, ":") {
+		return "", "", fmt.Errorf("invalid %s mapping '%s': bad project '%s'", kind, entry, project)
+	}
+	return strings.ToLower(decodedHost), decodedProject, nil
+}
+
+// newPublishRepos creates the publishing repositories: the working directory
+// alone, or every repository named in REPO_MAP, each once for every branch
+// BRANCH_MAP can send its DIDs to
+func newPublishRepos(config Config) (map[repoID]*publishRepo, error) {
+	// Every DID routes like the host and project of some entry, or like the
+	// bare host
+	keys := [][2]string{{}}
+	for _, route := range config.RepoMap {
+		keys = append(keys, [2]string{route.Host, route.Project})
+	}
+	for _, route := range config.BranchMap {
+		keys = append(keys, [2]string{route.Host, route.Project})
+	}
+	var ids []repoID
+	seen := make(map[repoID]bool)
+	branches := make(map[string]int)
+	for _, key := range keys {
+		path, ok := config.repoPath(key[0], key[1])
+		if !ok {
+			continue
+		}
+		branch, ok := config.branch(key[0], key[1])
+		if !ok {
+			continue
+		}
+		id := repoID{Path: path, Branch: branch}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+			branches[path]++
+		}
+	}
+
+	repos := make(map[repoID]*publishRepo)
+	locks := make(map[string]*sync.Mutex)
+	for _, id := range ids {
+		if id.Path != "" && locks[id.Path] == nil {
+			info, err := os.Stat(id.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid REPO_MAP repository: %w", err)
+			}
+			if !info.IsDir() {
+				return nil, fmt.Errorf("invalid REPO_MAP repository: %s is not a directory", id.Path)
+			}
+		}
+		if branches[id.Path] > 1 {
+			// The branches are written and checked out side by side
+			if (config.GitBackend != "" && config.GitBackend != "cli") || !config.GitWorktree {
+				name := id.Path
+				if name == "" {
+					name = "."
+				}
+				return nil, fmt.Errorf("BRANCH_MAP publishes %s to several branches, which needs GIT_BACKEND=cli and GIT_WORKTREE=true", name)
+			}
+			if config.GitWorktreePath != "" {
+				return nil, fmt.Errorf("GIT_WORKTREE_PATH can't be set when BRANCH_MAP publishes a repository to several branches")
+			}
+		}
+		if locks[id.Path] == nil {
+			locks[id.Path] = &sync.Mutex{}
+		}
+		publisher, err := newGitPublisher(config, id.Path, id.Branch)
+		if err != nil {
+			return nil, err
+		}
+		repos[id] = &publishRepo{
+			Path:         id.Path,
+			Branch:       id.Branch,
+			git:          publisher,
+			mu:           locks[id.Path],
+			claims:       newTargetClaims(),
+			branchMapped: len(config.BranchMap) > 0,
+		}
+	}
+	return repos, nil
+}
+
+// repoFor returns the repository and branch a DID is published to. Without
+// REPO_MAP the repository is always the working directory, and without
+// BRANCH_MAP the branch is always BRANCH; when a map is set, a route for the
+// host and project wins over one for the whole host, and unmapped hosts are
+// refused.
+func (p *DIDProcessor) repoFor(parsed *ParsedDID) (*publishRepo, error) {
+	path, ok := p.config.repoPath(parsed.Host, parsed.Project)
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", errRepoNotMapped, parsed.expectedID())
+	}
+	branch, ok := p.config.branch(parsed.Host, parsed.Project)
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", errBranchNotMapped, parsed.expectedID())
+	}
+	return p.repos[repoID{Path: path, Branch: branch}], nil
+}
+
+// repoPath returns the REPO_MAP path for a host and project
+func (c Config) repoPath(host, project string) (string, bool) {
+	if len(c.RepoMap) == 0 {
+		return "", true
+	}
+	var hostRoute *RepoRoute
+	for i, route := range c.RepoMap {
+		if route.Host != host {
+			continue
+		}
+		if route.Project == "" {
+			hostRoute = &c.RepoMap[i]
+		} else if route.Project == project {
+			return route.Path, true
+		}
+	}
+	if hostRoute == nil {
+		return "", false
+	}
+	return hostRoute.Path, true
+}
+
+// branch returns the BRANCH_MAP branch for a host and project
+func (c Config) branch(host, project string) (string, bool) {
+	if len(c.BranchMap) == 0 {
+		return c.Branch, true
+	}
+	var hostRoute *BranchRoute
+	for i, route := range c.BranchMap {
+		if route.Host != host {
+			continue
+		}
+		if route.Project == "" {
+			hostRoute = &c.BranchMap[i]
+		} else if route.Project == project {
+			return route.Branch, true
+		}
+	}
+	if hostRoute == nil {
+		return "", false
+	}
+	return hostRoute.Branch, true
+}
+
+// sortedRepos returns the publishing repositories in path order
+func (p *DIDProcessor) sortedRepos() []*publishRepo {
+	repos := make([]*publishRepo, 0, len(p.repos))
+	for _, repo := range p.repos {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if repos[i].Path != repos[j].Path {
+			return repos[i].Path < repos[j].Path
+		}
+		return repos[i].Branch < repos[j].Branch
+	})
+	return repos
+}
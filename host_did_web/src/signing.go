@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// Commit signing modes set with GIT_SIGNING
+const (
+	signingOff = "off"
+	signingGPG = "gpg" // OpenPGP signature
+	signingSSH = "ssh" // SSH signature (gpg.format=ssh)
+)
+
+// sshSigNamespace is the namespace git uses for SSH commit signatures
+const sshSigNamespace = "git"
+
+// checkCLISigningKey verifies at startup that the git binary can sign with
+// the configured key: a secret key known to gpg, or a readable SSH key file
+func checkCLISigningKey(mode, key string) error {
+	switch mode {
+	case signingGPG:
+		if output, err := exec.Command("gpg", "--batch", "--list-secret-keys", key).CombinedOutput(); err != nil {
+			return fmt.Errorf("GPG signing key '%s' is not available: %w: %s", key, err, bytes.TrimSpace(output))
+		}
+	case signingSSH:
+		if _, err := os.Stat(key); err != nil {
+			return fmt.Errorf("SSH signing key is not available: %w", err)
+		}
+	}
+	return nil
+}
+
+// cliSigningArgs returns the git arguments that make commit sign with the configured key
+func cliSigningArgs(mode, key string) []string {
+	switch mode {
+	case signingGPG:
+		return []string{"-c", "gpg.format=openpgp", "commit", "--gpg-sign=" + key}
+	case signingSSH:
+		return []string{"-c", "gpg.format=ssh", "commit", "--gpg-sign=" + key}
+	default:
+		return []string{"commit"}
+	}
+}
+
+// loadGoGitSigner reads the signing key for the gogit backend: an armored
+// OpenPGP private key for gpg, or an SSH private key for ssh
+func loadGoGitSigner(mode, keyPath, password string) (git.Signer, error) {
+	if mode == signingOff {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not available: %w", err)
+	}
+
+	switch mode {
+	case signingGPG:
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GPG signing key: %w", err)
+		}
+		entity := keyring[0]
+		if entity.PrivateKey == nil {
+			return nil, fmt.Errorf("GPG signing key has no private key")
+		}
+		if entity.PrivateKey.Encrypted {
+			if err := entity.DecryptPrivateKeys([]byte(password)); err != nil {
+				return nil, fmt.Errorf("failed to decrypt GPG signing key: %w", err)
+			}
+		}
+		return &gpgCommitSigner{entity: entity}, nil
+	case signingSSH:
+		var signer ssh.Signer
+		if password != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(password))
+		} else {
+			signer, err = ssh.ParsePrivateKey(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH signing key: %w", err)
+		}
+		return &sshCommitSigner{signer: signer}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// gpgCommitSigner produces armored detached OpenPGP signatures
+type gpgCommitSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s *gpgCommitSigner) Sign(message io.Reader) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+// sshCommitSigner produces armored SSHSIG signatures, as ssh-keygen -Y sign does
+type sshCommitSigner struct {
+	signer ssh.Signer
+}
+
+func (s *sshCommitSigner) Sign(message io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha512.Sum512(data)
+
+	// The signed blob is defined by the OpenSSH PROTOCOL.sshsig format
+	signedData := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          string
+	}{sshSigNamespace, "", "sha512", string(hash[:])})...)
+
+	var sig *ssh.Signature
+	if algSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// SHA-1 RSA signatures are rejected by current OpenSSH
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     string
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     string
+	}{1, string(s.signer.PublicKey().Marshal()), sshSigNamespace, "", "sha512", string(ssh.Marshal(sig))})...)
+
+	var armored bytes.Buffer
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return armored.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchCommitFiles returns the commit message prefix for a batch and the
+// file list its message names
+func (p *DIDProcessor) batchCommitFiles(batch []BatchItem) (string, []string) {
+	var fileList []string
+	prefix := p.config.DeactivateCommitMsg
+	for _, item := range batch {
+		switch {
+		case item.Remove:
+			fileList = append(fileList, "removed "+item.TargetFile)
+		case item.Deactivate:
+			fileList = append(fileList, "deactivated "+item.TargetFile)
+		default:
+			fileList = append(fileList, item.TargetFile)
+		}
+		// A batch mixing deactivations with other changes uses COMMIT_MSG
+		if !item.Deactivate {
+			prefix = p.config.CommitMsg
+		}
+	}
+	return prefix, fileList
+}
+
+// formatCommitMessage builds a batch commit message
+func formatCommitMessage(prefix string, fileList []string) string {
+	return fmt.Sprintf("%s (%d files): %s", prefix, len(fileList), strings.Join(fileList, ", "))
+}
+
+// parseCommitMessage splits a message built by formatCommitMessage with one
+// of the given prefixes back into its prefix and file list
+func parseCommitMessage(message string, prefixes ...string) (string, []string, bool) {
+	for _, prefix := range prefixes {
+		rest, ok := strings.CutPrefix(message, prefix+" (")
+		if !ok {
+			continue
+		}
+		count, list, ok := strings.Cut(rest, " files): ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			continue
+		}
+		fileList := strings.Split(list, ", ")
+		if len(fileList) == n && !strings.Contains(list, "\n") {
+			return prefix, fileList, true
+		}
+	}
+	return "", nil, false
+}
+
+// ownsCommit reports whether the commit was authored by GIT_AUTHOR_NAME and
+// GIT_AUTHOR_EMAIL, the only commits SQUASH_WINDOW may amend
+func (p *DIDProcessor) ownsCommit(commit *commitInfo) bool {
+	identity := p.config.GitIdentity
+	return identity.isSet() &&
+		commit.AuthorName == identity.AuthorName &&
+		strings.EqualFold(commit.AuthorEmail, identity.AuthorEmail)
+}
+
+// squashCommit folds the staged batch into the branch's last commit when
+// SQUASH_WINDOW allows it: the commit must be one of this service's batch
+// commits, authored by the configured identity less than SQUASH_WINDOW ago.
+// The amended commit lists both batches' files and is pushed with
+// --force-with-lease, so it only replaces the commit it amends. It reports
+// false, with the batch still staged, when the batch needs a commit of its
+// own, including when the remote has moved on and refuses the lease.
+func (p *DIDProcessor) squashCommit(repo *publishRepo, prefix string, fileList []string) (bool, error) {
+	last, err := repo.git.LastCommit()
+	if err != nil {
+		return false, err
+	}
+	if last == nil || last.Parents > 1 || !p.ownsCommit(last) || time.Since(last.AuthorTime) > p.config.SquashWindow {
+		return false, nil
+	}
+	lastPrefix, lastFiles, ok := parseCommitMessage(last.Message, p.config.CommitMsg, p.config.DeactivateCommitMsg)
+	if !ok {
+		return false, nil
+	}
+	if lastPrefix != prefix {
+		prefix = p.config.CommitMsg
+	}
+	merged := slices.Clone(lastFiles)
+	for _, file := range fileList {
+		if !slices.Contains(merged, file) {
+			merged = append(merged, file)
+		}
+	}
+
+	if err := repo.git.AmendCommit(formatCommitMessage(prefix, merged)); err != nil {
+		return false, err
+	}
+	err = repo.git.ForcePush(p.config.GitRemote, repo.Branch, last.SHA)
+	if errors.Is(err, errPushRejected) {
+		// Someone else pushed on top of the commit; put the batch back on
+		// the index and let it be committed and pushed normally
+		slog.Warn("Squash push rejected, committing the batch separately",
+			"repo", repo.name(), "branch", repo.Branch, "commit", last.SHA, "error", err)
+		CommitsSquashedTotal.WithLabelValues("lease_rejected").Inc()
+		if err := repo.git.ResetSoft(last.SHA); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	CommitsSquashedTotal.WithLabelValues("amended").Inc()
+	slog.Info("Squashed batch into the previous commit",
+		"repo", repo.name(), "branch", repo.Branch, "amended", last.SHA, "files", len(merged))
+	return true, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// LastPush describes the most recent commit a batch left its branch at
+type LastPush struct {
+	Commit string    `json:"commit"`
+	Repo   string    `json:"repo,omitempty"` // REPO_MAP repository; omitted for the working directory
+	At     time.Time `json:"at"`
+}
+
+// StatsResponse is returned by GET /stats
+type StatsResponse struct {
+	QueueDepth            int        `json:"queueDepth"` // Items sent but not yet picked up by the batch processor
+	QueueCapacity         int        `json:"queueCapacity"`
+	PendingItems          int        `json:"pendingItems"` // Items in the batch awaiting flush
+	Processing            bool       `json:"processing"`   // The pending items are being committed and pushed
+	LastFlushAt           *time.Time `json:"lastFlushAt"`  // Null until the first batch finishes
+	SecondsSinceLastFlush *float64   `json:"secondsSinceLastFlush"`
+	LastPush              *LastPush  `json:"lastPush"`
+	Processed             int64      `json:"processed"`       // Items committed since startup
+	Failed                int64      `json:"failed"`          // Items rejected, rolled back or abandoned since startup
+	DeadLetterItems       *int       `json:"deadLetterItems"` // Failed items awaiting retry in GET /failed; null when DEAD_LETTER_MAX is 0
+	BatchSize             int        `json:"batchSize"`
+	BatchTimeout          string     `json:"batchTimeout"`
+	StartedAt             time.Time  `json:"startedAt"`
+}
+
+// HealthzResponse is returned by GET /healthz
+type HealthzResponse struct {
+	Status                   string     `json:"status"`       // healthy, or unhealthy after too many failed batches in a row
+	DryRun                   bool       `json:"dryRun"`       // Nothing is pushed, so lastPushAt never advances
+	QueueDepth               int        `json:"queueDepth"`   // Items sent but not yet picked up by the batch processor
+	PendingItems             int        `json:"pendingItems"` // Items in the batch awaiting flush
+	QueueCapacity            int        `json:"queueCapacity"`
+	LastPushAt               *time.Time `json:"lastPushAt"` // Null until the first push
+	SecondsSinceLastPush     *float64   `json:"secondsSinceLastPush"`
+	ConsecutiveFailedBatches int        `json:"consecutiveFailedBatches"`
+	MaxFailedBatches         int        `json:"maxFailedBatches"`
+}
+
+// recordFlush counts consecutive flushes in which a repository's commit or
+// push failed; a flush in which none did resets the count
+func (b *pendingBatch) recordFlush(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if failed {
+		b.failedBatches++
+	} else {
+		b.failedBatches = 0
+	}
+}
+
+// finish records a flushed batch, indexed like results, and the items
+// abandoned before it, then clears the snapshot
+func (b *pendingBatch) finish(batch []BatchItem, results []BatchResult, abandoned int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.failed += int64(abandoned)
+	if len(batch) > 0 {
+		b.lastFlush = now
+	}
+	for i, item := range batch {
+		if results[i].Err != nil {
+			b.failed++
+			continue
+		}
+		b.processed++
+		if results[i].Commit != "" {
+			b.lastCommit, b.lastRepo, b.lastPushAt = results[i].Commit, item.Repo.Path, now
+		}
+	}
+	b.items = b.items[:0]
+	b.processing = false
+}
+
+// handleStats reports the batch queue and git processor state, so a slow
+// response can be told apart from a backed-up queue
+func (p *DIDProcessor) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := StatsResponse{
+		QueueDepth:    len(p.batchCh),
+		QueueCapacity: cap(p.batchCh),
+		BatchSize:     p.config.BatchSize,
+		BatchTimeout:  p.config.BatchTimeout.String(),
+		StartedAt:     p.startedAt,
+	}
+
+	p.pending.mu.Lock()
+	stats.PendingItems = len(p.pending.items)
+	stats.Processing = p.pending.processing
+	stats.Processed = p.pending.processed
+	stats.Failed = p.pending.failed
+	if !p.pending.lastFlush.IsZero() {
+		lastFlush := p.pending.lastFlush
+		since := time.Since(lastFlush).Seconds()
+		stats.LastFlushAt, stats.SecondsSinceLastFlush = &lastFlush, &since
+	}
+	if p.pending.lastCommit != "" {
+		stats.LastPush = &LastPush{Commit: p.pending.lastCommit, Repo: p.pending.lastRepo, At: p.pending.lastPushAt}
+	}
+	p.pending.mu.Unlock()
+	if p.deadLetters != nil {
+		deadLetterItems := p.deadLetters.len()
+		stats.DeadLetterItems = &deadLetterItems
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleHealthz reports whether the publisher is actually pushing, answering
+// 503 once more than HEALTH_MAX_FAILED_BATCHES batches in a row have failed.
+// Unlike /health it can fail, and unlike /ready it doesn't probe the remotes.
+func (p *DIDProcessor) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	health := HealthzResponse{
+		Status:           "healthy",
+		DryRun:           p.config.DryRun,
+		QueueDepth:       len(p.batchCh),
+		QueueCapacity:    cap(p.batchCh),
+		MaxFailedBatches: p.config.MaxFailedBatches,
+	}
+
+	p.pending.mu.Lock()
+	health.PendingItems = len(p.pending.items)
+	health.ConsecutiveFailedBatches = p.pending.failedBatches
+	if !p.pending.lastPushAt.IsZero() {
+		lastPush := p.pending.lastPushAt
+		since := time.Since(lastPush).Seconds()
+		health.LastPushAt, health.SecondsSinceLastPush = &lastPush, &since
+	}
+	p.pending.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.ConsecutiveFailedBatches > p.config.MaxFailedBatches {
+		health.Status = "unhealthy"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ndjsonContentType is the media type of streamed /process-dids responses
+const ndjsonContentType = "application/x-ndjson"
+
+// Progress stages reported in an NDJSON stream. A DID's last line is one of
+// committed, unchanged, done (dry run, validate only or async) or failed.
+const (
+	stageFetched   = "fetched"
+	stageWritten   = "written"
+	stageQueued    = "queued"
+	stageCommitted = "committed"
+	stageUnchanged = "unchanged"
+	stageDone      = "done"
+	stageFailed    = "failed"
+	stageSummary   = "summary"
+)
+
+// ProgressEvent is one line of a streamed /process-dids response
+type ProgressEvent struct {
+	Index   int           `json:"index"` // Position of the DID in the request; -1 on the summary line
+	DID     string        `json:"did,omitempty"`
+	Stage   string        `json:"stage"`
+	Result  *DIDResult    `json:"result,omitempty"`  // The DID's last line only
+	Summary *DIDsResponse `json:"summary,omitempty"` // The summary line only
+}
+
+// progressKey is the context key for a request's progress callback
+type progressKey struct{}
+
+// withProgress asks processDID to report each stage it completes
+func withProgress(ctx context.Context, report func(stage string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// reportProgress reports a completed stage, if anyone is listening
+func reportProgress(ctx context.Context, stage string) {
+	if report, ok := ctx.Value(progressKey{}).(func(string)); ok {
+		report(stage)
+	}
+}
+
+// acceptsNDJSON reports whether the client asked for a streamed response
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == ndjsonContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonWriter writes one JSON value per line and flushes it, from any
+// number of goroutines. Once a write fails, because the client went away,
+// later lines are dropped so the work behind them carries on undisturbed.
+type ndjsonWriter struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	failed bool
+}
+
+func (n *ndjsonWriter) write(v any) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to encode stream line", "error", err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.failed {
+		return
+	}
+	if _, err := n.w.Write(append(line, '\n')); err == nil {
+		err = n.rc.Flush()
+	}
+	if err != nil {
+		n.failed = true
+		slog.Warn("Client stopped reading progress stream", "error", err)
+	}
+}
+
+// finalStage names a DID's last stream line after its outcome
+func finalStage(result DIDResult) string {
+	switch {
+	case !result.Success:
+		return stageFailed
+	case result.Unchanged:
+		return stageUnchanged
+	case result.Published != nil && result.Published.Commit != nil:
+		return stageCommitted
+	default:
+		return stageDone
+	}
+}
+
+// streamProcessDIDs is handleProcessDIDs for clients that accept NDJSON: a
+// line per DID as each stage completes, its result once it is done, then a
+// summary line. The status is always 200 since it is sent before any DID is
+// processed; the summary carries the outcome. The DIDs are processed without
+// the request's cancellation, so a client that disconnects only stops
+// receiving lines and its items are still committed with the batch.
+func (p *DIDProcessor) streamProcessDIDs(w http.ResponseWriter, r *http.Request, dids []string, opts ProcessOptions) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	stream := &ndjsonWriter{w: w, rc: http.NewResponseController(w)}
+	stream.rc.Flush()
+
+	ctx := context.WithoutCancel(r.Context())
+	results := make([]DIDResult, len(dids))
+	var wg sync.WaitGroup
+	for i, did := range dids {
+		wg.Add(1)
+		go func(i int, did string) {
+			defer wg.Done()
+			ctx := withProgress(ctx, func(stage string) {
+				stream.write(ProgressEvent{Index: i, DID: did, Stage: stage})
+			})
+			results[i] = p.processListedDID(ctx, did, opts)
+			stream.write(ProgressEvent{Index: i, DID: did, Stage: finalStage(results[i]), Result: &results[i]})
+		}(i, did)
+	}
+	wg.Wait()
+
+	summary, _ := summarizeDIDResults(results)
+	summary.Results = nil
+	stream.write(ProgressEvent{Index: -1, Stage: stageSummary, Summary: &summary})
+}
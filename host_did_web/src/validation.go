@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// didCoreContext is the JSON-LD context every DID document must include
+const didCoreContext = "https://www.w3.org/ns/did/v1"
+
+// supportedKeyEncodings are the public key properties accepted on verification methods
+var supportedKeyEncodings = []string{"publicKeyJwk", "publicKeyMultibase", "publicKeyBase58", "publicKeyHex"}
+
+// defaultVerificationMethodTypes are the verification method types accepted
+// when VERIFICATION_METHOD_TYPES is unset
+const defaultVerificationMethodTypes = "JsonWebKey2020,Ed25519VerificationKey2018,Ed25519VerificationKey2020," +
+	"EcdsaSecp256k1VerificationKey2019,EcdsaSecp256k1RecoveryMethod2020,X25519KeyAgreementKey2019," +
+	"X25519KeyAgreementKey2020,Multikey"
+
+// base58Alphabet is the Bitcoin base58 alphabet used by publicKeyBase58 and
+// base58btc multibase values
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// DIDDocument is the subset of a DID Core document checked before publishing.
+// Only used for validation; the published file keeps every original field.
+type DIDDocument struct {
+	Context            json.RawMessage   `json:"@context"`
+	ID                 string            `json:"id"`
+	VerificationMethod []json.RawMessage `json:"verificationMethod"`
+	Authentication     []json.RawMessage `json:"authentication"`
+	AssertionMethod    []json.RawMessage `json:"assertionMethod"`
+	Deactivated        bool              `json:"deactivated"`
+}
+
+// VerificationMethod is a DID Core verification method. The string key
+// encodings are pointers so an empty value can be told from a missing one.
+type VerificationMethod struct {
+	ID                 string          `json:"id"`
+	Type               string          `json:"type"`
+	Controller         string          `json:"controller"`
+	PublicKeyJwk       json.RawMessage `json:"publicKeyJwk"`
+	PublicKeyMultibase *string         `json:"publicKeyMultibase"`
+	PublicKeyBase58    *string         `json:"publicKeyBase58"`
+	PublicKeyHex       *string         `json:"publicKeyHex"`
+}
+
+// MethodProblems lists the problems found in one verification method
+type MethodProblems struct {
+	Path     string   `json:"path"` // Where the method appears, e.g. verificationMethod[0]
+	ID       string   `json:"id,omitempty"`
+	Problems []string `json:"problems"`
+}
+
+// errIDMismatch is returned when a document supplied in the request doesn't
+// belong to the requested DID
+var errIDMismatch = errors.New("document id does not match the DID")
+
+// errCodeIDMismatch is the machine-readable code for errIDMismatch
+const errCodeIDMismatch = "id_mismatch"
+
+// errCodeMissingContext is the machine-readable code returned when a document
+// lacks a required JSON-LD context
+const errCodeMissingContext = "missing_context"
+
+// ContextError is returned in strict context mode when required JSON-LD
+// contexts are absent from a DID document
+type ContextError struct {
+	Missing []string
+}
+
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("DID document is missing required @context entries: %s", strings.Join(e.Missing, ", "))
+}
+
+// parseContextList parses a comma-separated list of context URLs
+func parseContextList(value string) []string {
+	var contexts []string
+	for _, context := range strings.Split(value, ",") {
+		if context = strings.TrimSpace(context); context != "" {
+			contexts = append(contexts, context)
+		}
+	}
+	return contexts
+}
+
+// missingContexts returns the required contexts not listed in the document's
+// @context, which may be a single string or an array of strings and objects
+func missingContexts(data []byte, required []string) []string {
+	var doc struct {
+		Context json.RawMessage `json:"@context"`
+	}
+	json.Unmarshal(data, &doc)
+
+	present := make(map[string]bool)
+	var single string
+	if err := json.Unmarshal(doc.Context, &single); err == nil {
+		present[single] = true
+	} else {
+		var entries []json.RawMessage
+		json.Unmarshal(doc.Context, &entries)
+		for _, entry := range entries {
+			// Embedded context objects can't satisfy a required URL
+			if err := json.Unmarshal(entry, &single); err == nil {
+				present[single] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, context := range required {
+		if !present[context] {
+			missing = append(missing, context)
+		}
+	}
+	return missing
+}
+
+// ValidationError lists every problem found in a DID document. The problems
+// with verification methods are also grouped by method in Methods.
+type ValidationError struct {
+	Problems []string
+	Methods  []MethodProblems
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid DID document: %s", strings.Join(e.Problems, "; "))
+}
+
+// validateDIDDocument checks the document against the DID Core structure and
+// returns the problems found, along with those of each verification method
+// grouped by method; no problems means the document is valid. Verification
+// methods must have one of allowedTypes, unless it is empty.
+func validateDIDDocument(data []byte, parsed *ParsedDID, allowedTypes []string) ([]string, []MethodProblems) {
+	var doc DIDDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []string{fmt.Sprintf("document is not a valid DID document: %v", err)}, nil
+	}
+
+	var problems []string
+	problems = append(problems, validateContext(doc.Context)...)
+
+	if problem := documentIDProblem(doc.ID, parsed); problem != "" {
+		problems = append(problems, problem)
+	}
+
+	// Collect verification method IDs so relationships can reference them
+	methodIDs := make(map[string]bool)
+	var methods []MethodProblems
+	// A deactivated document has had its verification methods removed
+	if len(doc.VerificationMethod) == 0 && !doc.Deactivated {
+		problems = append(problems, "at least one verificationMethod is required")
+	}
+	for i, raw := range doc.VerificationMethod {
+		path := fmt.Sprintf("verificationMethod[%d]", i)
+		id, methodProblems := validateVerificationMethod(raw, path, doc.ID, allowedTypes)
+		if len(methodProblems) > 0 {
+			problems = append(problems, methodProblems...)
+			methods = append(methods, MethodProblems{Path: path, ID: id, Problems: methodProblems})
+		}
+		if id != "" {
+			methodIDs[resolveDIDURL(doc.ID, id)] = true
+		}
+	}
+
+	for _, relationship := range []struct {
+		name    string
+		entries []json.RawMessage
+	}{
+		{"authentication", doc.Authentication},
+		{"assertionMethod", doc.AssertionMethod},
+	} {
+		relationshipProblems, embedded := validateRelationship(relationship.name, relationship.entries, doc.ID, methodIDs, allowedTypes)
+		problems = append(problems, relationshipProblems...)
+		methods = append(methods, embedded...)
+	}
+
+	return problems, methods
+}
+
+// validateContext requires @context to be, or to start with, the DID v1 context
+func validateContext(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return []string{"missing '@context'"}
+	}
+
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		if single != didCoreContext {
+			return []string{fmt.Sprintf("'@context' must be %s", didCoreContext)}
+		}
+		return nil
+	}
+
+	var contexts []json.RawMessage
+	if err := json.Unmarshal(raw, &contexts); err != nil || len(contexts) == 0 {
+		return []string{"'@context' must be a string or a non-empty array"}
+	}
+	var first string
+	if err := json.Unmarshal(contexts[0], &first); err != nil || first != didCoreContext {
+		return []string{fmt.Sprintf("first '@context' entry must be %s", didCoreContext)}
+	}
+	return nil
+}
+
+// validateVerificationMethod checks a verification method, including that
+// its key material decodes and its controller is the document, and returns
+// its id
+func validateVerificationMethod(raw json.RawMessage, path, docID string, allowedTypes []string) (string, []string) {
+	var method VerificationMethod
+	if err := json.Unmarshal(raw, &method); err != nil {
+		return "", []string{fmt.Sprintf("%s is not a valid verification method: %v", path, err)}
+	}
+
+	var problems []string
+	if method.ID == "" {
+		problems = append(problems, fmt.Sprintf("%s is missing 'id'", path))
+	}
+	if method.Type == "" {
+		problems = append(problems, fmt.Sprintf("%s is missing 'type'", path))
+	} else if len(allowedTypes) > 0 && !slices.Contains(allowedTypes, method.Type) {
+		problems = append(problems, fmt.Sprintf("%s has unsupported type %s (expected one of %s)",
+			path, method.Type, strings.Join(allowedTypes, ", ")))
+	}
+	if method.Controller == "" {
+		problems = append(problems, fmt.Sprintf("%s is missing 'controller'", path))
+	} else if docID != "" && method.Controller != docID {
+		problems = append(problems, fmt.Sprintf("%s controller %s is not the document id %s", path, method.Controller, docID))
+	}
+
+	encodings := 0
+	if len(method.PublicKeyJwk) > 0 {
+		var jwk map[string]interface{}
+		if err := json.Unmarshal(method.PublicKeyJwk, &jwk); err != nil || jwk["kty"] == nil {
+			problems = append(problems, fmt.Sprintf("%s has a malformed 'publicKeyJwk'", path))
+		}
+		encodings++
+	}
+	for _, encoding := range []struct {
+		name   string
+		value  *string
+		decode func(string) error
+	}{
+		{"publicKeyMultibase", method.PublicKeyMultibase, decodeMultibase},
+		{"publicKeyBase58", method.PublicKeyBase58, decodeBase58},
+		{"publicKeyHex", method.PublicKeyHex, decodeHex},
+	} {
+		if encoding.value == nil {
+			continue
+		}
+		encodings++
+		if *encoding.value == "" {
+			problems = append(problems, fmt.Sprintf("%s has an empty '%s'", path, encoding.name))
+		} else if err := encoding.decode(*encoding.value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s has a malformed '%s': %v", path, encoding.name, err))
+		}
+	}
+	if encodings != 1 {
+		problems = append(problems, fmt.Sprintf("%s must have exactly one key encoding (%s)",
+			path, strings.Join(supportedKeyEncodings, ", ")))
+	}
+
+	return method.ID, problems
+}
+
+// validateRelationship checks that each entry of a verification relationship
+// is either a reference to a declared verification method or an embedded
+// one. Problems with embedded methods are also returned grouped by method.
+func validateRelationship(name string, entries []json.RawMessage, docID string, methodIDs map[string]bool, allowedTypes []string) ([]string, []MethodProblems) {
+	var problems []string
+	var methods []MethodProblems
+	for i, raw := range entries {
+		path := fmt.Sprintf("%s[%d]", name, i)
+
+		var ref string
+		if err := json.Unmarshal(raw, &ref); err == nil {
+			if !methodIDs[resolveDIDURL(docID, ref)] {
+				problems = append(problems, fmt.Sprintf("%s references unknown verification method %s", path, ref))
+			}
+			continue
+		}
+
+		id, methodProblems := validateVerificationMethod(raw, path, docID, allowedTypes)
+		if len(methodProblems) > 0 {
+			problems = append(problems, methodProblems...)
+			methods = append(methods, MethodProblems{Path: path, ID: id, Problems: methodProblems})
+		}
+	}
+	return problems, methods
+}
+
+// decodeHex checks a publicKeyHex value, which has no 0x prefix
+func decodeHex(value string) error {
+	_, err := hex.DecodeString(value)
+	return err
+}
+
+// decodeMultibase checks a multibase value in one of the encodings used for
+// public keys: base58btc (z), base64url (u), base64 (m) or base16 (f)
+func decodeMultibase(value string) error {
+	prefix, data := value[0], value[1:]
+	if data == "" {
+		return errors.New("no data after the multibase prefix")
+	}
+	var err error
+	switch prefix {
+	case 'z':
+		err = decodeBase58(data)
+	case 'u':
+		_, err = base64.RawURLEncoding.DecodeString(data)
+	case 'm':
+		_, err = base64.RawStdEncoding.DecodeString(data)
+	case 'f':
+		if strings.ToLower(data) != data {
+			return errors.New("base16 multibase must be lower case")
+		}
+		err = decodeHex(data)
+	default:
+		return fmt.Errorf("unsupported multibase prefix %q", prefix)
+	}
+	return err
+}
+
+// decodeBase58 checks a Bitcoin-alphabet base58 value. Any string over the
+// alphabet decodes, so only the characters are checked.
+func decodeBase58(value string) error {
+	for i, c := range value {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return fmt.Errorf("invalid base58 character %q at offset %d", c, i)
+		}
+	}
+	return nil
+}
+
+// documentIDProblem describes why a document id doesn't match the DID, or
+// returns "" when it does
+func documentIDProblem(id string, parsed *ParsedDID) string {
+	if id == "" {
+		return "missing 'id'"
+	}
+	// Compare canonical forms so equivalent percent-encodings round-trip
+	docID := id
+	if parsedDocID, err := parseDID(docID); err == nil {
+		docID = parsedDocID.expectedID()
+	}
+	if expectedID := parsed.expectedID(); docID != expectedID {
+		return fmt.Sprintf("id mismatch: got %s, expected %s", id, expectedID)
+	}
+	return ""
+}
+
+// checkDocumentID fails with errIDMismatch unless the document's id matches
+// the DID, regardless of STRICT_VALIDATION
+func checkDocumentID(data []byte, parsed *ParsedDID) error {
+	var doc struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%w: %v", errIDMismatch, err)
+	}
+	if problem := documentIDProblem(doc.ID, parsed); problem != "" {
+		return fmt.Errorf("%w: %s", errIDMismatch, problem)
+	}
+	return nil
+}
+
+// resolveDIDURL expands a relative DID URL such as #key-1 against the document id
+func resolveDIDURL(docID, ref string) string {
+	if strings.HasPrefix(ref, "#") {
+		return docID + ref
+	}
+	return ref
+}
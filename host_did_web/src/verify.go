@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Outcomes of checking that a pushed document is served on its public URL
+const (
+	verifyPending = "pending" // Still polling
+	verifyOK      = "ok"      // The public URL serves the committed document
+	verifyTimeout = "timeout" // VERIFY_PUBLISH_TIMEOUT elapsed first
+)
+
+// maxVerifyInterval caps the delay between polls of the public URL
+const maxVerifyInterval = time.Minute
+
+// publishCheck tracks polling a document's public URL after its batch was
+// pushed. status is final once done is closed.
+type publishCheck struct {
+	done   chan struct{}
+	status string
+}
+
+// wait returns the check's outcome, or pending if it isn't known within timeout
+func (c *publishCheck) wait(timeout time.Duration) string {
+	select {
+	case <-c.done:
+		return c.status
+	case <-time.After(timeout):
+		return verifyPending
+	}
+}
+
+// startPublishCheck polls the item's public URL in the background until it
+// serves the committed document, updating the item's job as it goes
+func (p *DIDProcessor) startPublishCheck(item BatchItem) *publishCheck {
+	check := &publishCheck{done: make(chan struct{})}
+	if item.JobID != "" {
+		p.jobs.verify(item.JobID, verifyPending)
+	}
+
+	go func() {
+		check.status = p.verifyPublished(item)
+		close(check.done)
+		if item.JobID != "" {
+			p.jobs.verify(item.JobID, check.status)
+		}
+	}()
+	return check
+}
+
+// verifyPublished polls the public URL with backoff until it serves the
+// committed document or VERIFY_PUBLISH_TIMEOUT elapses. A push only means
+// Pages has a deployment to run, not that it has finished.
+func (p *DIDProcessor) verifyPublished(item BatchItem) string {
+	url := p.buildPublishedURL(item.ParsedDID)
+	logger := loggerForRequest(item.RequestID).With("did", item.ParsedDID.Original, "url", url)
+
+	expected, err := normalizeJSON(item.Document)
+	if err != nil {
+		expected = item.Document
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.VerifyPublishTimeout)
+	defer cancel()
+	start := time.Now()
+	delay := p.config.VerifyPublishInterval
+	for attempt := 1; ; attempt++ {
+		err := p.checkPublished(ctx, url, expected)
+		if err == nil {
+			PublishVerificationsTotal.WithLabelValues(verifyOK).Inc()
+			PublishVerificationDuration.Observe(time.Since(start).Seconds())
+			logger.Info("✅ Published document is live", "attempts", attempt, "elapsed", time.Since(start).Round(time.Second))
+			return verifyOK
+		}
+		logger.Debug("Published document not live yet", "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			PublishVerificationsTotal.WithLabelValues(verifyTimeout).Inc()
+			logger.Warn("⚠️ Published document not live before VERIFY_PUBLISH_TIMEOUT",
+				"attempts", attempt, "timeout", p.config.VerifyPublishTimeout, "error", err)
+			return verifyTimeout
+		}
+		delay = min(delay*2, maxVerifyInterval)
+	}
+}
+
+// checkPublished fetches url and compares the served JSON with expected
+func (p *DIDProcessor) checkPublished(ctx context.Context, url string, expected []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// Ask caches in front of Pages for a fresh copy
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxBodyBytes))
+	if err != nil {
+		return err
+	}
+	served, err := normalizeJSON(body)
+	if err != nil {
+		served = body
+	}
+	if !bytes.Equal(served, expected) {
+		return fmt.Errorf("served document differs from the committed one")
+	}
+	return nil
+}
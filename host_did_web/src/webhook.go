@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// WebhookPayload is POSTed to the callback URL once an item's batch finishes
+type WebhookPayload struct {
+	DID         string `json:"did"`
+	TargetFile  string `json:"targetFile"`
+	Removed     bool   `json:"removed,omitempty"`
+	Deactivated bool   `json:"deactivated,omitempty"`
+	Success     bool   `json:"success"`
+	Commit      string `json:"commit,omitempty"`
+	Branch      string `json:"branch"`
+	RequestID   string `json:"requestId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// validateCallbackURL checks that a callback URL is an absolute http(s) URL
+func validateCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid callbackUrl '%s' (expected an http or https URL)", callbackURL)
+	}
+	return nil
+}
+
+// notifyWebhook delivers the outcome of an item's batch to its callback URL,
+// falling back to WEBHOOK_URL. Delivery runs in the background and never
+// affects the batch result.
+func (p *DIDProcessor) notifyWebhook(item BatchItem, commit string, itemErr error) {
+	callbackURL := item.CallbackURL
+	if callbackURL == "" {
+		callbackURL = p.config.WebhookURL
+	}
+	if callbackURL == "" {
+		return
+	}
+
+	payload := WebhookPayload{
+		DID:         item.ParsedDID.Original,
+		TargetFile:  item.TargetFile,
+		Removed:     item.Remove,
+		Deactivated: item.Deactivate,
+		Success:     itemErr == nil,
+		Branch:      item.Repo.Branch,
+		RequestID:   item.RequestID,
+	}
+	if itemErr != nil {
+		payload.Error = itemErr.Error()
+	} else {
+		payload.Commit = commit
+	}
+
+	go p.deliverWebhook(callbackURL, payload)
+}
+
+// deliverWebhook POSTs the payload, retrying with exponential backoff
+func (p *DIDProcessor) deliverWebhook(callbackURL string, payload WebhookPayload) {
+	logger := loggerForRequest(payload.RequestID).With("callback_url", callbackURL, "did", payload.DID)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error("Failed to encode webhook payload", "error", err)
+		return
+	}
+
+	backoff := p.config.WebhookRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := p.postWebhook(callbackURL, body)
+		if err == nil {
+			logger.Info("Delivered webhook", "attempt", attempt)
+			return
+		}
+		if attempt > p.config.WebhookRetries {
+			WebhookFailuresTotal.Inc()
+			logger.Error("Giving up on webhook delivery", "attempts", attempt, "error", err)
+			return
+		}
+
+		logger.Warn("Webhook delivery failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postWebhook sends a single webhook request
+func (p *DIDProcessor) postWebhook(callbackURL string, body []byte) error {
+	resp, err := p.webhookClient.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
@@ -15,13 +15,19 @@ GH_REPO="${GH_REPO:-git@github.com:MalmikeFunProjects/HostWebDid.git}"
 GH_USER="${GH_USER:-malmike21}"
 GH_EMAIL="${GH_EMAIL:-malmike21@gmail.com}"
 
-# Initialize git if needed
-git init
 git config --global user.name "${GH_USER}"
 git config --global user.email "${GH_EMAIL}"
+
+# With GIT_REPO_URL set, the service clones the repository itself
+if [ -n "${GIT_REPO_URL}" ]; then
+  exec go run ./src "$@"
+fi
+
+# Initialize git if needed
+git init
 git checkout -b "${BRANCH}" || true
 git remote add origin "${GH_REPO}" || true
 git pull origin "${BRANCH}" || true
 
-# Run the application
-exec go run src/main.go
+# Run the application (arguments such as "publish did:web:..." are passed on)
+exec go run ./src "$@"